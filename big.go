@@ -0,0 +1,51 @@
+package enkodo
+
+import "math/big"
+
+// BigInt will encode an integer as the bytes of its GobEncode, its sign followed by its
+// absolute value. A nil integer is encoded as no bytes
+func (e *Encoder) BigInt(v *big.Int) (err error) {
+	var bs []byte
+	if bs, err = v.GobEncode(); err != nil {
+		return
+	}
+	return e.Bytes(bs)
+}
+
+// BigFloat will encode a float as the bytes of its GobEncode, which keeps its precision,
+// rounding mode and accuracy. A nil float is encoded as no bytes
+func (e *Encoder) BigFloat(v *big.Float) (err error) {
+	var bs []byte
+	if bs, err = v.GobEncode(); err != nil {
+		return
+	}
+	return e.Bytes(bs)
+}
+
+// BigInt will return a decoded integer, nil when none was encoded
+func (d *Decoder) BigInt() (v *big.Int, err error) {
+	var bs []byte
+	if err = d.Bytes(&bs); err != nil || len(bs) == 0 {
+		return
+	}
+
+	v = new(big.Int)
+	if err = v.GobDecode(bs); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// BigFloat will return a decoded float, nil when none was encoded
+func (d *Decoder) BigFloat() (v *big.Float, err error) {
+	var bs []byte
+	if err = d.Bytes(&bs); err != nil || len(bs) == 0 {
+		return
+	}
+
+	v = new(big.Float)
+	if err = v.GobDecode(bs); err != nil {
+		return nil, err
+	}
+	return
+}
@@ -0,0 +1,41 @@
+package enkodo
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+func TestBig(t *testing.T) {
+	huge, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	ints := []*big.Int{nil, big.NewInt(0), huge}
+	floats := []*big.Float{nil, new(big.Float), new(big.Float).SetPrec(200).Quo(big.NewFloat(1), big.NewFloat(3))}
+
+	e := newEncoder(nil)
+	for i := range ints {
+		if err := e.BigInt(ints[i]); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.BigFloat(floats[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := newDecoder(bytes.NewBuffer(e.bs))
+	for i := range ints {
+		n, err := d.BigInt()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (n == nil) != (ints[i] == nil) || n != nil && n.Cmp(ints[i]) != 0 {
+			t.Fatalf(testErrorFmt, ints[i], n)
+		}
+		f, err := d.BigFloat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (f == nil) != (floats[i] == nil) || f != nil && (f.Cmp(floats[i]) != 0 || f.Prec() != floats[i].Prec()) {
+			t.Fatalf(testErrorFmt, floats[i], f)
+		}
+	}
+}
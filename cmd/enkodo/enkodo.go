@@ -1,510 +1,231 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"go/ast"
-	"go/parser"
-	"go/token"
 	"io"
-	"io/fs"
 	"log"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"regexp"
 	"strings"
-	"unicode"
-)
-
-const packageName = "github.com/nullmonk/enkodo"
-
-// Used to find enkodo tags in the struct fields
-var tag = regexp.MustCompile("enkodo:\"(\\w+)\"")
-
-// This is all the types we know about. If you need more, make a new TypeConverter.
-// See Error type converter as an example
-var enc_types_advanced = map[string]TypeConverter{
-	"uint":    NewBasicTypeConverter("uint", "Uint"),
-	"uint8":   NewBasicTypeConverter("uint8", "Uint8"),
-	"uint16":  NewBasicTypeConverter("uint16", "Uint16"),
-	"uint32":  NewBasicTypeConverter("uint32", "Uint32"),
-	"uint64":  NewBasicTypeConverter("uint64", "Uint64"),
-	"int":     NewBasicTypeConverter("int", "Int"),
-	"int8":    NewBasicTypeConverter("int8", "Int8"),
-	"int16":   NewBasicTypeConverter("int16", "Int16"),
-	"int32":   NewBasicTypeConverter("int32", "Int32"),
-	"int64":   NewBasicTypeConverter("int64", "Int64"),
-	"float32": NewBasicTypeConverter("float32", "Float32"),
-	"float64": NewBasicTypeConverter("float64", "Float64"),
-	"string":  NewBasicTypeConverter("string", "String"),
-	"bool":    NewBasicTypeConverter("bool", "Bool"),
-	"[]byte":  NewBasicTypeConverter("[]byte", "Bytes"),
-	"error":   &ErrorTypeConverter{},
-}
-
-const ident = "\t"
-
-type TypeConverter interface {
-	// Name of the golang type for this converter
-	Name() string
-	// Name of the enkodo function used to encode it.
-	EnkodoFunction() string
-	// Take the value (e.g. struct.field) and return and modifications
-	// (e.g. struct.field.String()) to get passed to EnkodoFunction.
-	// must match the INPUT type of the EnkodoFunction
-	Enc(val string) string
-	// This code take a value v (output from EnkodoFunction) and converts it to Name()
-	//
-	// return nothing to just use the raw value of EnkodoFunc (e.g. Name = "string"
-	// and EnkodoFunc = "enkodo.String()")
-	//
-	// val, _ = enkodo.String()
-	// struct.Field = CustomType(val)
-	Dec(val string) string
-	// These packages must be imported to use this advanced type, ensure are included at the top
-	Imports() []string
-}
-
-type ErrorTypeConverter struct{}
-
-func (e *ErrorTypeConverter) Name() string {
-	return "error"
-}
-
-func (e *ErrorTypeConverter) EnkodoFunction() string {
-	return "String"
-}
-
-func (e *ErrorTypeConverter) Enc(val string) string {
-	return fmt.Sprintf("%s.Error()", val)
-}
-
-func (e *ErrorTypeConverter) Dec(val string) string {
-	return fmt.Sprintf("errors.New(%s)", val)
-}
-
-func (e *ErrorTypeConverter) Imports() []string {
-	return []string{"errors"}
-}
-
-type BasicTypeConverter struct {
-	goName  string
-	enkFunc string
-}
-
-func NewBasicTypeConverter(gotype, enkodoFunction string) *BasicTypeConverter {
-	return &BasicTypeConverter{
-		goName:  gotype,
-		enkFunc: enkodoFunction,
-	}
-}
-
-func (b *BasicTypeConverter) Name() string {
-	return b.goName
-}
-
-func (b *BasicTypeConverter) EnkodoFunction() string {
-	return b.enkFunc
-}
-
-func (b *BasicTypeConverter) Enc(val string) string {
-	return val // Use as is
-}
-
-func (b *BasicTypeConverter) Dec(val string) string {
-	return "" // Not mods needed, assumes enkFunc returns goName
-}
-
-func (b *BasicTypeConverter) Imports() []string {
-	return nil // Does not need to import anything
-}
-
-// A field on a struct, has a field name, go type, and optional override type
-type Field struct {
-	Name         string
-	Type         string
-	OverrideType string
-}
-
-// A struct has a name, and lots of fields
-type Struct struct {
-	Name   string
-	Fields []Field
-
-	_declared   map[string]string
-	_hasLoopVar bool
-}
-
-func (s *Struct) String() string {
-	return fmt.Sprintf("%s: %v", s.Name, s.Fields)
-}
 
-func (s *Struct) EncodeFunc(f io.Writer) error {
-	s._declared = make(map[string]string)
-	fnRef := strings.ToLower(s.Name[0:1])
-	fmt.Fprintf(f, "func (%s *%s) MarshalEnkodo(enc *enkodo.Encoder) (err error) {\n", fnRef, s.Name)
-	for _, field := range s.Fields {
-		field.Name = fnRef + "." + field.Name
-		s.EncodeField(1, field, f)
-	}
-	fmt.Fprintf(f, ident+"return\n}\n\n")
-	return nil
-}
+	"github.com/nullmonk/enkodo/gen"
+)
 
-func (s *Struct) DecodeFunc(f io.Writer) error {
-	fnRef := strings.ToLower(s.Name[0:1])
-	fmt.Fprintf(f, "func (%s *%s) UnmarshalEnkodo(dec *enkodo.Decoder) (err error) {\n", fnRef, s.Name)
-	for _, field := range s.Fields {
-		field.Name = fnRef + "." + field.Name
-		s.DecodeField(1, field, f)
-	}
-	fmt.Fprint(f, ident+"return\n}\n\n")
-	return nil
-}
+// Flags for debugging the generator, which are not shown in the usage
+var hidden = map[string]bool{"dump-ast": true}
 
-func (s *Struct) EncodeField(identCount int, field Field, f io.Writer) (err error) {
-	dent := strings.Repeat(ident, identCount)
-	name := field.Name
-	if field.OverrideType != "" {
-		name = fmt.Sprintf("%s(%s)", field.OverrideType, field.Name)
-		field.Type = field.OverrideType
-	}
-
-	if field.Type == "" || field.Type[0] == '[' && len(field.Type) == 2 {
-		fmt.Fprintf(f, "%s// Do not know what to do with %s (%s)\n", dent, field.Name, field.Type)
-		return
+func main() {
+	var opts gen.Options
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <path> [ - ]\n\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Generate enkodo marshal/unmarshal functions for Go source files under the given path.")
+		fmt.Fprintln(os.Stderr, "If the optional second positional argument is '-', generated files are written to stdout.")
+		fmt.Fprintln(os.Stderr, "If the path is '-', a single go source file is read from stdin and generated to stdout.")
+		fmt.Fprintln(os.Stderr, "Without a path under go generate, only the file holding the //go:generate directive ($GOFILE) is generated.")
+		fmt.Fprintln(os.Stderr, "'clean <path>' removes the files generated by enkodo under the path instead.")
+		fmt.Fprintln(os.Stderr, "'schema <path>' writes the wire layout of the structs under the path to stdout as JSON instead.")
+		fmt.Fprintln(os.Stderr, "'import <schema> <dir>' declares the structs of a schema under the directory and generates their methods.")
+		fmt.Fprintln(os.Stderr, "'compat <old schema> <new schema>' fails listing the changes that break decoding values written with the old schema.")
+		fmt.Fprintln(os.Stderr, "\nExamples:")
+		fmt.Fprintf(os.Stderr, "  %s ./pkg\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s ./example/basic\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -type=User,Post ./pkg\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -o user_codec.go ./pkg\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -check ./...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -diff ./... > enkodo.diff\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -watch ./...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -all -exclude=vendor,internal/legacy ./...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -exclude-type='^Internal' -exclude-field='^X' ./...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -lang=python -o ./decoders ./pkg\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s clean ./pkg\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s schema ./pkg > pkg.schema.json\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s import pkg.schema.json ./other/pkg\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s compat old.schema.json pkg.schema.json\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s - < user.go\n", os.Args[0])
+
+		// Debugging flags are left out of the usage
+		visible := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		visible.SetOutput(os.Stderr)
+		flag.VisitAll(func(f *flag.Flag) {
+			if !hidden[f.Name] {
+				visible.Var(f.Value, f.Name, f.Usage)
+			}
+		})
+		visible.PrintDefaults()
 	}
 
-	// Get the TypeConverter for this field type
-	if conv, ok := enc_types_advanced[field.Type]; ok {
-		fmt.Fprintf(f, "%senc.%s(%s)\n", dent, conv.EnkodoFunction(), conv.Enc(name))
-		return
+	help := flag.Bool("help", false, "Show help")
+	flag.BoolVar(&opts.Generics, "generics", false, "Use generic helper functions for slice fields (requires go1.18)")
+	flag.BoolVar(&opts.ErrorCodes, "errorcodes", false, "Encode errors with codes from the enkodo error registry to preserve their identity")
+	flag.BoolVar(&opts.Msgpack, "msgpack", false, "Also generate experimental MarshalMsgpack/UnmarshalMsgpack methods (scalars, strings, bytes, slices and struct pointers)")
+	flag.BoolVar(&opts.EmitInterfaces, "emit-interfaces", false, "Declare EnkodoMarshaler/EnkodoUnmarshaler in each package and assert the generated structs implement them")
+	flag.BoolVar(&opts.EmitAccessors, "emit-accessors", false, "Also generate exported GetField/SetField methods for each encoded field that does not already have them")
+	flag.BoolVar(&opts.CountBytes, "countbytes", false, "Also generate UnmarshalEnkodoN methods returning the number of bytes decoded")
+	flag.BoolVar(&opts.Binary, "binary", false, "Also generate MarshalBinary/UnmarshalBinary methods wrapping the enkodo ones, implementing encoding.BinaryMarshaler")
+	flag.BoolVar(&opts.EncodedSize, "encodedsize", false, "Also generate EncodedSize methods returning the number of bytes MarshalEnkodo writes")
+	flag.IntVar(&opts.MaxStructs, "maxstructs", 0, "Generate each package into enkodo_gen_<n>.go files holding at most this many structs")
+	flag.BoolVar(&opts.StrictOrder, "strict-order", false, "Record field order in enkodo.manifest and fail if fields were reordered since the last run")
+	flag.BoolVar(&opts.AllowReorder, "allow-reorder", false, "With -strict-order, accept reordered fields and update the manifest")
+	flag.BoolVar(&opts.IncludeTests, "include-tests", false, "Also generate for structs in _test.go files, into _enkodo_test.go files")
+	flag.BoolVar(&opts.Strict, "strict", false, "Decode int and uint as 64-bit values, returning an error if they overflow the platform's int, and fail on tagged fields of unsupported types or generated methods the structs already have")
+	flag.BoolVar(&opts.JSONFallback, "json-fallback", false, "Encode fields of types without enkodo methods that implement json.Marshaler as JSON bytes (slow, see README)")
+	flag.BoolVar(&opts.Tests, "tests", false, "Also write tests round tripping random values of each struct into _enkodo_test.go files")
+	flag.BoolVar(&opts.Fuzz, "fuzz", false, "Also write fuzz targets decoding arbitrary bytes into each struct into _enkodo_test.go files")
+	flag.BoolVar(&opts.Compare, "compare", false, "Also write benchmarks comparing enkodo with encoding/json and encoding/gob into _compare_test.go files")
+	flag.BoolVar(&opts.Bench, "bench", false, "Also write benchmarks of enkodo alone into _bench_test.go files, see -compare for baselines")
+	flag.BoolVar(&opts.All, "all", false, "Generate for every exported field of every exported struct, not only tagged fields. Tag a field enkodo:\"-\" to leave it out")
+	flag.BoolVar(&opts.Gated, "gated", false, "Declare EnkodoEnabled in each package, generated enkodo methods return enkodo.ErrDisabled while it is false")
+	flag.BoolVar(&opts.NilSlice, "nilslice", false, "Keep nil and empty slices distinct by writing slice lengths plus one, with 0 for nil (changes the wire format)")
+	flag.BoolVar(&opts.Framed, "framed", false, "Prefix the fields of each struct with their length, so older decoders skip fields appended since (changes the wire format)")
+	flag.StringVar(&opts.Template, "template", "", "File of text/template definitions overriding blocks of the generated methods, see the README")
+	flag.IntVar(&opts.FormatVersion, "format-version", 1, "Wire format version to generate for, see the README for the supported versions")
+	flag.BoolVar(&opts.DumpAST, "dump-ast", false, "Log the AST nodes of field types that can not be resolved")
+	flag.IntVar(&opts.MaxDepth, "maxdepth", 0, "Maximum nesting depth when decoding recursive structs (0 is unlimited)")
+	flag.IntVar(&opts.Budget, "budget", 0, "Bytes of strings and byte slices plus elements of slices and maps each decoded value may allocate (0 is unlimited)")
+	flag.StringVar(&opts.Output, "output", "", "File or directory to write the generated code to, a .go file gets every struct of its package (default <name>_enkodo.go next to each file)")
+	flag.StringVar(&opts.Output, "o", "", "Shorthand for -output")
+	flag.BoolVar(&opts.Check, "check", false, "Compare the generated code with the existing files instead of writing it, failing with a diff if any are out of date")
+	flag.BoolVar(&opts.Diff, "diff", false, "Print a unified diff of the changes to the generated files instead of writing them")
+	flag.StringVar(&opts.Runtime, "runtime", "", "Import path of the enkodo runtime the generated code uses, for forks or mirrors (default github.com/nullmonk/enkodo)")
+	flag.StringVar(&opts.Lang, "lang", "go", "Language to generate, go, or python, typescript or javascript for a <package>_enkodo.<ext> module per package decoding its structs")
+	flag.BoolVar(&opts.Combined, "combined", false, "Write the code of every struct in a package to a single enkodo_gen.go")
+	flag.BoolVar(&opts.Force, "force", false, "Regenerate packages even when their generated files record the hash of their current sources")
+	flag.IntVar(&opts.Jobs, "jobs", 0, "Packages to type check at once before generating (default one per CPU)")
+	watch := flag.Bool("watch", false, "Generate again whenever a go file under the path changes, until interrupted")
+	typeNames := flag.String("type", "", "Comma separated structs to generate for, only the files declaring them are regenerated")
+	exclude := flag.String("exclude", "", "Comma separated glob patterns of the files and directories under the path not to generate for, matched against their path under it or their name, e.g. vendor,*_mock.go")
+	excludeType := flag.String("exclude-type", "", "Regular expression of the types not to generate for, matched against their name, e.g. '^Internal'")
+	excludeField := flag.String("exclude-field", "", "Regular expression of the fields not to encode, matched against their name in every struct, e.g. '^X'")
+	config := flag.String("config", "", "File of type converters to register, see the README (default the closest "+gen.ConfigName+" from the working directory up)")
+	flag.Parse()
+	opts.Log = os.Stdout
+	if *typeNames != "" {
+		opts.Types = strings.Split(*typeNames, ",")
 	}
-
-	// Handle pointers to other types
-	if field.Type[0] == '*' {
-		fmt.Fprintf(f, "%senc.Encode(%s)\n", dent, name)
-		return
+	if *exclude != "" {
+		opts.Exclude = strings.Split(*exclude, ",")
 	}
-
-	// Handle arrays
-	if field.Type[0] == '[' {
-		fmt.Fprintf(f, "%senc.Int(len(%s))\n", dent, name)
-		fmt.Fprintf(f, "%sfor _, v := range %s {\n", dent, name)
-		if err := s.EncodeField(identCount+1, Field{Name: "v", Type: field.Type[2:]}, f); err != nil {
-			return err
+	if *excludeType != "" {
+		var err error
+		if opts.ExcludeType, err = regexp.Compile(*excludeType); err != nil {
+			log.Fatalf("invalid -exclude-type: %v", err)
 		}
-		fmt.Fprintln(f, dent+"}")
-		return
 	}
-
-	fmt.Fprintf(f, "%s// Do not know what to do with %s (%s)\n", dent, field.Name, field.Type)
-	return nil
-}
-
-func (s *Struct) DecodeField(identCount int, field Field, f io.Writer) (err error) {
-	dent := strings.Repeat(ident, identCount)
-	name := field.Name
-	/*
-		var ogType string
-		if field.OverrideType != "" {
-			ogType = field.Type
-			field.Type = field.OverrideType
+	if *excludeField != "" {
+		var err error
+		if opts.ExcludeField, err = regexp.Compile(*excludeField); err != nil {
+			log.Fatalf("invalid -exclude-field: %v", err)
 		}
-	*/
-	if field.Type == "" || field.Type[0] == '[' && len(field.Type) == 2 {
-		fmt.Fprintf(f, "%s// Do not know what to do with %s (%s)\n", dent, field.Name, field.Type)
-		return
-	}
-	// bytes is a special case for decode because we need to build the array
-	if field.Type == "[]byte" {
-		fmt.Fprintf(f, "%s%s = make([]byte, 0)\n", dent, name)
-		fmt.Fprintf(f, "%sif err = dec.Bytes(&%s); err != nil {\n", dent, name)
-		fmt.Fprintf(f, "%sreturn\n%s}\n", dent+ident, dent)
-		return
-	}
-
-	// These basic functions are all error wrapped
-	typ := field.Type
-	if field.OverrideType != "" {
-		typ = field.OverrideType
 	}
 
-	if conv, ok := enc_types_advanced[typ]; ok {
-		// Special case for overrides where we assign it to a different value, then set it in the obj
-		//init, varName := initType(field.Type)
-		//enhanced decoding where its converted
-		d := conv.Dec("v")
-		// Override requires a typecast back to the original gotype
-		if field.OverrideType != "" {
-			if d == "" {
-				d = "v"
-			}
-			d = fmt.Sprintf("%s(%s)", field.Type, d)
-		}
-		if d != "" {
-			/* Should come out like this:
-			// assume .Field error
-			if v, err := dec.String(); err == nil {
-				struct.Field = errors.New(v)
-			} else {
-				return err
-			}
-			*/
-
-			fmt.Fprintf(f, "%sif v, err := dec.%s(); err == nil {\n", dent, conv.EnkodoFunction())
-			fmt.Fprintf(f, "%s%s = %s\n", dent+ident, field.Name, d)
-			fmt.Fprintf(f, "%s} else {\n", dent)
-			fmt.Fprintf(f, "%sreturn err\n", dent+ident)
-			fmt.Fprintf(f, "%s}\n", dent)
-			//fmt.Fprintf(f, "%s%s = %s(%s)\n", dent, name, ogType, varName)
-		} else {
-
-			fmt.Fprintf(f, "%sif %s, err = dec.%s(); err != nil {\n", dent, field.Name, conv.EnkodoFunction())
-			fmt.Fprintf(f, "%sreturn err\n", dent+ident)
-			fmt.Fprintf(f, "%s}\n", dent)
+	// also accept GNU-style --help
+	for _, a := range os.Args[1:] {
+		if a == "--help" {
+			*help = true
+			break
 		}
-		return
 	}
-
-	// Handle pointers to other types
-	if field.Type[0] == '*' {
-		fmt.Fprintf(f, "%s%s = new(%s)\n", dent, name, strings.Trim(field.Type, "*"))
-		fmt.Fprintf(f, "%sif err = dec.Decode(%s); err != nil {\n", dent, name)
-		fmt.Fprintf(f, "%sreturn\n%s}\n", dent+ident, dent)
-		return
+	if *help {
+		flag.Usage()
+		os.Exit(0)
 	}
 
-	// Handle arrays
-	if field.Type[0] == '[' {
-		// Make sure we have this loop var initialized
-		if _, ok := s._declared["_arrLen"]; !ok {
-			s._declared["_arrLen"] = "int"
-			fmt.Fprintf(f, "%svar _arrLen int\n", dent)
+	if *config == "" {
+		var err error
+		if *config, err = gen.FindConfig("."); err != nil {
+			log.Fatal(err)
 		}
-		// temp var for the type
-		init, temp := initType(field.Type)
-		// Read the len
-		s.DecodeField(identCount, Field{"_arrLen", "int", ""}, f)
-		// Make the buffer
-		fmt.Fprintf(f, "%s%s = make(%s, 0, _arrLen)\n", dent, name, field.Type)
-		fmt.Fprintf(f, "%sfor i := 0; i < _arrLen; i++ {\n", dent)
-		fmt.Fprintf(f, "%s%s\n", dent+ident, init)
-
-		// This initType makes a var per type in a loop, its technically not needed as we
-		// could use a temp var, but
-		if err := s.DecodeField(identCount+1, Field{temp, field.Type[2:], ""}, f); err != nil {
-			return err
-		}
-		fmt.Fprintf(f, "%s%s = append(%s, %s)\n", dent+ident, name, name, temp)
-		fmt.Fprintln(f, dent+"}")
 	}
-	return nil
-}
-
-/*
-	Each var that is appended to an array needs to be intialized, and have a unique name per type.
-
-This function determines how to handle that properly
-*/
-func initType(typ string) (init string, name string) {
-	clean_typ := strings.Trim(typ, "[]")
-	name = "t"
-	//name = "_" + strings.ToLower(strings.TrimLeft(clean_typ, "*"))
-	if typ[0] == '*' {
-		init = fmt.Sprintf("var %s = new(%s)", name, clean_typ)
-	} else {
-		init = fmt.Sprintf("var %s %s", name, clean_typ)
-	}
-	return
-}
-
-func GetFieldType(f ast.Expr) (result string) {
-	switch t := f.(type) {
-	case *ast.Ident:
-		// basic types (e.g. Int)
-		result = t.Name
-	case *ast.StarExpr:
-		// pointer types
-		if v, ok := t.X.(*ast.Ident); !ok {
-			return
-		} else {
-			result = "*" + v.Name
+	if *config != "" {
+		if err := gen.LoadConfig(*config); err != nil {
+			log.Fatal(err)
 		}
-	case *ast.ArrayType:
-		result = "[]" + GetFieldType(t.Elt)
-	case *ast.SelectorExpr:
-		result = t.Sel.Name
-	default:
-		// uncomment below to error and see new types
-		// result = f.(*ast.Ident).Name
-		return
-	}
-	return
-}
-
-func GetStructFields(obj *ast.Object) *Struct {
-	if obj.Decl == nil {
-		return nil
-	}
-
-	ts, ok := obj.Decl.(*ast.TypeSpec)
-	if !ok {
-		return nil // not a type definition
-	}
-	st, ok := ts.Type.(*ast.StructType)
-	if !ok {
-		return nil // not a struct
 	}
 
-	s := &Struct{
-		Name:   ts.Name.Name,
-		Fields: make([]Field, 0),
-	}
-
-	for _, field := range st.Fields.List {
-		f := Field{
-			Name: field.Names[0].Name,
-			Type: GetFieldType(field.Type),
+	// Subcommands, which run instead of generating
+	switch flag.Arg(0) {
+	case "clean":
+		if flag.Arg(1) == "" {
+			flag.Usage()
+			log.Fatal("No path to clean given")
 		}
-		// Override the type with anything in a struct tag. E.g. enkodo:"int"
-		// skip fields that dont have the enkodo tag
-		if field.Tag == nil || !strings.Contains(field.Tag.Value, "enkodo") {
-			continue
+		removed, err := gen.Clean(flag.Arg(1))
+		for _, file := range removed {
+			fmt.Printf("Removed %s\n", file)
 		}
-		match := tag.FindStringSubmatch(field.Tag.Value)
-		if len(match) > 1 && len(match[1]) > 1 {
-			f.OverrideType = match[1]
+		if err != nil {
+			log.Fatal(err)
 		}
-		if !unicode.IsUpper(rune(f.Name[0])) || (f.Type == "" && f.OverrideType == "") {
-			// Only handle exported variables for now
-			continue
+		return
+	case "schema":
+		if flag.Arg(1) == "" {
+			flag.Usage()
+			log.Fatal("No path to describe given")
 		}
-		s.Fields = append(s.Fields, f)
-	}
-	if len(s.Fields) > 0 {
-		return s
-	}
-	return nil
-}
-func objectsInFile(file string) error {
-	fset := token.NewFileSet()
-	fil, err := parser.ParseFile(fset, file, nil, 0)
-	if err != nil {
-		log.Fatalf("failed to parse %s: %s", file, err)
-	}
-
-	pkg := fil.Name.Name // package name
-
-	structs := make([]*Struct, 0)
-	for _, obj := range fil.Scope.Objects {
-		if obj.Decl == nil {
-			continue
+		gen.SetOptions(opts)
+		if err := gen.ExportSchema(os.Stdout, flag.Arg(1)); err != nil {
+			log.Fatal(err)
 		}
-
-		s := GetStructFields(obj)
-		if s == nil {
-			continue
+		return
+	case "compat":
+		if flag.Arg(1) == "" || flag.Arg(2) == "" {
+			flag.Usage()
+			log.Fatal("The old and new schemas must be given")
 		}
-		structs = append(structs, s)
-	}
-
-	if len(structs) == 0 {
-		return nil
-	}
-	// open the output file
-	var out io.Writer
-	if len(os.Args) > 2 && os.Args[2] == "-" {
-		out = os.Stdout
-	} else {
-		filename := file[:len(file)-len(filepath.Ext(file))] + "_enkodo.go"
-		fmt.Printf("Found %d enkodo structs in %s, saving to %s\n", len(structs), file, filename)
-		oFile, err := os.Create(filename)
-		if err != nil {
-			return err
+		if err := gen.CheckCompatFiles(flag.Arg(1), flag.Arg(2)); err != nil {
+			log.Fatal(err)
 		}
-		defer oFile.Close()
-		out = oFile
-	}
-
-	// By default we import enkodo
-	imports := map[string]interface{}{
-		packageName: true,
-	}
-	// Check all the types that we will convert and see if they need to import anything
-	for _, struc := range structs {
-		for _, field := range struc.Fields {
-			ty := field.Type
-			if field.OverrideType != "" {
-				ty = field.OverrideType
-			}
-			if conv, ok := enc_types_advanced[ty]; ok {
-				for _, impt := range conv.Imports() {
-					imports[impt] = true
-				}
-			}
+		return
+	case "import":
+		if flag.Arg(1) == "" || flag.Arg(2) == "" {
+			flag.Usage()
+			log.Fatal("A schema and the directory to import it to must be given")
 		}
-	}
-
-	fmt.Fprint(out, "/* This file is auto-generated by enkodo */\n")
-	fmt.Fprintf(out, "package %s\n\n", pkg)
-	for i := range imports {
-		fmt.Fprintf(out, "import \"%s\"\n", i)
-	}
-	fmt.Fprintln(out, "")
-
-	for _, st := range structs {
-		st.EncodeFunc(out)
-		st.DecodeFunc(out)
-	}
-	return nil
-}
-
-func main() {
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s <path> [ - ]\n\n", os.Args[0])
-		fmt.Fprintln(os.Stderr, "Generate enkodo marshal/unmarshal functions for Go source files under the given path.")
-		fmt.Fprintln(os.Stderr, "If the optional second positional argument is '-', generated files are written to stdout.")
-		fmt.Fprintln(os.Stderr, "\nExamples:")
-		fmt.Fprintf(os.Stderr, "  %s ./pkg\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  %s ./example/basic\n", os.Args[0])
-		flag.PrintDefaults()
-	}
-
-	help := flag.Bool("help", false, "Show help")
-	flag.Parse()
-
-	// also accept GNU-style --help
-	for _, a := range os.Args[1:] {
-		if a == "--help" {
-			*help = true
-			break
+		gen.SetOptions(opts)
+		if err := gen.ImportSchema(flag.Arg(1), flag.Arg(2)); err != nil {
+			log.Fatal(err)
 		}
-	}
-	if *help {
-		flag.Usage()
-		os.Exit(0)
+		return
 	}
 
 	opath := flag.Arg(0)
+	if opath == "" {
+		// Run by go generate, which runs in the directory of the file holding the
+		// directive and names it in GOFILE
+		opath = os.Getenv("GOFILE")
+	}
 	if opath == "" {
 		flag.Usage()
 		log.Fatal("No input path given")
 	}
 
-	files := make([]string, 0, 10)
-
-	filepath.WalkDir(opath, func(path string, d fs.DirEntry, err error) error {
-		if !d.IsDir() {
-			files = append(files, path)
+	// Read a single file from stdin and write the result to stdout
+	if opath == "-" {
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatal(err)
 		}
-		return nil
-	})
+		gen.SetOptions(opts)
+		if err = gen.Generate(os.Stdout, src); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	if len(files) == 0 {
-		log.Fatal("No input files given")
+	opts.Stdout = flag.Arg(1) == "-"
+	gen.SetOptions(opts)
+	if *watch {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if err := gen.Watch(ctx, opath); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-	for _, file := range files {
-		objectsInFile(file)
+	if err := gen.GeneratePath(opath); err != nil {
+		log.Fatal(err)
 	}
 }
@@ -5,22 +5,33 @@ import (
 	"io"
 )
 
-func newDecoder(r io.Reader) *Decoder {
-	var (
-		d  Decoder
-		ok bool
-	)
+// NewDecoder will initialize a new instance of decoder, for calling generated methods
+// such as UnmarshalEnkodoN directly
+func NewDecoder(r io.Reader) *Decoder {
+	return newDecoder(r)
+}
 
-	if d.r, ok = r.(reader); !ok {
-		d.r = bufio.NewReader(r)
+func newDecoder(r io.Reader) *Decoder {
+	rr, ok := r.(reader)
+	if !ok {
+		rr = bufio.NewReader(r)
 	}
 
-	return &d
+	return &Decoder{r: rr}
 }
 
 // Decoder helps to Marshal data
 type Decoder struct {
 	r reader
+	// Counts the bytes read through r once Offset is called, nil until then
+	c *countingReader
+
+	// Current nesting depth of Decode calls
+	depth int
+
+	// Bytes and elements each value may allocate, 0 is unlimited, and those it has
+	budget int
+	spent  int
 }
 
 // Uint decodes a uint type
@@ -88,6 +99,12 @@ func (d *Decoder) Int64() (v int64, err error) {
 	return
 }
 
+// ZigZag decodes a zigzag encoded int64 type
+func (d *Decoder) ZigZag() (v int64, err error) {
+	v, err = decodeZigZag(d.r)
+	return
+}
+
 // Float32 decodes a float64 type
 func (d *Decoder) Float32() (v float32, err error) {
 	v, err = decodeFloat32(d.r)
@@ -108,17 +125,163 @@ func (d *Decoder) Bool() (v bool, err error) {
 
 // Bytes will append bytes to the inbound byteslice
 func (d *Decoder) Bytes(in *[]byte) (err error) {
-	return decodeBytes(d.r, in)
+	return d.bytes(in, "", -1)
+}
+
+// BytesMax will append bytes to the inbound byteslice like Bytes, returning a
+// MaxLengthError for field before allocating them when there are more than max
+func (d *Decoder) BytesMax(in *[]byte, field string, max int) (err error) {
+	return d.bytes(in, field, max)
+}
+
+// FixedBytes will fill the inbound byteslice, which must already have the length
+// that was encoded
+func (d *Decoder) FixedBytes(in []byte) (err error) {
+	_, err = io.ReadFull(d.r, in)
+	return
 }
 
 // String will return a decoded string
 func (d *Decoder) String() (str string, err error) {
-	return decodeString(d.r)
+	return d.string("", -1)
+}
+
+// StringMax will return a decoded string like String, returning a MaxLengthError for
+// field before allocating it when it is longer than max
+func (d *Decoder) StringMax(field string, max int) (str string, err error) {
+	return d.string(field, max)
+}
+
+// bytes decodes bytes into in, failing before it allocates them when there are more
+// than max, unless it is negative, or more than the budget has left
+func (d *Decoder) bytes(in *[]byte, field string, max int) (err error) {
+	var bsLength int
+	if bsLength, err = decodeInt(d.r); err != nil {
+		return
+	}
+
+	if max >= 0 && bsLength > max {
+		return &MaxLengthError{Field: field, Length: bsLength, Max: max}
+	}
+
+	if err = d.Allocate(bsLength); err != nil {
+		return
+	}
+
+	return readBytes(d.r, in, bsLength)
+}
+
+func (d *Decoder) string(field string, max int) (str string, err error) {
+	var bs []byte
+	if err = d.bytes(&bs, field, max); err != nil {
+		return
+	}
+
+	str = getStringFromBytes(bs)
+	return
+}
+
+// Error will return a decoded error, rebuilt from the code registry when its code is
+// known
+func (d *Decoder) Error() (v error, err error) {
+	var (
+		code int
+		msg  string
+	)
+
+	if code, err = d.Int(); err != nil {
+		return
+	}
+
+	if msg, err = d.String(); err != nil {
+		return
+	}
+
+	v = newError(code, msg)
+	return
 }
 
 // Decode will decode a decodee
 func (d *Decoder) Decode(v Decodee) (err error) {
-	return v.UnmarshalEnkodo(d)
+	if d.depth == 0 {
+		// Each value decoded has the whole budget
+		d.spent = 0
+	}
+
+	d.depth++
+	err = v.UnmarshalEnkodo(d)
+	d.depth--
+	return
+}
+
+// LimitBudget limits the bytes of strings and byte slices, and the elements of slices
+// and maps, that each value decoded may allocate to n, unless a lower limit is already
+// set. Decoding a value allocating more returns a BudgetError before allocating it
+func (d *Decoder) LimitBudget(n int) {
+	if d.budget == 0 || n < d.budget {
+		d.budget = n
+	}
+}
+
+// Allocate spends n of the budget of the value being decoded, before allocating n bytes
+// or elements, returning a BudgetError when that is more than it has left
+func (d *Decoder) Allocate(n int) (err error) {
+	if n < 0 {
+		return ErrInvalidLength
+	}
+
+	if d.budget > 0 && n > d.budget-d.spent {
+		return &BudgetError{Budget: d.budget, Needed: d.spent + n}
+	}
+
+	d.spent += n
+	return
+}
+
+// remaining will return how much of the budget is left, or -1 when it is unlimited
+func (d *Decoder) remaining() int {
+	if d.budget == 0 {
+		return -1
+	}
+
+	return d.budget - d.spent
+}
+
+// Depth will return the number of nested Decode calls currently in progress
+func (d *Decoder) Depth() int {
+	return d.depth
+}
+
+// Offset will return the number of bytes read since it was first called. Bytes are only
+// counted from then on, so decoders that never need it do not pay for counting. Call it
+// before decoding for the number of bytes read so far
+func (d *Decoder) Offset() int {
+	if d.c == nil {
+		d.c = &countingReader{r: d.r}
+		d.r = d.c
+	}
+
+	return d.c.n
+}
+
+// countingReader is a reader that counts the bytes read through it
+type countingReader struct {
+	r reader
+	n int
+}
+
+func (c *countingReader) Read(bs []byte) (n int, err error) {
+	n, err = c.r.Read(bs)
+	c.n += n
+	return
+}
+
+func (c *countingReader) ReadByte() (b byte, err error) {
+	if b, err = c.r.ReadByte(); err == nil {
+		c.n++
+	}
+
+	return
 }
 
 // Decodee is a data structure to be dedoded
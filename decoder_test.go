@@ -2,6 +2,7 @@ package enkodo
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"testing"
 )
@@ -47,3 +48,123 @@ func TestDecoder_Decode(t *testing.T) {
 		}
 	}
 }
+
+type depthNode struct {
+	children int
+	seen     int
+}
+
+func (n *depthNode) UnmarshalEnkodo(dec *Decoder) (err error) {
+	if dec.Depth() > n.seen {
+		n.seen = dec.Depth()
+	}
+
+	if n.children == 0 {
+		return
+	}
+
+	n.children--
+	return dec.Decode(n)
+}
+
+func TestDecoder_Depth(t *testing.T) {
+	dec := newDecoder(bytes.NewBuffer(nil))
+	n := depthNode{children: 4}
+	if err := dec.Decode(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	if n.seen != 5 {
+		t.Fatalf("invalid depth, expected %d and received %d", 5, n.seen)
+	}
+
+	if dec.Depth() != 0 {
+		t.Fatalf("invalid depth, expected %d and received %d", 0, dec.Depth())
+	}
+}
+
+func TestDecoder_Offset(t *testing.T) {
+	enc := newEncoder(nil)
+	enc.Int(300)
+	enc.String("hello")
+	bs := enc.bs
+
+	// Counting starts with the first call
+	dec := newDecoder(bytes.NewReader(bs))
+	if dec.Offset() != 0 {
+		t.Fatalf("invalid offset, expected %d and received %d", 0, dec.Offset())
+	}
+
+	if _, err := dec.Int(); err != nil {
+		t.Fatal(err)
+	}
+
+	if dec.Offset() != 2 {
+		t.Fatalf("invalid offset, expected %d and received %d", 2, dec.Offset())
+	}
+
+	if _, err := dec.String(); err != nil {
+		t.Fatal(err)
+	}
+
+	if dec.Offset() != len(bs) {
+		t.Fatalf("invalid offset, expected %d and received %d", len(bs), dec.Offset())
+	}
+}
+
+func TestDecoder_StringMax(t *testing.T) {
+	enc := newEncoder(nil)
+	enc.String("hello")
+	enc.Int(1 << 40)
+	bs := enc.bs
+
+	dec := newDecoder(bytes.NewReader(bs))
+	if str, err := dec.StringMax("Packet.Name", 5); err != nil || str != "hello" {
+		t.Fatalf("invalid value, expected %q and received %q (%v)", "hello", str, err)
+	}
+
+	// A hostile length fails before it is allocated
+	var lengthErr *MaxLengthError
+	if _, err := dec.StringMax("Packet.Name", 5); !errors.As(err, &lengthErr) || lengthErr.Length != 1<<40 {
+		t.Fatalf("invalid error, expected a MaxLengthError and received <%v>", err)
+	}
+
+	dec = newDecoder(bytes.NewReader(bs))
+	var out []byte
+	if err := dec.BytesMax(&out, "Packet.Data", 4); !errors.As(err, &lengthErr) || lengthErr.Field != "Packet.Data" {
+		t.Fatalf("invalid error, expected a MaxLengthError and received <%v>", err)
+	}
+}
+
+type budgetPair [2]string
+
+func (p *budgetPair) UnmarshalEnkodo(dec *Decoder) (err error) {
+	for i := range p {
+		if p[i], err = dec.String(); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func TestDecoder_Budget(t *testing.T) {
+	enc := newEncoder(nil)
+	for _, str := range []string{"abc", "def", "abcde", "fghij"} {
+		enc.String(str)
+	}
+
+	dec := newDecoder(bytes.NewReader(enc.bs))
+	dec.LimitBudget(8)
+	dec.LimitBudget(100)
+	var p budgetPair
+	if err := dec.Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each value decoded has the whole budget, which the second exceeds
+	var budgetErr *BudgetError
+	if err := dec.Decode(&p); !errors.As(err, &budgetErr) || budgetErr.Budget != 8 || budgetErr.Needed != 10 {
+		t.Fatalf("invalid error, expected a BudgetError and received <%v>", err)
+	}
+}
@@ -199,6 +199,16 @@ func decodeInt64(r reader) (v int64, err error) {
 	return
 }
 
+func decodeZigZag(r reader) (v int64, err error) {
+	var u64 uint64
+	if u64, err = decodeUint64(r); err != nil {
+		return
+	}
+
+	v = int64(u64>>1) ^ -int64(u64&1)
+	return
+}
+
 func decodeFloat32(r reader) (v float32, err error) {
 	var u32 uint32
 	if u32, err = decodeUint32(r); err != nil {
@@ -219,12 +229,8 @@ func decodeFloat64(r reader) (v float64, err error) {
 	return
 }
 
-func decodeBytes(r reader, in *[]byte) (err error) {
-	var bsLength int
-	if bsLength, err = decodeInt(r); err != nil {
-		return
-	}
-
+// readBytes reads bsLength bytes into in, which was checked against any limits
+func readBytes(r reader, in *[]byte, bsLength int) (err error) {
 	expandSlice(in, bsLength)
 
 	if bsLength == 0 {
@@ -237,16 +243,6 @@ func decodeBytes(r reader, in *[]byte) (err error) {
 	return
 }
 
-func decodeString(r reader) (str string, err error) {
-	var bs []byte
-	if err = decodeBytes(r, &bs); err != nil {
-		return
-	}
-
-	str = getStringFromBytes(bs)
-	return
-}
-
 func decodeBool(r reader) (v bool, err error) {
 	var u8 uint8
 	if u8, err = decodeUint8(r); err != nil {
@@ -97,13 +97,22 @@ func (e *Encoder) Int64(v int64) (err error) {
 	return e.flush()
 }
 
-// Float32 encodes an float32 type
+// ZigZag encodes an int64 type using zigzag encoding, so values of a small magnitude
+// encode to few bytes even when negative
+func (e *Encoder) ZigZag(v int64) (err error) {
+	e.bs = encodeZigZag(e.bs, v)
+	return e.flush()
+}
+
+// Float32 encodes an float32 type by its IEEE-754 bits, so NaN payloads and negative
+// zero round trip exactly
 func (e *Encoder) Float32(v float32) (err error) {
 	e.bs = encodeFloat32(e.bs, v)
 	return e.flush()
 }
 
-// Float64 encodes an float64 type
+// Float64 encodes an float64 type by its IEEE-754 bits, so NaN payloads and negative
+// zero round trip exactly
 func (e *Encoder) Float64(v float64) (err error) {
 	e.bs = encodeFloat64(e.bs, v)
 	return e.flush()
@@ -115,6 +124,13 @@ func (e *Encoder) Bytes(v []byte) (err error) {
 	return e.flush()
 }
 
+// FixedBytes will encode a byteslice to the writer without a length prefix, for
+// values whose length is known by the decoder (e.g. [16]byte)
+func (e *Encoder) FixedBytes(v []byte) (err error) {
+	e.bs = append(e.bs, v...)
+	return e.flush()
+}
+
 // String will encode a string to the writer
 func (e *Encoder) String(v string) (err error) {
 	e.bs = encodeString(e.bs, v)
@@ -127,6 +143,19 @@ func (e *Encoder) Bool(v bool) (err error) {
 	return e.flush()
 }
 
+// Error will encode an error as its registered code followed by its message, nil
+// errors are encoded with a zero code and no message
+func (e *Encoder) Error(v error) (err error) {
+	var msg string
+	if v != nil {
+		msg = v.Error()
+	}
+
+	e.bs = encodeInt(e.bs, errorCode(v))
+	e.bs = encodeString(e.bs, msg)
+	return e.flush()
+}
+
 // Encode will encode an encodee
 func (e *Encoder) Encode(v Encodee) (err error) {
 	return v.MarshalEnkodo(e)
@@ -64,6 +64,10 @@ func encodeInt64(bs []byte, v int64) (out []byte) {
 	return encodeUint64(bs, *(*uint64)(unsafe.Pointer(&v)))
 }
 
+func encodeZigZag(bs []byte, v int64) (out []byte) {
+	return encodeUint64(bs, uint64((v<<1)^(v>>63)))
+}
+
 func encodeFloat32(bs []byte, v float32) (out []byte) {
 	return encodeUint32(bs, math.Float32bits(v))
 }
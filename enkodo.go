@@ -1,6 +1,9 @@
 package enkodo
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	// ErrEmptyBytes are returned when inbound bytes are empty during decode
@@ -9,8 +12,104 @@ var (
 	ErrInvalidLength = errors.New("invalid length")
 	// ErrIsClosed is returned when an action is attempted on a closed instance
 	ErrIsClosed = errors.New("cannot perform action on closed instance")
+	// ErrMaxDepth is returned when nested decoding exceeds the allowed depth
+	ErrMaxDepth = errors.New("maximum decode depth exceeded")
+	// ErrDisabled is returned by methods generated with -gated while they are disabled
+	ErrDisabled = errors.New("enkodo encoding is disabled")
 )
 
 const (
 	ceiling = 0x80
 )
+
+// MaxLengthError is returned when a field is longer than the max length in its tag
+type MaxLengthError struct {
+	// Field is the struct and field name, e.g. User.Name
+	Field  string
+	Length int
+	Max    int
+}
+
+func (e *MaxLengthError) Error() string {
+	return fmt.Sprintf("%s has a length of %d which exceeds the max length of %d", e.Field, e.Length, e.Max)
+}
+
+// OverflowError is returned when a decoded value does not fit in the platform's int or
+// uint, e.g. a value encoded on a 64-bit machine decoded on a 32-bit one
+type OverflowError struct {
+	// Field is the struct and field name, e.g. User.Count
+	Field string
+	// Type is the go type that overflowed, int or uint
+	Type string
+}
+
+func (e *OverflowError) Error() string {
+	return fmt.Sprintf("%s overflows the platform's %s", e.Field, e.Type)
+}
+
+// UnregisteredTypeError is returned for an interface field holding a type without an ID
+// in the //enkodo:register directive of the interface, or decoding an unregistered ID
+type UnregisteredTypeError struct {
+	// Field is the struct and field name, e.g. Event.Shape
+	Field string
+	// Value is the value that could not be encoded, nil when decoding
+	Value interface{}
+	// ID is the type ID that could not be decoded
+	ID uint64
+}
+
+func (e *UnregisteredTypeError) Error() string {
+	if e.Value != nil {
+		return fmt.Sprintf("%s holds a %T which is not registered", e.Field, e.Value)
+	}
+	return fmt.Sprintf("%s has type ID %d which is not registered", e.Field, e.ID)
+}
+
+// VersionError is returned decoding a struct written by a newer version of it, with
+// fields tagged since that version the decoder does not know
+type VersionError struct {
+	// Type is the name of the struct
+	Type    string
+	Version int
+	// Latest is the version the decoder was generated for
+	Latest int
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("%s was written by version %d, newer than version %d it is decoded with", e.Type, e.Version, e.Latest)
+}
+
+// BudgetError is returned when decoding a value would allocate more bytes and elements
+// than the budget of its decoder, see Decoder.LimitBudget
+type BudgetError struct {
+	Budget int
+	// Bytes and elements the value would have allocated so far
+	Needed int
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("decoding needs %d bytes and elements, exceeding the budget of %d", e.Needed, e.Budget)
+}
+
+// FieldDecodeError is returned by generated decoders when a field fails to decode,
+// naming the struct and field the error it wraps happened in
+type FieldDecodeError struct {
+	// Struct is the name of the struct, e.g. User
+	Struct string
+	// Field is the name of the field, e.g. Email
+	Field string
+	Err   error
+}
+
+func (e *FieldDecodeError) Error() string {
+	path, err := e.Struct+"."+e.Field, e.Err
+	// Errors of nested structs extend the path, e.g. User.Address.Street
+	for inner, ok := err.(*FieldDecodeError); ok; inner, ok = err.(*FieldDecodeError) {
+		path, err = path+"."+inner.Field, inner.Err
+	}
+	return path + ": " + err.Error()
+}
+
+func (e *FieldDecodeError) Unwrap() error {
+	return e.Err
+}
@@ -201,6 +201,31 @@ func Test_encodeInt64(t *testing.T) {
 	}
 }
 
+func Test_encodeZigZag(t *testing.T) {
+	var (
+		bs  []byte
+		err error
+	)
+
+	for _, i := range []int64{0, -1, 1, -64, 63, -1000, math.MaxInt64, math.MinInt64} {
+		bs = encodeZigZag(bs, i)
+		var val int64
+		if val, err = decodeZigZag(bytes.NewReader(bs)); err != nil {
+			t.Fatal(err)
+		}
+
+		if val != i {
+			t.Fatalf("invalid value, expected <%d> and receIed <%d>", i, val)
+		}
+
+		bs = bs[:0]
+	}
+
+	if bs = encodeZigZag(bs, -1); len(bs) != 1 {
+		t.Fatalf("invalid length, expected %d and received %d", 1, len(bs))
+	}
+}
+
 func TestEncoderDecoder(t *testing.T) {
 	var (
 		a, b testStruct
@@ -224,6 +249,22 @@ func TestEncoderDecoder(t *testing.T) {
 	}
 }
 
+func TestSize(t *testing.T) {
+	a := newTestStruct()
+	bs, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := Size(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(bs) {
+		t.Fatalf("invalid size, expected %d and received %d", len(bs), n)
+	}
+}
+
 func BenchmarkEnkodoEncoding(b *testing.B) {
 	var err error
 	base := newTestStruct()
@@ -767,3 +808,73 @@ func (t *testStruct) isMatch(c *testStruct) (match bool) {
 
 	return true
 }
+
+func TestFixedBytes(t *testing.T) {
+	var (
+		in  = [4]byte{1, 2, 3, 4}
+		out [4]byte
+		err error
+	)
+
+	e := newEncoder(nil)
+	e.FixedBytes(in[:])
+	if len(e.bs) != len(in) {
+		t.Fatalf(testErrorFmt, len(in), len(e.bs))
+	}
+
+	d := newDecoder(bytes.NewBuffer(e.bs))
+	if err = d.FixedBytes(out[:]); err != nil {
+		t.Fatal(err)
+	} else if out != in {
+		t.Fatalf(testErrorFmt, in, out)
+	}
+}
+
+// benchmarkSliceDecode decodes a 100k []int64 the way generated code decodes slices
+// using decodeSlice
+func benchmarkSliceDecode(b *testing.B, decodeSlice func(d *Decoder, n int) ([]int64, error)) {
+	e := newEncoder(nil)
+	e.Int(100000)
+	for i := 0; i < 100000; i++ {
+		e.Int64(int64(i))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d := newDecoder(bytes.NewReader(e.bs))
+		n, err := d.Int()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err = decodeSlice(d, n); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSliceDecode_index(b *testing.B) {
+	benchmarkSliceDecode(b, func(d *Decoder, n int) (s []int64, err error) {
+		s = make([]int64, n)
+		for i := range s {
+			if s[i], err = d.Int64(); err != nil {
+				return
+			}
+		}
+		return
+	})
+}
+
+func BenchmarkSliceDecode_append(b *testing.B) {
+	benchmarkSliceDecode(b, func(d *Decoder, n int) (s []int64, err error) {
+		s = make([]int64, 0, n)
+		for i := 0; i < n; i++ {
+			var t int64
+			if t, err = d.Int64(); err != nil {
+				return
+			}
+			s = append(s, t)
+		}
+		return
+	})
+}
@@ -0,0 +1,86 @@
+package enkodo
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrorCoder is implemented by errors which report their own code when encoded with
+// Encoder.Error
+type ErrorCoder interface {
+	ErrorCode() int
+}
+
+type errorSentinel struct {
+	code int
+	err  error
+}
+
+var errorRegistry struct {
+	mux          sync.RWMutex
+	sentinels    []errorSentinel
+	constructors map[int]func(msg string) error
+}
+
+// RegisterError will register a sentinel error under a non-zero code, errors encoded
+// with that code decode as the same error value
+func RegisterError(code int, err error) {
+	errorRegistry.mux.Lock()
+	defer errorRegistry.mux.Unlock()
+	errorRegistry.sentinels = append(errorRegistry.sentinels, errorSentinel{code, err})
+}
+
+// RegisterErrorType will register a constructor used to rebuild errors encoded with
+// the provided non-zero code, typically by errors implementing ErrorCoder
+func RegisterErrorType(code int, fn func(msg string) error) {
+	errorRegistry.mux.Lock()
+	defer errorRegistry.mux.Unlock()
+	if errorRegistry.constructors == nil {
+		errorRegistry.constructors = make(map[int]func(msg string) error)
+	}
+
+	errorRegistry.constructors[code] = fn
+}
+
+// errorCode will return the registered code for an error, 0 if it is not known
+func errorCode(v error) int {
+	if c, ok := v.(ErrorCoder); ok {
+		return c.ErrorCode()
+	}
+
+	errorRegistry.mux.RLock()
+	defer errorRegistry.mux.RUnlock()
+	for _, s := range errorRegistry.sentinels {
+		if s.err == v {
+			return s.code
+		}
+	}
+
+	return 0
+}
+
+// newError will rebuild an error from its code and message
+func newError(code int, msg string) error {
+	if code == 0 {
+		if msg == "" {
+			return nil
+		}
+
+		return errors.New(msg)
+	}
+
+	errorRegistry.mux.RLock()
+	defer errorRegistry.mux.RUnlock()
+	for _, s := range errorRegistry.sentinels {
+		if s.code == code {
+			return s.err
+		}
+	}
+
+	if fn, ok := errorRegistry.constructors[code]; ok {
+		return fn(msg)
+	}
+
+	// Unknown code, keep the message at least
+	return errors.New(msg)
+}
@@ -0,0 +1,76 @@
+package enkodo
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+var errTestSentinel = errors.New("sentinel")
+
+type testCodedError struct {
+	msg string
+}
+
+func (e *testCodedError) Error() string {
+	return e.msg
+}
+
+func (e *testCodedError) ErrorCode() int {
+	return 2
+}
+
+func TestErrorCodes(t *testing.T) {
+	RegisterError(1, errTestSentinel)
+	RegisterErrorType(2, func(msg string) error { return &testCodedError{msg} })
+
+	tcs := []error{
+		errTestSentinel,
+		&testCodedError{"coded"},
+		errors.New("plain"),
+		nil,
+	}
+
+	e := newEncoder(nil)
+	for _, tc := range tcs {
+		if err := e.Error(tc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := newDecoder(bytes.NewBuffer(e.bs))
+	for _, tc := range tcs {
+		val, err := d.Error()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch expected := tc.(type) {
+		case *testCodedError:
+			if coded, ok := val.(*testCodedError); !ok || coded.msg != expected.msg {
+				t.Fatalf(testErrorFmt, expected, val)
+			}
+		case nil:
+			if val != nil {
+				t.Fatalf(testErrorFmt, nil, val)
+			}
+		default:
+			if expected == errTestSentinel && val != errTestSentinel {
+				t.Fatalf(testErrorFmt, expected, val)
+			} else if val.Error() != expected.Error() {
+				t.Fatalf(testErrorFmt, expected, val)
+			}
+		}
+	}
+}
+
+func TestFieldDecodeError(t *testing.T) {
+	err := error(&FieldDecodeError{Struct: "User", Field: "Address", Err: &FieldDecodeError{Struct: "Address", Field: "Street", Err: io.ErrUnexpectedEOF}})
+	if expected := "User.Address.Street: unexpected EOF"; err.Error() != expected {
+		t.Fatalf(testErrorFmt, expected, err.Error())
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected %v to wrap io.ErrUnexpectedEOF", err)
+	}
+}
@@ -0,0 +1,72 @@
+package enkodo
+
+import "io"
+
+// EncodeFramed encodes what fn writes prefixed with its length, so decoders that only
+// read the start of it, e.g. structs generated before fields were appended, can skip
+// the rest
+func EncodeFramed(e *Encoder, fn func(*Encoder) error) (err error) {
+	frame := newEncoder(nil)
+	if err = fn(frame); err != nil {
+		return
+	}
+
+	return e.Bytes(frame.bs)
+}
+
+// DecodeFramed decodes bytes written by EncodeFramed with fn, which can not read past
+// their end, then skips what fn left unread
+func DecodeFramed(d *Decoder, fn func(*Decoder) error) (err error) {
+	var n int
+	if n, err = d.Int(); err != nil {
+		return
+	}
+	if n < 0 {
+		return ErrInvalidLength
+	}
+
+	outer := d.r
+	frame := &frameReader{r: outer, n: n}
+	d.r = frame
+	err = fn(d)
+	d.r = outer
+	if err != nil {
+		return
+	}
+
+	if _, err = io.CopyN(io.Discard, frame, int64(frame.n)); err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return
+}
+
+// frameReader is a reader of the n bytes left in a frame
+type frameReader struct {
+	r reader
+	n int
+}
+
+func (f *frameReader) Read(bs []byte) (n int, err error) {
+	if f.n <= 0 {
+		return 0, io.EOF
+	}
+	if len(bs) > f.n {
+		bs = bs[:f.n]
+	}
+
+	n, err = f.r.Read(bs)
+	f.n -= n
+	return
+}
+
+func (f *frameReader) ReadByte() (b byte, err error) {
+	if f.n <= 0 {
+		return 0, io.EOF
+	}
+	if b, err = f.r.ReadByte(); err == nil {
+		f.n--
+	}
+
+	return
+}
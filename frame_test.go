@@ -0,0 +1,51 @@
+package enkodo
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFramed(t *testing.T) {
+	e := newEncoder(nil)
+	err := EncodeFramed(e, func(e *Encoder) error {
+		e.String("kept")
+		return e.String("appended")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Uint8(7)
+
+	// Decoding only the start of the frame skips the rest of it
+	d := newDecoder(bytes.NewBuffer(e.bs))
+	var out string
+	err = DecodeFramed(d, func(d *Decoder) (err error) {
+		out, err = d.String()
+		return
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "kept" {
+		t.Fatalf(testErrorFmt, "kept", out)
+	}
+	if after, err := d.Uint8(); err != nil || after != 7 {
+		t.Fatalf("expected the byte after the frame, received %d (%v)", after, err)
+	}
+
+	// Reading past the end of the frame fails rather than reading what follows it
+	d = newDecoder(bytes.NewBuffer(e.bs))
+	err = DecodeFramed(d, func(d *Decoder) (err error) {
+		for range 3 {
+			if _, err = d.String(); err != nil {
+				return
+			}
+		}
+		return
+	})
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF reading past the frame, received %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package gen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+)
+
+// StaleError is returned by GeneratePath under Options.Check when generated files
+// differ from what would be generated now
+type StaleError struct {
+	// The stale files
+	Files []string
+	// Unified diff from the files on disk to the code that would be generated
+	Diff string
+}
+
+func (e *StaleError) Error() string {
+	return fmt.Sprintf("%d generated files are out of date, regenerate them:\n%s", len(e.Files), e.Diff)
+}
+
+// dryRun reports whether generated files are compared with those on disk rather than
+// written, under Options.Check or Options.Diff
+func dryRun() bool {
+	return opts.Check || opts.Diff
+}
+
+// The files found stale so far under dryRun, and the generated files that would be
+// removed unless they are written again
+var (
+	stale    = &StaleError{}
+	removals = map[string]bool{}
+)
+
+// checkedFile collects the code that would be written to a file under dryRun,
+// comparing it with the file on Close
+type checkedFile struct {
+	bytes.Buffer
+	name string
+}
+
+func (c *checkedFile) Close() error {
+	delete(removals, c.name)
+	old, err := os.ReadFile(c.name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if d := diff(c.name, old, c.Bytes()); d != "" {
+		stale.Files = append(stale.Files, c.name)
+		stale.Diff += d
+	}
+	return nil
+}
+
+// createOutput creates the generated file filename, or under dryRun a file that
+// is only compared with it
+func createOutput(filename string) (io.WriteCloser, error) {
+	if dryRun() {
+		return &checkedFile{name: filename}, nil
+	}
+	return os.Create(filename)
+}
+
+// removeOutput removes the generated file filename. Under dryRun it is stale
+// unless it is written again before finishStale
+func removeOutput(filename string) {
+	if dryRun() {
+		if _, err := os.Stat(filename); err == nil {
+			removals[filename] = true
+		}
+		return
+	}
+	os.Remove(filename)
+}
+
+// finishStale returns the StaleError for the files found stale since the last call, or
+// nil when everything is up to date
+func finishStale() error {
+	names := make([]string, 0, len(removals))
+	for name := range removals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		old, _ := os.ReadFile(name)
+		stale.Files = append(stale.Files, name)
+		stale.Diff += diff(name, old, nil)
+	}
+
+	err := stale
+	stale, removals = &StaleError{}, map[string]bool{}
+	if len(err.Files) == 0 {
+		return nil
+	}
+	return err
+}
+
+// Lines of unchanged context around each hunk of a diff
+const diffContext = 3
+
+// An edit in a diff, a line kept (' '), removed ('-') or added ('+')
+type edit struct {
+	op   byte
+	line string
+}
+
+// diff returns a unified diff of the lines of a and b, or "" if they are equal
+func diff(name string, a, b []byte) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+	edits := diffLines(splitLines(a), splitLines(b))
+
+	out := &strings.Builder{}
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", name, name)
+	// Line numbers in a and b at the start of each edit
+	aLine, bLine := make([]int, len(edits)+1), make([]int, len(edits)+1)
+	for i, e := range edits {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if e.op != '+' {
+			aLine[i+1]++
+		}
+		if e.op != '-' {
+			bLine[i+1]++
+		}
+	}
+	for i := 0; i < len(edits); i++ {
+		if edits[i].op == ' ' {
+			continue
+		}
+		// Changes separated by fewer unchanged lines than two contexts share a hunk
+		start, end := max(0, i-diffContext), i+1
+		for j := i + 1; j < len(edits) && j-end < 2*diffContext; j++ {
+			if edits[j].op != ' ' {
+				end = j + 1
+			}
+		}
+		end = min(len(edits), end+diffContext)
+
+		fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(aLine[start], aLine[end]), hunkRange(bLine[start], bLine[end]))
+		for _, e := range edits[start:end] {
+			fmt.Fprintf(out, "%c%s\n", e.op, e.line)
+		}
+		i = end - 1
+	}
+	return out.String()
+}
+
+// hunkRange formats the lines from start to end as the range of a hunk header
+func hunkRange(start, end int) string {
+	if start == end {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, end-start)
+}
+
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// diffLines returns the edits turning the lines a into b. The lines in common at the
+// start and end are kept, and the rest matched by their longest common subsequence
+// unless that is too costly to find, when it is all replaced
+func diffLines(a, b []string) []edit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := make([]edit, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		edits = append(edits, edit{' ', line})
+	}
+	x, y := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(x)*len(y) > 1<<22 {
+		for _, line := range x {
+			edits = append(edits, edit{'-', line})
+		}
+		for _, line := range y {
+			edits = append(edits, edit{'+', line})
+		}
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+		lcs := make([][]int, len(x)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(y)+1)
+		}
+		for i := len(x) - 1; i >= 0; i-- {
+			for j := len(y) - 1; j >= 0; j-- {
+				if x[i] == y[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(x) || j < len(y) {
+			switch {
+			case i < len(x) && j < len(y) && x[i] == y[j]:
+				edits = append(edits, edit{' ', x[i]})
+				i, j = i+1, j+1
+			case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+				edits = append(edits, edit{'-', x[i]})
+				i++
+			default:
+				edits = append(edits, edit{'+', y[j]})
+				j++
+			}
+		}
+	}
+	for _, line := range a[len(a)-suffix:] {
+		edits = append(edits, edit{' ', line})
+	}
+	return edits
+}
@@ -0,0 +1,110 @@
+package gen
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	defer func() { opts = Options{} }()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "user.go")
+	if err := os.WriteFile(src, []byte("package fixture\n\ntype User struct {\n\tName string `enkodo:\"\"`\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := GeneratePath(dir); err != nil {
+		t.Fatal(err)
+	}
+	generated := filepath.Join(dir, "user_enkodo.go")
+	before, err := os.ReadFile(generated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Check = true
+	if err = GeneratePath(dir); err != nil {
+		t.Fatalf("expected the generated file to be up to date, received %v", err)
+	}
+
+	err = os.WriteFile(src, []byte("package fixture\n\ntype User struct {\n\tName string `enkodo:\"\"`\n\tAge  uint8  `enkodo:\"\"`\n}\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stale *StaleError
+	if err = GeneratePath(dir); !errors.As(err, &stale) {
+		t.Fatalf("expected a StaleError, received %v", err)
+	}
+	if len(stale.Files) != 1 || stale.Files[0] != generated {
+		t.Fatalf("invalid stale files, expected [%s] and received %v", generated, stale.Files)
+	}
+	if !strings.Contains(stale.Diff, "+\tenc.Uint8(u.Age)\n") {
+		t.Fatalf("expected the diff to add Age, received:\n%s", stale.Diff)
+	}
+	if after, _ := os.ReadFile(generated); string(after) != string(before) {
+		t.Fatal("expected -check to leave the generated file untouched")
+	}
+}
+
+func TestDiffOption(t *testing.T) {
+	defer func() { opts = Options{} }()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "user.go")
+	if err := os.WriteFile(src, []byte("package fixture\n\ntype User struct {\n\tName string `enkodo:\"\"`\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := GeneratePath(dir); err != nil {
+		t.Fatal(err)
+	}
+	generated := filepath.Join(dir, "user_enkodo.go")
+	before, err := os.ReadFile(generated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(src, []byte("package fixture\n\ntype User struct {\n\tName string `enkodo:\"\"`\n\tAge  uint8  `enkodo:\"\"`\n}\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The diff is written to stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	opts.Diff = true
+	err = GeneratePath(dir)
+	os.Stdout = stdout
+	w.Close()
+	out, _ := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("expected -diff to succeed with changes, received %v", err)
+	}
+	if !strings.HasPrefix(string(out), "--- "+generated+"\n") || !strings.Contains(string(out), "+\tenc.Uint8(u.Age)\n") {
+		t.Fatalf("expected only the diff adding Age, received:\n%s", out)
+	}
+	if after, _ := os.ReadFile(generated); string(after) != string(before) {
+		t.Fatal("expected -diff to leave the generated file untouched")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := "package a\n\nfunc A() {}\n\nfunc B() {}\n"
+	b := "package a\n\nfunc A() {}\n\nfunc C() {}\n"
+	expected := "--- a.go\n+++ a.go\n@@ -2,4 +2,4 @@\n \n func A() {}\n \n-func B() {}\n+func C() {}\n"
+	if d := diff("a.go", []byte(a), []byte(b)); d != expected {
+		t.Fatalf("invalid diff, expected:\n%s\nreceived:\n%s", expected, d)
+	}
+	if d := diff("a.go", []byte(a), []byte(a)); d != "" {
+		t.Fatalf("expected no diff between equal files, received:\n%s", d)
+	}
+	if d := diff("a.go", []byte(a), nil); !strings.Contains(d, "@@ -1,5 +0,0 @@\n-package a\n") {
+		t.Fatalf("invalid diff of a removed file, received:\n%s", d)
+	}
+}
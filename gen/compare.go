@@ -0,0 +1,182 @@
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// compareName returns the file -compare writes the benchmarks for file to, or -bench
+// without -compare
+func compareName(file string) string {
+	base, ok := strings.CutSuffix(file, "_test.go")
+	if !ok {
+		base = file[:len(file)-len(filepath.Ext(file))]
+	}
+	if !opts.Compare {
+		return base + "_bench_test.go"
+	}
+	return base + "_compare_test.go"
+}
+
+// writeCompareFile writes the benchmarks for structs to filename, comparing them with
+// encoding/json and encoding/gob under Options.Compare
+func writeCompareFile(filename, pkg string, structs []*Struct) error {
+	if !dryRun() {
+		logf("Saving enkodo benchmarks to %s\n", filename)
+	}
+	buf := bytes.NewBuffer(nil)
+	writeCompare(buf, pkg, structs, opts.Compare)
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to format the comparison benchmarks for %s: %w", pkg, err)
+	}
+
+	out, err := createOutput(filename)
+	if err != nil {
+		return err
+	}
+	if _, err = out.Write(src); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// benchCodecs are the codecs benchmarked by writeCompare, enkodo then the baselines
+var benchCodecs = []struct{ name, marshal, unmarshal string }{
+	{"Enkodo", "enkodo.Marshal(v)", "enkodo.Unmarshal(bs, &out)"},
+	{"JSON", "json.Marshal(v)", "json.Unmarshal(bs, &out)"},
+	{"Gob", "enkodoGobMarshal(v)", "gob.NewDecoder(bytes.NewReader(bs)).Decode(&out)"},
+}
+
+// writeCompare writes benchmarks of marshalling and unmarshalling each struct with
+// enkodo, and with encoding/json and encoding/gob as baselines, reporting the throughput,
+// allocations and encoded size of each. JSON and gob encode every exported field, not
+// only the enkodo ones, so the sample only sets the fields enkodo encodes
+func writeCompare(w io.Writer, pkg string, structs []*Struct, baselines bool) {
+	out := bytes.NewBuffer(nil)
+	samples := make(map[string]bool)
+	for _, s := range structs {
+		// Recursive structs would build their samples forever
+		samples[s.Name] = !s.Recursive
+	}
+	for _, s := range structs {
+		fmt.Fprintf(out, "func enkodoSample%s() *%s {\n", s.Name, s.Name)
+		if lit := sampleValue(s.Collection, samples); lit != "" {
+			// Named slices and maps convert a sample of their underlying type
+			fmt.Fprintf(out, "%sv := %s(%s)\n%sreturn &v\n}\n\n", ident, s.Name, lit, ident)
+		} else {
+			fmt.Fprintf(out, "%sreturn &%s{\n", ident, s.Name)
+			for _, field := range s.Fields {
+				if field.Getter != "" {
+					continue
+				}
+				if lit := sampleValue(field.Type, samples); lit != "" {
+					fmt.Fprintf(out, "%s%s: %s,\n", ident+ident, field.Name, lit)
+				}
+			}
+			fmt.Fprintf(out, "%s}\n}\n\n", ident)
+		}
+		if s.Only != "" {
+			continue // The benchmarks encode the samples they decode
+		}
+
+		codecs := benchCodecs
+		if !baselines {
+			codecs = codecs[:1]
+		}
+		for _, c := range codecs {
+			fmt.Fprintf(out, "func Benchmark%s_%sMarshal(b *testing.B) {\n", s.Name, c.name)
+			fmt.Fprintf(out, "%sv := enkodoSample%s()\n", ident, s.Name)
+			writeBenchSetup(out, c.marshal)
+			fmt.Fprintf(out, "%sfor i := 0; i < b.N; i++ {\n", ident)
+			fmt.Fprintf(out, "%sif _, err := %s; err != nil {\n%sb.Fatal(err)\n%s}\n%s}\n}\n\n", ident+ident, c.marshal, ident+ident+ident, ident+ident, ident)
+
+			fmt.Fprintf(out, "func Benchmark%s_%sUnmarshal(b *testing.B) {\n", s.Name, c.name)
+			fmt.Fprintf(out, "%sv := enkodoSample%s()\n", ident, s.Name)
+			writeBenchSetup(out, c.marshal)
+			fmt.Fprintf(out, "%sfor i := 0; i < b.N; i++ {\n", ident)
+			fmt.Fprintf(out, "%svar out %s\n", ident+ident, s.Name)
+			fmt.Fprintf(out, "%sif err := %s; err != nil {\n%sb.Fatal(err)\n%s}\n%s}\n}\n\n", ident+ident, c.unmarshal, ident+ident+ident, ident+ident, ident)
+		}
+	}
+
+	if baselines && usesPackage(out.String(), "gob") {
+		fmt.Fprint(out, `func enkodoGobMarshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+`)
+	}
+
+	fmt.Fprint(w, generatedHeader+"\n")
+	fmt.Fprintf(w, "package %s\n\n", pkg)
+	// Structs limited to one enkodo method have no benchmarks, which may leave packages
+	// unused
+	fmt.Fprint(w, "import (\n")
+	for _, imp := range []struct{ path, name string }{{"bytes", "bytes"}, {"encoding/gob", "gob"}, {"encoding/json", "json"}, {"testing", "testing"}} {
+		if usesPackage(out.String(), imp.name) {
+			fmt.Fprintf(w, "\t%q\n", imp.path)
+		}
+	}
+	if usesPackage(out.String(), "enkodo") {
+		fmt.Fprintf(w, "\n\t%s\n", importSpec(runtimePath()))
+	}
+	fmt.Fprint(w, ")\n\n")
+	out.WriteTo(w)
+}
+
+// writeBenchSetup writes the start of a benchmark encoding v with marshal, reporting its
+// encoded size as the bytes processed by each iteration
+func writeBenchSetup(out io.Writer, marshal string) {
+	fmt.Fprintf(out, "%sbs, err := %s\n", ident, marshal)
+	fmt.Fprintf(out, "%sif err != nil {\n%sb.Fatal(err)\n%s}\n", ident, ident+ident, ident)
+	fmt.Fprintf(out, "%sb.SetBytes(int64(len(bs)))\n", ident)
+	fmt.Fprintf(out, "%sb.ReportMetric(float64(len(bs)), \"bytes\")\n", ident)
+	fmt.Fprintf(out, "%sb.ReportAllocs()\n", ident)
+	fmt.Fprintf(out, "%sb.ResetTimer()\n", ident)
+}
+
+// sampleValue returns a go literal of type typ used as sample data by -compare, or ""
+// for types left as their zero value. Pointers to the structs in samples use their
+// sample function
+func sampleValue(typ string, samples map[string]bool) string {
+	if strings.HasPrefix(typ, "*") && samples[typ[1:]] {
+		return fmt.Sprintf("enkodoSample%s()", typ[1:])
+	}
+	if underlying, ok := namedTypes[typ]; ok {
+		if lit := sampleValue(underlying, samples); lit != "" {
+			return fmt.Sprintf("%s(%s)", typ, lit)
+		}
+		return ""
+	}
+	switch typ {
+	case "string":
+		return `"enkodo sample"`
+	case "bool":
+		return "true"
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "byte":
+		return "42"
+	case "float32", "float64":
+		return "3.5"
+	case "[]byte":
+		return `[]byte("enkodo sample")`
+	}
+	if strings.HasPrefix(typ, "[]") {
+		if lit := sampleValue(typ[2:], samples); lit != "" {
+			return fmt.Sprintf("%s{%s, %s, %s}", typ, lit, lit, lit)
+		}
+	}
+	if strings.HasPrefix(typ, "map[") {
+		key, val := splitMapType(typ)
+		if k, v := sampleValue(key, samples), sampleValue(val, samples); k != "" && v != "" {
+			return fmt.Sprintf("%s{%s: %s}", typ, k, v)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package gen
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	opts.Compare = true
+	defer func() { opts = Options{} }()
+
+	dir := writeModule(t, map[string]string{
+		"fixture.go": `package fixture
+
+type Level int8
+
+type User struct {
+	Name   string            ` + "`enkodo:\"\"`" + `
+	Age    uint8             ` + "`enkodo:\"\"`" + `
+	Level  Level             ` + "`enkodo:\"\"`" + `
+	Scores []float64         ` + "`enkodo:\"\"`" + `
+	Attrs  map[string]string ` + "`enkodo:\"\"`" + `
+	Home   *Address          ` + "`enkodo:\"\"`" + `
+}
+
+type Address struct {
+	Street string ` + "`enkodo:\"\"`" + `
+}
+`,
+	})
+	if err := GenerateFile(filepath.Join(dir, "fixture.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "fixture_compare_test.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"Level:  Level(42)", "Home:   enkodoSampleAddress()", "func BenchmarkUser_GobUnmarshal(b *testing.B)"} {
+		if !strings.Contains(string(data), expected) {
+			t.Fatalf("expected %q in the comparison benchmarks, received:\n%s", expected, data)
+		}
+	}
+
+	if testing.Short() {
+		t.Skip("skipping the comparison benchmarks in short mode")
+	}
+	cmd := exec.Command("go", "test", "-run", "^$", "-bench", ".", "-benchtime", "1x", ".")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("comparison benchmarks failed: %v\n%s\n%s", err, out, data)
+	}
+	for _, codec := range []string{"Enkodo", "JSON", "Gob"} {
+		if !strings.Contains(string(out), "BenchmarkUser_"+codec+"Marshal") {
+			t.Fatalf("expected a %s benchmark, received:\n%s", codec, out)
+		}
+	}
+}
+
+func TestBench(t *testing.T) {
+	opts.Bench = true
+	defer func() { opts = Options{} }()
+
+	dir := writeModule(t, map[string]string{
+		"fixture.go": `package fixture
+
+type User struct {
+	Name   string    ` + "`enkodo:\"\"`" + `
+	Scores []float64 ` + "`enkodo:\"\"`" + `
+}
+`,
+	})
+	if err := GenerateFile(filepath.Join(dir, "fixture.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "fixture_bench_test.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"func BenchmarkUser_EnkodoUnmarshal(b *testing.B)", "b.SetBytes(int64(len(bs)))", "b.ReportAllocs()"} {
+		if !strings.Contains(string(data), expected) {
+			t.Fatalf("expected %q in the benchmarks, received:\n%s", expected, data)
+		}
+	}
+	if strings.Contains(string(data), "encoding/json") {
+		t.Fatalf("expected no baselines without -compare, received:\n%s", data)
+	}
+
+	if testing.Short() {
+		t.Skip("skipping the benchmarks in short mode")
+	}
+	cmd := exec.Command("go", "test", "-run", "^$", "-bench", ".", "-benchtime", "1x", ".")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("benchmarks failed: %v\n%s\n%s", err, out, data)
+	}
+	for _, expected := range []string{"BenchmarkUser_EnkodoMarshal", "MB/s", "allocs/op"} {
+		if !strings.Contains(string(out), expected) {
+			t.Fatalf("expected %q in the benchmark results, received:\n%s", expected, out)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package gen
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// IncompatibleError is returned by CheckCompat for the changes between two schemas that
+// break decoding values written with the first
+type IncompatibleError struct {
+	Changes []string
+}
+
+func (e *IncompatibleError) Error() string {
+	return fmt.Sprintf("%d breaking wire format changes:\n\t%s", len(e.Changes), strings.Join(e.Changes, "\n\t"))
+}
+
+// CheckCompatFiles is CheckCompat for the schemas in the files beforeFile and afterFile, as
+// written by ExportSchema
+func CheckCompatFiles(beforeFile, afterFile string) error {
+	schemas := make([]*Schema, 2)
+	for i, file := range []string{beforeFile, afterFile} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		schemas[i] = &Schema{}
+		if err = json.Unmarshal(data, schemas[i]); err != nil {
+			return fmt.Errorf("invalid schema %s: %w", file, err)
+		}
+	}
+	return CheckCompat(schemas[0], schemas[1])
+}
+
+// CheckCompat returns an IncompatibleError listing the changes from before to after that
+// break decoding values written with before: removed structs, removed, added and
+// reordered fields, and fields written as another type. Fields are written by position
+// without their names, so renaming them is compatible, but values written before a field
+// was added end where it would start, unless the field is tagged since a later version.
+// Structs whose //enkodo:version was bumped are left out, their old values are decoded
+// through their migration
+func CheckCompat(before, after *Schema) error {
+	var changes []string
+	if before.FormatVersion != after.FormatVersion {
+		changes = append(changes, fmt.Sprintf("format version changed from %d to %d", before.FormatVersion, after.FormatVersion))
+	}
+	if before.NilSlice != after.NilSlice {
+		changes = append(changes, fmt.Sprintf("-nilslice changed from %v to %v", before.NilSlice, after.NilSlice))
+	}
+	if before.Framed != after.Framed {
+		changes = append(changes, fmt.Sprintf("-framed changed from %v to %v", before.Framed, after.Framed))
+	}
+
+	for _, pkg := range before.Packages {
+		i := slices.IndexFunc(after.Packages, func(p PackageSchema) bool { return p.Dir == pkg.Dir && p.Name == pkg.Name })
+		if i < 0 {
+			changes = append(changes, fmt.Sprintf("package %s in %s removed", pkg.Name, pkg.Dir))
+			continue
+		}
+		afterPkg := after.Packages[i]
+		for _, s := range pkg.Structs {
+			name := pkg.Name + "." + s.Name
+			j := slices.IndexFunc(afterPkg.Structs, func(t StructSchema) bool { return t.Name == s.Name })
+			if j < 0 {
+				changes = append(changes, name+" removed")
+				continue
+			}
+			// Old values of a struct with a new version are decoded by its migration
+			if s.Version > 0 && afterPkg.Structs[j].Version > s.Version {
+				continue
+			}
+			if before.Framed == after.Framed && s.framed(before) != afterPkg.Structs[j].framed(after) {
+				changes = append(changes, fmt.Sprintf("%s framed changed from %v to %v", name, s.framed(before), afterPkg.Structs[j].framed(after)))
+			}
+			changes = append(changes, structChanges(name, s, afterPkg.Structs[j])...)
+		}
+	}
+	if len(changes) > 0 {
+		return &IncompatibleError{changes}
+	}
+	return nil
+}
+
+// structChanges returns the breaking changes to the fields of the struct name
+func structChanges(name string, before, after StructSchema) []string {
+	var changes []string
+	if (before.FieldVersion > 0) != (after.FieldVersion > 0) {
+		changes = append(changes, fmt.Sprintf("%s is prefixed with its version only when fields are tagged since or until, which changed", name))
+	} else if before.FieldVersion > 0 {
+		// Fields since a later version are not read from values written before it
+		after.Fields = slices.DeleteFunc(slices.Clone(after.Fields), func(f FieldSchema) bool { return f.Since > before.FieldVersion })
+	}
+	hasBits := func(s StructSchema) bool {
+		return slices.ContainsFunc(s.Fields, func(f FieldSchema) bool { return f.Bit != nil })
+	}
+	if hasBits(before) != hasBits(after) {
+		changes = append(changes, fmt.Sprintf("%s is prefixed with the bitmask of omitempty fields only when it has some, which changed", name))
+	}
+
+	// Fields are paired by name. A field whose name is gone is paired with the field at its
+	// position when that one is new too, as renaming a field does not change what is written
+	paired := make([]int, len(before.Fields))
+	found := make([]bool, len(after.Fields))
+	for i, field := range before.Fields {
+		paired[i] = slices.IndexFunc(after.Fields, func(f FieldSchema) bool { return f.Name == field.Name })
+		if paired[i] >= 0 {
+			found[paired[i]] = true
+		}
+	}
+	for i := range paired {
+		if paired[i] < 0 && i < len(after.Fields) && !found[i] {
+			paired[i], found[i] = i, true
+		}
+	}
+	// Position of each field of after among the paired fields, fields only move when the
+	// order of those changes, not when others are removed or added before them
+	ranks := make([]int, len(after.Fields))
+	for k, r := 0, 0; k < len(after.Fields); k++ {
+		ranks[k] = r
+		if found[k] {
+			r++
+		}
+	}
+
+	r := 0
+	for i, field := range before.Fields {
+		k := paired[i]
+		if k < 0 {
+			changes = append(changes, fmt.Sprintf("%s.%s removed", name, field.Name))
+			continue
+		}
+		got := after.Fields[k]
+		if ranks[k] != r {
+			changes = append(changes, fmt.Sprintf("%s.%s moved from position %d to %d", name, field.Name, i, k))
+		}
+		r++
+		if !reflect.DeepEqual(field.Bit, got.Bit) {
+			changes = append(changes, fmt.Sprintf("%s.%s changed its omitempty bit", name, field.Name))
+		}
+		if field.Since != got.Since {
+			changes = append(changes, fmt.Sprintf("%s.%s changed the version it is written since", name, field.Name))
+		}
+		if field.PresentIf != got.PresentIf || field.PresentIfWritten != got.PresentIfWritten {
+			changes = append(changes, fmt.Sprintf("%s.%s changed the field saying if it is written", name, field.Name))
+		}
+		if change := wireChange(field.Wire, got.Wire); change != "" {
+			changes = append(changes, fmt.Sprintf("%s.%s %s", name, field.Name, change))
+		}
+	}
+	for k, field := range after.Fields {
+		if !found[k] {
+			changes = append(changes, fmt.Sprintf("%s.%s added, values written without it can not be decoded", name, field.Name))
+		}
+	}
+	return changes
+}
+
+// wireChange describes how after is written differently from before, or returns "" when
+// it is written the same. Interfaces may register new types, but not change or remove the
+// types of their IDs
+func wireChange(before, after TypeSchema) string {
+	if before.Kind != after.Kind || before.Type != after.Type || before.Len != after.Len {
+		return fmt.Sprintf("is written as %s %s instead of %s %s", after.Kind, after.Type, before.Kind, before.Type)
+	}
+	// Schemas exported before methods were recorded have none
+	if before.Method != "" && after.Method != "" && before.Method != after.Method {
+		return fmt.Sprintf("is written with %s instead of %s", after.Method, before.Method)
+	}
+	for _, entry := range before.Registry {
+		i := slices.IndexFunc(after.Registry, func(r RegisteredSchema) bool { return r.ID == entry.ID })
+		if i < 0 {
+			return fmt.Sprintf("no longer registers ID %d for %s", entry.ID, entry.Type)
+		}
+		if after.Registry[i].Type != entry.Type {
+			return fmt.Sprintf("registers ID %d for %s instead of %s", entry.ID, after.Registry[i].Type, entry.Type)
+		}
+	}
+	for _, pair := range [][2]*TypeSchema{{before.Key, after.Key}, {before.Elem, after.Elem}} {
+		if pair[0] != nil && pair[1] != nil {
+			if change := wireChange(*pair[0], *pair[1]); change != "" {
+				return change
+			}
+		}
+	}
+	return ""
+}
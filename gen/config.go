@@ -0,0 +1,127 @@
+package gen
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigName is the file enkodo reads converters from, in the directory it runs in or
+// the closest parent directory holding one
+const ConfigName = ".enkodo.yaml"
+
+// Config is the contents of a .enkodo.yaml file
+type Config struct {
+	Converters []ConverterConfig `yaml:"converters"`
+}
+
+// ConverterConfig declares a TypeConverter for a go type. $v in the expressions is
+// replaced by the value they convert, e.g. encode: $v.String() for a netip.Addr written
+// as a string and decode: netip.MustParseAddr($v) to read it back
+type ConverterConfig struct {
+	// Go type the converter is for, qualified by its package name, e.g. netip.Addr
+	Type string `yaml:"type"`
+	// Encoder and Decoder method the value is written and read with, e.g. String
+	Function string `yaml:"function"`
+	// Converts the field to the type the Encoder method takes, the field as is if empty
+	Encode string `yaml:"encode"`
+	// Converts the value the Decoder method returns to the field's type, that value as
+	// is if empty
+	Decode string `yaml:"decode"`
+	// Import paths the expressions refer to, e.g. net/netip
+	Imports []string `yaml:"imports"`
+}
+
+// configConverter is the TypeConverter of a ConverterConfig
+type configConverter struct {
+	ConverterConfig
+}
+
+func (c *configConverter) Name() string {
+	return c.Type
+}
+
+func (c *configConverter) EnkodoFunction() string {
+	return c.Function
+}
+
+func (c *configConverter) Enc(val string) string {
+	if c.Encode == "" {
+		return val
+	}
+	return strings.ReplaceAll(c.Encode, "$v", val)
+}
+
+func (c *configConverter) Dec(val string) string {
+	if c.Decode == "" {
+		return ""
+	}
+	return strings.ReplaceAll(c.Decode, "$v", val)
+}
+
+func (c *configConverter) Imports() []string {
+	return c.ConverterConfig.Imports
+}
+
+// FindConfig returns the .enkodo.yaml in dir or the closest of its parents holding one,
+// or "" when there is none
+func FindConfig(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		path := filepath.Join(dir, ConfigName)
+		if _, err = os.Stat(path); err == nil {
+			return path, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// LoadConfig reads the config at path and registers its converters, replacing those
+// already registered for their types
+func LoadConfig(path string) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var config Config
+	if err = yaml.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for i, c := range config.Converters {
+		if c.Type == "" || c.Function == "" {
+			return fmt.Errorf("%s: converter %d needs a type and a function", path, i+1)
+		}
+		if !encoderMethod(c.Function) {
+			return fmt.Errorf("%s: %s is written with %s, which is not an enkodo Encoder method", path, c.Type, c.Function)
+		}
+		enc_types_advanced[c.Type] = &configConverter{c}
+	}
+	return nil
+}
+
+// encoderMethod returns whether name is a method of the enkodo Encoder and Decoder that
+// the registered converters write values with
+func encoderMethod(name string) bool {
+	for _, conv := range enc_types_advanced {
+		if conv.EnkodoFunction() == name {
+			return true
+		}
+	}
+	return false
+}
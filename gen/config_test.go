@@ -0,0 +1,83 @@
+package gen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	defer delete(enc_types_advanced, "netip.Prefix")
+	dir := t.TempDir()
+	config := `converters:
+  - type: netip.Prefix
+    function: String
+    encode: $v.String()
+    decode: netip.MustParsePrefix($v)
+    imports: [net/netip]
+`
+	if err := os.WriteFile(filepath.Join(dir, ConfigName), []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(dir, "pkg", "sub")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path, err := FindConfig(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(dir, ConfigName) {
+		t.Fatalf("expected the config of the parent directory, received %q", path)
+	}
+	if err = LoadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+
+	src := `package fixture
+
+import "net/netip"
+
+type Host struct {
+	Net    netip.Prefix   ` + "`enkodo:\"\"`" + `
+	Routes []netip.Prefix ` + "`enkodo:\"\"`" + `
+}
+`
+	if out := generate(t, src); !strings.Contains(out, "enc.String(h.Net.String())") {
+		t.Fatalf("expected Net to use the configured converter, received:\n%s", out)
+	}
+	runGenerated(t, src, `package fixture
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Host{Net: netip.MustParsePrefix("10.0.0.0/8"), Routes: []netip.Prefix{netip.MustParsePrefix("::1/128")}}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Host
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Net != in.Net || len(out.Routes) != 1 || out.Routes[0] != in.Routes[0] {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+
+	bad := filepath.Join(t.TempDir(), ConfigName)
+	if err = os.WriteFile(bad, []byte("converters:\n  - type: netip.Prefix\n    function: Text\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err = LoadConfig(bad); err == nil || !strings.Contains(err.Error(), "Text") {
+		t.Fatalf("expected an error for an unknown Encoder method, received %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package gen
+
+import (
+	"fmt"
+	"io"
+)
+
+// Decoding an input of n bytes may allocate fuzzBudgetBase + n bytes of strings and byte
+// slices, and elements of slices and maps, see enkodo.Decoder.LimitBudget. Valid values
+// hold about one per byte of input, so a length read from the input beyond that fails
+// with an enkodo.BudgetError rather than being allocated
+const fuzzBudgetBase = "1 << 10"
+
+// writeFuzz writes a fuzz target for s decoding arbitrary bytes under a budget bounding
+// what it may allocate by the size of the input, failing when decoding panics. With
+// Options.Tests, random values seed the corpus
+func writeFuzz(out io.Writer, s *Struct) {
+	if s.Only == "encode" {
+		return
+	}
+	fmt.Fprintf(out, "func FuzzUnmarshal%s(f *testing.F) {\n", s.Name)
+	if opts.Tests && s.Only == "" {
+		fmt.Fprintf(out, "%sr := rand.New(rand.NewPCG(1, 2))\n", ident)
+		fmt.Fprintf(out, "%sfor i := 0; i < 10; i++ {\n", ident)
+		fmt.Fprintf(out, "%sif bs, err := enkodo.Marshal(enkodoRandom%s(r, 0)); err == nil {\n%sf.Add(bs)\n%s}\n%s}\n", ident+ident, s.Name, ident+ident+ident, ident+ident, ident)
+	}
+	fmt.Fprintf(out, "%sf.Fuzz(func(t *testing.T, bs []byte) {\n", ident)
+	fmt.Fprintf(out, "%sdec := enkodo.NewDecoder(bytes.NewReader(bs))\n", ident+ident)
+	fmt.Fprintf(out, "%sdec.LimitBudget(%s + len(bs))\n", ident+ident, fuzzBudgetBase)
+	fmt.Fprintf(out, "%svar out %s\n", ident+ident, s.Name)
+	fmt.Fprintf(out, "%s// Most inputs are invalid, which only needs to fail without panicking\n", ident+ident)
+	fmt.Fprintf(out, "%sdec.Decode(&out)\n", ident+ident)
+	fmt.Fprintf(out, "%s})\n}\n\n", ident)
+}
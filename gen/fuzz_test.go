@@ -0,0 +1,69 @@
+package gen
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFuzz(t *testing.T) {
+	defer func() { opts = Options{} }()
+	src := `package fixture
+
+type Node struct {
+	Name     string   ` + "`enkodo:\"\"`" + `
+	Counts   []int    ` + "`enkodo:\"\"`" + `
+	Attrs    map[string]int8 ` + "`enkodo:\"\"`" + `
+	Children []*Node  ` + "`enkodo:\"\"`" + `
+}
+`
+	// Slice lengths far longer than the input, which the budget of the targets rejects
+	// without -budget, and negative ones
+	seed := func(bs string) string { return "go test fuzz v1\n[]byte(" + strconv.Quote(bs) + ")\n" }
+	for name, o := range map[string]Options{
+		"fuzz":           {Fuzz: true},
+		"tests":          {Fuzz: true, Tests: true},
+		"combined_tests": {Fuzz: true, Tests: true, Combined: true},
+		"generics":       {Fuzz: true, Generics: true},
+		"budget":         {Fuzz: true, Budget: 1 << 30},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts = o
+			dir := writeModule(t, map[string]string{
+				"fixture.go":                               src,
+				"testdata/fuzz/FuzzUnmarshalNode/long":     seed("\x00\xff\xff\xff\xff\x0f"),
+				"testdata/fuzz/FuzzUnmarshalNode/map":      seed("\x00\x00\xff\xff\xff\xff\x0f"),
+				"testdata/fuzz/FuzzUnmarshalNode/negative": seed("\x00\xff\xff\xff\xff\xff\xff\xff\xff\xff\x01"),
+			})
+			if err := GeneratePath(dir); err != nil {
+				t.Fatal(err)
+			}
+			test := filepath.Join(dir, "fixture_enkodo_test.go")
+			if o.Combined {
+				test = filepath.Join(dir, "enkodo_gen_test.go")
+			}
+			data, err := os.ReadFile(test)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []string{"func FuzzUnmarshalNode(f *testing.F)", "dec.LimitBudget(1<<10 + len(bs))"}
+			if o.Tests {
+				expected = append(expected, "enkodo.Marshal(enkodoRandomNode(r, 0))", "func TestNode_EnkodoRoundTrip(t *testing.T)")
+			}
+			for _, e := range expected {
+				if !strings.Contains(string(data), e) {
+					t.Fatalf("expected %q in the fuzz targets, received:\n%s", e, data)
+				}
+			}
+			// go test runs the fuzz targets over the seeds without fuzzing
+			testModule(t, dir)
+		})
+	}
+
+	opts = Options{Fuzz: true, IncludeTests: true}
+	if err := GeneratePath(t.TempDir()); err == nil || !strings.Contains(err.Error(), "-include-tests") {
+		t.Fatalf("expected -fuzz to conflict with -include-tests, received %v", err)
+	}
+}
@@ -0,0 +1,3967 @@
+package gen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const packageName = "github.com/nullmonk/enkodo"
+
+// Used to find enkodo tags in struct tags that are not in the conventional format, with
+// the value single or double quoted and spaces around the colon. The key starts the tag or
+// follows a space, so keys ending in enkodo, e.g. myenkodo, are not read
+var tag = regexp.MustCompile(`(?:^|\s)enkodo\s*:\s*(?:"([^"]*)"|'([^']*)')`)
+
+// This is all the types we know about. If you need more, make a new TypeConverter.
+// See Error type converter as an example
+var enc_types_advanced = map[string]TypeConverter{
+	"uint":    NewBasicTypeConverter("uint", "Uint"),
+	"uint8":   NewBasicTypeConverter("uint8", "Uint8"),
+	"uint16":  NewBasicTypeConverter("uint16", "Uint16"),
+	"uint32":  NewBasicTypeConverter("uint32", "Uint32"),
+	"uint64":  NewBasicTypeConverter("uint64", "Uint64"),
+	"int":     NewBasicTypeConverter("int", "Int"),
+	"int8":    NewBasicTypeConverter("int8", "Int8"),
+	"int16":   NewBasicTypeConverter("int16", "Int16"),
+	"int32":   NewBasicTypeConverter("int32", "Int32"),
+	"int64":   NewBasicTypeConverter("int64", "Int64"),
+	"float32": NewBasicTypeConverter("float32", "Float32"),
+	"float64": NewBasicTypeConverter("float64", "Float64"),
+	"string":  NewBasicTypeConverter("string", "String"),
+	"bool":    NewBasicTypeConverter("bool", "Bool"),
+	"[]byte":  NewBasicTypeConverter("[]byte", "Bytes"),
+	"error":   &ErrorTypeConverter{},
+	// Qualified types
+	"time.Time":     &TimeTypeConverter{},
+	"time.Duration": &DurationTypeConverter{},
+	// Network addresses are written as their binary form, see enkodo.Encoder.IP
+	"net.IP":         NewBasicTypeConverter("net.IP", "IP"),
+	"netip.Addr":     NewBasicTypeConverter("netip.Addr", "Addr"),
+	"netip.AddrPort": NewBasicTypeConverter("netip.AddrPort", "AddrPort"),
+	// github.com/google/uuid, written as its 16 bytes
+	"uuid.UUID": NewBasicTypeConverter("uuid.UUID", "UUID"),
+	// Arbitrary precision numbers are written as their GobEncode, nil as no bytes
+	"*big.Int":   NewBasicTypeConverter("*big.Int", "BigInt"),
+	"*big.Float": NewBasicTypeConverter("*big.Float", "BigFloat"),
+}
+
+// Encoder methods writing network addresses as bytes, which other languages read as
+// such
+var addrMethods = map[string]bool{"IP": true, "Addr": true, "AddrPort": true}
+
+const ident = "\t"
+
+// converter returns the TypeConverter used for the go type typ
+func converter(typ string) (TypeConverter, bool) {
+	if typ == "error" && opts.ErrorCodes {
+		return &CodedErrorTypeConverter{}, true
+	}
+	zigzag := opts.FormatVersion >= 2
+	if (typ == "int" || typ == "uint") && opts.Strict {
+		return &IntWidthTypeConverter{goName: typ, zigzag: zigzag && typ == "int"}, true
+	}
+	switch typ {
+	case "int", "int16", "int32", "int64":
+		if zigzag {
+			return &ZigZagTypeConverter{typ}, true
+		}
+	case "time.Duration":
+		if zigzag {
+			return &DurationTypeConverter{zigzag: true}, true
+		}
+	}
+	conv, ok := enc_types_advanced[typ]
+	return conv, ok
+}
+
+// FormatVersions are the wire formats that can be generated with -format-version. Each
+// version changes the runtime functions some types are encoded with, so code generated
+// for one version can only decode values encoded by code of the same version
+var FormatVersions = map[int]string{
+	1: "integers as varints, with signed values as their two's complement",
+	2: "signed integers other than int8 zigzag encoded, so small negative values stay small",
+}
+
+// checkOptions returns an error for options that can not be generated
+func checkOptions() error {
+	if _, ok := FormatVersions[opts.FormatVersion]; !ok && opts.FormatVersion != 0 {
+		return fmt.Errorf("unsupported format version %d", opts.FormatVersion)
+	}
+	if opts.Tests && opts.IncludeTests {
+		return errors.New("-tests writes the tests of file.go to file_enkodo_test.go, where -include-tests generates the code of file_test.go")
+	}
+	if opts.Fuzz && opts.IncludeTests {
+		return errors.New("-fuzz writes the fuzz targets of file.go to file_enkodo_test.go, where -include-tests generates the code of file_test.go")
+	}
+	if opts.Check && opts.Stdout {
+		return errors.New("-check compares generated files, it can not write them to stdout")
+	}
+	if opts.Diff && opts.Stdout {
+		return errors.New("-diff compares generated files, it can not write them to stdout")
+	}
+	for _, pattern := range opts.Exclude {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid -exclude pattern %q: %w", pattern, err)
+		}
+	}
+	if _, ok := decoderLanguages[opts.Lang]; !ok && opts.Lang != "" && opts.Lang != "go" {
+		return fmt.Errorf("unsupported language %s", opts.Lang)
+	}
+	if decoderLanguages[opts.Lang] != nil && opts.Output != "" && !outputIsDir() {
+		return fmt.Errorf("-lang=%s writes a module per package, the output must be a directory", opts.Lang)
+	}
+	return nil
+}
+
+// fieldConverter returns the TypeConverter used for a field of type typ, taking the
+// options in the field's tag into account
+func fieldConverter(field Field, typ string) (TypeConverter, bool) {
+	if field.length {
+		// Lengths are written with enc.Int whatever the format version
+		if opts.Strict {
+			return &IntWidthTypeConverter{goName: "int"}, true
+		}
+		return enc_types_advanced["int"], true
+	}
+	if _, ok := field.Opts["zigzag"]; ok {
+		switch typ {
+		case "int", "int8", "int16", "int32", "int64":
+			return &ZigZagTypeConverter{typ}, true
+		}
+	}
+	return converter(typ)
+}
+
+// Written at the top of every generated file
+const generatedHeader = "/* This file is auto-generated by enkodo */"
+
+// Helpers emitted once per file in generics mode
+const genericHelpers = `func enkodoEncodeSlice[T any](enc *enkodo.Encoder, s []T, fn func(*enkodo.Encoder, T) error) (err error) {
+	if err = enc.Int(len(s)); err != nil {
+		return
+	}
+	for _, v := range s {
+		if err = fn(enc, v); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func enkodoDecodeSlice[T any](dec *enkodo.Decoder, s *[]T, fn func(*enkodo.Decoder) (T, error)) (err error) {
+	return enkodoDecodeSliceInto(dec, s, func(dec *enkodo.Decoder, t *T) (err error) {
+		*t, err = fn(dec)
+		return
+	})
+}
+
+func enkodoDecodeSliceInto[T any](dec *enkodo.Decoder, s *[]T, fn func(*enkodo.Decoder, *T) error) (err error) {
+	var n int
+	if n, err = dec.Int(); err != nil {
+		return
+	}
+	if n < 0 {
+		return enkodo.ErrInvalidLength
+	}
+	*s = make([]T, n)
+	for i := range *s {
+		if err = fn(dec, &(*s)[i]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+`
+
+// Options controls how code is generated
+type Options struct {
+	// Maximum nesting depth allowed when decoding recursive structs, 0 is unlimited
+	MaxDepth int
+	// Bytes of strings and byte slices, and elements of slices and maps, each value
+	// decoded may allocate in total, 0 is unlimited. See enkodo.Decoder.LimitBudget
+	Budget int
+	// Use generic helper functions for slices instead of inlining each loop
+	Generics bool
+	// Encode errors with their registered code so they decode to the same error
+	ErrorCodes bool
+	// Generate all the files of a package together, split into files of at most this
+	// many structs. 0 generates a file per source file
+	MaxStructs int
+	// Also generate MarshalMsgpack/UnmarshalMsgpack for the fields MessagePack supports
+	Msgpack bool
+	// Declare EnkodoMarshaler/EnkodoUnmarshaler in the generated package and assert
+	// that every generated struct implements them
+	EmitInterfaces bool
+	// Also generate exported GetField/SetField methods for each encoded field, unless
+	// the struct already has a method or field of that name
+	EmitAccessors bool
+	// Declare EnkodoEnabled in the generated package, and have the generated enkodo
+	// methods return enkodo.ErrDisabled while it is false
+	Gated bool
+	// Log the AST nodes of field types the generator can not resolve, to debug them
+	DumpAST bool
+	// File of text/template definitions parsed over the built-in method templates, to
+	// redefine their blocks, e.g. gate or depth. See template.go
+	Template string
+	// Write slice lengths plus one, with 0 for nil, so nil and empty slices decode as
+	// they were encoded
+	NilSlice bool
+	// Prefix the fields of each struct with their length, so decoders generated before
+	// fields were appended skip them rather than failing
+	Framed bool
+	// The wire format to generate for, one of FormatVersions. 0 is version 1
+	FormatVersion int
+	// Also generate UnmarshalEnkodoN, which reports the number of bytes decoded
+	CountBytes bool
+	// Also generate EncodedSize, which reports the number of bytes MarshalEnkodo writes,
+	// to size buffers and length prefixes before encoding
+	EncodedSize bool
+	// Also generate MarshalBinary and UnmarshalBinary wrapping the enkodo methods, so the
+	// structs implement encoding.BinaryMarshaler and encoding.BinaryUnmarshaler
+	Binary bool
+	// Record the field order of each struct in enkodo.manifest and fail when a later
+	// run finds the fields reordered, rather than only appended to
+	StrictOrder bool
+	// Accept reordered fields under StrictOrder, updating the manifest
+	AllowReorder bool
+	// Also generate for the structs in _test.go files, into _enkodo_test.go files
+	IncludeTests bool
+	// Decode int and uint as 64-bit values and return an error when they do not fit
+	// in the platform's int. Tagged fields of unsupported types, and helper methods
+	// that collide with a struct's own methods, are also an error rather than a warning,
+	// each listed with its file:line
+	Strict bool
+	// Write the generated files to stdout instead of next to their source
+	Stdout bool
+	// Where to report the files written, e.g. os.Stdout as the command does. Nothing is
+	// reported when it is nil
+	Log io.Writer
+	// Encode fields of types without enkodo methods that implement json.Marshaler and
+	// json.Unmarshaler as JSON bytes. This goes through encoding/json, so costs far
+	// more than enkodo encoding in both time and size
+	JSONFallback bool
+	// Also write benchmarks comparing enkodo with encoding/json and encoding/gob for each
+	// struct, into _compare_test.go files
+	Compare bool
+	// Also write benchmarks of marshalling and unmarshalling each struct with enkodo alone,
+	// into _bench_test.go files. Compare writes these along with its baselines
+	Bench bool
+	// Also write tests encoding random values of each struct, decoding them and comparing
+	// the result, into _enkodo_test.go files
+	Tests bool
+	// Also write fuzz targets decoding arbitrary bytes into each struct, failing when
+	// decoding panics, into _enkodo_test.go files. They decode under a budget bounding
+	// what decoding allocates by the size of the input, so the decoders spend it on
+	// slices and maps as with Budget, which only limits them when a budget is set
+	Fuzz bool
+	// Generate for every exported field of every exported struct, tagged or not. Fields
+	// tagged enkodo:"-" are left out
+	All bool
+	// Glob patterns of the files and directories under the path not to generate for,
+	// matched against their path relative to it and against their name, e.g. vendor,
+	// internal/legacy or *_mock.go
+	Exclude []string
+	// Types not to generate for, matched by name in every package, e.g. ^Internal for
+	// vendored or legacy structs. Fields holding them are skipped with a warning
+	ExcludeType *regexp.Regexp
+	// Fields not to encode, matched by name in every struct, as if tagged enkodo:"-"
+	ExcludeField *regexp.Regexp
+	// Only generate the files declaring these structs, leaving the rest untouched. The
+	// files are regenerated whole, so their other structs keep their methods
+	Types []string
+	// Where to write the generated code instead of next to each source file. A .go file
+	// gets the code of every struct in its package, and a bare file name is put in the
+	// directory of each package. Anything else is a directory the generated files are
+	// written to, under the names they would have had
+	Output string
+	// Write the code of every struct in a package to a single enkodo_gen.go, in the
+	// package directory or the directory named by Output
+	Combined bool
+	// Compare the generated code with the files on disk instead of writing it, making
+	// GeneratePath return a StaleError with their differences when any are out of date
+	Check bool
+	// Packages type checked at once before generating, 0 for one per CPU
+	Jobs int
+	// Regenerate packages whose generated files record the hash of their current sources,
+	// which are otherwise left as they are
+	Force bool
+	// Write a unified diff of the changes to the generated files to stdout instead of
+	// writing them, to preview what regenerating would do. Check takes precedence
+	Diff bool
+	// Import path of the enkodo runtime the generated code uses, for forks, vendored
+	// copies or mirrors of it. Defaults to github.com/nullmonk/enkodo
+	Runtime string
+	// Language to generate, go, or python, typescript or javascript for a module per
+	// package decoding the structs in it. Defaults to go
+	Lang string
+}
+
+var opts Options
+
+// stateMu is held by the functions of the package while they generate, as they do it with
+// package wide state: the options, the registered converters and the types found. Calls
+// from several goroutines run one at a time
+var stateMu sync.Mutex
+
+// SetOptions sets the options used by the following calls to generate code
+func SetOptions(o Options) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	opts = o
+}
+
+// logf reports progress to Options.Log, when it is set
+func logf(format string, args ...interface{}) {
+	if opts.Log != nil {
+		fmt.Fprintf(opts.Log, format, args...)
+	}
+}
+
+// RegisterConverter registers c as the TypeConverter for the go type name, replacing
+// any converter already registered for it
+func RegisterConverter(name string, c TypeConverter) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	enc_types_advanced[name] = c
+}
+
+// Packages (by directory and package name, as external tests share the directory)
+// that already had the package wide declarations written this run
+var declaredInterfaces = map[string]bool{}
+
+const interfacesDecl = `// EnkodoMarshaler is implemented by types that can encode themselves with enkodo
+type EnkodoMarshaler interface {
+	MarshalEnkodo(*enkodo.Encoder) error
+}
+
+// EnkodoUnmarshaler is implemented by types that can decode themselves with enkodo
+type EnkodoUnmarshaler interface {
+	UnmarshalEnkodo(*enkodo.Decoder) error
+}
+
+`
+
+// Declared once per package with -gated
+const gateDecl = `// EnkodoEnabled gates the generated enkodo methods, which return enkodo.ErrDisabled
+// while it is false
+var EnkodoEnabled = true
+
+`
+
+// Named types declared in the file being generated mapped to their underlying basic
+// type, e.g. type StatusCode int
+var namedTypes = map[string]string{}
+
+// Named slices and maps of the package being generated that enkodo methods are generated
+// for mapped to their underlying type, e.g. type IDList []uint64, see addCollections
+var collections = map[string]string{}
+
+// Exported types brought into the file being generated by dot imports, mapped to their
+// qualified name (e.g. Time -> time.Time for import . "time")
+var dotImports = map[string]string{}
+
+// Import paths of the dot imported packages, by package name. The generated file
+// imports them normally as it refers to their types by qualified name
+var dotImportPaths = map[string]string{}
+
+// Doc comments of the type specs in the file being generated. A type declared on its
+// own has its comment on the GenDecl rather than the spec
+var typeDocs = map[*ast.TypeSpec]*ast.CommentGroup{}
+
+// Type information for the package being generated, nil when it could not be loaded
+var pkgTypes *types.Package
+
+// Positions of the file being generated, for errors
+var fileSet *token.FileSet
+
+// checkedPackage is the type information loaded for a package, with the files it was
+// checked from
+type checkedPackage struct {
+	types *types.Package
+	files []*ast.File
+}
+
+// Type information already loaded this run, by directory. loadPackages fills it from
+// several goroutines
+var (
+	checkedPackages = map[string]checkedPackage{}
+	checkedMu       sync.Mutex
+)
+
+// sourceFallback imports packages from their export data, falling back to type checking
+// their source for packages without any, such as the other packages of the module
+type sourceFallback struct {
+	gc, source types.ImporterFrom
+}
+
+func (i sourceFallback) Import(path string) (*types.Package, error) {
+	return i.ImportFrom(path, "", 0)
+}
+
+func (i sourceFallback) ImportFrom(path, dir string, mode types.ImportMode) (*types.Package, error) {
+	if p, err := i.gc.ImportFrom(path, dir, mode); err == nil {
+		return p, nil
+	}
+	return i.source.ImportFrom(path, dir, mode)
+}
+
+// importerFor returns the importer for the packages imported by the package in dir. Its
+// source importer finds the module through a copy of build.Default pointed at the module
+// of dir, so packages of different modules can be checked at once, and a new one is made
+// each time as it caches what it loads
+func importerFor(dir string) types.ImporterFrom {
+	ctx := build.Default
+	if abs, err := filepath.Abs(moduleDir(dir)); err == nil {
+		ctx.Dir = abs
+	}
+	return sourceFallback{
+		gc:     importer.Default().(types.ImporterFrom),
+		source: &sourceImporter{ctx: ctx, fset: token.NewFileSet(), packages: make(map[string]*types.Package)},
+	}
+}
+
+// sourceImporter type checks the source of the packages it imports, which it finds with
+// ctx. Unlike the source importer of go/importer, which always uses build.Default, each
+// has its own build.Context. Errors are ignored as they are by typeCheck
+type sourceImporter struct {
+	ctx      build.Context
+	fset     *token.FileSet
+	packages map[string]*types.Package
+}
+
+func (i *sourceImporter) Import(path string) (*types.Package, error) {
+	return i.ImportFrom(path, "", 0)
+}
+
+func (i *sourceImporter) ImportFrom(path, dir string, mode types.ImportMode) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+	// The context has a Dir, so the directory imported from must be absolute
+	if dir != "" {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+	}
+	bp, err := i.ctx.Import(path, dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	if p, ok := i.packages[bp.ImportPath]; ok {
+		if p == nil {
+			return nil, fmt.Errorf("import cycle through package %s", bp.ImportPath)
+		}
+		return p, nil
+	}
+
+	i.packages[bp.ImportPath] = nil // Imported while it is checked only through a cycle
+	files := make([]*ast.File, 0, len(bp.GoFiles)+len(bp.CgoFiles))
+	for _, name := range append(bp.GoFiles, bp.CgoFiles...) {
+		fil, err := parser.ParseFile(i.fset, filepath.Join(bp.Dir, name), nil, 0)
+		if err != nil {
+			delete(i.packages, bp.ImportPath)
+			return nil, err
+		}
+		files = append(files, fil)
+	}
+	conf := types.Config{Importer: i, FakeImportC: true, Error: func(error) {}}
+	p, _ := conf.Check(bp.ImportPath, i.fset, files, nil)
+	i.packages[bp.ImportPath] = p
+	return p, nil
+}
+
+type TypeConverter interface {
+	// Name of the golang type for this converter
+	Name() string
+	// Name of the enkodo function used to encode it.
+	EnkodoFunction() string
+	// Take the value (e.g. struct.field) and return and modifications
+	// (e.g. struct.field.String()) to get passed to EnkodoFunction.
+	// must match the INPUT type of the EnkodoFunction
+	Enc(val string) string
+	// This code take a value v (output from EnkodoFunction) and converts it to Name()
+	//
+	// return nothing to just use the raw value of EnkodoFunc (e.g. Name = "string"
+	// and EnkodoFunc = "enkodo.String()")
+	//
+	// val, _ = enkodo.String()
+	// struct.Field = CustomType(val)
+	Dec(val string) string
+	// These packages must be imported to use this advanced type, ensure are included at the top
+	Imports() []string
+}
+
+type ErrorTypeConverter struct{}
+
+func (e *ErrorTypeConverter) Name() string {
+	return "error"
+}
+
+func (e *ErrorTypeConverter) EnkodoFunction() string {
+	return "String"
+}
+
+func (e *ErrorTypeConverter) Enc(val string) string {
+	return fmt.Sprintf("%s.Error()", val)
+}
+
+func (e *ErrorTypeConverter) Dec(val string) string {
+	return fmt.Sprintf("errors.New(%s)", val)
+}
+
+func (e *ErrorTypeConverter) Imports() []string {
+	return []string{"errors"}
+}
+
+// DurationTypeConverter encodes time.Duration as its int64 nanoseconds, zigzag encoded
+// from format version 2
+type DurationTypeConverter struct {
+	zigzag bool
+}
+
+func (d *DurationTypeConverter) Name() string {
+	return "time.Duration"
+}
+
+func (d *DurationTypeConverter) EnkodoFunction() string {
+	if d.zigzag {
+		return "ZigZag"
+	}
+	return "Int64"
+}
+
+func (d *DurationTypeConverter) Enc(val string) string {
+	return fmt.Sprintf("int64(%s)", val)
+}
+
+func (d *DurationTypeConverter) Dec(val string) string {
+	return fmt.Sprintf("time.Duration(%s)", val)
+}
+
+func (d *DurationTypeConverter) Imports() []string {
+	return []string{"time"}
+}
+
+// CodedErrorTypeConverter encodes errors as a code and message using the enkodo error
+// registry, so registered errors keep their identity when decoded
+type CodedErrorTypeConverter struct{}
+
+func (e *CodedErrorTypeConverter) Name() string {
+	return "error"
+}
+
+func (e *CodedErrorTypeConverter) EnkodoFunction() string {
+	return "Error"
+}
+
+func (e *CodedErrorTypeConverter) Enc(val string) string {
+	return val
+}
+
+func (e *CodedErrorTypeConverter) Dec(val string) string {
+	return "" // dec.Error() already returns an error
+}
+
+func (e *CodedErrorTypeConverter) Imports() []string {
+	return nil
+}
+
+// ZigZagTypeConverter encodes signed integers with zigzag encoding so small negative
+// values stay small on the wire. Used for fields tagged enkodo:"int,zigzag"
+type ZigZagTypeConverter struct {
+	goName string
+}
+
+func (z *ZigZagTypeConverter) Name() string {
+	return z.goName
+}
+
+func (z *ZigZagTypeConverter) EnkodoFunction() string {
+	return "ZigZag"
+}
+
+func (z *ZigZagTypeConverter) Enc(val string) string {
+	return fmt.Sprintf("int64(%s)", val)
+}
+
+func (z *ZigZagTypeConverter) Dec(val string) string {
+	return fmt.Sprintf("%s(%s)", z.goName, val)
+}
+
+func (z *ZigZagTypeConverter) Imports() []string {
+	return nil
+}
+
+// RangeChecker is implemented by TypeConverters whose decoded values may not fit in
+// the go type, the check is written before the value is converted
+type RangeChecker interface {
+	// OutOfRange returns a condition that is true when val does not fit
+	OutOfRange(val string) string
+}
+
+// IntWidthTypeConverter encodes int and uint as 64-bit values, and checks they fit in the
+// platform's int when decoding. Used under -strict
+type IntWidthTypeConverter struct {
+	goName string
+	// Encode the int zigzagged, for format version 2
+	zigzag bool
+}
+
+func (i *IntWidthTypeConverter) Name() string {
+	return i.goName
+}
+
+func (i *IntWidthTypeConverter) EnkodoFunction() string {
+	if i.goName == "uint" {
+		return "Uint64"
+	}
+	if i.zigzag {
+		return "ZigZag"
+	}
+	return "Int64"
+}
+
+func (i *IntWidthTypeConverter) Enc(val string) string {
+	return fmt.Sprintf("%s64(%s)", i.goName, val)
+}
+
+func (i *IntWidthTypeConverter) Dec(val string) string {
+	return fmt.Sprintf("%s(%s)", i.goName, val)
+}
+
+func (i *IntWidthTypeConverter) OutOfRange(val string) string {
+	if i.goName == "uint" {
+		return fmt.Sprintf("%s > math.MaxUint", val)
+	}
+	return fmt.Sprintf("%s < math.MinInt || %s > math.MaxInt", val, val)
+}
+
+func (i *IntWidthTypeConverter) Imports() []string {
+	return []string{"math"}
+}
+
+type BasicTypeConverter struct {
+	goName  string
+	enkFunc string
+}
+
+func NewBasicTypeConverter(gotype, enkodoFunction string) *BasicTypeConverter {
+	return &BasicTypeConverter{
+		goName:  gotype,
+		enkFunc: enkodoFunction,
+	}
+}
+
+func (b *BasicTypeConverter) Name() string {
+	return b.goName
+}
+
+func (b *BasicTypeConverter) EnkodoFunction() string {
+	return b.enkFunc
+}
+
+func (b *BasicTypeConverter) Enc(val string) string {
+	return val // Use as is
+}
+
+func (b *BasicTypeConverter) Dec(val string) string {
+	return "" // Not mods needed, assumes enkFunc returns goName
+}
+
+func (b *BasicTypeConverter) Imports() []string {
+	return nil // Does not need to import anything
+}
+
+// A field on a struct, has a field name, go type, optional override type and any
+// options given in the enkodo tag
+type Field struct {
+	Name         string
+	Type         string
+	OverrideType string
+	Opts         map[string]string
+	// Methods used to read and write the value instead of the field itself, set for
+	// fields declared with an //enkodo:accessor directive
+	Getter string
+	Setter string
+	// Set when the setter returns an error
+	SetterErr bool
+	// Set when the field is encoded as JSON by -json-fallback
+	JSON bool
+
+	// Set for the length of a slice or map being decoded
+	length bool
+	// Name of the field in errors when it is decoded into a temporary, see fieldLabel
+	label string
+}
+
+// A struct has a name, and lots of fields
+type Struct struct {
+	Name   string
+	Fields []Field
+	// Set when the struct references itself, directly or through other structs
+	Recursive bool
+	// Version declared by an //enkodo:version directive, 0 when unversioned
+	Version int
+	// Version written before the fields when some are tagged since or until, 0 when
+	// none are, see fieldVersion
+	FieldVersion int
+	// Half of the enkodo methods an //enkodo:only directive limits the struct to, encode
+	// or decode, empty for both
+	Only string
+	// Set when the fields are prefixed with their length, by -framed or the framed
+	// option of an //enkodo:options directive
+	Framed bool
+	// Maximum nesting depth decoded and bytes each decoded value may allocate, from
+	// -maxdepth and -budget or an //enkodo:options directive, 0 when unlimited
+	MaxDepth int
+	Budget   int
+	// Underlying type of a named slice or map, e.g. []uint64 for type IDList []uint64,
+	// which is encoded as that type instead of as fields. Empty for structs
+	Collection string
+	// Flags whose helper methods are not generated for the struct, as they collide
+	// with its own methods, and encode or decode for enkodo methods that are not
+	skip map[string]bool
+
+	_declared   map[string]string
+	_hasLoopVar bool
+	// Set when the length of the field being decoded was checked against its maxlen
+	// before it was allocated
+	_lenChecked bool
+}
+
+func (s *Struct) String() string {
+	return fmt.Sprintf("%s: %v", s.Name, s.Fields)
+}
+
+// codecFields returns the fields the enkodo methods of s encode, for a named slice or map
+// the value of its underlying type
+func (s *Struct) codecFields() []Field {
+	if s.Collection != "" {
+		return []Field{{Type: s.Collection}}
+	}
+	return s.Fields
+}
+
+// EncodeFunc writes MarshalEnkodo for the struct
+func (s *Struct) EncodeFunc(f io.Writer) error {
+	return s.execute(f, "encode")
+}
+
+// encodeFields writes the code encoding each field, the body of MarshalEnkodo
+func (s *Struct) encodeFields(f io.Writer) error {
+	s._declared = make(map[string]string)
+	fnRef := strings.ToLower(s.Name[0:1])
+	if s.Collection != "" {
+		s.EncodeField(1, Field{Name: "*" + fnRef, Type: s.Collection}, f)
+		return nil
+	}
+	present := s.presenceBits()
+	if s.FieldVersion > 0 {
+		// Decoders read the fields of the version they were written with
+		fmt.Fprintf(f, "%senc.Uint8(%d)\n", ident, s.FieldVersion)
+	}
+	written := slices.ContainsFunc(s.Fields, func(field Field) bool {
+		_, ok := present[field.Name]
+		return ok && writtenIn(field, s.FieldVersion)
+	})
+	if written {
+		// A bit for each omitempty field says if it is set, and so written
+		fmt.Fprintf(f, "%svar _present uint64\n", ident)
+		for _, field := range s.Fields {
+			if bit, ok := present[field.Name]; ok && writtenIn(field, s.FieldVersion) {
+				fmt.Fprintf(f, "%sif %s {\n", ident, nonZero(fnRef+"."+field.Name, field.Type))
+				fmt.Fprintf(f, "%s_present |= 1 << %d\n%s}\n", ident+ident, bit, ident)
+			}
+		}
+		fmt.Fprintf(f, "%senc.Uint64(_present)\n", ident)
+	}
+	for _, field := range s.Fields {
+		if !writtenIn(field, s.FieldVersion) {
+			continue // Removed, it is only decoded from older versions
+		}
+		code := bytes.NewBuffer(nil)
+		s.encodeStructField(field, fnRef, present, code)
+		if err := s.executeField(f, "encodeField", field, code.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeStructField writes the code encoding field in MarshalEnkodo, with the bits of
+// the omitempty fields in present
+func (s *Struct) encodeStructField(field Field, fnRef string, present map[string]int, f io.Writer) {
+	s.checkMaxLen(field, fnRef, f)
+	bit, omit := present[field.Name]
+	if field.Getter != "" {
+		field.Name = fnRef + "." + field.Getter + "()"
+	} else {
+		field.Name = fnRef + "." + field.Name
+	}
+	if omit {
+		fmt.Fprintf(f, "%sif _present&(1<<%d) != 0 {\n", ident, bit)
+		s.EncodeField(2, field, f)
+		fmt.Fprintf(f, "%s}\n", ident)
+		return
+	}
+	if flag, ok := field.Opts["presentif"]; ok {
+		// Only encode the value when the flag is set, after a byte saying if it was unless
+		// the flag was written as a field already
+		if !s.flagWritten(field) {
+			fmt.Fprintf(f, "%senc.Bool(%s.%s)\n", ident, fnRef, flag)
+		}
+		fmt.Fprintf(f, "%sif %s.%s {\n", ident, fnRef, flag)
+		s.EncodeField(2, field, f)
+		fmt.Fprintf(f, "%s}\n", ident)
+		return
+	}
+	s.EncodeField(1, field, f)
+}
+
+// flagWritten reports whether the presentif flag of field is a field of s written before
+// it, in every version and always decoded into the struct, so the flag decoded already
+// says if field is written instead of a byte of its own
+func (s *Struct) flagWritten(field Field) bool {
+	flag := field.Opts["presentif"]
+	present := s.presenceBits()
+	for _, f := range s.Fields {
+		if f.Name == field.Name {
+			return false
+		}
+		if f.Name == flag {
+			_, omit := present[f.Name]
+			_, gated := f.Opts["presentif"]
+			return !omit && !gated && f.Getter == "" && f.Setter == "" && versionCond(f) == ""
+		}
+	}
+	return false
+}
+
+// DecodeFunc writes UnmarshalEnkodo for the struct
+func (s *Struct) DecodeFunc(f io.Writer) error {
+	return s.execute(f, "decode")
+}
+
+// decodeFields writes the code decoding each field, the body of UnmarshalEnkodo
+func (s *Struct) decodeFields(f io.Writer) error {
+	fnRef := strings.ToLower(s.Name[0:1])
+	if s.Collection != "" {
+		// Decoded as the underlying type, then converted to the named one
+		s.DecodeField(1, Field{Name: "*" + fnRef, Type: s.Name, OverrideType: s.Collection}, f)
+		return nil
+	}
+	present := s.presenceBits()
+	if len(s.Fields) > 0 {
+		// Errors are wrapped with the field being decoded when they happened
+		fmt.Fprintf(f, "%svar _decoding string\n", ident)
+		fmt.Fprintf(f, "%sdefer func() {\n%sif err != nil && _decoding != \"\" {\n", ident, ident+ident)
+		fmt.Fprintf(f, "%serr = &enkodo.FieldDecodeError{Struct: %q, Field: _decoding, Err: err}\n", ident+ident+ident, s.Name)
+		fmt.Fprintf(f, "%s}\n%s}()\n", ident+ident, ident)
+	}
+	if s.FieldVersion > 0 {
+		fmt.Fprintf(f, "%svar _version uint8\n", ident)
+		fmt.Fprintf(f, "%sif _version, err = dec.Uint8(); err != nil {\n%sreturn\n%s}\n", ident, ident+ident, ident)
+		fmt.Fprintf(f, "%sif _version > %d {\n", ident, s.FieldVersion)
+		fmt.Fprintf(f, "%sreturn &enkodo.VersionError{Type: %q, Version: int(_version), Latest: %d}\n%s}\n", ident+ident, s.Name, s.FieldVersion, ident)
+	}
+	if cond, ok := anyVersionRange("_version", " && ", " || ", s.presenceVersions()); ok {
+		// Only the versions with omitempty fields write their bitmask
+		read := fmt.Sprintf("if _present, err = dec.Uint64(); err != nil {\n%sreturn\n}\n", ident)
+		if cond != "" {
+			read = fmt.Sprintf("if %s {\n%s}\n", cond, indent(read))
+		}
+		fmt.Fprintf(f, "%svar _present uint64\n%s", ident, indent(read))
+	}
+	for _, field := range s.Fields {
+		fmt.Fprintf(f, "%s_decoding = %q\n", ident, field.Name)
+		code := bytes.NewBuffer(nil)
+		if cond := versionCond(field); cond == "" {
+			s.decodeStructField(field, fnRef, present, code)
+		} else {
+			// Fields the version was written without are left as their zero value
+			fmt.Fprintf(code, "%sif %s {\n", ident, cond)
+			buf := bytes.NewBuffer(nil)
+			s.scoped(func() { s.decodeStructField(field, fnRef, present, buf) })
+			fmt.Fprintf(code, "%s%s}\n", indent(buf.String()), ident)
+		}
+		if err := s.executeField(f, "decodeField", field, code.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indent indents each line of code by one more level
+func indent(code string) string {
+	lines := strings.SplitAfter(code, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			lines[i] = ident + line
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// decodeStructField writes the code decoding field in UnmarshalEnkodo, with the bits of
+// the omitempty fields in present
+func (s *Struct) decodeStructField(field Field, fnRef string, present map[string]int, f io.Writer) {
+	if field.Setter != "" {
+		s.decodeAccessor(field, fnRef, f)
+		return
+	}
+	name := field.Name
+	field.Name = fnRef + "." + field.Name
+	s._lenChecked = false
+	if bit, ok := present[name]; ok {
+		fmt.Fprintf(f, "%sif _present&(1<<%d) != 0 {\n", ident, bit)
+		s.scoped(func() { s.DecodeField(2, field, f) })
+		fmt.Fprintf(f, "%s}\n", ident)
+	} else if flag, ok := field.Opts["presentif"]; ok {
+		if !s.flagWritten(field) {
+			fmt.Fprintf(f, "%sif %s.%s, err = dec.Bool(); err != nil {\n%sreturn\n%s}\n", ident, fnRef, flag, ident+ident, ident)
+		}
+		fmt.Fprintf(f, "%sif %s.%s {\n", ident, fnRef, flag)
+		s.scoped(func() { s.DecodeField(2, field, f) })
+		fmt.Fprintf(f, "%s}\n", ident)
+	} else {
+		s.DecodeField(1, field, f)
+	}
+	field.Name = name
+	if !s._lenChecked {
+		s.checkMaxLen(field, fnRef, f)
+	}
+}
+
+// DecodeNFunc writes UnmarshalEnkodoN, which decodes the struct and returns the number
+// of bytes it took, so callers can step over concatenated values
+func (s *Struct) DecodeNFunc(f io.Writer) error {
+	return s.execute(f, "decodeN")
+}
+
+// scoped runs fn, which writes code in a nested block. Variables it declares are only
+// in scope within the block so are forgotten afterwards
+func (s *Struct) scoped(fn func()) {
+	declared := make(map[string]string, len(s._declared))
+	for k, v := range s._declared {
+		declared[k] = v
+	}
+	fn()
+	s._declared = declared
+}
+
+// decodeAccessor decodes an accessor field into a temporary and passes it to the setter
+func (s *Struct) decodeAccessor(field Field, fnRef string, f io.Writer) {
+	temp := "_" + field.Name
+	fmt.Fprintf(f, "%svar %s %s\n", ident, temp, field.Type)
+	setter := fmt.Sprintf("%s.%s(%s)", fnRef, field.Setter, temp)
+	field.Name, field.label = temp, s.Name+"."+field.Name
+	s.DecodeField(1, field, f)
+	if field.SetterErr {
+		fmt.Fprintf(f, "%sif err = %s; err != nil {\n%sreturn\n%s}\n", ident, setter, ident+ident, ident)
+	} else {
+		fmt.Fprintf(f, "%s%s\n", ident, setter)
+	}
+}
+
+// fieldLabel returns the name used for a field in errors, e.g. User.Name. Values decoded
+// into temporaries, like slice elements, are labelled with the struct alone
+func (s *Struct) fieldLabel(name string) string {
+	fnRef := strings.ToLower(s.Name[0:1])
+	if field, ok := strings.CutPrefix(name, fnRef+"."); ok {
+		return s.Name + "." + field
+	}
+	return s.Name
+}
+
+// maxLen returns the maxlen in the tag of field, and the name it is reported with when
+// the field is longer, recording that the length is checked as it is decoded. ok is
+// false when it has none
+func (s *Struct) maxLen(field Field) (max, label string, ok bool) {
+	if max, ok = field.Opts["maxlen"]; !ok || field.length {
+		return "", "", false
+	}
+	if label = field.label; label == "" {
+		label = s.fieldLabel(field.Name)
+	}
+	s._lenChecked = true
+	return max, label, true
+}
+
+// checkLen writes a check that the decoded length n is not negative, which make panics
+// on, and no more than the maxlen of field if it has one, before what it is the length
+// of is allocated
+func (s *Struct) checkLen(dent, n string, field Field, f io.Writer) {
+	checkNegative(dent, n, f)
+	s.checkLenMax(dent, n, field, f)
+}
+
+// checkNegative writes a check that the decoded length n is not negative
+func checkNegative(dent, n string, f io.Writer) {
+	fmt.Fprintf(f, "%sif %s < 0 {\n%sreturn enkodo.ErrInvalidLength\n%s}\n", dent, n, dent+ident, dent)
+}
+
+// checkLenMax writes a check that the decoded length n is no more than the maxlen of
+// field, if it has one
+func (s *Struct) checkLenMax(dent, n string, field Field, f io.Writer) {
+	max, label, ok := s.maxLen(field)
+	if !ok {
+		return
+	}
+	fmt.Fprintf(f, "%sif %s > %s {\n", dent, n, max)
+	fmt.Fprintf(f, "%sreturn &enkodo.MaxLengthError{Field: %q, Length: %s, Max: %s}\n%s}\n", dent+ident, label, n, max, dent)
+}
+
+// checkMaxLen writes a check that field is no longer than the maxlen in its tag, if it
+// has one. It is called before a field is encoded, and after it is decoded when its
+// length could not be checked before it was allocated, e.g. for JSON fields
+func (s *Struct) checkMaxLen(field Field, fnRef string, f io.Writer) {
+	max, ok := field.Opts["maxlen"]
+	if !ok {
+		return
+	}
+	val := fnRef + "." + field.Name
+	fmt.Fprintf(f, "%sif len(%s) > %s {\n", ident, val, max)
+	fmt.Fprintf(f, "%sreturn &enkodo.MaxLengthError{Field: \"%s.%s\", Length: len(%s), Max: %s}\n", ident+ident, s.Name, field.Name, val, max)
+	fmt.Fprintf(f, "%s}\n", ident)
+}
+
+func (s *Struct) EncodeField(identCount int, field Field, f io.Writer) (err error) {
+	dent := strings.Repeat(ident, identCount)
+	name := field.Name
+	if encode, _, ok := converterFuncs(field); ok {
+		fmt.Fprintf(f, "%sif err = %s(enc, %s); err != nil {\n", dent, encode, name)
+		fmt.Fprintf(f, "%sreturn\n%s}\n", dent+ident, dent)
+		return
+	}
+	if field.JSON {
+		fmt.Fprintf(f, "%sif bs, err := json.Marshal(&%s); err == nil {\n", dent, name)
+		fmt.Fprintf(f, "%senc.Bytes(bs)\n", dent+ident)
+		fmt.Fprintf(f, "%s} else {\n%sreturn err\n%s}\n", dent, dent+ident, dent)
+		return
+	}
+	if isGzip(field) {
+		if field.Type != "[]byte" && field.Type != "[]uint8" {
+			name = fmt.Sprintf("[]byte(%s)", name)
+		}
+		fmt.Fprintf(f, "%sif err = enkodo.EncodeGzip(enc, %s); err != nil {\n", dent, name)
+		fmt.Fprintf(f, "%sreturn\n%s}\n", dent+ident, dent)
+		return
+	}
+	if isRuns(field) {
+		elem := runsElem(field)
+		fmt.Fprintf(f, "%sif err = enkodo.EncodeRuns(enc, %s, func(v %s) (err error) {\n", dent, name, field.Type[2:])
+		if err := s.EncodeField(identCount+1, elem, f); err != nil {
+			return err
+		}
+		fmt.Fprintf(f, "%sreturn\n%s}); err != nil {\n", dent+ident, dent)
+		fmt.Fprintf(f, "%sreturn\n%s}\n", dent+ident, dent)
+		return
+	}
+	// Named basic types are encoded as their underlying type, as if it were overridden
+	field = resolveNamed(field)
+	// A basic override on a slice, array or map applies to its elements
+	var elemOverride string
+	if isElementOverride(field) {
+		elemOverride, field.OverrideType = field.OverrideType, ""
+	}
+	if field.OverrideType != "" {
+		name = fmt.Sprintf("%s(%s)", field.OverrideType, field.Name)
+		field.Type = field.OverrideType
+	}
+
+	if field.Type == "" || field.Type[0] == '[' && len(field.Type) == 2 {
+		fmt.Fprintf(f, "%s// Do not know what to do with %s (%s)\n", dent, field.Name, field.Type)
+		return
+	}
+
+	if opts.NilSlice && (field.Type == "[]byte" || field.Type == "[]uint8") {
+		writeNilLen(f, dent, name)
+		fmt.Fprintf(f, "%senc.FixedBytes(%s)\n", dent, name)
+		return
+	}
+
+	// Get the TypeConverter for this field type
+	if conv, ok := fieldConverter(field, field.Type); ok {
+		fmt.Fprintf(f, "%senc.%s(%s)\n", dent, conv.EnkodoFunction(), conv.Enc(name))
+		return
+	}
+
+	// Struct values encode through their address, getters return a copy to take it of
+	if isEnkodoStruct(field.Type) {
+		if strings.HasSuffix(name, ")") {
+			fmt.Fprintf(f, "%s{\n%s_v := %s\n", dent, dent+ident, name)
+			encodeStruct(dent+ident, "&_v", f)
+			fmt.Fprintf(f, "%s}\n", dent)
+		} else {
+			encodeStruct(dent, "&"+name, f)
+		}
+		return
+	}
+
+	if entries, ok := registries[field.Type]; ok {
+		encodeRegistered(dent, name, s.fieldLabel(field.Name), entries, f)
+		return
+	}
+
+	// Handle pointers, a presence flag is written first so nil pointers round trip.
+	// Pointers to enkodo structs use their methods, others are written dereferenced
+	if field.Type[0] == '*' {
+		fmt.Fprintf(f, "%senc.Bool(%s != nil)\n", dent, name)
+		fmt.Fprintf(f, "%sif %s != nil {\n", dent, name)
+		if isEnkodoStruct(field.Type[1:]) {
+			encodeStruct(dent+ident, name, f)
+		} else if err := s.EncodeField(identCount+1, resolveNamed(Field{Name: "*" + name, Type: field.Type[1:]}), f); err != nil {
+			return err
+		}
+		fmt.Fprintln(f, dent+"}")
+		return
+	}
+
+	// Handle maps, keys and values are written in pairs after the length
+	if strings.HasPrefix(field.Type, "map[") {
+		key, val := splitMapType(field.Type)
+		fmt.Fprintf(f, "%senc.Int(len(%s))\n", dent, name)
+		fmt.Fprintf(f, "%sfor k, v := range %s {\n", dent, name)
+		if isEnkodoStruct(key) {
+			encodeStruct(dent+ident, "&k", f)
+		} else if err := s.EncodeField(identCount+1, resolveNamed(Field{Name: "k", Type: key}), f); err != nil {
+			return err
+		}
+		if err := s.EncodeField(identCount+1, resolveNamed(Field{Name: "v", Type: val, OverrideType: elemOverride}), f); err != nil {
+			return err
+		}
+		fmt.Fprintln(f, dent+"}")
+		return
+	}
+
+	// Handle fixed size arrays, the length is part of the type so is not written
+	if isFixedArray(field.Type) {
+		_, elem := splitArrayType(field.Type)
+		if (elem == "byte" || elem == "uint8") && elemOverride == "" {
+			fmt.Fprintf(f, "%senc.FixedBytes(%s[:])\n", dent, indexable(name))
+			return
+		}
+		fmt.Fprintf(f, "%sfor _, v := range %s {\n", dent, name)
+		if err := s.EncodeField(identCount+1, Field{Name: "v", Type: elem, OverrideType: elemOverride}, f); err != nil {
+			return err
+		}
+		fmt.Fprintln(f, dent+"}")
+		return
+	}
+
+	// Handle arrays, the generic helpers do not keep nil slices
+	if field.Type[0] == '[' && opts.Generics && !opts.NilSlice {
+		elem := field.Type[2:]
+		// Basic types can pass the encoder method straight to the helper
+		if conv, ok := converter(elem); ok && conv.Enc("v") == "v" && elemOverride == "" {
+			fmt.Fprintf(f, "%senkodoEncodeSlice(enc, %s, (*enkodo.Encoder).%s)\n", dent, name, conv.EnkodoFunction())
+			return
+		}
+		fmt.Fprintf(f, "%senkodoEncodeSlice(enc, %s, func(enc *enkodo.Encoder, v %s) (err error) {\n", dent, name, elem)
+		if err := s.EncodeField(identCount+1, Field{Name: "v", Type: elem, OverrideType: elemOverride}, f); err != nil {
+			return err
+		}
+		fmt.Fprintf(f, "%sreturn\n%s})\n", dent+ident, dent)
+		return
+	}
+	if field.Type[0] == '[' {
+		if opts.NilSlice {
+			writeNilLen(f, dent, name)
+		} else {
+			fmt.Fprintf(f, "%senc.Int(len(%s))\n", dent, name)
+		}
+		fmt.Fprintf(f, "%sfor _, v := range %s {\n", dent, name)
+		if err := s.EncodeField(identCount+1, Field{Name: "v", Type: field.Type[2:], OverrideType: elemOverride}, f); err != nil {
+			return err
+		}
+		fmt.Fprintln(f, dent+"}")
+		return
+	}
+
+	fmt.Fprintf(f, "%s// Do not know what to do with %s (%s)\n", dent, field.Name, field.Type)
+	return nil
+}
+
+func (s *Struct) DecodeField(identCount int, field Field, f io.Writer) (err error) {
+	dent := strings.Repeat(ident, identCount)
+	name := field.Name
+	if _, decode, ok := converterFuncs(field); ok {
+		fmt.Fprintf(f, "%sif err = %s(dec, &%s); err != nil {\n", dent, decode, name)
+		fmt.Fprintf(f, "%sreturn\n%s}\n", dent+ident, dent)
+		return
+	}
+	if field.JSON {
+		if _, ok := s._declared["_json"]; !ok {
+			s._declared["_json"] = "[]byte"
+			fmt.Fprintf(f, "%svar _json []byte\n", dent)
+		}
+		fmt.Fprintf(f, "%sif err = dec.Bytes(&_json); err != nil {\n", dent)
+		fmt.Fprintf(f, "%sreturn\n", dent+ident)
+		fmt.Fprintf(f, "%s}\n", dent)
+		fmt.Fprintf(f, "%sif err = json.Unmarshal(_json, &%s); err != nil {\n", dent, name)
+		fmt.Fprintf(f, "%sreturn\n%s}\n", dent+ident, dent)
+		return
+	}
+	if isGzip(field) {
+		// Bounded fields stop decompressing once they pass their maxlen
+		call := "DecodeGzip(dec, &%s)"
+		if max, label, ok := s.maxLen(field); ok {
+			call = fmt.Sprintf("DecodeGzipMax(dec, &%%s, %q, %s)", label, max)
+		}
+		if field.Type == "[]byte" || field.Type == "[]uint8" {
+			fmt.Fprintf(f, "%sif err = enkodo."+call+"; err != nil {\n", dent, name)
+			fmt.Fprintf(f, "%sreturn\n%s}\n", dent+ident, dent)
+			return
+		}
+		if _, ok := s._declared["_gzip"]; !ok {
+			s._declared["_gzip"] = "[]byte"
+			fmt.Fprintf(f, "%svar _gzip []byte\n", dent)
+		}
+		fmt.Fprintf(f, "%sif err = enkodo."+call+"; err != nil {\n", dent, "_gzip")
+		fmt.Fprintf(f, "%sreturn\n%s}\n", dent+ident, dent)
+		fmt.Fprintf(f, "%s%s = %s(_gzip)\n", dent, name, field.Type)
+		return
+	}
+	if isRuns(field) {
+		elem := runsElem(field)
+		elem.Name = "*t"
+		// and runs once they expand past it
+		if max, label, ok := s.maxLen(field); ok {
+			fmt.Fprintf(f, "%sif err = enkodo.DecodeRunsMax(dec, &%s, %q, %s, func(t *%s) (err error) {\n", dent, name, label, max, field.Type[2:])
+		} else {
+			fmt.Fprintf(f, "%sif err = enkodo.DecodeRuns(dec, &%s, func(t *%s) (err error) {\n", dent, name, field.Type[2:])
+		}
+		if err := s.DecodeField(identCount+1, elem, f); err != nil {
+			return err
+		}
+		fmt.Fprintf(f, "%sreturn\n%s}); err != nil {\n", dent+ident, dent)
+		fmt.Fprintf(f, "%sreturn\n%s}\n", dent+ident, dent)
+		return
+	}
+	/*
+		var ogType string
+		if field.OverrideType != "" {
+			ogType = field.Type
+			field.Type = field.OverrideType
+		}
+	*/
+	// Named basic types are encoded as their underlying type, as if it were overridden
+	field = resolveNamed(field)
+	// A basic override on a slice, array or map applies to its elements
+	var elemOverride string
+	if isElementOverride(field) {
+		elemOverride, field.OverrideType = field.OverrideType, ""
+	}
+	if isCompositeOverride(field) {
+		// Decode into the override type, then convert it back to the field type
+		fmt.Fprintf(f, "%s{\n", dent)
+		fmt.Fprintf(f, "%svar _v %s\n", dent+ident, field.OverrideType)
+		s.scoped(func() {
+			err = s.DecodeField(identCount+1, Field{Name: "_v", Type: field.OverrideType, Opts: field.Opts, label: s.fieldLabel(name)}, f)
+		})
+		fmt.Fprintf(f, "%s%s = %s(_v)\n", dent+ident, name, field.Type)
+		fmt.Fprintf(f, "%s}\n", dent)
+		return
+	}
+	if field.Type == "" || field.Type[0] == '[' && len(field.Type) == 2 {
+		fmt.Fprintf(f, "%s// Do not know what to do with %s (%s)\n", dent, field.Name, field.Type)
+		return
+	}
+	// bytes is a special case for decode because we need to build the array
+	if field.Type == "[]byte" && opts.NilSlice {
+		s.readNilLen(dent, name, field, f)
+		fmt.Fprintf(f, "%sif err = dec.FixedBytes(%s); err != nil {\n", dent, name)
+		fmt.Fprintf(f, "%sreturn\n%s}\n", dent+ident, dent)
+		return
+	}
+	if field.Type == "[]byte" {
+		fmt.Fprintf(f, "%s%s = make([]byte, 0)\n", dent, name)
+		if max, label, ok := s.maxLen(field); ok {
+			fmt.Fprintf(f, "%sif err = dec.BytesMax(&%s, %q, %s); err != nil {\n", dent, name, label, max)
+		} else {
+			fmt.Fprintf(f, "%sif err = dec.Bytes(&%s); err != nil {\n", dent, name)
+		}
+		fmt.Fprintf(f, "%sreturn\n%s}\n", dent+ident, dent)
+		return
+	}
+
+	// These basic functions are all error wrapped
+	typ := field.Type
+	if field.OverrideType != "" {
+		typ = field.OverrideType
+	}
+
+	if typ == "string" && (field.Type == "string" || namedTypes[field.Type] == "string") {
+		if max, label, ok := s.maxLen(field); ok {
+			// Strings longer than the max fail before they are allocated
+			if field.Type == "string" {
+				fmt.Fprintf(f, "%sif %s, err = dec.StringMax(%q, %s); err != nil {\n%sreturn err\n%s}\n", dent, name, label, max, dent+ident, dent)
+			} else {
+				fmt.Fprintf(f, "%sif v, err := dec.StringMax(%q, %s); err == nil {\n", dent, label, max)
+				fmt.Fprintf(f, "%s%s = %s(v)\n%s} else {\n%sreturn err\n%s}\n", dent+ident, name, field.Type, dent, dent+ident, dent)
+			}
+			return
+		}
+	}
+
+	if conv, ok := fieldConverter(field, typ); ok {
+		// Special case for overrides where we assign it to a different value, then set it in the obj
+		//init, varName := initType(field.Type)
+		//enhanced decoding where its converted
+		d := conv.Dec("v")
+		// Override requires a typecast back to the original gotype
+		if field.OverrideType != "" {
+			if d == "" {
+				d = "v"
+			}
+			d = fmt.Sprintf("%s(%s)", field.Type, d)
+		}
+		if d != "" {
+			/* Should come out like this:
+			// assume .Field error
+			if v, err := dec.String(); err == nil {
+				struct.Field = errors.New(v)
+			} else {
+				return err
+			}
+			*/
+
+			fmt.Fprintf(f, "%sif v, err := dec.%s(); err == nil {\n", dent, conv.EnkodoFunction())
+			if rc, ok := conv.(RangeChecker); ok {
+				fmt.Fprintf(f, "%sif %s {\n", dent+ident, rc.OutOfRange("v"))
+				fmt.Fprintf(f, "%sreturn &enkodo.OverflowError{Field: \"%s\", Type: \"%s\"}\n", dent+ident+ident, s.fieldLabel(field.Name), conv.Name())
+				fmt.Fprintf(f, "%s}\n", dent+ident)
+			}
+			fmt.Fprintf(f, "%s%s = %s\n", dent+ident, field.Name, d)
+			fmt.Fprintf(f, "%s} else {\n", dent)
+			fmt.Fprintf(f, "%sreturn err\n", dent+ident)
+			fmt.Fprintf(f, "%s}\n", dent)
+			//fmt.Fprintf(f, "%s%s = %s(%s)\n", dent, name, ogType, varName)
+		} else {
+
+			fmt.Fprintf(f, "%sif %s, err = dec.%s(); err != nil {\n", dent, field.Name, conv.EnkodoFunction())
+			fmt.Fprintf(f, "%sreturn err\n", dent+ident)
+			fmt.Fprintf(f, "%s}\n", dent)
+		}
+		return
+	}
+
+	// Struct values decode in place
+	if isEnkodoStruct(field.Type) {
+		addr := "&" + name
+		if strings.HasPrefix(name, "*") {
+			addr = name[1:]
+		}
+		fmt.Fprintf(f, "%sif err = dec.Decode(%s); err != nil {\n", dent, addr)
+		fmt.Fprintf(f, "%sreturn\n%s}\n", dent+ident, dent)
+		return
+	}
+
+	if entries, ok := registries[field.Type]; ok {
+		decodeRegistered(dent, name, s.fieldLabel(field.Name), entries, f)
+		return
+	}
+
+	// Handle pointers, which are only allocated when their presence flag is set
+	if field.Type[0] == '*' {
+		// _err leaves err unshadowed for the bare returns of the field decoded below
+		fmt.Fprintf(f, "%sif _nonNil, _err := dec.Bool(); _err != nil {\n%sreturn _err\n", dent, dent+ident)
+		fmt.Fprintf(f, "%s} else if !_nonNil {\n%s%s = nil\n%s} else {\n", dent, dent+ident, name, dent)
+		fmt.Fprintf(f, "%s%s = new(%s)\n", dent+ident, name, field.Type[1:])
+		if isEnkodoStruct(field.Type[1:]) {
+			fmt.Fprintf(f, "%sif err = dec.Decode(%s); err != nil {\n", dent+ident, name)
+			fmt.Fprintf(f, "%sreturn\n%s}\n", dent+ident+ident, dent+ident)
+		} else if err = s.DecodeField(identCount+1, resolveNamed(Field{Name: "*" + name, Type: field.Type[1:]}), f); err != nil {
+			return err
+		}
+		fmt.Fprintln(f, dent+"}")
+		return
+	}
+
+	// Handle maps
+	if strings.HasPrefix(field.Type, "map[") {
+		key, val := splitMapType(field.Type)
+		n, k, t := mapVars(identCount)
+		if _, ok := s._declared[n]; !ok {
+			s._declared[n] = "int"
+			fmt.Fprintf(f, "%svar %s int\n", dent, n)
+		}
+		s.DecodeField(identCount, Field{Name: n, Type: "int", length: true}, f)
+		s.checkLen(dent, n, field, f)
+		s.allocate(dent, n, f)
+		fmt.Fprintf(f, "%s%s = make(%s, %s)\n", dent, name, field.Type, n)
+		fmt.Fprintf(f, "%sfor %s := 0; %s < %s; %s++ {\n", dent, indexVar(identCount), indexVar(identCount), n, indexVar(identCount))
+		fmt.Fprintf(f, "%svar %s %s\n", dent+ident, k, key)
+		fmt.Fprintf(f, "%svar %s %s\n", dent+ident, t, val)
+		// Nested maps declare their length in the loop, so it is out of scope after it
+		s.scoped(func() {
+			if isEnkodoStruct(key) {
+				fmt.Fprintf(f, "%sif err = dec.Decode(&%s); err != nil {\n", dent+ident, k)
+				fmt.Fprintf(f, "%sreturn\n%s}\n", dent+ident+ident, dent+ident)
+			} else if err = s.DecodeField(identCount+1, resolveNamed(Field{Name: k, Type: key}), f); err != nil {
+				return
+			}
+			err = s.DecodeField(identCount+1, resolveNamed(Field{Name: t, Type: val, OverrideType: elemOverride}), f)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(f, "%s%s[%s] = %s\n", dent+ident, indexable(name), k, t)
+		fmt.Fprintln(f, dent+"}")
+		return
+	}
+
+	// Handle fixed size arrays, each element is decoded in place
+	if isFixedArray(field.Type) {
+		_, elem := splitArrayType(field.Type)
+		if (elem == "byte" || elem == "uint8") && elemOverride == "" {
+			fmt.Fprintf(f, "%sif err = dec.FixedBytes(%s[:]); err != nil {\n", dent, indexable(name))
+			fmt.Fprintf(f, "%sreturn\n%s}\n", dent+ident, dent)
+			return
+		}
+		i := indexVar(identCount)
+		fmt.Fprintf(f, "%sfor %s := range %s {\n", dent, i, name)
+		if err := s.DecodeField(identCount+1, Field{Name: indexable(name) + "[" + i + "]", Type: elem, OverrideType: elemOverride}, f); err != nil {
+			return err
+		}
+		fmt.Fprintln(f, dent+"}")
+		return
+	}
+
+	// Handle arrays, the generic helpers do not keep nil slices or check lengths
+	_, bounded := field.Opts["maxlen"]
+	if field.Type[0] == '[' && opts.Generics && !opts.NilSlice && !bounded && !s.budgeted() {
+		elem := field.Type[2:]
+		// Basic types can pass the decoder method straight to the helper
+		if conv, ok := converter(elem); ok && conv.Dec("v") == "" && elem != "[]byte" && elemOverride == "" {
+			fmt.Fprintf(f, "%sif err = enkodoDecodeSlice(dec, &%s, (*enkodo.Decoder).%s); err != nil {\n", dent, name, conv.EnkodoFunction())
+			fmt.Fprintf(f, "%sreturn\n%s}\n", dent+ident, dent)
+			return
+		}
+		// Otherwise the helper hands us a pointer to each element in the slice
+		fmt.Fprintf(f, "%sif err = enkodoDecodeSliceInto(dec, &%s, func(dec *enkodo.Decoder, t *%s) (err error) {\n", dent, name, elem)
+		if err := s.DecodeField(identCount+1, Field{Name: "*t", Type: elem, OverrideType: elemOverride}, f); err != nil {
+			return err
+		}
+		fmt.Fprintf(f, "%sreturn\n%s}); err != nil {\n", dent+ident, dent)
+		fmt.Fprintf(f, "%sreturn\n%s}\n", dent+ident, dent)
+		return
+	}
+	if field.Type[0] == '[' {
+		// Make the slice and decode each element in place. Nested slices reuse _arrLen,
+		// which is safe as the range is evaluated once
+		if opts.NilSlice {
+			s.readNilLen(dent, name, field, f)
+		} else {
+			s.readLen(dent, f)
+			s.checkLen(dent, "_arrLen", field, f)
+			s.allocate(dent, "_arrLen", f)
+			fmt.Fprintf(f, "%s%s = make(%s, _arrLen)\n", dent, name, field.Type)
+		}
+		i := indexVar(identCount)
+		fmt.Fprintf(f, "%sfor %s := range %s {\n", dent, i, name)
+		if err := s.DecodeField(identCount+1, Field{Name: indexable(name) + "[" + i + "]", Type: field.Type[2:], OverrideType: elemOverride}, f); err != nil {
+			return err
+		}
+		fmt.Fprintln(f, dent+"}")
+	}
+	return nil
+}
+
+// encodeStruct writes the code encoding the enkodo struct pointed to by ref, returning
+// the errors of its MarshalEnkodo, e.g. a field over its maxlen
+func encodeStruct(dent, ref string, f io.Writer) {
+	fmt.Fprintf(f, "%sif err = enc.Encode(%s); err != nil {\n%sreturn\n%s}\n", dent, ref, dent+ident, dent)
+}
+
+// writeNilLen writes the length of the slice name for -nilslice, 0 for a nil slice and
+// the length plus one otherwise, so nil and empty slices stay distinct
+func writeNilLen(f io.Writer, dent, name string) {
+	fmt.Fprintf(f, "%sif %s == nil {\n%senc.Int(0)\n", dent, name, dent+ident)
+	fmt.Fprintf(f, "%s} else {\n%senc.Int(len(%s) + 1)\n%s}\n", dent, dent+ident, name, dent)
+}
+
+// readLen reads a slice length into _arrLen, declaring it on first use
+func (s *Struct) readLen(dent string, f io.Writer) {
+	if _, ok := s._declared["_arrLen"]; !ok {
+		s._declared["_arrLen"] = "int"
+		fmt.Fprintf(f, "%svar _arrLen int\n", dent)
+	}
+	s.DecodeField(strings.Count(dent, ident), Field{Name: "_arrLen", Type: "int", length: true}, f)
+}
+
+// readNilLen reads a length written by writeNilLen and makes the slice name of the type
+// of field
+func (s *Struct) readNilLen(dent, name string, field Field, f io.Writer) {
+	s.readLen(dent, f)
+	// 0 is a nil slice, so only lengths below it are invalid
+	checkNegative(dent, "_arrLen", f)
+	s.checkLenMax(dent, "_arrLen-1", field, f)
+	fmt.Fprintf(f, "%sif _arrLen == 0 {\n%s%s = nil\n%s} else {\n", dent, dent+ident, name, dent)
+	s.allocate(dent+ident, "_arrLen-1", f)
+	fmt.Fprintf(f, "%s%s = make(%s, _arrLen-1)\n%s}\n", dent+ident, name, field.Type, dent)
+}
+
+// allocate writes the code spending the n bytes or elements about to be allocated from
+// the decode budget, see Options.Budget
+func (s *Struct) allocate(dent, n string, f io.Writer) {
+	if s.budgeted() {
+		fmt.Fprintf(f, "%sif err = dec.Allocate(%s); err != nil {\n%sreturn\n%s}\n", dent, n, dent+ident, dent)
+	}
+}
+
+// budgeted reports whether decoding s spends the decode budget on slices and maps, as it
+// has a budget of its own or the fuzz targets decode it under one
+func (s *Struct) budgeted() bool {
+	return s.Budget > 0 || opts.Fuzz
+}
+
+// msgpackKind returns the msgpack Encoder/Decoder method used for a basic go type, and
+// the go type that method works with
+func msgpackKind(typ string) (method, goType string) {
+	switch typ {
+	case "int", "int8", "int16", "int32", "int64":
+		return "Int", "int64"
+	case "uint", "uint8", "byte", "uint16", "uint32", "uint64":
+		return "Uint", "uint64"
+	case "float32", "float64", "bool", "string":
+		return strings.ToUpper(typ[:1]) + typ[1:], typ
+	case "[]byte":
+		return "Bin", "[]byte"
+	}
+	return "", ""
+}
+
+// msgpackSupported reports whether a field of type typ can be written by -msgpack
+func msgpackSupported(typ string) bool {
+	if method, _ := msgpackKind(typ); method != "" {
+		return true
+	}
+	if strings.HasPrefix(typ, "[]") {
+		return msgpackSupported(typ[2:])
+	}
+	// Pointers to other generated structs, named slices and maps have no msgpack methods
+	if _, ok := collections[strings.TrimPrefix(typ, "*")]; ok {
+		return false
+	}
+	return strings.HasPrefix(typ, "*") && !strings.ContainsAny(typ[1:], "*[.") && isEnkodoStruct(typ[1:])
+}
+
+// msgpackFields returns the fields that -msgpack can write, with overrides applied
+func (s *Struct) msgpackFields() []Field {
+	fields := make([]Field, 0, len(s.Fields))
+	for _, field := range s.Fields {
+		typ := field.Type
+		if field.OverrideType != "" {
+			typ = field.OverrideType
+		}
+		if _, converted := field.Opts["converter"]; converted || !msgpackSupported(typ) || field.Getter != "" || isElementOverride(field) {
+			continue
+		}
+		if method, _ := msgpackKind(typ); isCompositeOverride(field) && method == "" {
+			continue // decoded by converting each element to the go type
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// MsgpackEncodeFunc writes a MarshalMsgpack method encoding the struct as a MessagePack
+// map keyed by field name
+func (s *Struct) MsgpackEncodeFunc(f io.Writer) {
+	fnRef := strings.ToLower(s.Name[0:1])
+	fields := s.msgpackFields()
+	fmt.Fprintf(f, "// MarshalMsgpack encodes %s as a MessagePack map keyed by field name\n", s.Name)
+	fmt.Fprintf(f, "func (%s *%s) MarshalMsgpack() ([]byte, error) {\n", fnRef, s.Name)
+	fmt.Fprintf(f, "%senc := msgpack.NewEncoder()\n", ident)
+	fmt.Fprintf(f, "%senc.Map(%d)\n", ident, len(fields))
+	for _, field := range fields {
+		fmt.Fprintf(f, "%senc.String(%q)\n", ident, field.Name)
+		typ := field.Type
+		if field.OverrideType != "" {
+			typ = field.OverrideType
+		}
+		s.msgpackEncodeField(1, fnRef+"."+field.Name, typ, f)
+	}
+	fmt.Fprintf(f, "%sreturn enc.Bytes(), nil\n}\n\n", ident)
+}
+
+func (s *Struct) msgpackEncodeField(identCount int, name, typ string, f io.Writer) {
+	dent := strings.Repeat(ident, identCount)
+	if method, goType := msgpackKind(typ); method != "" {
+		fmt.Fprintf(f, "%senc.%s(%s(%s))\n", dent, method, goType, name)
+		return
+	}
+	if strings.HasPrefix(typ, "[]") {
+		fmt.Fprintf(f, "%senc.Array(len(%s))\n", dent, name)
+		fmt.Fprintf(f, "%sfor _, v := range %s {\n", dent, name)
+		s.msgpackEncodeField(identCount+1, "v", typ[2:], f)
+		fmt.Fprintln(f, dent+"}")
+		return
+	}
+	// Pointer to a struct
+	fmt.Fprintf(f, "%sif %s == nil {\n%senc.Nil()\n", dent, name, dent+ident)
+	fmt.Fprintf(f, "%s} else if bs, err := %s.MarshalMsgpack(); err != nil {\n%sreturn nil, err\n", dent, name, dent+ident)
+	fmt.Fprintf(f, "%s} else {\n%senc.Raw(bs)\n%s}\n", dent, dent+ident, dent)
+}
+
+// MsgpackDecodeFunc writes an UnmarshalMsgpack method decoding a MessagePack map,
+// skipping any keys that are not fields of the struct
+func (s *Struct) MsgpackDecodeFunc(f io.Writer) {
+	fnRef := strings.ToLower(s.Name[0:1])
+	fmt.Fprintf(f, "// UnmarshalMsgpack decodes %s from a MessagePack map\n", s.Name)
+	fmt.Fprintf(f, "func (%s *%s) UnmarshalMsgpack(bs []byte) (err error) {\n", fnRef, s.Name)
+	fmt.Fprintf(f, "%sdec := msgpack.NewDecoder(bs)\n", ident)
+	fmt.Fprintf(f, "%svar _n int\n", ident)
+	fmt.Fprintf(f, "%sif _n, err = dec.Map(); err != nil {\n%sreturn\n%s}\n", ident, ident+ident, ident)
+	fmt.Fprintf(f, "%sfor _i := 0; _i < _n; _i++ {\n", ident)
+	fmt.Fprintf(f, "%svar key string\n", ident+ident)
+	fmt.Fprintf(f, "%sif key, err = dec.String(); err != nil {\n%sreturn\n%s}\n", ident+ident, ident+ident+ident, ident+ident)
+	fmt.Fprintf(f, "%sswitch key {\n", ident+ident)
+	for _, field := range s.msgpackFields() {
+		fmt.Fprintf(f, "%scase %q:\n", ident+ident, field.Name)
+		typ := field.Type
+		if field.OverrideType != "" {
+			typ = field.OverrideType
+		}
+		s.msgpackDecodeField(3, fnRef+"."+field.Name, field.Type, typ, f)
+	}
+	fmt.Fprintf(f, "%sdefault:\n", ident+ident)
+	fmt.Fprintf(f, "%sif err = dec.Skip(); err != nil {\n%sreturn\n%s}\n", ident+ident+ident, ident+ident+ident+ident, ident+ident+ident)
+	fmt.Fprintf(f, "%s}\n%s}\n%sreturn\n}\n\n", ident+ident, ident, ident)
+}
+
+// msgpackDecodeField decodes into name, goType is the type of name and typ the type
+// it is written as on the wire (they differ for overrides)
+func (s *Struct) msgpackDecodeField(identCount int, name, goType, typ string, f io.Writer) {
+	dent := strings.Repeat(ident, identCount)
+	if method, _ := msgpackKind(typ); method != "" {
+		fmt.Fprintf(f, "%sif v, err := dec.%s(); err == nil {\n", dent, method)
+		fmt.Fprintf(f, "%s%s = %s(v)\n", dent+ident, name, goType)
+		fmt.Fprintf(f, "%s} else {\n%sreturn err\n%s}\n", dent, dent+ident, dent)
+		return
+	}
+	if strings.HasPrefix(typ, "[]") {
+		n, i := fmt.Sprintf("_n%d", identCount), fmt.Sprintf("_i%d", identCount)
+		fmt.Fprintf(f, "%svar %s int\n", dent, n)
+		fmt.Fprintf(f, "%sif %s, err = dec.Array(); err != nil {\n%sreturn\n%s}\n", dent, n, dent+ident, dent)
+		fmt.Fprintf(f, "%s%s = make(%s, %s)\n", dent, name, goType, n)
+		fmt.Fprintf(f, "%sfor %s := range %s {\n", dent, i, name)
+		s.msgpackDecodeField(identCount+1, name+"["+i+"]", goType[2:], typ[2:], f)
+		fmt.Fprintln(f, dent+"}")
+		return
+	}
+	// Pointer to a struct
+	fmt.Fprintf(f, "%sif dec.IsNil() {\n%s%s = nil\n%sdec.Skip()\n", dent, dent+ident, name, dent+ident)
+	fmt.Fprintf(f, "%s} else if raw, err := dec.Raw(); err != nil {\n%sreturn err\n", dent, dent+ident)
+	fmt.Fprintf(f, "%s} else {\n%s%s = new(%s)\n", dent, dent+ident, name, typ[1:])
+	fmt.Fprintf(f, "%sif err = %s.UnmarshalMsgpack(raw); err != nil {\n%sreturn err\n%s}\n", dent+ident, name, dent+ident+ident, dent+ident)
+	fmt.Fprintf(f, "%s}\n", dent)
+}
+
+// isElementOverride reports whether the override type of a slice, array or map field
+// is for its elements, e.g. [][]SocialMedia tagged enkodo:"string". []byte can be
+// converted to a string so is overridden as a whole
+func isElementOverride(field Field) bool {
+	override := field.OverrideType
+	if override == "" || strings.HasPrefix(override, "[") || strings.HasPrefix(override, "map[") || strings.HasPrefix(override, "*") {
+		return false
+	}
+	if field.Type == "[]byte" || field.Type == "[]uint8" {
+		return false
+	}
+	return strings.HasPrefix(field.Type, "[") || strings.HasPrefix(field.Type, "map[")
+}
+
+// elementOverride returns the override of a field of type typ, with overrides having
+// the same slice, array and map layers as typ turned into the override of its elements,
+// e.g. string for []SocialMedia tagged enkodo:"[]string", which a []SocialMedia can not
+// be converted to as a whole. Map keys must be the same, the override applies to values
+func elementOverride(typ, override string) string {
+	if typ == "[]byte" || typ == "[]uint8" {
+		return override
+	}
+	elem, elemOverride, layered := typ, override, false
+	for {
+		switch {
+		case strings.HasPrefix(elem, "[]") && strings.HasPrefix(elemOverride, "[]"):
+			elem, elemOverride = elem[2:], elemOverride[2:]
+		case strings.HasPrefix(elem, "map[") && strings.HasPrefix(elemOverride, "map["):
+			key, val := splitMapType(elem)
+			overrideKey, overrideVal := splitMapType(elemOverride)
+			if key != overrideKey {
+				return override
+			}
+			elem, elemOverride = val, overrideVal
+		case isFixedArray(elem) && isFixedArray(elemOverride):
+			n, val := splitArrayType(elem)
+			overrideN, overrideVal := splitArrayType(elemOverride)
+			if n != overrideN {
+				return override
+			}
+			elem, elemOverride = val, overrideVal
+		default:
+			if !layered || elem == elemOverride || strings.ContainsAny(elemOverride, "[*") {
+				return override
+			}
+			return elemOverride
+		}
+		layered = true
+	}
+}
+
+// isCompositeOverride reports whether a field is overridden with a slice, array, map or
+// pointer type, e.g. a Blob tagged enkodo:"[]byte", so is decoded as that type and
+// converted
+func isCompositeOverride(field Field) bool {
+	override := field.OverrideType
+	if override == "" || override == field.Type {
+		return false
+	}
+	return strings.HasPrefix(override, "[") || strings.HasPrefix(override, "map[") || strings.HasPrefix(override, "*")
+}
+
+// indexVar returns the loop index used when decoding elements at identCount, nested
+// loops need their own
+func indexVar(identCount int) string {
+	if identCount <= 1 {
+		return "_i"
+	}
+	return fmt.Sprintf("_i%d", identCount)
+}
+
+// mapVars returns the names of the length, key and value variables used to decode a
+// map, which differ for nested maps so the outer loop's are not overwritten
+func mapVars(identCount int) (n, k, t string) {
+	if identCount <= 1 {
+		return "_mapLen", "k", "t"
+	}
+	return fmt.Sprintf("_mapLen%d", identCount), fmt.Sprintf("k%d", identCount), fmt.Sprintf("t%d", identCount)
+}
+
+// isFixedArray reports whether typ is a fixed size array such as [16]byte
+func isFixedArray(typ string) bool {
+	return strings.HasPrefix(typ, "[") && !strings.HasPrefix(typ, "[]")
+}
+
+// splitArrayType splits a fixed size array type such as [16]byte into its length and
+// element type
+func splitArrayType(typ string) (n, elem string) {
+	end := strings.IndexByte(typ, ']')
+	return typ[1:end], typ[end+1:]
+}
+
+// indexable wraps dereferenced names in parens so they can be indexed or sliced
+func indexable(name string) string {
+	if strings.HasPrefix(name, "*") {
+		return "(" + name + ")"
+	}
+	return name
+}
+
+// splitMapType splits a map type such as map[string][]int into its key and value types
+func splitMapType(typ string) (key, val string) {
+	depth := 0
+	for i := len("map"); i < len(typ); i++ {
+		switch typ[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return typ[len("map["):i], typ[i+1:]
+			}
+		}
+	}
+	return
+}
+
+// presenceVersions returns the version ranges of the omitempty fields, the bitmask of
+// those that are set is written by the versions in any of them
+func (s *Struct) presenceVersions() [][2]int {
+	var ranges [][2]int
+	for _, field := range s.Fields {
+		if _, ok := field.Opts["omitempty"]; ok {
+			since, until := fieldVersions(field)
+			ranges = append(ranges, [2]int{since, until})
+		}
+	}
+	return ranges
+}
+
+// presenceBits returns the bit of each omitempty field in the presence mask written
+// before the fields, by field name
+func (s *Struct) presenceBits() map[string]int {
+	bits := make(map[string]int)
+	for _, field := range s.Fields {
+		if _, ok := field.Opts["omitempty"]; ok {
+			bits[field.Name] = len(bits)
+		}
+	}
+	return bits
+}
+
+// fieldVersions returns the versions of its struct a field is written in, from since up
+// to but not including until, with 0 for the tags it does not have
+func fieldVersions(field Field) (since, until int) {
+	since, _ = strconv.Atoi(field.Opts["since"])
+	until, _ = strconv.Atoi(field.Opts["until"])
+	return
+}
+
+// checkFieldVersions drops the since and until options of a field of the struct name
+// that are not versions from 1 to 255, the version is written as a byte, or do not
+// leave any version to write it in
+func checkFieldVersions(name string, field Field) {
+	for _, key := range []string{"since", "until"} {
+		if v, ok := field.Opts[key]; ok {
+			if n, err := strconv.Atoi(v); err != nil || n < 1 || n > 255 {
+				log.Printf("warning: ignoring invalid %s %q on %s.%s, versions are from 1 to 255", key, v, name, field.Name)
+				delete(field.Opts, key)
+			}
+		}
+	}
+	if since, until := fieldVersions(field); until != 0 && until <= since {
+		log.Printf("warning: ignoring until=%d on %s.%s, it is not after since=%d", until, name, field.Name, since)
+		delete(field.Opts, "until")
+	}
+}
+
+// fieldVersion returns the version of a struct with fields tagged since or until, the
+// latest of those versions and of its //enkodo:version directive, or 0 when none are
+// tagged
+func (s *Struct) fieldVersion() int {
+	latest, tagged := s.Version, false
+	for _, field := range s.Fields {
+		since, until := fieldVersions(field)
+		if since > 0 || until > 0 {
+			latest, tagged = max(latest, since, until), true
+		}
+	}
+	if !tagged {
+		return 0
+	}
+	return max(latest, 1)
+}
+
+// writtenIn reports whether field is written by the version of its struct, which is
+// always when the struct has none
+func writtenIn(field Field, version int) bool {
+	since, until := fieldVersions(field)
+	return version == 0 || since <= version && (until == 0 || version < until)
+}
+
+// versionCond returns the condition on the _version decoded for the fields written in
+// it, or "" for fields written in every version
+func versionCond(field Field) string {
+	since, until := fieldVersions(field)
+	return versionRange("_version", " && ", since, until)
+}
+
+// anyVersionRange returns the condition that the variable version is in any of the
+// ranges from since up to but not including until, "" when it always is. ok is false
+// when there are no ranges, so it never is
+func anyVersionRange(version, and, or string, ranges [][2]int) (cond string, ok bool) {
+	var conds []string
+	for _, r := range ranges {
+		c := versionRange(version, and, r[0], r[1])
+		if c == "" {
+			return "", true
+		}
+		if len(ranges) > 1 && strings.Contains(c, and) {
+			c = "(" + c + ")"
+		}
+		if !slices.Contains(conds, c) {
+			conds = append(conds, c)
+		}
+	}
+	return strings.Join(conds, or), len(conds) > 0
+}
+
+// versionRange returns the condition that the variable version is from since up to but
+// not including until, joining the comparisons with and, or "" when it is any version.
+// Decoders in other languages check the versions of fields the same way
+func versionRange(version, and string, since, until int) string {
+	var conds []string
+	if since > 1 {
+		conds = append(conds, fmt.Sprintf("%s >= %d", version, since))
+	}
+	if until > 0 {
+		conds = append(conds, fmt.Sprintf("%s < %d", version, until))
+	}
+	return strings.Join(conds, and)
+}
+
+// nonZero returns an expression that is true when name, of type typ, is not its zero
+// value, or "" for types omitempty does not support
+func nonZero(name, typ string) string {
+	if underlying, ok := namedTypes[typ]; ok {
+		typ = underlying
+	}
+	if underlying, ok := collections[typ]; ok {
+		typ = underlying
+	}
+	switch typ {
+	case "string":
+		return name + ` != ""`
+	case "bool":
+		return name
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64",
+		"byte", "rune", "float32", "float64", "time.Duration":
+		return name + " != 0"
+	case "time.Time":
+		return "!" + name + ".IsZero()"
+	case "net.IP":
+		return "len(" + name + ") != 0"
+	case "netip.Addr", "netip.AddrPort":
+		return name + ".IsValid()"
+	case "uuid.UUID":
+		return name + " != [16]byte{}"
+	case "error":
+		return name + " != nil"
+	}
+	switch {
+	case strings.HasPrefix(typ, "[]"), strings.HasPrefix(typ, "map["):
+		return "len(" + name + ") != 0"
+	case strings.HasPrefix(typ, "*"):
+		return name + " != nil"
+	}
+	return ""
+}
+
+// converterFuncs returns the functions a field tagged enkodo:",converter=pkg.EncodeIP" is
+// written and read with, as pkg.EncodeIP(enc, v) and pkg.DecodeIP(dec, &v). The Encode
+// prefix is optional in the tag
+func converterFuncs(field Field) (encode, decode string, ok bool) {
+	name, ok := field.Opts["converter"]
+	if !ok {
+		return
+	}
+	var pkg string
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		pkg, name = name[:i+1], name[i+1:]
+	}
+	name = strings.TrimPrefix(name, "Encode")
+	return pkg + "Encode" + name, pkg + "Decode" + name, true
+}
+
+// isGzip reports whether a field is compressed with the gzip option, which is only
+// valid for bytes and strings
+func isGzip(field Field) bool {
+	_, ok := field.Opts["gzip"]
+	typ := field.Type
+	if field.OverrideType != "" {
+		typ = field.OverrideType
+	}
+	return ok && (typ == "[]byte" || typ == "[]uint8" || typ == "string")
+}
+
+// resolveNamed casts fields of a named basic type (e.g. type StatusCode int) through
+// their underlying type, the same as an override in the enkodo tag would
+// isRuns reports whether field is a slice tagged enkodo:",rle", which is written as runs
+// of equal values by enkodo.EncodeRuns
+func isRuns(field Field) bool {
+	_, ok := field.Opts["rle"]
+	return ok && strings.HasPrefix(field.Type, "[]") && len(field.Type) > 2
+}
+
+// runsElem returns the element field encoded for each run of a rle slice
+func runsElem(field Field) Field {
+	elem := field.Type[2:]
+	if elem == "byte" {
+		elem = "uint8" // byte only has a converter as part of []byte
+	}
+	return resolveNamed(Field{Name: "v", Type: elem})
+}
+
+func resolveNamed(field Field) Field {
+	if underlying, ok := namedTypes[field.Type]; ok && field.OverrideType == "" {
+		field.OverrideType = underlying
+	}
+	return field
+}
+
+// baseType strips any slice, map and pointer prefixes from typ, leaving the element type
+func baseType(typ string) string {
+	for {
+		switch {
+		case strings.HasPrefix(typ, "map["):
+			_, typ = splitMapType(typ)
+		case strings.HasPrefix(typ, "[]"):
+			typ = typ[2:]
+		case isFixedArray(typ):
+			_, typ = splitArrayType(typ)
+		case strings.HasPrefix(typ, "*"):
+			typ = typ[1:]
+		default:
+			return typ
+		}
+	}
+}
+
+// fieldRef is a field of a struct, labelled like Node.Children, holding the type typ
+type fieldRef struct {
+	field, typ string
+}
+
+// markRecursive flags every struct that can reach itself through its fields. Values of
+// them can hold themselves through pointers or shared slices and maps, which encoding
+// would never finish, so each is warned about with the fields it reaches itself through
+func markRecursive(structs []*Struct) {
+	refs := packageRefs(structs)
+	for _, s := range structs {
+		for _, field := range s.codecFields() {
+			label := s.Name
+			if field.Name != "" {
+				label += "." + field.Name
+			}
+			refs[s.Name] = append(refs[s.Name], fieldRef{label, baseType(field.Type)})
+		}
+	}
+
+	type step struct {
+		name string
+		path []string
+	}
+	for _, s := range structs {
+		seen := make(map[string]bool)
+		queue := []step{{name: s.Name}}
+	search:
+		for len(queue) > 0 {
+			cur := queue[0]
+			queue = queue[1:]
+			for _, ref := range refs[cur.name] {
+				path := append(slices.Clone(cur.path), ref.field)
+				if ref.typ == s.Name {
+					s.Recursive = true
+					hint := ""
+					if s.MaxDepth == 0 {
+						hint = ", and decoding is only limited in depth by -maxdepth"
+					}
+					log.Printf("warning: %s can hold itself through %s, encoding a value with a cycle never finishes%s", s.Name, strings.Join(path, " -> "), hint)
+					break search
+				}
+				if !seen[ref.typ] {
+					seen[ref.typ] = true
+					queue = append(queue, step{ref.typ, path})
+				}
+			}
+		}
+	}
+}
+
+// packageRefs returns the fields of the types of the package being generated other than
+// structs, found from its type information, so markRecursive finds the cycles through the
+// other files of the package when it is generated a file at a time. The fields of named
+// slices, maps and pointers are their elements
+func packageRefs(structs []*Struct) map[string][]fieldRef {
+	refs := make(map[string][]fieldRef)
+	if pkgTypes == nil {
+		return refs
+	}
+	qualifier := types.RelativeTo(pkgTypes)
+	for _, name := range pkgTypes.Scope().Names() {
+		tn, ok := pkgTypes.Scope().Lookup(name).(*types.TypeName)
+		if !ok || tn.IsAlias() || slices.ContainsFunc(structs, func(s *Struct) bool { return s.Name == name }) {
+			continue
+		}
+		st, ok := tn.Type().Underlying().(*types.Struct)
+		if !ok {
+			refs[name] = append(refs[name], fieldRef{name, baseType(types.TypeString(tn.Type().Underlying(), qualifier))})
+			continue
+		}
+		for i := 0; i < st.NumFields(); i++ {
+			field := st.Field(i)
+			value, tagged := tagValue("`" + st.Tag(i) + "`")
+			if value == "-" || excludedField(field.Name()) || !tagged && !(allFields(name) && field.Exported()) {
+				continue
+			}
+			refs[name] = append(refs[name], fieldRef{name + "." + field.Name(), baseType(types.TypeString(field.Type(), qualifier))})
+		}
+	}
+	return refs
+}
+
+// GetFieldType returns the go type of the field type expression f as the generator names
+// it, e.g. []*othr.Config, or "" when it can not be resolved
+func GetFieldType(f ast.Expr) string {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return getFieldType(f)
+}
+
+func getFieldType(f ast.Expr) (result string) {
+	switch t := f.(type) {
+	case *ast.Ident:
+		// basic types (e.g. Int)
+		result = t.Name
+		if q, ok := dotImports[t.Name]; ok && t.Obj == nil {
+			// Brought in by a dot import, e.g. Time from import . "time"
+			result = q
+		}
+	case *ast.StarExpr:
+		// pointer types
+		switch v := t.X.(type) {
+		case *ast.Ident:
+			result = "*" + v.Name
+		case *ast.SelectorExpr:
+			// pointers to imported types (e.g. *othr.Config)
+			if x := getFieldType(v); x != "" {
+				result = "*" + x
+			}
+		}
+	case *ast.ArrayType:
+		switch l := t.Len.(type) {
+		case nil:
+			result = "[]" + getFieldType(t.Elt)
+		case *ast.BasicLit:
+			result = "[" + l.Value + "]" + getFieldType(t.Elt)
+		case *ast.Ident:
+			// Array sized by a constant
+			result = "[" + l.Name + "]" + getFieldType(t.Elt)
+		}
+	case *ast.MapType:
+		key, val := getFieldType(t.Key), getFieldType(t.Value)
+		if key == "" || val == "" {
+			return
+		}
+		result = "map[" + key + "]" + val
+	case *ast.SelectorExpr:
+		// qualified types (e.g. time.Time)
+		if x, ok := t.X.(*ast.Ident); ok {
+			result = x.Name + "." + t.Sel.Name
+		}
+	default:
+		// uncomment below to error and see new types
+		// result = f.(*ast.Ident).Name
+		return
+	}
+	return
+}
+
+// unresolved returns the innermost node of the type expr that GetFieldType can not
+// resolve, or nil when it resolves all of it
+func unresolved(expr ast.Expr) ast.Expr {
+	switch t := expr.(type) {
+	case *ast.Ident, *ast.SelectorExpr, *ast.StarExpr:
+		if getFieldType(t) == "" {
+			return t
+		}
+	case *ast.ArrayType:
+		if n := unresolved(t.Elt); n != nil {
+			return n
+		}
+		if getFieldType(t) == "" {
+			return t // sized by an expression
+		}
+	case *ast.MapType:
+		if n := unresolved(t.Key); n != nil {
+			return n
+		}
+		return unresolved(t.Value)
+	default:
+		return t
+	}
+	return nil
+}
+
+// dumpAST logs the AST node of an unresolved field type for -dump-ast
+func dumpAST(structName, fieldName string, expr ast.Expr) {
+	node := unresolved(expr)
+	if node == nil {
+		return
+	}
+	msg := fmt.Sprintf("ast: %s.%s is %T (%s)", structName, fieldName, expr, types.ExprString(expr))
+	if node != expr {
+		msg += fmt.Sprintf(", unresolved at %T (%s)", node, types.ExprString(node))
+	}
+	log.Print(msg)
+}
+
+// tagValue returns the value of the enkodo key in the struct tag literal lit. Besides
+// the conventional format, tags in double quoted literals and enkodo:'value' are read
+func tagValue(lit string) (string, bool) {
+	raw, err := strconv.Unquote(lit)
+	if err != nil {
+		raw = strings.Trim(lit, "`\"")
+	}
+	if value, ok := reflect.StructTag(raw).Lookup("enkodo"); ok {
+		return value, true
+	}
+	if match := tag.FindStringSubmatch(raw); match != nil {
+		return match[1] + match[2], true
+	}
+	return "", false
+}
+
+// fieldNames returns the names of a field declaration for warnings, e.g. User.Name
+func fieldNames(structName string, field *ast.Field) string {
+	if len(field.Names) == 0 {
+		return structName + "." + types.ExprString(field.Type) // embedded
+	}
+	names := make([]string, 0, len(field.Names))
+	for _, name := range field.Names {
+		names = append(names, structName+"."+name.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// fieldIdents returns the names of a struct field. Embedded fields are named after their
+// type, e.g. Meta for *othr.Meta, and are encoded with its enkodo methods like any other
+// field of that type. Embedded generic types have no name
+func fieldIdents(field *ast.Field) []*ast.Ident {
+	if len(field.Names) > 0 {
+		return field.Names
+	}
+	typ := field.Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	switch t := typ.(type) {
+	case *ast.Ident:
+		return []*ast.Ident{t}
+	case *ast.SelectorExpr:
+		return []*ast.Ident{t.Sel}
+	}
+	return nil
+}
+
+// parseTag splits an enkodo tag such as "int,zigzag" into the override type and the
+// options that follow it. Options may carry a value, e.g. "string,maxlen=255"
+func parseTag(value string) (override string, opts map[string]string) {
+	parts := strings.Split(value, ",")
+	if len(parts[0]) > 1 {
+		override = parts[0]
+	}
+
+	opts = make(map[string]string)
+	for _, opt := range parts[1:] {
+		k, v, _ := strings.Cut(strings.TrimSpace(opt), "=")
+		if k != "" {
+			opts[k] = v
+		}
+	}
+	return
+}
+
+// GetStructFields returns the struct declared by obj with its enkodo fields, or nil if
+// obj is not a struct or has none. Those are the tagged fields, exported or not, or
+// every exported field with -all or an //enkodo:generate directive, except fields tagged
+// enkodo:"-". Fields of types that
+// can not be resolved are skipped with a warning, or are an error under -strict when tagged
+func GetStructFields(obj *ast.Object) (*Struct, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return getStructFields(obj)
+}
+
+func getStructFields(obj *ast.Object) (*Struct, error) {
+	if obj.Decl == nil {
+		return nil, nil
+	}
+
+	ts, ok := obj.Decl.(*ast.TypeSpec)
+	if !ok {
+		return nil, nil // not a type definition
+	}
+	if excludedType(ts.Name.Name) {
+		return nil, nil
+	}
+	if _, ok := collections[ts.Name.Name]; ok {
+		return collectionStruct(ts), nil
+	}
+	st, ok := ts.Type.(*ast.StructType)
+	if !ok {
+		return nil, nil // not a struct
+	}
+
+	s := &Struct{
+		Name:   ts.Name.Name,
+		Fields: make([]Field, 0),
+	}
+
+	// Types of all the fields, tagged or not, for options that refer to other fields
+	fieldTypes := make(map[string]string)
+	for _, field := range st.Fields.List {
+		for _, name := range fieldIdents(field) {
+			fieldTypes[name.Name] = getFieldType(field.Type)
+		}
+	}
+
+	// Unsupported fields are skipped with a warning, except tagged fields under -strict
+	// which are errors. Every error is returned, so they can all be fixed at once
+	var errs []error
+	skip := func(field *ast.Field, tagged bool, err error) {
+		if opts.Strict && tagged {
+			errs = append(errs, positioned(field.Pos(), err))
+		} else {
+			log.Printf("warning: skipping %v", err)
+		}
+	}
+
+	// Number of omitempty fields, each takes a bit of the presence mask
+	omitted := 0
+	for _, field := range st.Fields.List {
+		// Override the type with anything in a struct tag. E.g. enkodo:"int"
+		// skip fields that dont have the enkodo tag, unless generating for all fields
+		var (
+			value  string
+			tagged bool
+		)
+		if field.Tag != nil {
+			if value, tagged = tagValue(field.Tag.Value); !tagged && strings.Contains(field.Tag.Value, "enkodo") {
+				log.Printf("warning: skipping %s, can not parse the enkodo tag in %s", fieldNames(s.Name, field), field.Tag.Value)
+				continue
+			}
+		}
+		if !tagged && !allFields(ts.Name.Name) {
+			continue
+		}
+		if value == "-" {
+			continue // opted out
+		}
+		override, fieldOpts := parseTag(value)
+
+		names := fieldIdents(field)
+		if len(names) == 0 {
+			log.Printf("warning: skipping %s, embedded fields of this type are not supported", fieldNames(s.Name, field))
+			continue
+		}
+		for _, name := range names {
+			if excludedField(name.Name) {
+				continue
+			}
+			f := Field{
+				Name:         name.Name,
+				Type:         getFieldType(field.Type),
+				OverrideType: elementOverride(getFieldType(field.Type), override),
+				Opts:         make(map[string]string, len(fieldOpts)),
+			}
+			for k, v := range fieldOpts {
+				f.Opts[k] = v
+			}
+			if max, ok := f.Opts["maxlen"]; ok {
+				if _, err := strconv.Atoi(max); err != nil {
+					log.Printf("warning: ignoring invalid maxlen %q on %s.%s", max, s.Name, f.Name)
+					delete(f.Opts, "maxlen")
+				}
+			}
+			checkFieldVersions(s.Name, f)
+			if !tagged && !name.IsExported() {
+				// The generated methods are in the same package so can encode
+				// unexported fields, but only when asked to with a tag
+				continue
+			}
+			if flag, ok := f.Opts["presentif"]; ok && fieldTypes[flag] != "bool" {
+				log.Printf("warning: ignoring presentif on %s.%s, %s is not a bool field", s.Name, f.Name, flag)
+				delete(f.Opts, "presentif")
+			}
+			if _, ok := f.Opts["omitempty"]; ok {
+				if _, presentif := f.Opts["presentif"]; presentif || nonZero(f.Name, f.Type) == "" || omitted == 64 {
+					log.Printf("warning: ignoring omitempty on %s.%s, it is only supported on up to 64 fields of basic, slice, map and pointer types without presentif", s.Name, f.Name)
+					delete(f.Opts, "omitempty")
+				} else {
+					omitted++
+				}
+			}
+			if _, ok := f.Opts["gzip"]; ok && !isGzip(f) {
+				log.Printf("warning: ignoring gzip on %s.%s, only bytes and strings can be compressed", s.Name, f.Name)
+				delete(f.Opts, "gzip")
+			}
+			if converter, ok := f.Opts["converter"]; ok {
+				if converter == "" {
+					log.Printf("warning: ignoring converter on %s.%s, it names no functions", s.Name, f.Name)
+					delete(f.Opts, "converter")
+				} else {
+					// Written by the functions it names, whatever its type
+					s.Fields = append(s.Fields, f)
+					continue
+				}
+			}
+			if opts.DumpAST && f.OverrideType == "" {
+				dumpAST(s.Name, f.Name, field.Type)
+			}
+			if strings.HasPrefix(f.Type, "map[") {
+				if key, _ := splitMapType(f.Type); methodlessStruct(key) {
+					err := fmt.Errorf("%s.%s has map keys of type %s which has no enkodo methods, tag its fields to generate them", s.Name, f.Name, key)
+					skip(field, tagged, err)
+					continue
+				}
+			}
+			if unresolved(field.Type) != nil && f.OverrideType == "" {
+				err := fmt.Errorf("%s.%s has type %s which is not supported, add an override type to its enkodo tag to encode it", s.Name, f.Name, types.ExprString(field.Type))
+				skip(field, tagged, err)
+				continue
+			}
+			if opts.JSONFallback && f.OverrideType == "" {
+				f.JSON = needsJSONFallback(s.Name, f.Name)
+			}
+			if methodlessStruct(baseType(f.Type)) && !pointerConverter(f.Type) && f.OverrideType == "" && !f.JSON {
+				err := fmt.Errorf("%s.%s has type %s which has no enkodo methods, tag its fields to generate them", s.Name, f.Name, f.Type)
+				skip(field, tagged, err)
+				continue
+			}
+			if f.OverrideType == "" && !f.JSON && unregisteredInterface(baseType(f.Type)) {
+				err := fmt.Errorf("%s.%s has type %s, an interface without an //enkodo:register directive registering the types it holds", s.Name, f.Name, f.Type)
+				skip(field, tagged, err)
+				continue
+			}
+			if f.OverrideType == "" && !f.JSON && unsupportedImport(f.Type) {
+				err := fmt.Errorf("%s.%s has type %s which is not supported, add an override type to its enkodo tag to encode it", s.Name, f.Name, f.Type)
+				skip(field, tagged, err)
+				continue
+			}
+			s.Fields = append(s.Fields, f)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	s.Fields = append(s.Fields, accessorFields(s.Name, typeDocs[ts])...)
+	s.Version = structVersion(s.Name, typeDocs[ts])
+	s.Framed, s.MaxDepth, s.Budget = opts.Framed, opts.MaxDepth, opts.Budget
+	s.applyOptions(typeDocs[ts])
+	if s.Only = structOnly(s.Name, typeDocs[ts]); s.Only != "" {
+		other := map[string]string{"encode": "decode", "decode": "encode"}[s.Only]
+		s.skip = map[string]bool{other: true}
+	}
+	s.FieldVersion = s.fieldVersion()
+	if len(s.Fields) > 0 {
+		return s, nil
+	}
+	return nil, nil
+}
+
+// collectionStruct returns the Struct generating the enkodo methods of the named slice or
+// map declared by ts, or nil when its elements can not be encoded
+func collectionStruct(ts *ast.TypeSpec) *Struct {
+	if unresolved(ts.Type) != nil {
+		log.Printf("warning: skipping %s, its type %s is not supported", ts.Name.Name, types.ExprString(ts.Type))
+		return nil
+	}
+	// Its methods encode a single value, so it has no fields for msgpack to write
+	s := &Struct{
+		Name:       ts.Name.Name,
+		Collection: getFieldType(ts.Type),
+		MaxDepth:   opts.MaxDepth,
+		Budget:     opts.Budget,
+		skip:       map[string]bool{"msgpack": true},
+	}
+	s.applyOptions(typeDocs[ts])
+	return s
+}
+
+// positioned prefixes err with the file:line:column of pos in the file being generated
+func positioned(pos token.Pos, err error) error {
+	if fileSet == nil || !pos.IsValid() {
+		return err
+	}
+	return fmt.Errorf("%s: %w", fileSet.Position(pos), err)
+}
+
+const accessorDirective = "//enkodo:accessor "
+
+const onlyDirective = "//enkodo:only "
+
+const generateDirective = "//enkodo:generate"
+
+const optionsDirective = "//enkodo:options "
+
+// applyOptions applies the comma separated options of the //enkodo:options directives in
+// the doc comment of the struct over those of the generator, e.g.
+// //enkodo:options version=3,framed,maxdepth=8. Those are version, as declared by
+// //enkodo:version, framed or framed=false, maxdepth and budget. Invalid options are
+// ignored with a warning
+func (s *Struct) applyOptions(doc *ast.CommentGroup) {
+	if doc == nil {
+		return
+	}
+	for _, c := range doc.List {
+		list, ok := strings.CutPrefix(c.Text, optionsDirective)
+		if !ok {
+			continue
+		}
+		for _, option := range strings.Split(list, ",") {
+			key, value, hasValue := strings.Cut(strings.TrimSpace(option), "=")
+			var err error
+			switch key {
+			case "version", "maxdepth", "budget":
+				var n int
+				n, err = strconv.Atoi(value)
+				switch {
+				case err != nil:
+				case n < 0 || key == "version" && n == 0:
+					err = fmt.Errorf("%d is out of range", n)
+				case key == "version":
+					s.Version = n
+				case key == "maxdepth":
+					s.MaxDepth = n
+				default:
+					s.Budget = n
+				}
+			case "framed":
+				framed := true
+				if hasValue {
+					framed, err = strconv.ParseBool(value)
+				}
+				if err == nil {
+					s.Framed = framed
+				}
+			default:
+				err = errors.New("unknown option")
+			}
+			if err != nil {
+				log.Printf("warning: ignoring the option %q of %s: %v", strings.TrimSpace(option), s.Name, err)
+			}
+		}
+	}
+}
+
+// Types of the package being generated with an //enkodo:generate directive in their doc
+// comment, see loadGenerateDirectives
+var generateDirectives = map[string]bool{}
+
+// loadGenerateDirectives collects the types declared in files with an //enkodo:generate
+// directive in their doc comment. Every exported field of those structs is encoded as if
+// it were tagged, as with -all, and named slices and maps have their methods generated
+// even when no struct holds them
+func loadGenerateDirectives(files []*ast.File) {
+	generateDirectives = make(map[string]bool)
+	for _, fil := range files {
+		for _, decl := range fil.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				if ts := spec.(*ast.TypeSpec); hasGenerateDirective(gd, ts) {
+					generateDirectives[ts.Name.Name] = true
+				}
+			}
+		}
+	}
+}
+
+// hasGenerateDirective reports whether the doc comment of the type ts, declared in gd,
+// has an //enkodo:generate directive
+func hasGenerateDirective(gd *ast.GenDecl, ts *ast.TypeSpec) bool {
+	doc := ts.Doc
+	if doc == nil && len(gd.Specs) == 1 {
+		doc = gd.Doc
+	}
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		if strings.TrimSpace(c.Text) == generateDirective {
+			return true
+		}
+	}
+	return false
+}
+
+// allFields reports whether every exported field of the type name declared in the package
+// being generated is encoded, tagged or not, as it is exported under -all or has an
+// //enkodo:generate directive
+func allFields(name string) bool {
+	return opts.All && token.IsExported(name) || generateDirectives[name]
+}
+
+// excludedType reports whether Options.ExcludeType matches typ, by its name without the
+// package it is qualified with
+func excludedType(typ string) bool {
+	return opts.ExcludeType != nil && opts.ExcludeType.MatchString(typ[strings.LastIndex(typ, ".")+1:])
+}
+
+// excludedField reports whether Options.ExcludeField matches the field name
+func excludedField(name string) bool {
+	return opts.ExcludeField != nil && opts.ExcludeField.MatchString(name)
+}
+
+// structOnly returns the half of the enkodo methods that an //enkodo:only directive in
+// the doc comment of a struct limits it to, e.g. //enkodo:only encode for structs that
+// are only ever written
+func structOnly(structName string, doc *ast.CommentGroup) string {
+	if doc == nil {
+		return ""
+	}
+	for _, c := range doc.List {
+		only, ok := strings.CutPrefix(c.Text, onlyDirective)
+		if !ok {
+			continue
+		}
+		if only = strings.TrimSpace(only); only != "encode" && only != "decode" {
+			log.Printf("warning: ignoring %q on %s, only encode or decode can be generated alone", c.Text, structName)
+			return ""
+		}
+		return only
+	}
+	return ""
+}
+
+// accessorFields returns the fields declared by //enkodo:accessor directives in the doc
+// comment of a struct, e.g. //enkodo:accessor Name=GetName/SetName. They are encoded
+// after the tagged fields, in the order they are declared
+func accessorFields(structName string, doc *ast.CommentGroup) []Field {
+	if doc == nil {
+		return nil
+	}
+	fields := make([]Field, 0)
+	for _, c := range doc.List {
+		if !strings.HasPrefix(c.Text, accessorDirective) {
+			continue
+		}
+		for _, spec := range strings.Fields(strings.TrimPrefix(c.Text, accessorDirective)) {
+			f, err := accessorField(structName, spec)
+			if err != nil {
+				log.Printf("warning: ignoring accessor %q on %s: %v", spec, structName, err)
+				continue
+			}
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// needsJSONFallback reports whether the field of a struct has a type that enkodo can not
+// encode, but that can be encoded with encoding/json. Types with enkodo methods, or
+// tagged fields that will have them generated, are encoded with enkodo
+func needsJSONFallback(structName, fieldName string) bool {
+	if pkgTypes == nil {
+		return false
+	}
+	obj, ok := pkgTypes.Scope().Lookup(structName).(*types.TypeName)
+	if !ok {
+		return false
+	}
+	st, ok := obj.Type().Underlying().(*types.Struct)
+	if !ok {
+		return false
+	}
+	var typ types.Type
+	for i := 0; i < st.NumFields(); i++ {
+		if st.Field(i).Name() == fieldName {
+			typ = st.Field(i).Type()
+		}
+	}
+	named, ok := typ.(*types.Named)
+	if !ok {
+		return false // basic types, slices, maps and pointers are encoded by enkodo
+	}
+
+	methods := types.NewMethodSet(types.NewPointer(named))
+	has := func(name string) bool {
+		return methods.Lookup(named.Obj().Pkg(), name) != nil
+	}
+	if has("MarshalEnkodo") || !has("MarshalJSON") || !has("UnmarshalJSON") {
+		return false
+	}
+	if fields, ok := named.Underlying().(*types.Struct); ok && named.Obj().Pkg() == pkgTypes && !excludedType(named.Obj().Name()) && (hasEnkodoTags(fields) || allFields(named.Obj().Name())) {
+		return false
+	}
+	return true
+}
+
+// hasEnkodoTags reports whether any field of st has an enkodo tag, other than
+// enkodo:"-" which opts the field out, and is not excluded by Options.ExcludeField
+func hasEnkodoTags(st *types.Struct) bool {
+	for i := 0; i < st.NumFields(); i++ {
+		if value, ok := tagValue("`" + st.Tag(i) + "`"); ok && value != "-" && !excludedField(st.Field(i).Name()) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupType returns the type declared as typ in the package being generated or, for
+// qualified names such as othr.Config, in the package it imports
+func lookupType(typ string) (*types.TypeName, bool) {
+	if pkgTypes == nil {
+		return nil, false
+	}
+	scope := pkgTypes.Scope()
+	if pkg, name, ok := strings.Cut(typ, "."); ok {
+		scope = nil
+		for _, p := range pkgTypes.Imports() {
+			if p.Name() == pkg {
+				scope = p.Scope()
+			}
+		}
+		if scope == nil || !token.IsExported(name) {
+			return nil, false
+		}
+		typ = name
+	}
+	obj, ok := scope.Lookup(typ).(*types.TypeName)
+	return obj, ok
+}
+
+// structType returns the struct type declared as typ, see lookupType
+func structType(typ string) (*types.Struct, bool) {
+	obj, ok := lookupType(typ)
+	if !ok {
+		return nil, false
+	}
+	st, ok := obj.Type().Underlying().(*types.Struct)
+	return st, ok
+}
+
+// isEnkodoStruct reports whether typ is a struct with enkodo methods, either hand written
+// or generated for it, or a named slice or map they are generated for. Structs of
+// imported packages need methods in their own package, so -all only applies to the
+// package being generated
+func isEnkodoStruct(typ string) bool {
+	if _, ok := collections[typ]; ok {
+		return true
+	}
+	obj, ok := lookupType(typ)
+	if !ok {
+		return false
+	}
+	// Methods promoted from an embedded struct count, they are the struct's methods too
+	marshal, _, _ := types.LookupFieldOrMethod(types.NewPointer(obj.Type()), false, obj.Pkg(), "MarshalEnkodo")
+	switch u := obj.Type().Underlying().(type) {
+	case *types.Struct:
+		local := !strings.Contains(typ, ".")
+		return marshal != nil || !excludedType(typ) && (hasEnkodoTags(u) || local && allFields(typ))
+	case *types.Slice, *types.Map:
+		// Those of imported packages have their methods generated there
+		return marshal != nil
+	}
+	return false
+}
+
+// methodlessStruct reports whether typ is a struct without enkodo methods, that no
+// converter encodes either
+func methodlessStruct(typ string) bool {
+	if _, ok := structType(typ); !ok || isEnkodoStruct(typ) {
+		return false
+	}
+	_, ok := converter(typ)
+	return !ok
+}
+
+// pointerConverter reports whether typ holds pointers to its base type that have a
+// converter of their own, e.g. *big.Int in []*big.Int
+func pointerConverter(typ string) bool {
+	base := baseType(typ)
+	_, ok := converter("*" + base)
+	return ok && strings.Contains(typ, "*"+base)
+}
+
+// unsupportedImport reports whether typ holds a type of an imported package that can not
+// be encoded, being neither a named basic type, a type with a converter nor a struct with
+// enkodo methods. Without type information nothing is known to be unsupported
+func unsupportedImport(typ string) bool {
+	base := baseType(typ)
+	if !strings.Contains(base, ".") {
+		return false
+	}
+	if _, ok := lookupType(base); !ok || isEnkodoStruct(base) {
+		return false
+	}
+	_, conv := converter(base)
+	_, named := namedTypes[base]
+	return !conv && !named && !pointerConverter(typ)
+}
+
+// accessorField parses a single Name=Getter/Setter accessor and checks the methods
+// against the package type information. The field type is the getter's result type
+func accessorField(structName, spec string) (Field, error) {
+	name, methods, ok := strings.Cut(spec, "=")
+	getter, setter, ok2 := strings.Cut(methods, "/")
+	if !ok || !ok2 || name == "" || getter == "" || setter == "" {
+		return Field{}, fmt.Errorf("expected Name=Getter/Setter")
+	}
+	if pkgTypes == nil {
+		return Field{}, fmt.Errorf("no type information for the package")
+	}
+	obj, ok := pkgTypes.Scope().Lookup(structName).(*types.TypeName)
+	if !ok {
+		return Field{}, fmt.Errorf("%s not found in package %s", structName, pkgTypes.Name())
+	}
+	methodSet := types.NewMethodSet(types.NewPointer(obj.Type()))
+	method := func(name string) *types.Signature {
+		if sel := methodSet.Lookup(pkgTypes, name); sel != nil {
+			return sel.Type().(*types.Signature)
+		}
+		return nil
+	}
+
+	get := method(getter)
+	if get == nil {
+		return Field{}, fmt.Errorf("no method %s", getter)
+	}
+	if get.Params().Len() != 0 || get.Results().Len() != 1 {
+		return Field{}, fmt.Errorf("%s must take no arguments and return one value", getter)
+	}
+	typ := get.Results().At(0).Type()
+
+	set := method(setter)
+	if set == nil {
+		return Field{}, fmt.Errorf("no method %s", setter)
+	}
+	if set.Params().Len() != 1 || !types.Identical(set.Params().At(0).Type(), typ) {
+		return Field{}, fmt.Errorf("%s must take a single %s", setter, typ)
+	}
+	errType := types.Universe.Lookup("error").Type()
+	setterErr := set.Results().Len() == 1 && types.Identical(set.Results().At(0).Type(), errType)
+	if set.Results().Len() > 1 || set.Results().Len() == 1 && !setterErr {
+		return Field{}, fmt.Errorf("%s may only return an error", setter)
+	}
+
+	qualifier := func(p *types.Package) string {
+		if p == pkgTypes {
+			return ""
+		}
+		return p.Name()
+	}
+	return Field{
+		Name:      name,
+		Type:      types.TypeString(typ, qualifier),
+		Getter:    getter,
+		Setter:    setter,
+		SetterErr: setterErr,
+	}, nil
+}
+
+// hasMember reports whether the package type information has a field or method called
+// name on the struct. Without type information nothing is known to exist
+func hasMember(structName, name string) bool {
+	obj, ok := lookupType(structName)
+	if !ok {
+		return false
+	}
+	member, index, _ := types.LookupFieldOrMethod(types.NewPointer(obj.Type()), false, pkgTypes, name)
+	// Members promoted from embedded fields are shadowed by the struct's own
+	return member != nil && len(index) == 1
+}
+
+// AccessorFuncs writes a GetField and SetField method for each encoded field, giving
+// access to unexported fields without exporting them. Methods the struct already has,
+// in the source or through an //enkodo:accessor directive, are left alone
+func (s *Struct) AccessorFuncs(f io.Writer) {
+	fnRef := strings.ToLower(s.Name[0:1])
+	for _, field := range s.Fields {
+		if field.Getter != "" {
+			continue
+		}
+		exported := strings.ToUpper(field.Name[0:1]) + field.Name[1:]
+		if getter := "Get" + exported; !hasMember(s.Name, getter) {
+			fmt.Fprintf(f, "// %s returns the %s field of %s\n", getter, field.Name, s.Name)
+			fmt.Fprintf(f, "func (%s *%s) %s() %s {\n", fnRef, s.Name, getter, field.Type)
+			fmt.Fprintf(f, "%sreturn %s.%s\n}\n\n", ident, fnRef, field.Name)
+		}
+		if setter := "Set" + exported; !hasMember(s.Name, setter) {
+			fmt.Fprintf(f, "// %s sets the %s field of %s\n", setter, field.Name, s.Name)
+			fmt.Fprintf(f, "func (%s *%s) %s(value %s) {\n", fnRef, s.Name, setter, field.Type)
+			fmt.Fprintf(f, "%s%s.%s = value\n}\n\n", ident, fnRef, field.Name)
+		}
+	}
+}
+
+// runtimePath returns the import path of the enkodo runtime, see Options.Runtime
+func runtimePath() string {
+	if opts.Runtime != "" {
+		return opts.Runtime
+	}
+	return packageName
+}
+
+// importSpec returns the import of path. The runtime is always used as enkodo, so it is
+// named when its path ends in anything else
+func importSpec(path string) string {
+	if path == runtimePath() && path[strings.LastIndex(path, "/")+1:] != "enkodo" {
+		return fmt.Sprintf("enkodo %q", path)
+	}
+	return strconv.Quote(path)
+}
+
+// isGenerated reports whether file was written by enkodo, going by the header before
+// its package clause, so sources merely mentioning the header are not mistaken for it
+func isGenerated(file string) bool {
+	fil, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return false
+	}
+	for _, group := range fil.Comments {
+		for _, c := range group.List {
+			if c.Pos() < fil.Package && c.Text == generatedHeader {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// typeCheck loads the type information for the package of fil, returning it with the
+// files it was checked from. When the file is on disk the other go files in its
+// directory are checked along with it, leaving out tests and files written by enkodo,
+// otherwise the other sources of its package, e.g. those added to a Generator, are.
+// Errors are ignored so whatever does check is still usable
+func typeCheck(fset *token.FileSet, file string, fil *ast.File, fromDisk bool, sources []generatorSource) (*types.Package, []*ast.File) {
+	files := []*ast.File{fil}
+	dir := filepath.Dir(file)
+	if !fromDisk {
+		for _, source := range sources {
+			if source.name == file {
+				continue
+			}
+			if other, err := parser.ParseFile(fset, source.name, source.src, parser.ParseComments); err == nil && other.Name.Name == fil.Name.Name {
+				files = append(files, other)
+			}
+		}
+	} else {
+		checkedMu.Lock()
+		p, ok := checkedPackages[dir]
+		checkedMu.Unlock()
+		if ok {
+			return p.types, p.files
+		}
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+		for _, m := range matches {
+			if filepath.Clean(m) == filepath.Clean(file) || strings.HasSuffix(m, "_test.go") || isGenerated(m) {
+				continue
+			}
+			other, err := parser.ParseFile(fset, m, nil, parser.ParseComments)
+			if err != nil || other.Name.Name != fil.Name.Name {
+				continue
+			}
+			files = append(files, other)
+		}
+	}
+	conf := types.Config{Importer: importerFor(dir), Error: func(error) {}}
+	p, _ := conf.Check(fil.Name.Name, fset, files, nil)
+	if fromDisk {
+		checkedMu.Lock()
+		checkedPackages[dir] = checkedPackage{p, files}
+		checkedMu.Unlock()
+	}
+	return p, files
+}
+
+// moduleDir returns the directory of the go.mod of the module holding dir, or dir when
+// it is not in one
+func moduleDir(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return dir
+	}
+	for d := abs; ; {
+		if _, err := os.Stat(filepath.Join(d, "go.mod")); err == nil {
+			return d
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir
+		}
+		d = parent
+	}
+}
+
+// loadPackages type checks the packages of files before they are generated, Options.Jobs
+// packages at once, as loading their imports takes most of the time on large trees. Each
+// is checked from the file typeCheck would check it from when generating, and the code is
+// still generated a file at a time in order, so the output is the same as checking them
+// one by one
+func loadPackages(files []string) {
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = runtime.GOMAXPROCS(0)
+	}
+	if jobs == 1 {
+		return // Checked as they are generated
+	}
+
+	// The first file of each package
+	first := make(map[string]string)
+	order := make([]string, 0)
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		if _, ok := first[dir]; ok {
+			continue
+		}
+		first[dir] = file
+		order = append(order, dir)
+	}
+
+	dirs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < min(jobs, len(order)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dir := range dirs {
+				fset := token.NewFileSet()
+				// Files that do not parse are reported when they are generated
+				if fil, err := parser.ParseFile(fset, first[dir], nil, parser.ParseComments); err == nil {
+					typeCheck(fset, first[dir], fil, true, nil)
+				}
+			}
+		}()
+	}
+	for _, dir := range order {
+		dirs <- dir
+	}
+	close(dirs)
+	wg.Wait()
+}
+
+// addNamedTypes adds the named basic types declared in scope to namedTypes, with their
+// names prefixed by qualifier. Types with a converter of their own, e.g. time.Duration,
+// keep it
+func addNamedTypes(scope *types.Scope, qualifier string) {
+	for _, name := range scope.Names() {
+		tn, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok || tn.IsAlias() || qualifier != "" && !tn.Exported() {
+			continue
+		}
+		if _, ok := converter(qualifier + name); ok {
+			continue
+		}
+		if basic, ok := tn.Type().Underlying().(*types.Basic); ok {
+			if _, ok := converter(basic.Name()); ok {
+				namedTypes[qualifier+name] = basic.Name()
+			}
+		}
+	}
+}
+
+// addCollections adds the named slices and maps of the package being generated that the
+// fields of its enkodo structs hold to collections, directly or through one another, as
+// those fields are encoded with their enkodo methods. Those with -all or an
+// //enkodo:generate directive are added too, so they can be encoded on their own
+func addCollections(scope *types.Scope) {
+	qualifier := func(p *types.Package) string {
+		if p == pkgTypes {
+			return ""
+		}
+		return p.Name()
+	}
+	var add func(typ types.Type)
+	add = func(typ types.Type) {
+		switch t := typ.(type) {
+		case *types.Named:
+			if t.Obj().Pkg() != pkgTypes || t.TypeParams().Len() > 0 || t.TypeArgs().Len() > 0 {
+				return
+			}
+			if _, ok := collections[t.Obj().Name()]; ok || excludedType(t.Obj().Name()) {
+				return
+			}
+			switch u := t.Underlying().(type) {
+			case *types.Slice, *types.Map:
+				collections[t.Obj().Name()] = types.TypeString(u, qualifier)
+				add(u)
+			}
+		case *types.Pointer:
+			add(t.Elem())
+		case *types.Slice:
+			add(t.Elem())
+		case *types.Array:
+			add(t.Elem())
+		case *types.Map:
+			add(t.Key())
+			add(t.Elem())
+		}
+	}
+	for _, name := range scope.Names() {
+		tn, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok || tn.IsAlias() || excludedType(name) {
+			continue
+		}
+		switch u := tn.Type().Underlying().(type) {
+		case *types.Struct:
+			for i := 0; i < u.NumFields(); i++ {
+				value, tagged := tagValue("`" + u.Tag(i) + "`")
+				if !tagged && !(allFields(name) && u.Field(i).Exported()) || value == "-" || excludedField(u.Field(i).Name()) {
+					continue
+				}
+				// Overridden fields are encoded as the override type instead, and those
+				// with a converter by its functions
+				if override, fieldOpts := parseTag(value); override == "" && fieldOpts["converter"] == "" {
+					add(u.Field(i).Type())
+				}
+			}
+		case *types.Slice, *types.Map:
+			if allFields(name) {
+				add(tn.Type())
+			}
+		}
+	}
+}
+
+// resetState clears what was collected about the package being generated
+func resetState() {
+	namedTypes = make(map[string]string)
+	collections = make(map[string]string)
+	dotImportPaths = make(map[string]string)
+	methods = nil
+	sourceHash = ""
+}
+
+// parseFile returns the package name and the enkodo structs found in a go source file.
+// src is passed to parser.ParseFile, so it may be nil to read the file from disk
+func parseFile(file string, src interface{}) (pkg string, structs []*Struct, err error) {
+	return parseSource(file, src, nil)
+}
+
+// parseSource is parseFile for a file held in memory with the other sources of its
+// package, which it is type checked along with
+func parseSource(file string, src interface{}, sources []generatorSource) (pkg string, structs []*Struct, err error) {
+	fset := token.NewFileSet()
+	fil, err := parser.ParseFile(fset, file, src, parser.ParseComments)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+
+	pkg = fil.Name.Name // package name
+	fileSet = fset
+	var files []*ast.File
+	pkgTypes, files = typeCheck(fset, file, fil, src == nil, sources)
+	loadGenerateDirectives(files)
+
+	typeDocs = make(map[*ast.TypeSpec]*ast.CommentGroup)
+	for _, decl := range fil.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			if ts.Doc != nil {
+				typeDocs[ts] = ts.Doc
+			} else if len(gd.Specs) == 1 {
+				typeDocs[ts] = gd.Doc
+			}
+		}
+	}
+
+	// Dot imports are scoped to the file, unlike named types and import paths which are
+	// collected for the whole package
+	dotImports = make(map[string]string)
+	for _, spec := range fil.Imports {
+		if spec.Name == nil || spec.Name.Name != "." {
+			continue
+		}
+		path, _ := strconv.Unquote(spec.Path.Value)
+		dir := filepath.Dir(file)
+		p, err := importerFor(dir).ImportFrom(path, dir, 0)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to load dot import %s in %s: %w", path, file, err)
+		}
+		dotImportPaths[p.Name()] = path
+		for _, name := range p.Scope().Names() {
+			if _, ok := p.Scope().Lookup(name).(*types.TypeName); ok && token.IsExported(name) {
+				dotImports[name] = p.Name() + "." + name
+			}
+		}
+	}
+
+	// Named basic types, e.g. type SocialMedia string, are encoded as their underlying
+	// type. The type information covers every file of the package and named types of
+	// named types, without it only the types declared in this file are found
+	if pkgTypes != nil {
+		addNamedTypes(pkgTypes.Scope(), "")
+		// Those of imported packages are used by qualified name, e.g. othr.Level
+		for _, p := range pkgTypes.Imports() {
+			addNamedTypes(p.Scope(), p.Name()+".")
+		}
+		// Named slices and maps the structs hold have enkodo methods generated as well
+		addCollections(pkgTypes.Scope())
+	} else {
+		for _, obj := range fil.Scope.Objects {
+			if ts, ok := obj.Decl.(*ast.TypeSpec); ok {
+				if id, ok := ts.Type.(*ast.Ident); ok {
+					if _, ok := converter(id.Name); ok {
+						namedTypes[ts.Name.Name] = id.Name
+					}
+				}
+			}
+		}
+	}
+
+	loadRegistries(files)
+
+	// The scope is a map, so its objects are sorted by position to generate the structs
+	// in the order they are declared
+	objects := make([]*ast.Object, 0, len(fil.Scope.Objects))
+	for _, obj := range fil.Scope.Objects {
+		if obj.Decl != nil {
+			objects = append(objects, obj)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Pos() < objects[j].Pos() })
+
+	// The errors of every struct are returned together, they are positioned in the file
+	var errs []error
+	structs = make([]*Struct, 0)
+	for _, obj := range objects {
+
+		s, err := getStructFields(obj)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if s == nil {
+			continue
+		}
+		if err = s.checkHelpers(); err != nil {
+			errs = append(errs, positioned(obj.Pos(), err))
+			continue
+		}
+		if s.checkHandWritten() {
+			continue // Neither method is left to generate
+		}
+		structs = append(structs, s)
+	}
+	if len(errs) > 0 {
+		return "", nil, errors.Join(errs...)
+	}
+	// The other files of the package are found through its type information
+	markRecursive(structs)
+	return
+}
+
+// helperMethods are the optional methods generated by flags, by the flag enabling them
+func helperMethods() map[string][]string {
+	helpers := make(map[string][]string)
+	if opts.Msgpack {
+		helpers["msgpack"] = []string{"MarshalMsgpack", "UnmarshalMsgpack"}
+	}
+	if opts.CountBytes {
+		helpers["countbytes"] = []string{"UnmarshalEnkodoN"}
+	}
+	if opts.EncodedSize {
+		helpers["encodedsize"] = []string{"EncodedSize"}
+	}
+	if opts.Binary {
+		helpers["binary"] = []string{"MarshalBinary", "UnmarshalBinary"}
+	}
+	return helpers
+}
+
+// checkHelpers finds the helper methods that the struct already has a method or field
+// for, which would not compile if generated. They are skipped with a warning, or are an
+// error under -strict
+func (s *Struct) checkHelpers() error {
+	helpers := helperMethods()
+	flags := make([]string, 0, len(helpers))
+	for flag := range helpers {
+		flags = append(flags, flag)
+	}
+	sort.Strings(flags)
+	for _, flag := range flags {
+		for _, method := range helpers[flag] {
+			if !hasMember(s.Name, method) {
+				continue
+			}
+			err := fmt.Errorf("%s already has a %s, which -%s would generate", s.Name, method, flag)
+			if opts.Strict {
+				return err
+			}
+			log.Printf("warning: skipping the -%s methods of %s: %v", flag, s.Name, err)
+			if s.skip == nil {
+				s.skip = make(map[string]bool)
+			}
+			s.skip[flag] = true
+			break
+		}
+	}
+	return nil
+}
+
+// checkHandWritten skips generating the enkodo methods that the struct already has,
+// written by hand in any file of the package, which would otherwise be declared twice.
+// It reports whether that leaves neither method to generate
+func (s *Struct) checkHandWritten() bool {
+	for _, m := range []struct{ block, method string }{{"encode", "MarshalEnkodo"}, {"decode", "UnmarshalEnkodo"}} {
+		if s.skip[m.block] || !hasMember(s.Name, m.method) {
+			continue
+		}
+		log.Printf("warning: not generating %s.%s, it is already declared", s.Name, m.method)
+		if s.skip == nil {
+			s.skip = make(map[string]bool)
+		}
+		s.skip[m.block] = true
+	}
+	return s.skip["encode"] && s.skip["decode"]
+}
+
+// writeFile writes the generated enkodo file for the structs to w, formatted like
+// gofmt. declare is set when this file should hold the package wide declarations (e.g.
+// -emit-interfaces)
+func writeFile(w io.Writer, pkg string, structs []*Struct, declare bool) error {
+	out := bytes.NewBuffer(nil)
+	// By default we import enkodo
+	imports := map[string]interface{}{
+		runtimePath(): true,
+	}
+	// Check all the types that we will convert and see if they need to import anything
+	for _, struc := range structs {
+		for _, field := range struc.codecFields() {
+			ty := field.Type
+			if field.OverrideType != "" {
+				ty = field.OverrideType
+			}
+			if field.JSON {
+				imports["encoding/json"] = true
+			}
+			conv, ok := fieldConverter(field, ty)
+			if !ok {
+				conv, ok = converter(baseType(ty))
+			}
+			if ok {
+				for _, impt := range conv.Imports() {
+					imports[impt] = true
+				}
+			}
+		}
+	}
+
+	// The generic helpers are declared once per package, any other file that calls
+	// them still needs the build constraint
+	generics := opts.Generics && declare
+	for _, struc := range structs {
+		for _, field := range struc.codecFields() {
+			if opts.Generics && strings.HasPrefix(field.Type, "[]") && field.Type != "[]byte" {
+				generics = true
+			}
+		}
+	}
+
+	// Write the methods first so we know which dot imported packages they refer to
+	body := bytes.NewBuffer(nil)
+	for _, st := range structs {
+		if err := st.execute(body, "methods"); err != nil {
+			return err
+		}
+		if opts.Msgpack && !st.skip["msgpack"] {
+			imports[runtimePath()+"/msgpack"] = true
+		}
+	}
+	// Converters may not refer to the packages they import, e.g. time.Time is written
+	// with enkodo functions, so imports the methods do not use are dropped
+	for path := range imports {
+		if path != runtimePath() && !strings.Contains(body.String(), path[strings.LastIndex(path, "/")+1:]+".") {
+			delete(imports, path)
+		}
+	}
+	// Packages qualifying the field types or converters, e.g. othr in othr.Level, are
+	// imported the same as dot imported ones when the methods refer to them
+	qualified := make(map[string]string, len(dotImportPaths))
+	for name, path := range dotImportPaths {
+		qualified[name] = path
+	}
+	for _, struc := range structs {
+		for _, field := range struc.codecFields() {
+			encode, _, _ := converterFuncs(field)
+			for _, match := range qualifier.FindAllStringSubmatch(field.Type+" "+encode, -1) {
+				if path, ok := importPath(match[1]); ok {
+					qualified[match[1]] = path
+				}
+			}
+		}
+	}
+	for name, path := range qualified {
+		for _, line := range strings.Split(body.String(), "\n") {
+			if !strings.HasPrefix(strings.TrimSpace(line), "//") && strings.Contains(line, name+".") {
+				imports[path] = true
+				break
+			}
+		}
+	}
+
+	if generics {
+		fmt.Fprint(out, "//go:build go1.18\n\n")
+	}
+	fmt.Fprint(out, generatedHeader+"\n")
+	if sourceHash != "" {
+		fmt.Fprint(out, hashDirective+sourceHash+"\n")
+	}
+	fmt.Fprintf(out, "package %s\n\n", pkg)
+	paths := make([]string, 0, len(imports))
+	for i := range imports {
+		paths = append(paths, i)
+	}
+	sort.Strings(paths)
+	for _, i := range paths {
+		fmt.Fprintf(out, "import %s\n", importSpec(i))
+	}
+	fmt.Fprintln(out, "")
+	if opts.Generics && declare {
+		fmt.Fprint(out, genericHelpers)
+	}
+	if opts.Gated && declare {
+		fmt.Fprint(out, gateDecl)
+	}
+	if len(structs) > 0 {
+		// Changes to the runtime interfaces break the build here rather than where the
+		// structs are passed to enkodo
+		fmt.Fprint(out, "// The generated structs implement the enkodo runtime interfaces\nvar (\n")
+		for _, st := range structs {
+			if st.Only != "decode" {
+				fmt.Fprintf(out, "%s_ enkodo.Encodee = (*%s)(nil)\n", ident, st.Name)
+			}
+			if st.Only != "encode" {
+				fmt.Fprintf(out, "%s_ enkodo.Decodee = (*%s)(nil)\n", ident, st.Name)
+			}
+		}
+		fmt.Fprint(out, ")\n\n")
+	}
+	if opts.EmitInterfaces {
+		if declare {
+			fmt.Fprint(out, interfacesDecl)
+		}
+		for _, st := range structs {
+			if st.Only != "decode" {
+				fmt.Fprintf(out, "var _ EnkodoMarshaler = (*%s)(nil)\n", st.Name)
+			}
+			if st.Only != "encode" {
+				fmt.Fprintf(out, "var _ EnkodoUnmarshaler = (*%s)(nil)\n", st.Name)
+			}
+		}
+		fmt.Fprintln(out, "")
+	}
+	body.WriteTo(out)
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		// Write the code anyway, so what the generator got wrong can be seen
+		w.Write(out.Bytes())
+		return fmt.Errorf("failed to format the generated code for %s: %w", pkg, err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// Generate generates code for the go source file src, writing it to w. Nothing is
+// written if src has no enkodo structs
+func Generate(w io.Writer, src []byte) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if err := checkOptions(); err != nil {
+		return err
+	}
+	resetState()
+	pkg, structs, err := parseFile("input.go", src)
+	if err != nil {
+		return err
+	}
+
+	if len(structs) == 0 || !selected(structs) {
+		return nil
+	}
+	return writeFile(w, pkg, structs, true)
+}
+
+// The structs of Options.Types found so far by GeneratePath
+var typesFound = map[string]bool{}
+
+// selected reports whether structs should be generated, which without Options.Types is
+// always. Otherwise one of them must be in Types, and those that are are noted as found
+func selected(structs []*Struct) bool {
+	if len(opts.Types) == 0 {
+		return true
+	}
+	ok := false
+	for _, s := range structs {
+		if slices.Contains(opts.Types, s.Name) {
+			typesFound[s.Name] = true
+			ok = true
+		}
+	}
+	return ok
+}
+
+// GeneratePath generates code for every file under root, with a file generated per
+// source file or, with MaxStructs set, per package
+func GeneratePath(root string) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if err := checkOptions(); err != nil {
+		return err
+	}
+	files := collectFiles(root)
+	if len(files) == 0 {
+		return fmt.Errorf("no input files in %s", root)
+	}
+	typesFound = make(map[string]bool)
+	checkedPackages = make(map[string]checkedPackage)
+	var err error
+	if lang := decoderLanguages[opts.Lang]; lang != nil {
+		loadPackages(files)
+		err = generateDecoders(root, lang)
+	} else if err = checkOutputs(files); err == nil {
+		// Checked before leaving out the packages that are up to date, which would still
+		// have their code overwritten
+		if files = outdated(files); len(files) > 0 {
+			loadPackages(files)
+			err = generatePath(files)
+		}
+	}
+	if stale := finishStale(); err == nil {
+		err = stale
+	}
+	// Under Diff the differences are the output rather than a failure
+	var changes *StaleError
+	if opts.Diff && !opts.Check && errors.As(err, &changes) {
+		fmt.Print(changes.Diff)
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, name := range opts.Types {
+		if !typesFound[name] {
+			return fmt.Errorf("no enkodo struct %s found in %s", name, root)
+		}
+	}
+	return nil
+}
+
+func generatePath(files []string) error {
+	_, single := packageOutput("")
+	if outputIsDir() {
+		if err := os.MkdirAll(opts.Output, 0o755); err != nil {
+			return err
+		}
+		for _, file := range files {
+			if !sameDir(filepath.Dir(file), opts.Output) {
+				log.Printf("warning: writing to %s, outside the package directory of %s. Go only allows methods in the package of their type, so the generated code will not compile there", opts.Output, file)
+				break
+			}
+		}
+	}
+
+	if !single && opts.MaxStructs == 0 {
+		for _, file := range files {
+			if err := generateFile(file); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	packages := make(map[string][]string)
+	dirs := make([]string, 0)
+	tests := make([]string, 0)
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			// Test files are generated on their own to keep them out of the regular build
+			tests = append(tests, file)
+			continue
+		}
+		dir := filepath.Dir(file)
+		if _, ok := packages[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		packages[dir] = append(packages[dir], file)
+	}
+	for _, dir := range dirs {
+		if err := generatePackage(dir, packages[dir]); err != nil {
+			return err
+		}
+	}
+	for _, file := range tests {
+		if err := generateFile(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Name of the file -combined generates each package into
+const combinedName = "enkodo_gen.go"
+
+// checkOutputs returns an error when the code of different files, or of different packages
+// when they are generated whole, would be written to the same file, as each would overwrite
+// the code of the one before it. This happens when Options.Output names a directory or a
+// file outside of the packages
+func checkOutputs(files []string) error {
+	if opts.Stdout {
+		return nil
+	}
+	from := make(map[string]string)
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		output, source := outputName(file), file
+		if name, single := packageOutput(dir); single && !strings.HasSuffix(file, "_test.go") {
+			output, source = name, dir
+		} else if opts.MaxStructs > 0 && !strings.HasSuffix(file, "_test.go") {
+			outDir := dir
+			if opts.Output != "" {
+				outDir = opts.Output
+			}
+			output, source = filepath.Join(outDir, "enkodo_gen_0.go"), dir
+		}
+		output = filepath.Clean(output)
+		if prev, ok := from[output]; ok && prev != source {
+			return fmt.Errorf("%s and %s would both be generated into %s, generate them into separate outputs", prev, source, output)
+		}
+		from[output] = source
+	}
+	return nil
+}
+
+// packageOutput returns the single file the package in dir is generated into, when
+// Options.Output names a file or with Options.Combined
+func packageOutput(dir string) (string, bool) {
+	switch {
+	case filepath.Ext(opts.Output) == ".go" && filepath.Base(opts.Output) == opts.Output:
+		return filepath.Join(dir, opts.Output), true
+	case filepath.Ext(opts.Output) == ".go":
+		return opts.Output, true
+	case opts.Combined && opts.Output != "":
+		return filepath.Join(opts.Output, combinedName), true
+	case opts.Combined:
+		return filepath.Join(dir, combinedName), true
+	}
+	return "", false
+}
+
+// outputIsDir reports whether Options.Output names a directory
+func outputIsDir() bool {
+	return opts.Output != "" && filepath.Ext(opts.Output) != ".go"
+}
+
+// sameDir reports whether the directories a and b are the same
+func sameDir(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// GeneratePackage generates code for all the files of a package in dir together,
+// splitting the output into enkodo_gen_<n>.go files of at most MaxStructs structs, or
+// into the single file named by Options.Output
+func GeneratePackage(dir string, files []string) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return generatePackage(dir, files)
+}
+
+func generatePackage(dir string, files []string) error {
+	resetState()
+	sourceHash = sourceHashes[dir]
+	var (
+		pkg string
+		all []*Struct
+	)
+	for _, file := range files {
+		p, structs, err := parseFile(file, nil)
+		if err != nil {
+			return err
+		}
+		pkg = p
+		all = append(all, structs...)
+	}
+	if !selected(all) {
+		return nil
+	}
+
+	outDir := dir
+	if opts.Output != "" {
+		outDir = opts.Output
+	}
+	output, single := packageOutput(dir)
+
+	// Remove the files from a previous run, there may be fewer of them now. Those of
+	// another layout would redeclare the methods
+	old, _ := filepath.Glob(filepath.Join(outDir, "enkodo_gen_*.go"))
+	if single {
+		old = old[:0]
+		for _, file := range files {
+			old = append(old, outputName(file))
+		}
+	} else {
+		old = append(old, filepath.Join(outDir, combinedName))
+	}
+	for _, file := range old {
+		if isGenerated(file) {
+			removeOutput(file)
+		}
+	}
+
+	if len(all) == 0 {
+		return nil
+	}
+	if opts.StrictOrder {
+		if err := checkFieldOrder(dir, all); err != nil {
+			return err
+		}
+	}
+	if !opts.Stdout && !dryRun() {
+		if err := recordVersions(dir, pkg, all); err != nil {
+			return err
+		}
+	}
+	declaredInterfaces[dir+" "+pkg] = true
+	// Sort by name so structs land in the same file between runs
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	if (opts.Compare || opts.Bench) && !opts.Stdout {
+		if err := writeCompareFile(compareName(filepath.Join(dir, "enkodo.go")), pkg, all); err != nil {
+			return err
+		}
+	}
+	if (opts.Tests || opts.Fuzz) && !opts.Stdout {
+		if err := writeTestFile(filepath.Join(dir, "enkodo_gen_test.go"), pkg, all); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; len(all) > 0; i++ {
+		n := len(all)
+		if !single && opts.MaxStructs > 0 {
+			n = min(opts.MaxStructs, n)
+		}
+		chunk := all[:n]
+		all = all[n:]
+
+		if opts.Stdout {
+			if err := writeFile(os.Stdout, pkg, chunk, i == 0); err != nil {
+				return err
+			}
+			continue
+		}
+		filename := filepath.Join(outDir, fmt.Sprintf("enkodo_gen_%d.go", i))
+		if single {
+			filename = output
+		}
+		if !dryRun() {
+			logf("Saving %d enkodo structs in %s to %s\n", len(chunk), dir, filename)
+		}
+		out, err := createOutput(filename)
+		if err != nil {
+			return err
+		}
+		err = writeFile(out, pkg, chunk, i == 0)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// outputName returns the file generated for file, in the directory named by
+// Options.Output if it names one. Test files generate into a test file so the code stays
+// in the test build
+func outputName(file string) string {
+	name := file[:len(file)-len(filepath.Ext(file))] + "_enkodo.go"
+	if base, ok := strings.CutSuffix(file, "_test.go"); ok {
+		name = base + "_enkodo_test.go"
+	}
+	if outputIsDir() {
+		return filepath.Join(opts.Output, filepath.Base(name))
+	}
+	return name
+}
+
+// CollectFiles returns the go files under root to generate for, as walked by
+// walkFiles. Files written by enkodo are never generated for, and test files are only
+// included with IncludeTests. Tests come after the other files so package wide
+// declarations are written to a file that is part of the regular build. A root that is a
+// file, e.g. $GOFILE under go generate, is always included
+func CollectFiles(root string) []string {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return collectFiles(root)
+}
+
+func collectFiles(root string) []string {
+	if info, err := os.Stat(root); err == nil && !info.IsDir() {
+		return []string{root}
+	}
+	files := make([]string, 0, 10)
+	tests := make([]string, 0)
+	walkFiles(root, func(path string) {
+		if isGenerated(path) {
+			return
+		}
+		if strings.HasSuffix(path, "_test.go") {
+			if opts.IncludeTests {
+				tests = append(tests, path)
+			}
+			return
+		}
+		files = append(files, path)
+	})
+	return append(files, tests...)
+}
+
+// rootDir returns the directory of the path given to walkFiles, the path without a
+// trailing /... or the directory of a file
+func rootDir(root string) string {
+	if root = strings.TrimSuffix(root, "..."); root == "" {
+		return "."
+	}
+	if info, err := os.Stat(root); err == nil && !info.IsDir() {
+		return filepath.Dir(root)
+	}
+	return root
+}
+
+// walkFiles calls fn with each go file under root. Like the go tool, it leaves out
+// directories starting with . or _ and testdata, as well as those Options.Exclude
+// matches. A trailing /... is accepted as the walk already includes subdirectories
+func walkFiles(root string, fn func(path string)) {
+	if root = strings.TrimSuffix(root, "..."); root == "" {
+		root = "."
+	}
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("warning: skipping %s: %v", path, err)
+			return nil
+		}
+		if d.IsDir() {
+			if skipDir(root, path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) == ".go" && !excluded(root, path) {
+			fn(path)
+		}
+		return nil
+	})
+}
+
+// skipDir reports whether walkFiles leaves out the directory path under root
+func skipDir(root, path string) bool {
+	name := filepath.Base(path)
+	return path != root && (name[0] == '.' || name[0] == '_' || name == "testdata" || excluded(root, path))
+}
+
+// excluded reports whether a pattern of Options.Exclude matches path, relative to the
+// walked root or by its name
+func excluded(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = path
+	}
+	for _, pattern := range opts.Exclude {
+		pattern = filepath.Clean(filepath.FromSlash(pattern))
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Clean removes the files written by enkodo under root, such as those left behind for
+// renamed or removed structs, returning their names. Migration stubs are kept, they are
+// yours once written
+func Clean(root string) ([]string, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	removed := make([]string, 0)
+	var errs []error
+	walkFiles(root, func(path string) {
+		if !isGenerated(path) {
+			return
+		}
+		if err := os.Remove(path); err != nil {
+			errs = append(errs, err)
+			return
+		}
+		removed = append(removed, path)
+	})
+	return removed, errors.Join(errs...)
+}
+
+// GenerateFile generates code for the go source file at path file, into the file
+// named by outputName
+func GenerateFile(file string) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return generateFile(file)
+}
+
+func generateFile(file string) (err error) {
+	resetState()
+	sourceHash = sourceHashes[filepath.Dir(file)]
+	pkg, structs, err := parseFile(file, nil)
+	if err != nil {
+		return err
+	}
+
+	if len(structs) == 0 || !selected(structs) {
+		return nil
+	}
+	if opts.StrictOrder {
+		if err := checkFieldOrder(filepath.Dir(file), structs); err != nil {
+			return err
+		}
+	}
+	if !opts.Stdout && !dryRun() {
+		if err := recordVersions(filepath.Dir(file), pkg, structs); err != nil {
+			return err
+		}
+	}
+	// open the output file
+	var out io.Writer
+	if opts.Stdout {
+		out = os.Stdout
+	} else {
+		filename := outputName(file)
+		if !dryRun() {
+			logf("Found %d enkodo structs in %s, saving to %s\n", len(structs), file, filename)
+		}
+		oFile, cerr := createOutput(filename)
+		if cerr != nil {
+			return cerr
+		}
+		// Under dryRun the file is compared on Close
+		defer func() {
+			if cerr := oFile.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		out = oFile
+	}
+
+	// Package wide declarations only go in the first generated file of each package
+	key := filepath.Dir(file) + " " + pkg
+	declare := !declaredInterfaces[key]
+	declaredInterfaces[key] = true
+
+	if err = writeFile(out, pkg, structs, declare); err != nil {
+		return err
+	}
+	if (opts.Compare || opts.Bench) && !opts.Stdout {
+		if err = writeCompareFile(compareName(file), pkg, structs); err != nil {
+			return err
+		}
+	}
+	if (opts.Tests || opts.Fuzz) && !opts.Stdout {
+		return writeTestFile(testName(file), pkg, structs)
+	}
+	return nil
+}
@@ -0,0 +1,4379 @@
+package gen
+
+import (
+	"bytes"
+	"go/build"
+	"go/format"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// generate runs the generator over src and returns the generated file
+func generate(t *testing.T, src string) string {
+	t.Helper()
+	resetState()
+	pkg, structs, err := parseFile("fixture.go", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err = writeFile(buf, pkg, structs, true); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// fixtureSums holds the requirements and go.sum lines of the modules fixtures may require,
+// so their tests build under -mod=readonly, listing the modules they import in turn
+var fixtureSums = map[string][]struct{ require, lines string }{
+	"github.com/vmihailenco/msgpack/v5 v5.4.1": {
+		{"github.com/vmihailenco/msgpack/v5 v5.4.1", `github.com/vmihailenco/msgpack/v5 v5.4.1 h1:cQriyiUvjTwOHg8QZaPihLWeRAAVoCpE00IUPn0Bjt8=
+github.com/vmihailenco/msgpack/v5 v5.4.1/go.mod h1:GaZTsDaehaPpQVyxrf5mtQlH+pc21PIudVV/E3rRQok=
+`},
+		{"github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect", `github.com/vmihailenco/tagparser/v2 v2.0.0 h1:y09buUbR+b5aycVFQs/g70pqKVZNBmxwAhO7/IwNM9g=
+github.com/vmihailenco/tagparser/v2 v2.0.0/go.mod h1:Wri+At7QHww0WTrCBeu4J6bNtoV6mEfg5OIWRZA9qds=
+`},
+	},
+	"github.com/google/uuid v1.6.0": {
+		{"github.com/google/uuid v1.6.0", `github.com/google/uuid v1.6.0 h1:NIvaJDMOsjHA8n1jAhLSgzrAzy1Hgr+hNrb57e+94F0=
+github.com/google/uuid v1.6.0/go.mod h1:TIyPZe4MgqvfeYDBFedMoGGpEw/LqOeaOT+nhxU+yHo=
+`},
+	},
+}
+
+// writeModule writes files into a throwaway module that uses the enkodo runtime in
+// this repository, returning its directory. requires are extra "module version"
+// requirements for the module, each with its go.sum lines in fixtureSums
+func writeModule(t *testing.T, files map[string]string, requires ...string) string {
+	t.Helper()
+	root, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	files["go.mod"] = "module fixture\n\ngo 1.24.0\n\nrequire " + packageName + " v0.0.0\n\nreplace " + packageName + " => " + root + "\n"
+	for _, req := range requires {
+		sums, ok := fixtureSums[req]
+		if !ok {
+			t.Fatalf("no go.sum lines for %s, add them to fixtureSums", req)
+		}
+		for _, sum := range sums {
+			files["go.mod"] += "\nrequire " + sum.require + "\n"
+			files["go.sum"] += sum.lines
+		}
+	}
+	for name, data := range files {
+		if err = os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// testModule runs the tests of the module in dir, env is added to the environment of
+// go test, e.g. to test another GOARCH
+func testModule(t *testing.T, dir string, env ...string) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping compilation of generated code in short mode")
+	}
+
+	cmd := exec.Command("go", "test", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		// The modules required by the fixture can not be downloaded offline
+		for _, offline := range []string{"dial tcp", "no such host", "module lookup disabled"} {
+			if bytes.Contains(out, []byte(offline)) {
+				t.Skipf("skipping, the modules of the fixture can not be downloaded: %s", out)
+			}
+		}
+		generated, _ := filepath.Glob(filepath.Join(dir, "*enkodo*.go"))
+		for _, name := range generated {
+			data, _ := os.ReadFile(name)
+			out = append(out, data...)
+		}
+		t.Fatalf("generated code failed: %v\n%s", err, out)
+	}
+}
+
+// runGenerated builds a throwaway module holding src, the code generated for it and
+// testSrc, then runs its tests against the enkodo runtime in this repository
+func runGenerated(t *testing.T, src, testSrc string) {
+	t.Helper()
+	testModule(t, writeModule(t, map[string]string{
+		"fixture.go":        src,
+		"fixture_enkodo.go": generate(t, src),
+		"fixture_test.go":   testSrc,
+	}))
+}
+
+func TestMaxDepth(t *testing.T) {
+	opts.MaxDepth = 10
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+type Node struct {
+	Children []*Node ` + "`enkodo:\"\"`" + `
+}
+
+type Leaf struct {
+	Name string ` + "`enkodo:\"\"`" + `
+}
+`
+	out := generate(t, src)
+	if strings.Count(out, "enkodo.ErrMaxDepth") != 1 {
+		t.Fatalf("expected a single depth guard for Node, received:\n%s", out)
+	}
+
+	runGenerated(t, src, `package fixture
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func chain(depth int) *Node {
+	n := &Node{}
+	for i := 1; i < depth; i++ {
+		n = &Node{Children: []*Node{n}}
+	}
+	return n
+}
+
+func TestDepth(t *testing.T) {
+	var n Node
+	bs, err := enkodo.Marshal(chain(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = enkodo.Unmarshal(bs, &n); err != nil {
+		t.Fatal(err)
+	}
+
+	if bs, err = enkodo.Marshal(chain(1000)); err != nil {
+		t.Fatal(err)
+	}
+	if err = enkodo.Unmarshal(bs, &n); !errors.Is(err, enkodo.ErrMaxDepth) {
+		t.Fatalf("invalid error, expected <%v> and received <%v>", enkodo.ErrMaxDepth, err)
+	}
+}
+`)
+}
+
+func TestCycleWarning(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+type Node struct {
+	Children []*Node ` + "`enkodo:\"\"`" + `
+}
+
+type Owner struct {
+	Pets []Pet ` + "`enkodo:\"\"`" + `
+}
+
+type Pet struct {
+	Owner *Owner ` + "`enkodo:\"\"`" + `
+}
+
+type Leaf struct {
+	Name string ` + "`enkodo:\"\"`" + `
+}
+`
+	generate(t, src)
+	for _, expected := range []string{
+		"Node can hold itself through Node.Children, encoding a value with a cycle never finishes, and decoding is only limited in depth by -maxdepth",
+		"Owner can hold itself through Owner.Pets -> Pet.Owner",
+		"Pet can hold itself through Pet.Owner -> Owner.Pets",
+	} {
+		if !strings.Contains(logs.String(), expected) {
+			t.Fatalf("expected a warning containing %q, received:\n%s", expected, logs.String())
+		}
+	}
+	if strings.Contains(logs.String(), "Leaf") {
+		t.Fatalf("expected no warning for Leaf, received:\n%s", logs.String())
+	}
+
+	logs.Reset()
+	opts.MaxDepth = 10
+	generate(t, src)
+	if !strings.Contains(logs.String(), "Node can hold itself") || strings.Contains(logs.String(), "-maxdepth") {
+		t.Fatalf("expected the warning without suggesting -maxdepth, received:\n%s", logs.String())
+	}
+}
+
+// Generated a file at a time, structs reaching themselves through the other files of the
+// package are still guarded and warned about
+func TestCycleAcrossFiles(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	opts.MaxDepth = 10
+	defer func() { opts = Options{} }()
+
+	dir := writeModule(t, map[string]string{
+		"a.go": "package fixture\n\ntype A struct {\n\tBs []*B `enkodo:\"\"`\n}\n",
+		"b.go": "package fixture\n\ntype B struct {\n\tA *A `enkodo:\"\"`\n}\n",
+		"fixture_test.go": `package fixture
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestDepth(t *testing.T) {
+	a := &A{}
+	for i := 0; i < 1000; i++ {
+		a = &A{Bs: []*B{{A: a}}}
+	}
+	bs, err := enkodo.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out A
+	if err = enkodo.Unmarshal(bs, &out); !errors.Is(err, enkodo.ErrMaxDepth) {
+		t.Fatalf("invalid error, expected <%v> and received <%v>", enkodo.ErrMaxDepth, err)
+	}
+}
+`,
+	})
+	if err := GeneratePath(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"A can hold itself through A.Bs -> B.A", "B can hold itself through B.A -> A.Bs"} {
+		if !strings.Contains(logs.String(), expected) {
+			t.Fatalf("expected a warning containing %q, received:\n%s", expected, logs.String())
+		}
+	}
+	for _, name := range []string{"a_enkodo.go", "b_enkodo.go"} {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err != nil || !strings.Contains(string(data), "enkodo.ErrMaxDepth") {
+			t.Fatalf("expected a depth guard in %s, received:\n%s (%v)", name, data, err)
+		}
+	}
+	testModule(t, dir)
+}
+
+func TestGenerics(t *testing.T) {
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+type Sample struct {
+	A []int64  ` + "`enkodo:\"\"`" + `
+	B []string ` + "`enkodo:\"\"`" + `
+	C []uint8  ` + "`enkodo:\"\"`" + `
+	D []bool   ` + "`enkodo:\"\"`" + `
+	E []*Inner ` + "`enkodo:\"\"`" + `
+	F []int32  ` + "`enkodo:\"\"`" + `
+	G []error  ` + "`enkodo:\"\"`" + `
+	H []int16  ` + "`enkodo:\"\"`" + `
+	I []uint32 ` + "`enkodo:\"\"`" + `
+}
+
+type Other struct {
+	A []uint16  ` + "`enkodo:\"\"`" + `
+	B []float64 ` + "`enkodo:\"\"`" + `
+	C []uint64  ` + "`enkodo:\"\"`" + `
+	D []*Inner  ` + "`enkodo:\"\"`" + `
+	E []string  ` + "`enkodo:\"\"`" + `
+	F []float32 ` + "`enkodo:\"\"`" + `
+}
+
+type Inner struct {
+	Name string ` + "`enkodo:\"\"`" + `
+}
+`
+	inlined := generate(t, src)
+	opts.Generics = true
+	generic := generate(t, src)
+	if len(generic) >= len(inlined) {
+		t.Fatalf("expected generic output (%d bytes) to be smaller than inlined output (%d bytes)", len(generic), len(inlined))
+	}
+
+	if !strings.HasPrefix(generic, "//go:build go1.18\n") {
+		t.Fatalf("expected a go1.18 build constraint, received:\n%s", generic)
+	}
+
+	// Helpers are declared once per package, not in every generated file
+	dir := writeModule(t, map[string]string{
+		"a.go": "package fixture\n\ntype A struct {\n\tN []int `enkodo:\"\"`\n}\n",
+		"b.go": "package fixture\n\ntype B struct {\n\tN []int `enkodo:\"\"`\n}\n",
+	})
+	for _, name := range []string{"a.go", "b.go"} {
+		if err := GenerateFile(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testModule(t, dir)
+
+	runGenerated(t, src, `package fixture
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Sample{
+		A: []int64{1, -2, 3},
+		B: []string{"a", "bc"},
+		C: []uint8{7},
+		D: []bool{true, false},
+		E: []*Inner{{Name: "x"}, {Name: "y"}},
+		F: []int32{},
+		G: []error{errors.New("boom")},
+		H: []int16{4},
+		I: []uint32{9, 10},
+	}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Sample
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+}
+
+func TestMaps(t *testing.T) {
+	src := `package fixture
+
+type User struct {
+	Name string ` + "`enkodo:\"\"`" + `
+}
+
+type Index struct {
+	Blobs  map[string][]byte         ` + "`enkodo:\"\"`" + `
+	Users  map[int64]*User           ` + "`enkodo:\"\"`" + `
+	Nested map[string]map[int]string ` + "`enkodo:\"\"`" + `
+	Lists  map[uint16][]float64      ` + "`enkodo:\"\"`" + `
+	Groups []map[string]int          ` + "`enkodo:\"\"`" + `
+}
+`
+	runGenerated(t, src, `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Index{
+		Blobs:  map[string][]byte{"a": {1, 2}, "b": {}},
+		Users:  map[int64]*User{-1: {Name: "alice"}, 7: {Name: "bob"}},
+		Nested: map[string]map[int]string{"x": {1: "one", 2: "two"}, "y": {}},
+		Lists:  map[uint16][]float64{3: {1.5, -2}},
+		Groups: []map[string]int{{"a": 1}, {"b": 2, "c": 3}},
+	}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Index
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+}
+
+func TestStructValues(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+type User struct {
+	Name string ` + "`enkodo:\"\"`" + `
+}
+
+type Plain struct {
+	Name string
+}
+
+type Crew struct {
+	Lead    User            ` + "`enkodo:\"\"`" + `
+	Members []User          ` + "`enkodo:\"\"`" + `
+	ByName  map[string]User ` + "`enkodo:\"\"`" + `
+	Pair    [2]User         ` + "`enkodo:\"\"`" + `
+	Plain   Plain           ` + "`enkodo:\"\"`" + `
+}
+`
+	out := generate(t, src)
+	if !strings.Contains(out, "enc.Encode(&c.Lead)") || !strings.Contains(out, "dec.Decode(&c.Lead)") {
+		t.Fatalf("expected Lead to be encoded through its address, received:\n%s", out)
+	}
+	if strings.Contains(out, "t.Plain") || !strings.Contains(logs.String(), "Crew.Plain has type Plain which has no enkodo methods") {
+		t.Fatalf("expected Plain to be skipped with a warning, logged %q", logs.String())
+	}
+
+	for _, generics := range []bool{false, true} {
+		opts.Generics = generics
+		runGenerated(t, src, `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Crew{
+		Lead:    User{Name: "alice"},
+		Members: []User{{Name: "bob"}, {Name: "carol"}},
+		ByName:  map[string]User{"dave": {Name: "dave"}},
+		Pair:    [2]User{{Name: "erin"}, {Name: "frank"}},
+	}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Crew
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+	}
+}
+
+func TestNilPointers(t *testing.T) {
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+type User struct {
+	Name string ` + "`enkodo:\"\"`" + `
+}
+
+type Crew struct {
+	Lead    *User            ` + "`enkodo:\"\"`" + `
+	Backup  *User            ` + "`enkodo:\"\"`" + `
+	Members []*User          ` + "`enkodo:\"\"`" + `
+	ByName  map[string]*User ` + "`enkodo:\"\"`" + `
+}
+`
+	for _, generics := range []bool{false, true} {
+		opts.Generics = generics
+		runGenerated(t, src, `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Crew{
+		Lead:    &User{Name: "alice"},
+		Members: []*User{{Name: "bob"}, nil},
+		ByName:  map[string]*User{"carol": nil},
+	}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decoding over a value clears the pointers that were nil
+	out := Crew{Backup: &User{Name: "stale"}}
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+	}
+}
+
+func TestBasicPointers(t *testing.T) {
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+import "time"
+
+type Rank uint8
+
+type Profile struct {
+	Name   *string            ` + "`enkodo:\"\"`" + `
+	Score  *int64             ` + "`enkodo:\"\"`" + `
+	Rank   *Rank              ` + "`enkodo:\"\"`" + `
+	Seen   *time.Time         ` + "`enkodo:\"\"`" + `
+	Counts []*int             ` + "`enkodo:\"\"`" + `
+	Notes  map[string]*string ` + "`enkodo:\"\"`" + `
+}
+`
+	out := generate(t, src)
+	if !strings.Contains(out, "enc.String(*p.Name)") || !strings.Contains(out, "if *p.Name, err = dec.String(); err != nil {") {
+		t.Fatalf("expected Name to be written dereferenced, received:\n%s", out)
+	}
+
+	for _, o := range []Options{{}, {Generics: true}, {FormatVersion: 2}} {
+		opts = o
+		runGenerated(t, src, `package fixture
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	name, score, rank, seen, count := "alice", int64(-3), Rank(2), time.Unix(10, 0), 4
+	in := Profile{
+		Name:   &name,
+		Score:  &score,
+		Rank:   &rank,
+		Seen:   &seen,
+		Counts: []*int{&count, nil},
+		Notes:  map[string]*string{"a": &name, "b": nil},
+	}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Profile
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+
+	if bs, err = enkodo.Marshal(&Profile{}); err != nil {
+		t.Fatal(err)
+	}
+	out = Profile{}
+	if err = enkodo.Unmarshal(bs, &out); err != nil || out.Name != nil || out.Seen != nil {
+		t.Fatalf("expected nil pointers, received %+v: %v", out, err)
+	}
+}
+`)
+	}
+}
+
+func TestEmbeddedFields(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	defer func() { opts = Options{} }()
+	opts.CountBytes = true
+
+	src := `package fixture
+
+type Rank uint8
+
+type Meta struct {
+	ID string ` + "`enkodo:\"\"`" + `
+}
+
+type Extra struct {
+	Note string ` + "`enkodo:\"\"`" + `
+}
+
+type Event struct {
+	Meta    ` + "`enkodo:\"\"`" + `
+	*Extra  ` + "`enkodo:\"\"`" + `
+	Rank    ` + "`enkodo:\"\"`" + `
+	Payload []byte ` + "`enkodo:\"\"`" + `
+}
+`
+	out := generate(t, src)
+	if !strings.Contains(out, "enc.Encode(&e.Meta)") || !strings.Contains(out, "enc.Uint8(uint8(e.Rank))") {
+		t.Fatalf("expected the embedded fields to be encoded, received:\n%s", out)
+	}
+	// Event's own UnmarshalEnkodoN shadows the one promoted from Meta
+	if strings.Contains(logs.String(), "already has") {
+		t.Fatalf("expected the -countbytes methods of Event, logged %q", logs.String())
+	}
+
+	runGenerated(t, src, `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Event{
+		Meta:    Meta{ID: "abc"},
+		Extra:   &Extra{Note: "hi"},
+		Rank:    3,
+		Payload: []byte{1, 2},
+	}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Event
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+}
+
+func TestInterfaceRegistry(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+//enkodo:register *Circle=1 Square=2 Other=0
+type Shape interface {
+	Area() float64
+}
+
+type Any interface{}
+
+type Circle struct {
+	R float64 ` + "`enkodo:\"\"`" + `
+}
+
+func (c *Circle) Area() float64 { return 3 * c.R * c.R }
+
+type Square struct {
+	S float64 ` + "`enkodo:\"\"`" + `
+}
+
+func (s Square) Area() float64 { return s.S * s.S }
+
+type Other struct {
+	X int ` + "`enkodo:\"\"`" + `
+}
+
+func (o *Other) Area() float64 { return 0 }
+
+type Canvas struct {
+	Main   Shape   ` + "`enkodo:\"\"`" + `
+	Shapes []Shape ` + "`enkodo:\"\"`" + `
+	Empty  Shape   ` + "`enkodo:\"\"`" + `
+	Extra  Any     ` + "`enkodo:\"\"`" + `
+}
+`
+	out := generate(t, src)
+	if strings.Contains(out, "Extra") || !strings.Contains(logs.String(), "Canvas.Extra has type Any, an interface without an //enkodo:register directive") {
+		t.Fatalf("expected Extra to be skipped, logged %q", logs.String())
+	}
+	if !strings.Contains(logs.String(), `ignoring "Other=0" registered on Shape`) {
+		t.Fatalf("expected the invalid registration to be ignored, logged %q", logs.String())
+	}
+
+	for _, generics := range []bool{false, true} {
+		opts.Generics = generics
+		runGenerated(t, src, `package fixture
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Canvas{
+		Main:   &Circle{R: 2},
+		Shapes: []Shape{Square{S: 3}, nil, &Circle{R: 1}},
+	}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Canvas
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+
+	var unregistered *enkodo.UnregisteredTypeError
+	if _, err = enkodo.Marshal(&Canvas{Main: &Other{}}); !errors.As(err, &unregistered) || unregistered.Field != "Canvas.Main" {
+		t.Fatalf("expected an UnregisteredTypeError, received %v", err)
+	}
+}
+`)
+	}
+}
+
+func TestNestedSlices(t *testing.T) {
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+type Grid struct {
+	Chunks [][]byte              ` + "`enkodo:\"\"`" + `
+	Rows   [][]int64             ` + "`enkodo:\"\"`" + `
+	Cubes  [][][]int             ` + "`enkodo:\"\"`" + `
+	Index  []map[string][]int    ` + "`enkodo:\"\"`" + `
+	Names  map[string][][]string ` + "`enkodo:\"\"`" + `
+}
+`
+	for _, o := range []Options{{}, {Generics: true}, {NilSlice: true}} {
+		opts = o
+		runGenerated(t, src, `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Grid{
+		Chunks: [][]byte{{1, 2}, {}, {3}},
+		Rows:   [][]int64{{-1, 2}, {}, {1 << 40}},
+		Cubes:  [][][]int{{{1}, {2, 3}}, {}},
+		Index:  []map[string][]int{{"a": {1, 2}}, {"b": {}}},
+		Names:  map[string][][]string{"x": {{"y", "z"}}},
+	}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Grid
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+	}
+}
+
+func TestNamedMapKeys(t *testing.T) {
+	src := `package fixture
+
+type StatusCode int
+
+type Label string
+
+type Response struct {
+	Messages map[StatusCode]string ` + "`enkodo:\"\"`" + `
+	Labels   map[Label]Label       ` + "`enkodo:\"\"`" + `
+}
+`
+	out := generate(t, src)
+	if !strings.Contains(out, "enc.Int(int(k))") || !strings.Contains(out, "k = StatusCode(v)") {
+		t.Fatalf("expected key casts for StatusCode, received:\n%s", out)
+	}
+
+	runGenerated(t, src, `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Response{
+		Messages: map[StatusCode]string{200: "OK", 404: "Not Found", -1: "Unknown"},
+		Labels:   map[Label]Label{"env": "prod"},
+	}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Response
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+}
+
+func TestNamedTypes(t *testing.T) {
+	// The named types are declared in another file of the package
+	dir := writeModule(t, map[string]string{
+		"types.go": `package fixture
+
+type SocialMedia string
+
+type Rank uint8
+
+type Level Rank
+
+type Ratio float64
+`,
+		"user.go": `package fixture
+
+type User struct {
+	Media   SocialMedia           ` + "`enkodo:\"\"`" + `
+	Level   Level                 ` + "`enkodo:\"\"`" + `
+	Ratios  []Ratio               ` + "`enkodo:\"\"`" + `
+	ByMedia map[SocialMedia]Level ` + "`enkodo:\"\"`" + `
+	Rank    Rank                  ` + "`enkodo:\"int\"`" + `
+}
+`,
+		"user_test.go": `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := User{
+		Media:   "github",
+		Level:   3,
+		Ratios:  []Ratio{0.5, 2},
+		ByMedia: map[SocialMedia]Level{"x": 1},
+		Rank:    200,
+	}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out User
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`,
+	})
+	if err := GenerateFile(filepath.Join(dir, "user.go")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "user_enkodo.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"enc.String(string(u.Media))", "enc.Uint8(uint8(u.Level))", "enc.Int(int(u.Rank))"} {
+		if !strings.Contains(string(data), expected) {
+			t.Fatalf("expected %q, received:\n%s", expected, data)
+		}
+	}
+	testModule(t, dir)
+}
+
+func TestImportedTypes(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	dir := writeModule(t, map[string]string{
+		"othr/othr.go": `package othr
+
+type Level uint8
+
+type Config struct {
+	Name  string ` + "`enkodo:\"\"`" + `
+	Level Level  ` + "`enkodo:\"\"`" + `
+}
+
+type Handler func()
+`,
+		"user.go": `package fixture
+
+import "fixture/othr"
+
+type User struct {
+	Config  othr.Config  ` + "`enkodo:\"\"`" + `
+	Backup  *othr.Config ` + "`enkodo:\"\"`" + `
+	Level   othr.Level   ` + "`enkodo:\"\"`" + `
+	Levels  []othr.Level ` + "`enkodo:\"\"`" + `
+	Handler othr.Handler ` + "`enkodo:\"\"`" + `
+}
+`,
+		"user_test.go": `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"fixture/othr"
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := User{
+		Config: othr.Config{Name: "primary", Level: 2},
+		Backup: &othr.Config{Name: "backup"},
+		Level:  7,
+		Levels: []othr.Level{1, 2},
+	}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out User
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`,
+	})
+	// The imported package is generated first, as it would be by go generate ./...
+	for _, file := range []string{"othr/othr.go", "user.go"} {
+		if err := GenerateFile(filepath.Join(dir, file)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "user_enkodo.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"enc.Encode(&u.Config)", "enc.Uint8(uint8(u.Level))", "othr.Level(v)"} {
+		if !strings.Contains(string(data), expected) {
+			t.Fatalf("expected %q, received:\n%s", expected, data)
+		}
+	}
+	if strings.Contains(string(data), "Handler") || !strings.Contains(logs.String(), "User.Handler has type othr.Handler which is not supported") {
+		t.Fatalf("expected Handler to be skipped, received:\n%s\n%s", logs.String(), data)
+	}
+	testModule(t, dir)
+}
+
+func TestStructMapKeys(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	src := `package fixture
+
+type Point struct {
+	X int ` + "`enkodo:\"\"`" + `
+	Y int ` + "`enkodo:\"\"`" + `
+}
+
+type Plain struct {
+	X, Y int
+}
+
+type Grid struct {
+	Labels map[Point]string ` + "`enkodo:\"\"`" + `
+	Plain  map[Plain]string ` + "`enkodo:\"\"`" + `
+}
+`
+	out := generate(t, src)
+	if strings.Contains(out, "g.Plain") || !strings.Contains(logs.String(), "Grid.Plain has map keys of type Plain") {
+		t.Fatalf("expected Plain keys without enkodo methods to be skipped, received:\n%s\n%s", logs.String(), out)
+	}
+
+	runGenerated(t, src, `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Grid{Labels: map[Point]string{{X: 1, Y: 2}: "a", {X: -3, Y: 4}: "b"}}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Grid
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+}
+
+func TestEmitInterfaces(t *testing.T) {
+	opts.EmitInterfaces = true
+	defer func() { opts = Options{} }()
+
+	dir := writeModule(t, map[string]string{
+		"a.go": "package fixture\n\ntype A struct {\n\tName string `enkodo:\"\"`\n}\n",
+		"b.go": "package fixture\n\ntype B struct {\n\tA *A `enkodo:\"\"`\n}\n",
+		"fixture_test.go": `package fixture
+
+import "testing"
+
+func TestInterfaces(t *testing.T) {
+	var m EnkodoMarshaler = &B{A: &A{}}
+	if _, ok := m.(EnkodoUnmarshaler); !ok {
+		t.Fatal("expected B to implement EnkodoUnmarshaler")
+	}
+}
+`,
+	})
+	for _, name := range []string{"a.go", "b.go"} {
+		if err := GenerateFile(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, _ := os.ReadFile(filepath.Join(dir, "a_enkodo.go"))
+	b, _ := os.ReadFile(filepath.Join(dir, "b_enkodo.go"))
+	if n := strings.Count(string(a)+string(b), "type EnkodoMarshaler interface"); n != 1 {
+		t.Fatalf("expected the interfaces to be declared once, declared %d times", n)
+	}
+	if !strings.Contains(string(b), "var _ EnkodoMarshaler = (*B)(nil)") {
+		t.Fatalf("expected an assertion for B, received:\n%s", b)
+	}
+	testModule(t, dir)
+}
+
+func TestFixedArrays(t *testing.T) {
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+type Block struct {
+	ID     [16]byte   ` + "`enkodo:\"\"`" + `
+	Hashes [][32]byte ` + "`enkodo:\"\"`" + `
+	Coords [3]int16   ` + "`enkodo:\"\"`" + `
+}
+`
+	testSrc := `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Block{ID: [16]byte{1, 2, 3}, Coords: [3]int16{7, 0, 1}}
+	for i := 0; i < 5; i++ {
+		var h [32]byte
+		for j := range h {
+			h[j] = byte(i * j)
+		}
+		in.Hashes = append(in.Hashes, h)
+	}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 16 ID bytes, a length byte, five raw hashes and three int16 varints
+	if len(bs) != 16+1+5*32+3 {
+		t.Fatalf("invalid length, expected %d and received %d", 16+1+5*32+3, len(bs))
+	}
+
+	var out Block
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`
+	runGenerated(t, src, testSrc)
+	opts.Generics = true
+	runGenerated(t, src, testSrc)
+}
+
+func TestErrorCodes(t *testing.T) {
+	opts.ErrorCodes = true
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+import (
+	"errors"
+
+	"github.com/nullmonk/enkodo"
+)
+
+var ErrNotFound = errors.New("not found")
+
+func init() {
+	enkodo.RegisterError(404, ErrNotFound)
+}
+
+type Result struct {
+	Err    error   ` + "`enkodo:\"\"`" + `
+	Errors []error ` + "`enkodo:\"\"`" + `
+}
+`
+	runGenerated(t, src, `package fixture
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Result{Err: ErrNotFound, Errors: []error{errors.New("other"), nil}}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Result
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Err != ErrNotFound {
+		t.Fatalf("invalid value, expected %v and received %v", ErrNotFound, out.Err)
+	}
+	if out.Errors[0].Error() != "other" || out.Errors[1] != nil {
+		t.Fatalf("invalid value, expected %v and received %v", in.Errors, out.Errors)
+	}
+}
+`)
+}
+
+func TestGenerate(t *testing.T) {
+	in := []byte("package users\n\ntype User struct {\n\tEmail string `enkodo:\"\"`\n}\n")
+	out := bytes.NewBuffer(nil)
+	if err := Generate(out, in); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"package users", "func (u *User) MarshalEnkodo", "enc.String(u.Email)"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("expected output to contain %q, received:\n%s", expected, out)
+		}
+	}
+}
+
+func TestZigZag(t *testing.T) {
+	src := `package fixture
+
+type Deltas struct {
+	Plain  []int64 ` + "`enkodo:\"\"`" + `
+	A      int     ` + "`enkodo:\"int,zigzag\"`" + `
+	B      int16   ` + "`enkodo:\",zigzag\"`" + `
+	C      int64   ` + "`enkodo:\",zigzag\"`" + `
+}
+`
+	runGenerated(t, src, `package fixture
+
+import (
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, tc := range []Deltas{
+		{A: -1, B: -1, C: -1},
+		{A: -1000, B: -1000, C: -1000},
+		{A: 1 << 40, B: 1 << 14, C: 1 << 62},
+	} {
+		bs, err := enkodo.Marshal(&tc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out Deltas
+		if err = enkodo.Unmarshal(bs, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.A != tc.A || out.B != tc.B || out.C != tc.C {
+			t.Fatalf("invalid value, expected %+v and received %+v", tc, out)
+		}
+	}
+
+	// Compare against plain varints for the small negatives
+	zigzag, _ := enkodo.Marshal(&Deltas{A: -1, B: -1000, C: -1})
+	plain, _ := enkodo.Marshal(&Deltas{Plain: []int64{-1, -1000, -1}})
+	if len(zigzag) >= len(plain) {
+		t.Fatalf("expected zigzag (%d bytes) to be smaller than plain varints (%d bytes)", len(zigzag), len(plain))
+	}
+}
+`)
+}
+
+func TestDotImports(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"othr/othr.go": `package othr
+
+type Level uint8
+
+type Config struct {
+	Name  string ` + "`enkodo:\"\"`" + `
+	Level Level  ` + "`enkodo:\"\"`" + `
+}
+`,
+		"event.go": `package fixture
+
+import . "fixture/othr"
+
+type Event struct {
+	Config Config  ` + "`enkodo:\"\"`" + `
+	Level  Level   ` + "`enkodo:\"\"`" + `
+	Levels []Level ` + "`enkodo:\"\"`" + `
+}
+`,
+		"event_test.go": `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"fixture/othr"
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Event{Config: othr.Config{Name: "primary", Level: 2}, Level: 7, Levels: []othr.Level{1, 2}}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Event
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`,
+	})
+	for _, file := range []string{"othr/othr.go", "event.go"} {
+		if err := GenerateFile(filepath.Join(dir, file)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "event_enkodo.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Level and Config resolve to the types of othr, which is imported by its path
+	for _, expected := range []string{`"fixture/othr"`, "enc.Encode(&e.Config)", "enc.Uint8(uint8(e.Level))", "othr.Level(v)"} {
+		if !strings.Contains(string(data), expected) {
+			t.Fatalf("expected %q, received:\n%s", expected, data)
+		}
+	}
+	testModule(t, dir)
+}
+
+func TestTypes(t *testing.T) {
+	opts.Types = []string{"User"}
+	defer func() { opts = Options{} }()
+
+	field := " struct {\n\tName string `enkodo:\"\"`\n}\n"
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"user.go": "package fixture\n\ntype User" + field + "\ntype Group" + field,
+		"post.go": "package fixture\n\ntype Post" + field,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := GeneratePath(dir); err != nil {
+		t.Fatal(err)
+	}
+	// The file declaring User is regenerated whole, the other is left alone
+	data, err := os.ReadFile(filepath.Join(dir, "user_enkodo.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "(u *User) MarshalEnkodo") || !strings.Contains(string(data), "(g *Group) MarshalEnkodo") {
+		t.Fatalf("expected both structs of user.go, received:\n%s", data)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "post_enkodo.go")); err == nil {
+		t.Fatal("expected post.go not to be generated")
+	}
+
+	opts.Types = []string{"User", "Missing"}
+	if err = GeneratePath(dir); err == nil || !strings.Contains(err.Error(), "no enkodo struct Missing") {
+		t.Fatalf("expected an error for Missing, received %v", err)
+	}
+}
+
+func TestCollectFiles(t *testing.T) {
+	defer func() { opts = Options{} }()
+
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"user.go":              "package fixture\n",
+		"user_test.go":         "package fixture\n",
+		"user_enkodo.go":       generatedHeader + "\npackage fixture\n",
+		"go.mod":               "module fixture\n",
+		"README.md":            "# fixture\n",
+		".git/hooks/hook.go":   "package hooks\n",
+		"_old/old.go":          "package old\n",
+		"testdata/fixture.go":  "package fixture\n",
+		"internal/internal.go": "package internal\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{filepath.Join(dir, "internal", "internal.go"), filepath.Join(dir, "user.go")}
+	if files := CollectFiles(dir); !slices.Equal(files, expected) {
+		t.Fatalf("invalid files, expected %v and received %v", expected, files)
+	}
+	opts.IncludeTests = true
+	expected = append(expected, filepath.Join(dir, "user_test.go"))
+	if files := CollectFiles(dir); !slices.Equal(files, expected) {
+		t.Fatalf("invalid files, expected %v and received %v", expected, files)
+	}
+
+	// Patterns match directories and files by their path under the root or their name
+	for _, exclude := range [][]string{{"internal", "*_test.go"}, {"internal/*.go", "user_test.go"}} {
+		opts.Exclude = exclude
+		expected = []string{filepath.Join(dir, "user.go")}
+		if files := CollectFiles(dir); !slices.Equal(files, expected) {
+			t.Fatalf("invalid files excluding %v, expected %v and received %v", exclude, expected, files)
+		}
+	}
+	opts.Exclude = []string{"[user"}
+	if err := GeneratePath(dir); err == nil || !strings.Contains(err.Error(), "-exclude") {
+		t.Fatalf("expected an error for an invalid pattern, received %v", err)
+	}
+}
+
+func TestCollectFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"user.go", "user_test.go"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("package fixture\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A file named by go generate is generated even when it is a test
+	file := filepath.Join(dir, "user_test.go")
+	if files := CollectFiles(file); len(files) != 1 || files[0] != file {
+		t.Fatalf("expected only %s, received %v", file, files)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	defer func() { opts = Options{} }()
+
+	files := make(map[string]string)
+	for i, pkg := range []string{"a", "b", "c", "d", "e"} {
+		src := "package " + pkg + "\n\ntype Level uint8\n\ntype Item struct {\n\tName  string `enkodo:\"\"`\n\tLevel Level  `enkodo:\"\"`\n}\n"
+		if i > 0 {
+			// Each package holds the structs and named types of the one before
+			prev := []string{"a", "b", "c", "d"}[i-1]
+			src += "\ntype Holder struct {\n\tItem  " + prev + ".Item  `enkodo:\"\"`\n\tLevel " + prev + ".Level `enkodo:\"\"`\n}\n"
+			src = strings.Replace(src, "\n\ntype Level", "\n\nimport \"fixture/"+prev+"\"\n\ntype Level", 1)
+		}
+		files[filepath.Join(pkg, pkg+".go")] = src
+	}
+	// generateWith generates a copy of files checking jobs packages at once, returning the
+	// generated code of each package
+	generateWith := func(jobs int) map[string]string {
+		dir := writeModule(t, files)
+		opts.Jobs = jobs
+		if err := GeneratePath(dir + "/..."); err != nil {
+			t.Fatal(err)
+		}
+		out := make(map[string]string)
+		for name := range files {
+			if filepath.Ext(name) != ".go" {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(dir, outputName(name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out[name] = string(data)
+		}
+		return out
+	}
+
+	defaultDir := build.Default.Dir
+	serial := generateWith(1)
+	if build.Default.Dir != defaultDir {
+		t.Fatalf("expected build.Default to be left as it is, received the directory %s", build.Default.Dir)
+	}
+	if !strings.Contains(serial[filepath.Join("e", "e.go")], "enc.Uint8(uint8(h.Level))") {
+		t.Fatalf("expected the named type of d to be resolved, received:\n%s", serial[filepath.Join("e", "e.go")])
+	}
+	for name, out := range generateWith(4) {
+		if out != serial[name] {
+			t.Fatalf("expected the same code for %s checking packages at once, received:\n%s\ninstead of:\n%s", name, out, serial[name])
+		}
+	}
+}
+
+func TestOutput(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	defer func() { opts = Options{} }()
+
+	field := " struct {\n\tName string `enkodo:\"\"`\n}\n"
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"user.go": "package fixture\n\ntype User" + field,
+		"post.go": "package fixture\n\ntype Post" + field,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A file name gets every struct of the package, in the package directory
+	opts.Output = "codec.go"
+	if err := GeneratePath(dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "codec.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "(u *User) MarshalEnkodo") || !strings.Contains(string(data), "(p *Post) MarshalEnkodo") {
+		t.Fatalf("expected both structs in codec.go, received:\n%s", data)
+	}
+
+	// A directory gets the files under the names they would have had
+	opts.Output = filepath.Join(dir, "generated")
+	if err = GeneratePath(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"user_enkodo.go", "post_enkodo.go"} {
+		if _, err = os.Stat(filepath.Join(opts.Output, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !strings.Contains(logs.String(), "outside the package directory") {
+		t.Fatalf("expected a warning about the directory, logged %q", logs.String())
+	}
+
+	// Files or packages generated into the same file fail instead of overwriting each other
+	if err = os.MkdirAll(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "sub", "user.go"), []byte("package sub\n\ntype Account"+field), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range []Options{
+		{Output: filepath.Join(dir, "generated")},
+		{Output: filepath.Join(dir, "generated"), Combined: true},
+		{Output: filepath.Join(dir, "generated", "codec.go")},
+		{Output: filepath.Join(dir, "generated"), MaxStructs: 1},
+	} {
+		opts = o
+		if err = GeneratePath(dir + "/..."); err == nil || !strings.Contains(err.Error(), "would both be generated into") {
+			t.Fatalf("expected an error for the colliding outputs of %+v, received %v", o, err)
+		}
+	}
+	opts = Options{Output: "codec.go"}
+	if err = GeneratePath(dir + "/..."); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCombined(t *testing.T) {
+	defer func() { opts = Options{} }()
+
+	field := " struct {\n\tName string `enkodo:\"\"`\n}\n"
+	dir := writeModule(t, map[string]string{
+		"user.go": "package fixture\n\ntype User" + field,
+		"post.go": "package fixture\n\ntype Post" + field,
+		"fixture_test.go": `package fixture
+
+import (
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	bs, err := enkodo.Marshal(&Post{Name: "p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Post
+	if err = enkodo.Unmarshal(bs, &out); err != nil || out.Name != "p" {
+		t.Fatalf("invalid value, expected %v and received %v (%v)", "p", out.Name, err)
+	}
+}
+`,
+	})
+	files := []string{filepath.Join(dir, "user.go"), filepath.Join(dir, "post.go")}
+
+	// The files of a previous run without -combined would redeclare the methods
+	if err := generatePath(files); err != nil {
+		t.Fatal(err)
+	}
+	opts.Combined = true
+	if err := generatePath(files); err != nil {
+		t.Fatal(err)
+	}
+	if generated, _ := filepath.Glob(filepath.Join(dir, "*_enkodo.go")); len(generated) != 0 {
+		t.Fatalf("expected the files of the previous run to be removed, found %v", generated)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, combinedName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "(u *User) MarshalEnkodo") || !strings.Contains(string(data), "(p *Post) MarshalEnkodo") {
+		t.Fatalf("expected both structs in %s, received:\n%s", combinedName, data)
+	}
+	testModule(t, dir)
+}
+
+func TestMaxStructs(t *testing.T) {
+	opts.MaxStructs = 2
+	defer func() { opts = Options{} }()
+
+	field := " struct {\n\tName string `enkodo:\"\"`\n}\n"
+	dir := writeModule(t, map[string]string{
+		"a.go": "package fixture\n\ntype Echo" + field + "\ntype Alpha" + field + "\ntype Charlie" + field,
+		"b.go": "package fixture\n\ntype Delta" + field + "\ntype Bravo" + field,
+		"fixture_test.go": `package fixture
+
+import (
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	bs, err := enkodo.Marshal(&Echo{Name: "e"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Echo
+	if err = enkodo.Unmarshal(bs, &out); err != nil || out.Name != "e" {
+		t.Fatalf("invalid value, expected %v and received %v (%v)", "e", out.Name, err)
+	}
+}
+`,
+	})
+	// A leftover from a previous run with more files
+	os.WriteFile(filepath.Join(dir, "enkodo_gen_3.go"), []byte(generatedHeader+"\npackage fixture\n"), 0o644)
+
+	if err := GeneratePackage(dir, []string{filepath.Join(dir, "a.go"), filepath.Join(dir, "b.go")}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{{"Alpha", "Bravo"}, {"Charlie", "Delta"}, {"Echo"}}
+	generated, _ := filepath.Glob(filepath.Join(dir, "enkodo_gen_*.go"))
+	if len(generated) != len(expected) {
+		t.Fatalf("invalid number of files, expected %d and received %d (%v)", len(expected), len(generated), generated)
+	}
+	for i, names := range expected {
+		data, err := os.ReadFile(filepath.Join(dir, "enkodo_gen_"+strconv.Itoa(i)+".go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(string(data), "MarshalEnkodo(enc"); n != len(names) {
+			t.Fatalf("invalid number of structs in file %d, expected %d and received %d", i, len(names), n)
+		}
+		for _, name := range names {
+			if !strings.Contains(string(data), "*"+name+") MarshalEnkodo") {
+				t.Fatalf("expected %s in file %d, received:\n%s", name, i, data)
+			}
+		}
+	}
+	testModule(t, dir)
+}
+
+func TestMaxLen(t *testing.T) {
+	defer func() { opts = Options{} }()
+	src := `package fixture
+
+type Label string
+
+type Packet struct {
+	Name  string         ` + "`enkodo:\"string,maxlen=8\"`" + `
+	Data  []byte         ` + "`enkodo:\",maxlen=4\"`" + `
+	Tags  []string       ` + "`enkodo:\",maxlen=2\"`" + `
+	Attrs map[string]int ` + "`enkodo:\",maxlen=1\"`" + `
+	Label Label          ` + "`enkodo:\",maxlen=3\"`" + `
+	Blob  []byte         ` + "`enkodo:\",gzip,maxlen=16\"`" + `
+	Runs  []uint8        ` + "`enkodo:\",rle,maxlen=4\"`" + `
+}
+
+type Unbounded struct {
+	Name  string         ` + "`enkodo:\"\"`" + `
+	Data  []byte         ` + "`enkodo:\"\"`" + `
+	Tags  []string       ` + "`enkodo:\"\"`" + `
+	Attrs map[string]int ` + "`enkodo:\"\"`" + `
+	Label Label          ` + "`enkodo:\"\"`" + `
+	Blob  []byte         ` + "`enkodo:\",gzip\"`" + `
+	Runs  []uint8        ` + "`enkodo:\",rle\"`" + `
+}
+
+// Lengths writes the lengths prefixing the fields of Packet, so they can be hostile
+type Lengths struct {
+	Name  int ` + "`enkodo:\"\"`" + `
+	Data  int ` + "`enkodo:\"\"`" + `
+	Tags  int ` + "`enkodo:\"\"`" + `
+	Attrs int ` + "`enkodo:\"\"`" + `
+}
+`
+	out := generate(t, src)
+	// Only encoding checks the length of the value, decoding checks it before allocating
+	if !strings.Contains(out, `dec.StringMax("Packet.Name", 8)`) || strings.Count(out, "len(p.Name) > 8") != 1 {
+		t.Fatalf("expected decoding to check lengths before allocating, received:\n%s", out)
+	}
+
+	for _, o := range []Options{{}, {Generics: true}, {NilSlice: true}} {
+		opts = o
+		runGenerated(t, src, `package fixture
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestMaxLen(t *testing.T) {
+	var lengthErr *enkodo.MaxLengthError
+	if _, err := enkodo.Marshal(&Packet{Name: "much too long"}); !errors.As(err, &lengthErr) {
+		t.Fatalf("invalid error, expected a MaxLengthError and received <%v>", err)
+	} else if lengthErr.Field != "Packet.Name" || lengthErr.Max != 8 {
+		t.Fatalf("invalid error, received <%v>", err)
+	}
+
+	in := Packet{Name: "short", Data: []byte{1}, Tags: []string{"a", "b"}, Attrs: map[string]int{"a": 1}, Label: "abc", Blob: make([]byte, 16), Runs: []uint8{1, 1, 1, 2}}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Packet
+	if err = enkodo.Unmarshal(bs, &out); err != nil || !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v (%v)", in, out, err)
+	}
+
+	// The same wire format, written without the limits
+	for field, u := range map[string]Unbounded{
+		"Packet.Name":  {Name: "much too long"},
+		"Packet.Data":  {Data: make([]byte, 5)},
+		"Packet.Tags":  {Tags: []string{"a", "b", "c"}},
+		"Packet.Attrs": {Attrs: map[string]int{"a": 1, "b": 2}},
+		"Packet.Label": {Label: "abcd"},
+		// Checked as they are decompressed and expanded, not once they are
+		"Packet.Blob": {Blob: make([]byte, 1<<20)},
+		"Packet.Runs": {Runs: make([]uint8, 1<<20)},
+	} {
+		if bs, err = enkodo.Marshal(&u); err != nil {
+			t.Fatal(err)
+		}
+		if err = enkodo.Unmarshal(bs, &out); !errors.As(err, &lengthErr) || lengthErr.Field != field {
+			t.Fatalf("invalid error, expected a MaxLengthError for %s and received <%v>", field, err)
+		}
+	}
+
+	// Hostile lengths fail before they are allocated. Negative ones fail without a limit
+	// too, rather than panicking in make
+	for _, c := range []struct {
+		lengths Lengths
+		field   string
+	}{
+		{Lengths{Name: 1 << 42}, "Packet.Name"},
+		{Lengths{Data: 1 << 42}, "Packet.Data"},
+		{Lengths{Tags: 1 << 42}, "Packet.Tags"},
+		{Lengths{Attrs: 1 << 42}, "Packet.Attrs"},
+		{Lengths{Name: -1}, ""},
+		{Lengths{Data: -2}, ""},
+		{Lengths{Tags: -1}, ""},
+		{Lengths{Tags: math.MinInt64}, ""},
+		{Lengths{Attrs: -1}, ""},
+	} {
+		if bs, err = enkodo.Marshal(&c.lengths); err != nil {
+			t.Fatal(err)
+		}
+		if c.field != "" {
+			if err = enkodo.Unmarshal(bs, &out); !errors.As(err, &lengthErr) || lengthErr.Field != c.field {
+				t.Fatalf("invalid error for %+v, expected a MaxLengthError for %s and received <%v>", c.lengths, c.field, err)
+			}
+			continue
+		}
+		for _, v := range []enkodo.Decodee{&Packet{}, &Unbounded{}} {
+			if err = enkodo.Unmarshal(bs, v); !errors.Is(err, enkodo.ErrInvalidLength) {
+				t.Fatalf("invalid error for %+v into %T, expected ErrInvalidLength and received <%v>", c.lengths, v, err)
+			}
+		}
+	}
+}
+`)
+	}
+}
+
+// Errors encoding nested structs, e.g. a field over its maxlen, are returned by the outer
+// Marshal rather than leaving it to write a truncated value
+func TestNestedErrors(t *testing.T) {
+	src := `package fixture
+
+type Inner struct {
+	Name string ` + "`enkodo:\",maxlen=2\"`" + `
+}
+
+type Outer struct {
+	Value Inner         ` + "`enkodo:\"\"`" + `
+	Ptr   *Inner        ` + "`enkodo:\"\"`" + `
+	List  []Inner       ` + "`enkodo:\"\"`" + `
+	Ptrs  []*Inner      ` + "`enkodo:\"\"`" + `
+	Keys  map[Inner]int ` + "`enkodo:\"\"`" + `
+}
+`
+	runGenerated(t, src, `package fixture
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestNestedErrors(t *testing.T) {
+	long := Inner{Name: "too long"}
+	for _, in := range []Outer{
+		{Value: long},
+		{Ptr: &long},
+		{List: []Inner{{}, long}},
+		{Ptrs: []*Inner{&long}},
+		{Keys: map[Inner]int{long: 1}},
+	} {
+		var lengthErr *enkodo.MaxLengthError
+		if bs, err := enkodo.Marshal(&in); !errors.As(err, &lengthErr) || lengthErr.Field != "Inner.Name" {
+			t.Fatalf("invalid error for %+v, expected a MaxLengthError for Inner.Name and received <%v> with %x", in, err, bs)
+		}
+	}
+}
+`)
+}
+
+func TestBudget(t *testing.T) {
+	defer func() { opts = Options{} }()
+	src := `package fixture
+
+type Packet struct {
+	Name     string         ` + "`enkodo:\"\"`" + `
+	Data     []byte         ` + "`enkodo:\"\"`" + `
+	Scores   []int64        ` + "`enkodo:\"\"`" + `
+	Attrs    map[string]int ` + "`enkodo:\"\"`" + `
+	Children []Packet       ` + "`enkodo:\"\"`" + `
+}
+`
+	for _, o := range []Options{{Budget: 32}, {Budget: 32, Generics: true}, {Budget: 32, NilSlice: true}} {
+		opts = o
+		runGenerated(t, src, `package fixture
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestBudget(t *testing.T) {
+	child := Packet{Name: "def", Data: []byte{}, Scores: []int64{}, Attrs: map[string]int{}, Children: []Packet{}}
+	in := Packet{Name: "abc", Data: []byte{1, 2}, Scores: []int64{1, 2}, Attrs: map[string]int{"a": 1}, Children: []Packet{child}}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Packet
+	if err = enkodo.Unmarshal(bs, &out); err != nil || !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v (%v)", in, out, err)
+	}
+
+	// The budget is shared by the structs nested in the value
+	var budgetErr *enkodo.BudgetError
+	for _, big := range []Packet{
+		{Name: string(make([]byte, 33))},
+		{Scores: make([]int64, 33)},
+		{Attrs: map[string]int{"a": 1, "b": 2}, Children: make([]Packet, 15), Name: string(make([]byte, 15))},
+		{Children: []Packet{{Data: make([]byte, 20)}, {Data: make([]byte, 20)}}},
+	} {
+		if bs, err = enkodo.Marshal(&big); err != nil {
+			t.Fatal(err)
+		}
+		if err = enkodo.Unmarshal(bs, &out); !errors.As(err, &budgetErr) || budgetErr.Budget != 32 {
+			t.Fatalf("invalid error, expected a BudgetError and received <%v>", err)
+		}
+	}
+
+	// Hostile lengths fail before they are allocated
+	if err = enkodo.Unmarshal([]byte{0, 0, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, &out); !errors.As(err, &budgetErr) {
+		t.Fatalf("invalid error, expected a BudgetError and received <%v>", err)
+	}
+}
+`)
+	}
+}
+
+func TestMsgpack(t *testing.T) {
+	opts.Msgpack = true
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+type Level int
+
+type Profile struct {
+	Name    string   ` + "`enkodo:\"\"`" + `
+	Age     uint8    ` + "`enkodo:\"\"`" + `
+	Score   float64  ` + "`enkodo:\"\"`" + `
+	Delta   int32    ` + "`enkodo:\"\"`" + `
+	Level   Level    ` + "`enkodo:\"int\"`" + `
+	Tags    []string ` + "`enkodo:\"\"`" + `
+	Data    []byte   ` + "`enkodo:\"\"`" + `
+	Ok      bool     ` + "`enkodo:\"\"`" + `
+	Inner   *Inner   ` + "`enkodo:\"\"`" + `
+	Skipped error    ` + "`enkodo:\"\"`" + `
+}
+
+type Inner struct {
+	ID []int64 ` + "`enkodo:\"\"`" + `
+}
+`
+	testModule(t, writeModule(t, map[string]string{
+		"fixture.go":        src,
+		"fixture_enkodo.go": generate(t, src),
+		"fixture_test.go": `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type mirror struct {
+	Name  string
+	Age   uint8
+	Score float64
+	Delta int32
+	Level int
+	Tags  []string
+	Data  []byte
+	Ok    bool
+	Inner *mirrorInner
+}
+
+type mirrorInner struct {
+	ID []int64
+}
+
+func TestReference(t *testing.T) {
+	in := Profile{Name: "x", Age: 200, Score: 1.5, Delta: -70000, Level: 3, Tags: []string{"a", "b"}, Data: []byte{1, 2}, Ok: true, Inner: &Inner{ID: []int64{-1, 1 << 40}}}
+	bs, err := in.MarshalMsgpack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m mirror
+	if err = msgpack.Unmarshal(bs, &m); err != nil {
+		t.Fatal(err)
+	}
+	expected := mirror{Name: "x", Age: 200, Score: 1.5, Delta: -70000, Level: 3, Tags: []string{"a", "b"}, Data: []byte{1, 2}, Ok: true, Inner: &mirrorInner{ID: []int64{-1, 1 << 40}}}
+	if !reflect.DeepEqual(m, expected) {
+		t.Fatalf("invalid value, expected %+v and received %+v", expected, m)
+	}
+
+	if bs, err = msgpack.Marshal(&m); err != nil {
+		t.Fatal(err)
+	}
+	var out Profile
+	if err = out.UnmarshalMsgpack(bs); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`,
+	}, "github.com/vmihailenco/msgpack/v5 v5.4.1"))
+}
+
+func TestAccessors(t *testing.T) {
+	src := `package fixture
+
+import "errors"
+
+// User keeps its fields unexported
+//
+//enkodo:accessor Name=GetName/SetName
+//enkodo:accessor Age=GetAge/SetAge Missing=GetMissing/SetMissing
+type User struct {
+	ID   int ` + "`enkodo:\"\"`" + `
+	name string
+	age  uint8
+}
+
+func (u *User) GetName() string     { return u.name }
+func (u *User) SetName(name string) { u.name = name }
+
+func (u *User) GetAge() uint8 { return u.age }
+func (u *User) SetAge(age uint8) error {
+	if age > 150 {
+		return errors.New("invalid age")
+	}
+	u.age = age
+	return nil
+}
+`
+	out := generate(t, src)
+	if !strings.Contains(out, "enc.String(u.GetName())") || !strings.Contains(out, "u.SetName(_Name)") {
+		t.Fatalf("expected Name to use its accessors, received:\n%s", out)
+	}
+	if strings.Contains(out, "Missing") {
+		t.Fatalf("expected the accessor without methods to be skipped, received:\n%s", out)
+	}
+
+	runGenerated(t, src, `package fixture
+
+import (
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := User{ID: 7}
+	in.SetName("alice")
+	in.SetAge(30)
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out User
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out != in {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+
+	// The setter error is returned from decoding
+	in.age = 200
+	bs, _ = enkodo.Marshal(&in)
+	if err = enkodo.Unmarshal(bs, &out); err == nil {
+		t.Fatal("expected the SetAge error")
+	}
+}
+`)
+}
+
+func TestEmitAccessors(t *testing.T) {
+	opts.EmitAccessors = true
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+type User struct {
+	name string ` + "`enkodo:\"\"`" + `
+	age  uint8  ` + "`enkodo:\"\"`" + `
+}
+
+// GetAge already exists so is not generated
+func (u *User) GetAge() int { return int(u.age) }
+`
+	out := generate(t, src)
+	for _, method := range []string{"GetName() string", "SetName(value string)", "SetAge(value uint8)"} {
+		if !strings.Contains(out, method) {
+			t.Fatalf("expected %s to be generated, received:\n%s", method, out)
+		}
+	}
+	if strings.Contains(out, "GetAge") {
+		t.Fatalf("expected the existing GetAge to be kept, received:\n%s", out)
+	}
+
+	runGenerated(t, src, `package fixture
+
+import (
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var in User
+	in.SetName("alice")
+	in.SetAge(30)
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out User
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.GetName() != "alice" || out.GetAge() != 30 {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+}
+
+func TestEncodedSize(t *testing.T) {
+	opts.EncodedSize = true
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+type User struct {
+	Name    string            ` + "`enkodo:\"\"`" + `
+	Age     int               ` + "`enkodo:\"\"`" + `
+	Friends []*User           ` + "`enkodo:\"\"`" + `
+	Attrs   map[string]string ` + "`enkodo:\"\"`" + `
+}
+`
+	runGenerated(t, src, `package fixture
+
+import (
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestEncodedSize(t *testing.T) {
+	for _, u := range []*User{
+		{},
+		{Name: "alice", Age: -1, Friends: []*User{{Name: "bob", Age: 1 << 40}}, Attrs: map[string]string{"a": "b"}},
+	} {
+		bs, err := enkodo.Marshal(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := u.EncodedSize(); n != len(bs) {
+			t.Fatalf("invalid size of %+v, expected %d and received %d", u, len(bs), n)
+		}
+	}
+}
+`)
+}
+
+func TestBinary(t *testing.T) {
+	opts.Binary = true
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+type User struct {
+	Name  string ` + "`enkodo:\"\"`" + `
+	Bytes []byte ` + "`enkodo:\"\"`" + `
+}
+`
+	runGenerated(t, src, `package fixture
+
+import (
+	"encoding"
+	"testing"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = (*User)(nil)
+	_ encoding.BinaryUnmarshaler = (*User)(nil)
+)
+
+func TestBinary(t *testing.T) {
+	bs, err := (&User{Name: "alice", Bytes: []byte("abc")}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out User
+	if err = out.UnmarshalBinary(bs); err != nil {
+		t.Fatal(err)
+	}
+	// The decoded value must not share the data
+	for i := range bs {
+		bs[i] = 0
+	}
+	if out.Name != "alice" || string(out.Bytes) != "abc" {
+		t.Fatalf("invalid value, received %+v", out)
+	}
+}
+`)
+}
+
+func TestCountBytes(t *testing.T) {
+	opts.CountBytes = true
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+type User struct {
+	Name string   ` + "`enkodo:\"\"`" + `
+	Age  int      ` + "`enkodo:\"\"`" + `
+	Tags []string ` + "`enkodo:\"\"`" + `
+}
+`
+	runGenerated(t, src, `package fixture
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	users := []User{{Name: "alice", Age: 300, Tags: []string{"a", "b"}}, {Name: "bob"}}
+	var buf bytes.Buffer
+	w := enkodo.NewWriter(&buf)
+	sizes := make([]int, len(users))
+	for i := range users {
+		bs, err := enkodo.Marshal(&users[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[i] = len(bs)
+		if err = w.Encode(&users[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dec := enkodo.NewDecoder(&buf)
+	for i := range users {
+		var out User
+		n, err := out.UnmarshalEnkodoN(dec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != sizes[i] {
+			t.Fatalf("invalid count, expected %d and received %d", sizes[i], n)
+		}
+		if out.Name != users[i].Name {
+			t.Fatalf("invalid value, expected %+v and received %+v", users[i], out)
+		}
+	}
+}
+`)
+}
+
+func TestHelperCollision(t *testing.T) {
+	opts.CountBytes = true
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+import "github.com/nullmonk/enkodo"
+
+type User struct {
+	Name string ` + "`enkodo:\"\"`" + `
+}
+
+// UnmarshalEnkodoN is hand written, so -countbytes must not generate it
+func (u *User) UnmarshalEnkodoN(dec *enkodo.Decoder) (int, error) {
+	return 0, nil
+}
+
+type Group struct {
+	Name string ` + "`enkodo:\"\"`" + `
+}
+`
+	out := generate(t, src)
+	if strings.Count(out, ") UnmarshalEnkodoN(") != 1 || !strings.Contains(out, "(g *Group) UnmarshalEnkodoN") {
+		t.Fatalf("expected UnmarshalEnkodoN to only be generated for Group, received:\n%s", out)
+	}
+	// The generated file compiles alongside the hand written method
+	runGenerated(t, src, "package fixture\n")
+
+	opts.Strict = true
+	if err := Generate(io.Discard, []byte(src)); err == nil || !strings.Contains(err.Error(), "User already has a UnmarshalEnkodoN") {
+		t.Fatalf("expected a collision error under -strict, received %v", err)
+	}
+}
+
+func TestHandWritten(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"fixture.go": `package fixture
+
+type Full struct {
+	Name string ` + "`enkodo:\"\"`" + `
+}
+
+type Half struct {
+	Name  string ` + "`enkodo:\"\"`" + `
+	Count int    ` + "`enkodo:\"\"`" + `
+}
+`,
+		// Methods written by hand in another file of the package
+		"codec.go": `package fixture
+
+import "github.com/nullmonk/enkodo"
+
+func (f *Full) MarshalEnkodo(enc *enkodo.Encoder) error {
+	return enc.String("full " + f.Name)
+}
+
+func (f *Full) UnmarshalEnkodo(dec *enkodo.Decoder) (err error) {
+	f.Name, err = dec.String()
+	return
+}
+
+func (h *Half) MarshalEnkodo(enc *enkodo.Encoder) error {
+	enc.String(h.Name)
+	return enc.Int(h.Count * 2)
+}
+`,
+		"fixture_test.go": `package fixture
+
+import (
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	bs, err := enkodo.Marshal(&Half{Name: "half", Count: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Half
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "half" || out.Count != 4 {
+		t.Fatalf("expected the hand written MarshalEnkodo to be used, received %+v", out)
+	}
+}
+`,
+	})
+	if err := GeneratePath(dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "fixture_enkodo.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "Full)") || strings.Contains(string(data), "MarshalEnkodo(enc") || !strings.Contains(string(data), "(h *Half) UnmarshalEnkodo") {
+		t.Fatalf("expected only UnmarshalEnkodo of Half to be generated, received:\n%s", data)
+	}
+	testModule(t, dir)
+}
+
+func TestOnly(t *testing.T) {
+	defer func() { opts = Options{} }()
+	src := `package fixture
+
+//enkodo:only encode
+type Event struct {
+	Name  string ` + "`enkodo:\"\"`" + `
+	Count int    ` + "`enkodo:\"\"`" + `
+}
+
+//enkodo:only decode
+type Received struct {
+	Name  string ` + "`enkodo:\"\"`" + `
+	Count int    ` + "`enkodo:\"\"`" + `
+}
+`
+	testSrc := `package fixture
+
+import (
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestOnly(t *testing.T) {
+	bs, err := enkodo.Marshal(&Event{Name: "login", Count: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Received
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "login" || out.Count != 3 {
+		t.Fatalf("invalid value, received %+v", out)
+	}
+}
+`
+	// The helpers of each half are only generated with it, and the generated tests only
+	// for the halves there are
+	opts = Options{Binary: true, CountBytes: true, EncodedSize: true, EmitInterfaces: true, Tests: true, Fuzz: true, Bench: true}
+	dir := writeModule(t, map[string]string{"fixture.go": src, "fixture_test.go": testSrc})
+	if err := GeneratePath(dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "fixture_enkodo.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for method, expected := range map[string]bool{
+		"(e *Event) MarshalEnkodo":       true,
+		"(e *Event) UnmarshalEnkodo":     false,
+		"(e *Event) UnmarshalBinary":     false,
+		"(e *Event) EncodedSize":         true,
+		"(r *Received) MarshalEnkodo":    false,
+		"(r *Received) UnmarshalEnkodoN": true,
+		"(r *Received) MarshalBinary":    false,
+		"enkodo.Encodee = (*Event)":      true,
+		"enkodo.Decodee = (*Event)":      false,
+		"enkodo.Decodee = (*Received)":   true,
+	} {
+		if strings.Contains(string(data), method) != expected {
+			t.Fatalf("expected %s to be generated: %v, received:\n%s", method, expected, data)
+		}
+	}
+	testModule(t, dir)
+}
+
+func TestFieldDecodeError(t *testing.T) {
+	src := `package fixture
+
+type Address struct {
+	Street string ` + "`enkodo:\"\"`" + `
+}
+
+type User struct {
+	Name    string  ` + "`enkodo:\"\"`" + `
+	Address Address ` + "`enkodo:\"\"`" + `
+}
+`
+	runGenerated(t, src, `package fixture
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestFieldDecodeError(t *testing.T) {
+	bs, err := enkodo.Marshal(&User{Name: "ada", Address: Address{Street: "Main St"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out User
+	err = enkodo.Unmarshal(bs[:len(bs)-2], &out)
+	if err == nil || err.Error() != "User.Address.Street: "+io.ErrUnexpectedEOF.Error() {
+		t.Fatalf("expected the path of the truncated field, received %v", err)
+	}
+	var fieldErr *enkodo.FieldDecodeError
+	if !errors.As(err, &fieldErr) || fieldErr.Struct != "User" || fieldErr.Field != "Address" {
+		t.Fatalf("expected a FieldDecodeError for User.Address, received %#v", err)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected %v to wrap io.ErrUnexpectedEOF", err)
+	}
+}
+`)
+}
+
+func TestFormatVersion(t *testing.T) {
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+type Point struct {
+	X    int32   ` + "`enkodo:\"\"`" + `
+	Y    int     ` + "`enkodo:\"\"`" + `
+	Path []int64 ` + "`enkodo:\"\"`" + `
+	Flag int8    ` + "`enkodo:\"\"`" + `
+}
+`
+	for version, calls := range map[int][]string{
+		1: {"enc.Int32(p.X)", "enc.Int(p.Y)", "enc.Int64(v)", "enc.Int8(p.Flag)"},
+		2: {"enc.ZigZag(int64(p.X))", "enc.ZigZag(int64(p.Y))", "enc.ZigZag(int64(v))", "enc.Int8(p.Flag)", "_arrLen, err = dec.Int()"},
+	} {
+		opts.FormatVersion = version
+		out := generate(t, src)
+		for _, call := range calls {
+			if !strings.Contains(out, call) {
+				t.Fatalf("expected %s for format version %d, received:\n%s", call, version, out)
+			}
+		}
+	}
+
+	opts.FormatVersion = 3
+	if err := Generate(io.Discard, []byte(src)); err == nil {
+		t.Fatal("expected an error for an unsupported format version")
+	}
+
+	opts.FormatVersion = 2
+	runGenerated(t, src, `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Point{X: -1, Y: -2, Path: []int64{-3, 4}, Flag: -5}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Small negative values take a byte each
+	if len(bs) != 6 {
+		t.Fatalf("expected 6 bytes, received %d", len(bs))
+	}
+
+	var out Point
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+}
+
+func TestGated(t *testing.T) {
+	opts.Gated = true
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+type User struct {
+	Name string ` + "`enkodo:\"\"`" + `
+}
+`
+	runGenerated(t, src, `package fixture
+
+import (
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := User{Name: "alice"}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	EnkodoEnabled = false
+	defer func() { EnkodoEnabled = true }()
+	if _, err = enkodo.Marshal(&in); err != enkodo.ErrDisabled {
+		t.Fatalf("expected ErrDisabled, received %v", err)
+	}
+	var out User
+	if err = enkodo.Unmarshal(bs, &out); err != enkodo.ErrDisabled {
+		t.Fatalf("expected ErrDisabled, received %v", err)
+	}
+
+	EnkodoEnabled = true
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out != in {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+}
+
+func TestFloatBits(t *testing.T) {
+	src := `package fixture
+
+type Sample struct {
+	Double float64 ` + "`enkodo:\"\"`" + `
+	Single float32 ` + "`enkodo:\"\"`" + `
+}
+`
+	// Floats are written by their IEEE-754 bits, see Encoder.Float64
+	runGenerated(t, src, `package fixture
+
+import (
+	"math"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	// A signaling NaN with a payload, which arithmetic would quiet
+	nan := math.Float64frombits(0x7ff0000000000123)
+	nan32 := math.Float32frombits(0x7f800123)
+	negZero := math.Copysign(0, -1)
+
+	for _, in := range []Sample{{Double: negZero, Single: float32(negZero)}, {Double: nan, Single: nan32}} {
+		bs, err := enkodo.Marshal(&in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out Sample
+		if err = enkodo.Unmarshal(bs, &out); err != nil {
+			t.Fatal(err)
+		}
+		if math.Float64bits(out.Double) != math.Float64bits(in.Double) || math.Float32bits(out.Single) != math.Float32bits(in.Single) {
+			t.Fatalf("expected bits %x %x, received %x %x",
+				math.Float64bits(in.Double), math.Float32bits(in.Single), math.Float64bits(out.Double), math.Float32bits(out.Single))
+		}
+	}
+}
+`)
+}
+
+func TestIncludeTests(t *testing.T) {
+	opts.IncludeTests = true
+	opts.Generics = true
+	defer func() { opts = Options{} }()
+
+	dir := writeModule(t, map[string]string{
+		"fixture.go": "package fixture\n\ntype Plain struct {\n\tTags []string `enkodo:\"\"`\n}\n",
+		// Internal test files share the package, and its generic helpers
+		"internal_test.go": "package fixture\n\ntype Local struct {\n\tTags []string `enkodo:\"\"`\n}\n",
+		"fixture_test.go": `package fixture_test
+
+import (
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+type External struct {
+	Tags []string ` + "`enkodo:\"\"`" + `
+}
+
+func TestRoundTrip(t *testing.T) {
+	bs, err := enkodo.Marshal(&External{Tags: []string{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out External
+	if err = enkodo.Unmarshal(bs, &out); err != nil || len(out.Tags) != 2 || out.Tags[1] != "b" {
+		t.Fatalf("invalid value, expected [a b] and received %v (%v)", out.Tags, err)
+	}
+}
+`,
+	})
+
+	files := CollectFiles(dir)
+	if !strings.HasSuffix(files[len(files)-1], "_test.go") || strings.HasSuffix(files[0], "_test.go") {
+		t.Fatalf("expected test files last, received %v", files)
+	}
+	for _, file := range files {
+		if filepath.Ext(file) != ".go" {
+			continue
+		}
+		if err := GenerateFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "fixture_enkodo_test.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "package fixture_test") {
+		t.Fatalf("expected the external test package, received:\n%s", data)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "internal_enkodo_test.go")); err != nil {
+		t.Fatal(err)
+	}
+	testModule(t, dir)
+}
+
+func TestStrictIntWidth(t *testing.T) {
+	opts.Strict = true
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+type Wide struct {
+	Count int64  ` + "`enkodo:\"\"`" + `
+	Size  uint64 ` + "`enkodo:\"\"`" + `
+}
+
+type Narrow struct {
+	Count int  ` + "`enkodo:\"\"`" + `
+	Size  uint ` + "`enkodo:\"\"`" + `
+}
+`
+	dir := writeModule(t, map[string]string{
+		"fixture.go":        src,
+		"fixture_enkodo.go": generate(t, src),
+		"fixture_test.go": `package fixture
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestOverflow(t *testing.T) {
+	bs, err := enkodo.Marshal(&Wide{Count: 7, Size: 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Narrow
+	if err = enkodo.Unmarshal(bs, &out); err != nil || out.Count != 7 || out.Size != 9 {
+		t.Fatalf("invalid value, expected {7 9} and received %+v (%v)", out, err)
+	}
+
+	big := int64(math.MaxInt32) + 1
+	bs, _ = enkodo.Marshal(&Wide{Count: big})
+	err = enkodo.Unmarshal(bs, &out)
+	var overflow *enkodo.OverflowError
+	if strconv.IntSize == 32 {
+		if !errors.As(err, &overflow) || overflow.Field != "Narrow.Count" {
+			t.Fatalf("expected an overflow of Narrow.Count, received %v", err)
+		}
+	} else if err != nil || int64(out.Count) != big {
+		t.Fatalf("invalid value, expected %d and received %d (%v)", big, out.Count, err)
+	}
+}
+`,
+	})
+	testModule(t, dir)
+	testModule(t, dir, "GOARCH=386")
+}
+
+func TestDuration(t *testing.T) {
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+import "time"
+
+type Job struct {
+	Timeout time.Duration            ` + "`enkodo:\"\"`" + `
+	Retries []time.Duration          ` + "`enkodo:\"\"`" + `
+	Limits  map[string]time.Duration ` + "`enkodo:\"\"`" + `
+}
+`
+	for version, call := range map[int]string{1: "enc.Int64(int64(j.Timeout))", 2: "enc.ZigZag(int64(j.Timeout))"} {
+		opts.FormatVersion = version
+		if out := generate(t, src); !strings.Contains(out, call) {
+			t.Fatalf("expected %s in version %d, received:\n%s", call, version, out)
+		}
+
+		runGenerated(t, src, `package fixture
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Job{
+		Timeout: 3 * time.Second,
+		Retries: []time.Duration{time.Millisecond, -time.Minute},
+		Limits:  map[string]time.Duration{"read": time.Hour},
+	}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Job
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+	}
+}
+
+// durationConverter is registered by TestRegisterConverter the way a program embedding
+// the generator would
+type durationConverter struct{}
+
+func (durationConverter) Name() string           { return "time.Duration" }
+func (durationConverter) EnkodoFunction() string { return "Int64" }
+func (durationConverter) Enc(val string) string  { return "int64(" + val + ")" }
+func (durationConverter) Dec(val string) string  { return "time.Duration(" + val + ")" }
+func (durationConverter) Imports() []string      { return []string{"time"} }
+
+func TestRegisterConverter(t *testing.T) {
+	defer func(c TypeConverter) { enc_types_advanced["time.Duration"] = c }(enc_types_advanced["time.Duration"])
+	RegisterConverter("time.Duration", durationConverter{})
+
+	src := `package fixture
+
+import "time"
+
+type Job struct {
+	Timeout time.Duration   ` + "`enkodo:\"\"`" + `
+	Retries []time.Duration ` + "`enkodo:\"\"`" + `
+}
+`
+	out := bytes.NewBuffer(nil)
+	if err := Generate(out, []byte(src)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "enc.Int64(int64(j.Timeout))") {
+		t.Fatalf("expected Timeout to use the registered converter, received:\n%s", out)
+	}
+
+	runGenerated(t, src, `package fixture
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Job{Timeout: time.Minute, Retries: []time.Duration{time.Second, 2 * time.Second}}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Job
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Timeout != in.Timeout || len(out.Retries) != 2 || out.Retries[1] != in.Retries[1] {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+}
+
+func TestConverterTag(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"fixture.go": `package fixture
+
+import (
+	"net"
+	"time"
+
+	// Only named by the converter tag
+	_ "fixture/conv"
+)
+
+type Host struct {
+	Addr    net.IP        ` + "`enkodo:\",converter=conv.EncodeIP\"`" + `
+	Timeout time.Duration ` + "`enkodo:\",converter=Seconds\"`" + `
+}
+`,
+		"seconds.go": `package fixture
+
+import (
+	"time"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func EncodeSeconds(enc *enkodo.Encoder, d time.Duration) error {
+	return enc.Uint(uint(d / time.Second))
+}
+
+func DecodeSeconds(dec *enkodo.Decoder, d *time.Duration) error {
+	s, err := dec.Uint()
+	*d = time.Duration(s) * time.Second
+	return err
+}
+`,
+		"conv/conv.go": `package conv
+
+import (
+	"net"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func EncodeIP(enc *enkodo.Encoder, ip net.IP) error {
+	return enc.Bytes(ip.To16())
+}
+
+func DecodeIP(dec *enkodo.Decoder, ip *net.IP) error {
+	return dec.Bytes((*[]byte)(ip))
+}
+`,
+		"fixture_test.go": `package fixture
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Host{Addr: net.ParseIP("10.0.0.1"), Timeout: time.Minute}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bs) != 18 {
+		t.Fatalf("expected the address as 16 bytes and the timeout in seconds, received %x", bs)
+	}
+
+	var out Host
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.Addr.Equal(in.Addr) || out.Timeout != in.Timeout {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`,
+	})
+	if err := GenerateFile(filepath.Join(dir, "fixture.go")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "fixture_enkodo.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"conv.EncodeIP(enc, h.Addr)", "conv.DecodeIP(dec, &h.Addr)", "EncodeSeconds(enc, h.Timeout)", `"fixture/conv"`} {
+		if !strings.Contains(string(data), expected) {
+			t.Fatalf("expected %q in the generated code, received:\n%s", expected, data)
+		}
+	}
+	testModule(t, dir)
+}
+
+func TestNetConverters(t *testing.T) {
+	defer func() { opts = Options{} }()
+	src := `package fixture
+
+import (
+	"net"
+	"net/netip"
+)
+
+type Flow struct {
+	Src   net.IP           ` + "`enkodo:\"\"`" + `
+	Dst   netip.Addr       ` + "`enkodo:\"\"`" + `
+	Peer  netip.AddrPort   ` + "`enkodo:\"\"`" + `
+	Hops  []netip.Addr     ` + "`enkodo:\"\"`" + `
+	Proxy *netip.AddrPort  ` + "`enkodo:\"\"`" + `
+	Gate  netip.Addr       ` + "`enkodo:\",omitempty\"`" + `
+}
+`
+	if out := generate(t, src); !strings.Contains(out, "enc.Addr(f.Dst)") || !strings.Contains(out, "f.Src, err = dec.IP()") {
+		t.Fatalf("expected the addresses to be written in their binary form, received:\n%s", out)
+	}
+	// -tests makes random addresses for the round trip tests
+	opts.Tests = true
+	dir := writeModule(t, map[string]string{"fixture.go": src, "fixture_test.go": `package fixture
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestSize(t *testing.T) {
+	in := Flow{Src: net.ParseIP("10.0.0.1"), Dst: netip.MustParseAddr("10.0.0.2"), Peer: netip.MustParseAddrPort("10.0.0.3:443")}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A bitmask, the three IPv4 addresses and port with their lengths, an empty slice
+	// and a nil pointer
+	if len(bs) != 1+5+5+7+1+1 {
+		t.Fatalf("expected the addresses in their binary form, received %x", bs)
+	}
+}
+`})
+	if err := GeneratePath(dir); err != nil {
+		t.Fatal(err)
+	}
+	testModule(t, dir)
+}
+
+func TestUUIDConverter(t *testing.T) {
+	defer func() { opts = Options{} }()
+	src := `package fixture
+
+import "github.com/google/uuid"
+
+type Order struct {
+	ID     uuid.UUID   ` + "`enkodo:\"\"`" + `
+	Items  []uuid.UUID ` + "`enkodo:\"\"`" + `
+	Parent *uuid.UUID  ` + "`enkodo:\"\"`" + `
+	Batch  uuid.UUID   ` + "`enkodo:\",omitempty\"`" + `
+}
+`
+	// -tests makes random UUIDs for the round trip tests
+	opts.Tests = true
+	dir := writeModule(t, map[string]string{"fixture.go": src, "fixture_test.go": `package fixture
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nullmonk/enkodo"
+)
+
+func TestSize(t *testing.T) {
+	bs, err := enkodo.Marshal(&Order{ID: uuid.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A bitmask, the 16 bytes of the ID, an empty slice and a nil pointer
+	if len(bs) != 1+16+1+1 {
+		t.Fatalf("expected the UUID as 16 bytes, received %x", bs)
+	}
+}
+`}, "github.com/google/uuid v1.6.0")
+	// Generated in the module requiring uuid, so it is not added to this one
+	if err := GeneratePath(dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "fixture_enkodo.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "enc.UUID(o.ID)") || !strings.Contains(string(data), "o.ID, err = dec.UUID()") {
+		t.Fatalf("expected the UUID to be written as its bytes, received:\n%s", data)
+	}
+	testModule(t, dir)
+}
+
+func TestBigConverters(t *testing.T) {
+	defer func() { opts = Options{} }()
+	src := `package fixture
+
+import "math/big"
+
+type Ledger struct {
+	Balance *big.Int            ` + "`enkodo:\"\"`" + `
+	Rate    *big.Float          ` + "`enkodo:\"\"`" + `
+	Entries []*big.Int          ` + "`enkodo:\"\"`" + `
+	Limits  map[string]*big.Int ` + "`enkodo:\"\"`" + `
+}
+`
+	if out := generate(t, src); !strings.Contains(out, "enc.BigInt(l.Balance)") || !strings.Contains(out, "l.Rate, err = dec.BigFloat()") {
+		t.Fatalf("expected the numbers to be written by their converters, received:\n%s", out)
+	}
+	// -tests makes random numbers for the round trip tests
+	opts.Tests = true
+	dir := writeModule(t, map[string]string{"fixture.go": src, "fixture_test.go": `package fixture
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	balance, _ := new(big.Int).SetString("-1000000000000000000000000", 10)
+	in := Ledger{Balance: balance, Entries: []*big.Int{big.NewInt(1), nil}}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Ledger
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Balance.Cmp(in.Balance) != 0 || out.Rate != nil || len(out.Entries) != 2 || out.Entries[0].Cmp(in.Entries[0]) != 0 || out.Entries[1] != nil {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`})
+	if err := GeneratePath(dir); err != nil {
+		t.Fatal(err)
+	}
+	testModule(t, dir)
+}
+
+func TestRunLength(t *testing.T) {
+	src := `package fixture
+
+type Level uint16
+
+type Frame struct {
+	Flags  []uint8 ` + "`enkodo:\",rle\"`" + `
+	Pixels []byte  ` + "`enkodo:\",rle\"`" + `
+	Levels []Level ` + "`enkodo:\",rle\"`" + `
+	Plain  []uint8 ` + "`enkodo:\"\"`" + `
+}
+`
+	out := generate(t, src)
+	if !strings.Contains(out, "enkodo.EncodeRuns(enc, f.Flags, func(v uint8)") {
+		t.Fatalf("expected Flags to be written as runs, received:\n%s", out)
+	}
+
+	runGenerated(t, src, `package fixture
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	flags := make([]uint8, 4096)
+	flags[7], flags[2000], flags[2001] = 1, 3, 3
+
+	in := Frame{Flags: flags, Pixels: []byte{1, 2, 3}, Levels: []Level{5, 5, 5, 5, 9}}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Frame
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Flags, in.Flags) || !bytes.Equal(out.Pixels, in.Pixels) || !reflect.DeepEqual(out.Levels, in.Levels) {
+		t.Fatalf("invalid value, expected %v %v %v and received %v %v %v", len(in.Flags), in.Pixels, in.Levels, len(out.Flags), out.Pixels, out.Levels)
+	}
+
+	plain, _ := enkodo.Marshal(&Frame{Plain: flags})
+	if len(bs) >= len(plain)/10 {
+		t.Fatalf("expected runs (%d bytes) to be far smaller than the plain slice (%d bytes)", len(bs), len(plain))
+	}
+}
+`)
+}
+
+func TestJSONFallback(t *testing.T) {
+	opts.JSONFallback = true
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// Color has no enkodo methods, only JSON ones
+type Color struct {
+	R, G, B uint8
+}
+
+func (c Color) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B))
+}
+
+func (c *Color) UnmarshalJSON(bs []byte) error {
+	var s string
+	if err := json.Unmarshal(bs, &s); err != nil {
+		return err
+	}
+	_, err := fmt.Sscanf(s, "#%02x%02x%02x", &c.R, &c.G, &c.B)
+	return err
+}
+
+type Inner struct {
+	Name string ` + "`enkodo:\"\"`" + `
+}
+
+type Paint struct {
+	Name   string  ` + "`enkodo:\"\"`" + `
+	Color  Color   ` + "`enkodo:\"\"`" + `
+	Amount big.Int ` + "`enkodo:\"\"`" + `
+	Inner  *Inner  ` + "`enkodo:\"\"`" + `
+}
+`
+	out := generate(t, src)
+	if !strings.Contains(out, "json.Marshal(&p.Color)") || !strings.Contains(out, "json.Unmarshal(_json, &p.Amount)") {
+		t.Fatalf("expected Color and Amount to fall back to JSON, received:\n%s", out)
+	}
+	if strings.Contains(out, "json.Marshal(&p.Inner)") {
+		t.Fatalf("expected Inner to use its enkodo methods, received:\n%s", out)
+	}
+
+	runGenerated(t, src, `package fixture
+
+import (
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Paint{Name: "teal", Color: Color{0, 128, 128}, Inner: &Inner{Name: "base"}}
+	in.Amount.SetString("123456789012345678901234567890", 10)
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Paint
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != in.Name || out.Color != in.Color || out.Amount.Cmp(&in.Amount) != 0 || out.Inner.Name != "base" {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+}
+
+func TestUnsupportedFieldType(t *testing.T) {
+	src := `package fixture
+
+type Job struct {
+	Name     string      ` + "`enkodo:\"\"`" + `
+	Callback func() bool ` + "`enkodo:\"\"`" + `
+}
+`
+	buf := bytes.NewBuffer(nil)
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	out := generate(t, src)
+	if !strings.Contains(buf.String(), "Job.Callback has type func() bool which is not supported") {
+		t.Fatalf("expected a warning for Callback, received %q", buf)
+	}
+	if !strings.Contains(out, "enc.String(j.Name)") || strings.Contains(out, "Callback") {
+		t.Fatalf("expected only Name to be generated, received:\n%s", out)
+	}
+
+	opts.Strict = true
+	defer func() { opts = Options{} }()
+	resetState()
+	if _, _, err := parseFile("fixture.go", src); err == nil || !strings.Contains(err.Error(), "Job.Callback") {
+		t.Fatalf("expected an error for Callback under -strict, received %v", err)
+	}
+
+	// Every unsupported field is listed with its position, including slices of them
+	queue := `
+type Queue struct {
+	Hooks []func() ` + "`enkodo:\"\"`" + `
+	Done  chan int ` + "`enkodo:\"\"`" + `
+}
+`
+	resetState()
+	_, _, err := parseFile("fixture.go", src+queue)
+	if err == nil {
+		t.Fatal("expected errors under -strict")
+	}
+	for _, expected := range []string{
+		"fixture.go:5:2: Job.Callback has type func() bool which is not supported",
+		"fixture.go:9:2: Queue.Hooks has type []func() which is not supported",
+		"fixture.go:10:2: Queue.Done has type chan int which is not supported",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected %q, received:\n%v", expected, err)
+		}
+	}
+}
+
+func TestDumpAST(t *testing.T) {
+	opts.DumpAST = true
+	defer func() { opts = Options{} }()
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	src := `package fixture
+
+type User struct {
+	Name     string            ` + "`enkodo:\"\"`" + `
+	Handlers map[string]func() ` + "`enkodo:\"\"`" + `
+	Events   chan int          ` + "`enkodo:\"\"`" + `
+	Size     int               ` + "`enkodo:\"\"`" + `
+}
+`
+	generate(t, src)
+	for _, expected := range []string{
+		"ast: User.Handlers is *ast.MapType (map[string]func()), unresolved at *ast.FuncType (func())",
+		"ast: User.Events is *ast.ChanType (chan int)\n",
+	} {
+		if !strings.Contains(logs.String(), expected) {
+			t.Fatalf("expected %q in the log, received:\n%s", expected, logs.String())
+		}
+	}
+	if strings.Contains(logs.String(), "User.Name") || strings.Contains(logs.String(), "User.Size") {
+		t.Fatalf("expected only the unresolved fields to be dumped, received:\n%s", logs.String())
+	}
+}
+
+func TestSliceDecodeInPlace(t *testing.T) {
+	src := `package fixture
+
+type Grid struct {
+	Cells [][]int32 ` + "`enkodo:\"\"`" + `
+	Names []string  ` + "`enkodo:\"\"`" + `
+}
+`
+	out := generate(t, src)
+	if strings.Contains(out, "append(") || !strings.Contains(out, "g.Cells[_i][_i2], err = dec.Int32()") {
+		t.Fatalf("expected elements to be decoded in place, received:\n%s", out)
+	}
+
+	runGenerated(t, src, `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Grid{Cells: [][]int32{{1, 2, 3}, {}, {-4}}, Names: []string{"a", "b"}}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Grid
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+
+	// Running out of bytes part way through the elements is an error
+	if err = enkodo.Unmarshal(bs[:len(bs)-2], &out); err == nil {
+		t.Fatal("expected an error decoding a truncated slice")
+	}
+}
+`)
+}
+
+func TestPresentIf(t *testing.T) {
+	src := `package fixture
+
+type Profile struct {
+	Name    string   ` + "`enkodo:\"\"`" + `
+	Age     int      ` + "`enkodo:\"int,presentif=HasAge\"`" + `
+	HasTags bool     ` + "`enkodo:\"\"`" + `
+	Tags    []string ` + "`enkodo:\",presentif=HasTags\"`" + `
+	Scores  []int    ` + "`enkodo:\"\"`" + `
+	HasAge  bool
+}
+`
+	// A flag written as a field is not written again, the decoded field is the gate
+	out := generate(t, src)
+	if strings.Count(out, "enc.Bool(p.HasTags)") != 1 || strings.Count(out, "dec.Bool()") != 2 || strings.Count(out, "enc.Bool(p.HasAge)") != 1 {
+		t.Fatalf("expected HasTags to be written once and HasAge before Age, received:\n%s", out)
+	}
+
+	runGenerated(t, src, `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, in := range []Profile{
+		{Name: "set", Age: 30, HasAge: true, Tags: []string{"a"}, HasTags: true, Scores: []int{1}},
+		{Name: "unset", Age: 30, Tags: []string{"ignored"}, Scores: []int{}},
+	} {
+		bs, err := enkodo.Marshal(&in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out := Profile{Age: -1}
+		if err = enkodo.Unmarshal(bs, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !in.HasAge {
+			// Absent values are not written, so are left alone
+			in.Age, in.Tags = -1, nil
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+		}
+	}
+}
+`)
+}
+
+func TestFieldVersions(t *testing.T) {
+	src := `package fixture
+
+type UserV1 struct {
+	Name string ` + "`enkodo:\",since=1\"`" + `
+	Age  uint8  ` + "`enkodo:\"\"`" + `
+}
+
+type User struct {
+	Name  string   ` + "`enkodo:\"\"`" + `
+	Age   uint8    ` + "`enkodo:\",until=3\"`" + `
+	Email string   ` + "`enkodo:\",since=2\"`" + `
+	Tags  []string ` + "`enkodo:\",since=3,omitempty\"`" + `
+}
+`
+	out := generate(t, src)
+	if !strings.Contains(out, "enc.Uint8(3)") || strings.Count(out, "enc.Uint8(u.Age)") != 1 {
+		t.Fatalf("expected User to write version 3 without Age, received:\n%s", out)
+	}
+
+	runGenerated(t, src, `package fixture
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestVersions(t *testing.T) {
+	// Values written by older versions are decoded without the fields since newer ones
+	bs, err := enkodo.Marshal(&UserV1{Name: "alice", Age: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var old User
+	if err = enkodo.Unmarshal(bs, &old); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (User{Name: "alice", Age: 30}); !reflect.DeepEqual(old, expected) {
+		t.Fatalf("invalid value, expected %+v and received %+v", expected, old)
+	}
+
+	// Fields until the current version are no longer written
+	in := User{Name: "bob", Age: 40, Email: "bob@example.com", Tags: []string{"a"}}
+	if bs, err = enkodo.Marshal(&in); err != nil {
+		t.Fatal(err)
+	}
+	var out User
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if in.Age = 0; !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+
+	// Older versions can not skip the fields of newer ones
+	var v1 UserV1
+	var versionErr *enkodo.VersionError
+	if err = enkodo.Unmarshal(bs, &v1); !errors.As(err, &versionErr) || versionErr.Version != 3 || versionErr.Latest != 1 {
+		t.Fatalf("expected a VersionError, received %v", err)
+	}
+}
+`)
+}
+
+func TestFramed(t *testing.T) {
+	opts.Framed = true
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+type UserV1 struct {
+	Name string ` + "`enkodo:\"\"`" + `
+}
+
+type User struct {
+	Name  string ` + "`enkodo:\"\"`" + `
+	Email string ` + "`enkodo:\"\"`" + `
+}
+
+type Users struct {
+	First  User   ` + "`enkodo:\"\"`" + `
+	Second User   ` + "`enkodo:\"\"`" + `
+}
+
+type UsersV1 struct {
+	First  UserV1 ` + "`enkodo:\"\"`" + `
+	Second UserV1 ` + "`enkodo:\"\"`" + `
+}
+`
+	runGenerated(t, src, `package fixture
+
+import (
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestFramed(t *testing.T) {
+	in := Users{First: User{Name: "alice", Email: "alice@example.com"}, Second: User{Name: "bob"}}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decoders without the appended field skip it
+	var out UsersV1
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.First.Name != "alice" || out.Second.Name != "bob" {
+		t.Fatalf("invalid value, expected alice and bob, received %+v", out)
+	}
+
+	var round Users
+	if err = enkodo.Unmarshal(bs, &round); err != nil {
+		t.Fatal(err)
+	}
+	if round != in {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, round)
+	}
+}
+`)
+}
+
+func TestNestedOverride(t *testing.T) {
+	src := `package fixture
+
+type SocialMedia string
+
+type Profile struct {
+	Accounts [][]SocialMedia          ` + "`enkodo:\"string\"`" + `
+	Grid     [2][]SocialMedia         ` + "`enkodo:\"string\"`" + `
+	ByName   map[string][]SocialMedia ` + "`enkodo:\"string\"`" + `
+}
+`
+	runGenerated(t, src, `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Profile{
+		Accounts: [][]SocialMedia{{"twitter", "github"}, {"mastodon", "bluesky", "gitlab"}},
+		Grid:     [2][]SocialMedia{{"a"}, {"b", "c"}},
+		ByName:   map[string][]SocialMedia{"work": {"linkedin"}},
+	}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Profile
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+}
+
+func TestElementOverride(t *testing.T) {
+	src := `package fixture
+
+type SocialMedia string
+
+type Level int
+
+type Profile struct {
+	Accounts []SocialMedia           ` + "`enkodo:\"[]string\"`" + `
+	Nested   [][]SocialMedia         ` + "`enkodo:\"[][]string\"`" + `
+	Levels   map[string]Level        ` + "`enkodo:\"map[string]uint8\"`" + `
+	Pair     [2]Level                ` + "`enkodo:\"[2]int16\"`" + `
+	ByName   map[string][]SocialMedia ` + "`enkodo:\"map[string][]string\"`" + `
+}
+`
+	// The overrides apply to the elements, as the collections can not be converted
+	if out := generate(t, src); !strings.Contains(out, "enc.Uint8(uint8(v))") || strings.Contains(out, "[]SocialMedia(_v)") {
+		t.Fatalf("expected the overrides to apply to the elements, received:\n%s", out)
+	}
+	runGenerated(t, src, `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Profile{
+		Accounts: []SocialMedia{"twitter", "github"},
+		Nested:   [][]SocialMedia{{"a"}, {"b", "c"}},
+		Levels:   map[string]Level{"admin": 200},
+		Pair:     [2]Level{-3, 300},
+		ByName:   map[string][]SocialMedia{"work": {"linkedin"}},
+	}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Profile
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+}
+
+func TestCompositeOverride(t *testing.T) {
+	src := `package fixture
+
+type Blob []byte
+
+type Names []string
+
+type File struct {
+	Data  Blob  ` + "`enkodo:\"[]byte\"`" + `
+	Names Names ` + "`enkodo:\"[]string\"`" + `
+}
+`
+	runGenerated(t, src, `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := File{Data: Blob("hello"), Names: Names{"a", "b"}}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out File
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+}
+
+func TestCollections(t *testing.T) {
+	src := `package fixture
+
+type IDList []uint64
+
+type Labels map[string]string
+
+type Groups []IDList
+
+type byName []string
+
+type User struct {
+	IDs    IDList  ` + "`enkodo:\"\"`" + `
+	Labels Labels  ` + "`enkodo:\",omitempty\"`" + `
+	Groups Groups  ` + "`enkodo:\"\"`" + `
+	Spare  *IDList ` + "`enkodo:\"\"`" + `
+}
+
+type Plain struct {
+	IDs []uint64 ` + "`enkodo:\"\"`" + `
+}
+`
+	out := generate(t, src)
+	if strings.Contains(out, "byName") {
+		t.Fatalf("expected no methods for a slice no struct holds, received:\n%s", out)
+	}
+	defer func() { opts = Options{} }()
+	opts.All = true
+	out = generate(t, "package fixture\n\ntype Names []string\n")
+	if !strings.Contains(out, "func (n *Names) MarshalEnkodo(") {
+		t.Fatalf("expected -all to generate the methods of exported slices, received:\n%s", out)
+	}
+	opts = Options{}
+	runGenerated(t, src, `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := User{
+		IDs:    IDList{1, 2, 3},
+		Labels: Labels{"env": "prod"},
+		Groups: Groups{{4}, {}},
+		Spare:  &IDList{5},
+	}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out User
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+
+func TestStandalone(t *testing.T) {
+	in := IDList{1, 300, 70000}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Written the same as the underlying slice
+	if plain, _ := enkodo.Marshal(&Plain{IDs: in}); !reflect.DeepEqual(bs, plain) {
+		t.Fatalf("expected the bytes of a []uint64 %v, received %v", plain, bs)
+	}
+
+	var out IDList
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+}
+
+func TestGenerateDirective(t *testing.T) {
+	src := `package fixture
+
+//enkodo:generate
+type Profile struct {
+	Name  string
+	Age   int ` + "`enkodo:\"uint8\"`" + `
+	Cache []byte ` + "`enkodo:\"-\"`" + `
+	notes string
+}
+
+type Account struct {
+	Owner Profile ` + "`enkodo:\"\"`" + `
+}
+
+// Names are encoded on their own
+//
+//enkodo:generate
+type Names []string
+
+type Plain struct {
+	Name string
+}
+`
+	out := generate(t, src)
+	for _, expected := range []string{"func (n *Names) MarshalEnkodo(", "enc.Encode(&a.Owner)"} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("expected %q in the generated code, received:\n%s", expected, out)
+		}
+	}
+	for _, unexpected := range []string{"Cache", "notes", "Plain"} {
+		if strings.Contains(out, unexpected) {
+			t.Fatalf("expected no %q in the generated code, received:\n%s", unexpected, out)
+		}
+	}
+	runGenerated(t, src, `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Account{Owner: Profile{Name: "Ada", Age: 36}}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Account
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+}
+
+func TestStructOptions(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+// Node is framed and depth limited on its own
+//enkodo:options framed,maxdepth=8,budget=1024
+type Node struct {
+	Name     string  ` + "`enkodo:\"\"`" + `
+	Children []*Node ` + "`enkodo:\"\"`" + `
+}
+
+//enkodo:options colour=red,maxdepth=-1
+type Leaf struct {
+	Name string ` + "`enkodo:\"\"`" + `
+}
+`
+	out := generate(t, src)
+	for _, expected := range []string{"enkodo.EncodeFramed", "enkodo.DecodeFramed", "enkodo.ErrMaxDepth", "dec.LimitBudget(1024)"} {
+		if strings.Count(out, expected) != 1 {
+			t.Fatalf("expected %s for Node only, received:\n%s", expected, out)
+		}
+	}
+	for _, expected := range []string{`ignoring the option "colour=red" of Leaf`, `ignoring the option "maxdepth=-1" of Leaf`} {
+		if !strings.Contains(logs.String(), expected) {
+			t.Fatalf("expected a warning containing %q, received:\n%s", expected, logs.String())
+		}
+	}
+
+	// The directive overrides the flags
+	opts.Framed = true
+	out = generate(t, strings.Replace(src, "framed,", "framed=false,", 1))
+	if strings.Count(out, "enkodo.EncodeFramed") != 1 {
+		t.Fatalf("expected only Leaf to be framed, received:\n%s", out)
+	}
+	opts.Framed = false
+
+	runGenerated(t, src, `package fixture
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Node{Name: "root", Children: []*Node{{Name: "child"}}}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Node
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "root" || len(out.Children) != 1 || out.Children[0].Name != "child" {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+
+	n := &Node{}
+	for i := 0; i < 20; i++ {
+		n = &Node{Children: []*Node{n}}
+	}
+	if bs, err = enkodo.Marshal(n); err != nil {
+		t.Fatal(err)
+	}
+	if err = enkodo.Unmarshal(bs, &out); !errors.Is(err, enkodo.ErrMaxDepth) {
+		t.Fatalf("invalid error, expected <%v> and received <%v>", enkodo.ErrMaxDepth, err)
+	}
+}
+`)
+}
+
+func TestExcludeRegexp(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	defer func() { opts = Options{} }()
+	opts.ExcludeType = regexp.MustCompile("^Internal")
+	opts.ExcludeField = regexp.MustCompile("^X")
+
+	src := `package fixture
+
+type InternalState struct {
+	Name string ` + "`enkodo:\"\"`" + `
+}
+
+type InternalIDs []uint64
+
+type User struct {
+	Name   string        ` + "`enkodo:\"\"`" + `
+	XCache []byte        ` + "`enkodo:\"\"`" + `
+	State  InternalState ` + "`enkodo:\"\"`" + `
+	IDs    InternalIDs   ` + "`enkodo:\"\"`" + `
+}
+
+type Legacy struct {
+	XName string ` + "`enkodo:\"\"`" + `
+}
+`
+	out := generate(t, src)
+	for _, unexpected := range []string{"func (i *InternalState)", "func (i *InternalIDs)", "XCache", "func (l *Legacy)"} {
+		if strings.Contains(out, unexpected) {
+			t.Fatalf("expected no %s, received:\n%s", unexpected, out)
+		}
+	}
+	if !strings.Contains(logs.String(), "User.State") {
+		t.Fatalf("expected a warning for User.State, received:\n%s", logs.String())
+	}
+
+	runGenerated(t, src, `package fixture
+
+import (
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := User{Name: "alice", XCache: []byte{1}, IDs: InternalIDs{1}}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out User
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "alice" || out.XCache != nil {
+		t.Fatalf("invalid value, expected only the name of %+v and received %+v", in, out)
+	}
+}
+`)
+}
+
+func TestGzip(t *testing.T) {
+	src := `package fixture
+
+type Document struct {
+	Title string ` + "`enkodo:\"\"`" + `
+	Body  []byte ` + "`enkodo:\"[]byte,gzip\"`" + `
+	Text  string ` + "`enkodo:\"string,gzip\"`" + `
+}
+`
+	runGenerated(t, src, `package fixture
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	body := bytes.Repeat([]byte("all work and no play "), 500)
+	for _, in := range []Document{
+		{Title: "large", Body: body, Text: string(body)},
+		{Title: "empty", Body: []byte{}},
+	} {
+		bs, err := enkodo.Marshal(&in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(in.Body) > 0 && len(bs) > len(body)/10 {
+			t.Fatalf("expected the fields to be compressed, received %d bytes", len(bs))
+		}
+
+		var out Document
+		if err = enkodo.Unmarshal(bs, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+		}
+	}
+}
+`)
+}
+
+func TestTagValue(t *testing.T) {
+	for lit, expected := range map[string]string{
+		"`enkodo:\"int\"`":                     "int",
+		"`json:\"name\" enkodo:\",maxlen=4\"`": ",maxlen=4",
+		`"enkodo:\"uint8\""`:                   "uint8",
+		"`enkodo:'string'`":                    "string",
+		"`json:\"id\" enkodo: \"int\"`":        "int",
+		"`enkodo:\"\"`":                        "",
+	} {
+		if value, ok := tagValue(lit); !ok || value != expected {
+			t.Fatalf("expected %q from %s, received %q (%v)", expected, lit, value, ok)
+		}
+	}
+	for _, lit := range []string{"`json:\"name\"`", "`enkodo:int`", "`myenkodo:\"int\"`", "`json:\"id\" myenkodo: 'int'`"} {
+		if value, ok := tagValue(lit); ok {
+			t.Fatalf("expected no enkodo tag in %s, received %q", lit, value)
+		}
+	}
+}
+
+func TestMalformedTags(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	src := "package fixture\n\ntype User struct {\n" +
+		"\tName  string \"enkodo:\\\"\\\"\"\n" +
+		"\tAge   int    `enkodo:'uint8'`\n" +
+		"\tEmail string `enkodo:string`\n" +
+		"}\n"
+	out := generate(t, src)
+	if !strings.Contains(out, "enc.String(u.Name)") || !strings.Contains(out, "enc.Uint8(uint8(u.Age))") {
+		t.Fatalf("expected the recoverable tags to be used, received:\n%s", out)
+	}
+	if strings.Contains(out, "Email") || !strings.Contains(logs.String(), "User.Email") {
+		t.Fatalf("expected a warning skipping Email, received:\n%s\n%s", logs.String(), out)
+	}
+
+	runGenerated(t, src, `package fixture
+
+import (
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := User{Name: "alice", Age: 30}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out User
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out != in {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+}
+
+func TestSkipTag(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	src := `package fixture
+
+import "sync"
+
+type Cache struct {
+	Name  string         ` + "`enkodo:\"\"`" + `
+	mu    sync.Mutex     ` + "`enkodo:\"-\"`" + `
+	Items map[string]int ` + "`enkodo:\"-\"`" + `
+	Size  int            ` + "`enkodo:\"-\"`" + `
+	Owner *Derived       ` + "`enkodo:\"\"`" + `
+}
+
+// Only opted out fields, so no methods are generated
+type Derived struct {
+	Total int ` + "`enkodo:\"-\"`" + `
+}
+`
+	out := generate(t, src)
+	for _, unexpected := range []string{"c.mu", "c.Items", "c.Size", "c.Owner", "Derived) MarshalEnkodo"} {
+		if strings.Contains(out, unexpected) {
+			t.Fatalf("expected %s to be skipped, received:\n%s", unexpected, out)
+		}
+	}
+	if !strings.Contains(logs.String(), "Cache.Owner has type *Derived which has no enkodo methods") {
+		t.Fatalf("expected a warning for Owner, logged %q", logs.String())
+	}
+
+	runGenerated(t, src, `package fixture
+
+import (
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Cache{Name: "users", Items: map[string]int{"a": 1}, Size: 1}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Cache
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != in.Name || out.Items != nil || out.Size != 0 {
+		t.Fatalf("invalid value, expected only Name and received %+v", out)
+	}
+}
+`)
+}
+
+func TestOmitEmpty(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	src := `package fixture
+
+import "time"
+
+type Event struct {
+	ID     int               ` + "`enkodo:\"\"`" + `
+	Note   string            ` + "`enkodo:\",omitempty\"`" + `
+	Tags   []string          ` + "`enkodo:\",omitempty\"`" + `
+	Attrs  map[string]string ` + "`enkodo:\",omitempty\"`" + `
+	At     time.Time         ` + "`enkodo:\",omitempty\"`" + `
+	Parent *Event            ` + "`enkodo:\",omitempty\"`" + `
+	Hash   [4]byte           ` + "`enkodo:\",omitempty\"`" + `
+}
+`
+	out := generate(t, src)
+	if !strings.Contains(logs.String(), "ignoring omitempty on Event.Hash") {
+		t.Fatalf("expected omitempty on Hash to be ignored, logged %q", logs.String())
+	}
+	if !strings.Contains(out, "_present |= 1 << 4") || strings.Contains(out, "1 << 5") {
+		t.Fatalf("expected a presence bit for each omitempty field, received:\n%s", out)
+	}
+
+	runGenerated(t, src, `package fixture
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	sparse := Event{ID: 1}
+	full := Event{
+		ID:     2,
+		Note:   "note",
+		Tags:   []string{"a"},
+		Attrs:  map[string]string{"k": "v"},
+		At:     time.Unix(1700000000, 0),
+		Parent: &Event{ID: 1},
+		Hash:   [4]byte{1, 2, 3, 4},
+	}
+	for _, in := range []Event{sparse, full} {
+		bs, err := enkodo.Marshal(&in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if in.ID == sparse.ID && len(bs) != 6 {
+			t.Fatalf("expected the sparse event to take 6 bytes, received %d", len(bs))
+		}
+
+		var out Event
+		if err = enkodo.Unmarshal(bs, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !out.At.Equal(in.At) {
+			t.Fatalf("invalid time, expected %v and received %v", in.At, out.At)
+		}
+		out.At = in.At
+		if !reflect.DeepEqual(in, out) {
+			t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+		}
+	}
+}
+`)
+}
+
+func TestAll(t *testing.T) {
+	opts.All = true
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+type User struct {
+	Name, Email string
+	Age         uint8
+	Secret      string ` + "`enkodo:\"-\"`" + `
+	OnChange    func()
+	Events      chan int
+	internal    int
+}
+
+type Group struct {
+	Name    string
+	Members []*User
+	Score   int ` + "`enkodo:\",zigzag\"`" + `
+}
+
+// Unexported structs are left alone
+type cache struct {
+	Size int
+}
+`
+	buf := bytes.NewBuffer(nil)
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	out := generate(t, src)
+	for _, expected := range []string{"func (u *User) MarshalEnkodo", "func (g *Group) MarshalEnkodo", "enc.String(u.Email)", "enc.ZigZag(int64(g.Score))"} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("expected %q, received:\n%s", expected, out)
+		}
+	}
+	for _, unexpected := range []string{"cache", "Secret", "OnChange", "Events", "internal"} {
+		if strings.Contains(out, unexpected) {
+			t.Fatalf("expected %s to be skipped, received:\n%s", unexpected, out)
+		}
+	}
+	for _, field := range []string{"User.OnChange", "User.Events"} {
+		if !strings.Contains(buf.String(), field+" has type") {
+			t.Fatalf("expected a warning for %s, received %q", field, buf)
+		}
+	}
+
+	runGenerated(t, src, `package fixture
+
+import (
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Group{Name: "admins", Members: []*User{{Name: "a", Email: "a@b", Age: 3, Secret: "x"}}, Score: -2}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Group
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	m := out.Members[0]
+	if out.Name != in.Name || out.Score != -2 || m.Name != "a" || m.Email != "a@b" || m.Age != 3 || m.Secret != "" {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+}
+
+func TestNilSlice(t *testing.T) {
+	opts.NilSlice = true
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+type List struct {
+	Values []int  ` + "`enkodo:\"\"`" + `
+	Data   []byte ` + "`enkodo:\"\"`" + `
+}
+`
+	runGenerated(t, src, `package fixture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, in := range []List{
+		{},
+		{Values: []int{}, Data: []byte{}},
+		{Values: []int{1, 2}, Data: []byte{3}},
+	} {
+		bs, err := enkodo.Marshal(&in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out List
+		if err = enkodo.Unmarshal(bs, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(in, out) || (in.Values == nil) != (out.Values == nil) || (in.Data == nil) != (out.Data == nil) {
+			t.Fatalf("invalid value, expected %#v and received %#v", in, out)
+		}
+	}
+}
+`)
+}
+
+func TestClean(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"user.go":                generatedHeader + "\n",
+		"user_enkodo.go":         generatedHeader + "\npackage fixture\n",
+		"old_enkodo.go":          "//go:build go1.18\n\n" + generatedHeader + "\npackage fixture\n",
+		"user_migrate_v1_v2.go":  "package fixture\n",
+		"header.go":              "package fixture\n\nconst header = `" + generatedHeader + "`\n",
+		"internal/a_enkodo.go":   generatedHeader + "\npackage internal\n",
+		"internal/a.go":          "package internal\n",
+		".cache/cache_enkodo.go": generatedHeader + "\npackage cache\n",
+	}
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := Clean(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{filepath.Join(dir, "internal", "a_enkodo.go"), filepath.Join(dir, "old_enkodo.go"), filepath.Join(dir, "user_enkodo.go")}
+	if !slices.Equal(removed, expected) {
+		t.Fatalf("invalid removed files, expected %v and received %v", expected, removed)
+	}
+	for name := range files {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if kept := !slices.Contains(expected, filepath.Join(dir, name)); kept != (err == nil) {
+			t.Fatalf("expected %s to be kept %v, received %v", name, kept, err)
+		}
+	}
+}
+
+func TestFormatted(t *testing.T) {
+	opts = Options{Generics: true, Gated: true, EmitInterfaces: true, CountBytes: true, Framed: true}
+	defer func() { opts = Options{} }()
+
+	out := generate(t, goldenSrc)
+	src, err := format.Source([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(src) != out {
+		t.Fatalf("expected gofmt'd code, received:\n%s", out)
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	opts = Options{Msgpack: true, CountBytes: true, EmitAccessors: true}
+	defer func() { opts = Options{} }()
+
+	src := `package fixture
+
+import "time"
+
+type Zulu struct {
+	When    time.Time     ` + "`enkodo:\"\"`" + `
+	Timeout time.Duration ` + "`enkodo:\"\"`" + `
+}
+
+type Alpha struct {
+	Hits  []int64 ` + "`enkodo:\"\"`" + `
+	Child *Zulu   ` + "`enkodo:\"\"`" + `
+}
+
+type Mike struct {
+	Names []string          ` + "`enkodo:\"\"`" + `
+	Attrs map[string]string ` + "`enkodo:\"\"`" + `
+}
+`
+	out := generate(t, src)
+	zulu, alpha, mike := strings.Index(out, "(z *Zulu) MarshalEnkodo"), strings.Index(out, "(a *Alpha) MarshalEnkodo"), strings.Index(out, "(m *Mike) MarshalEnkodo")
+	if zulu < 0 || zulu > alpha || alpha > mike {
+		t.Fatalf("expected the structs in the order they are declared, received:\n%s", out)
+	}
+	for i := 0; i < 20; i++ {
+		if again := generate(t, src); again != out {
+			t.Fatalf("expected the same output on every run, received:\n%s\nthen:\n%s", out, again)
+		}
+	}
+}
+
+func TestRuntime(t *testing.T) {
+	opts = Options{Runtime: "example.com/mirror/codec", Msgpack: true}
+	defer func() { opts = Options{} }()
+
+	out := generate(t, "package fixture\n\ntype User struct {\n\tName string `enkodo:\"\"`\n}\n")
+	for _, expected := range []string{`import enkodo "example.com/mirror/codec"`, `import "example.com/mirror/codec/msgpack"`} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("expected %s, received:\n%s", expected, out)
+		}
+	}
+
+	// A runtime named enkodo is imported as it is
+	opts.Runtime = "example.com/mirror/enkodo"
+	if out = generate(t, "package fixture\n\ntype User struct {\n\tName string `enkodo:\"\"`\n}\n"); !strings.Contains(out, `import "example.com/mirror/enkodo"`) {
+		t.Fatalf("expected the runtime imported without a name, received:\n%s", out)
+	}
+}
@@ -0,0 +1,77 @@
+package gen
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+)
+
+// generatorSource is a go source file added to a Generator
+type generatorSource struct {
+	name string
+	src  []byte
+}
+
+// Generator generates the enkodo methods of the structs in go source files of a package
+// held in memory, e.g. by build tools, without reading or writing any file other than the
+// packages they import. Each Generator has its own options and sources, which it generates
+// with instead of those set by SetOptions. Generators may be used from any goroutine, and
+// generate one at a time along with the other functions of the package, as they all share
+// its state
+type Generator struct {
+	// The options to generate with, instead of those set by SetOptions
+	Options Options
+
+	sources []generatorSource
+	pkg     string
+}
+
+// NewGenerator returns a Generator generating with o
+func NewGenerator(o Options) *Generator {
+	return &Generator{Options: o}
+}
+
+// AddFile adds the go source file src, named name in errors, to the package generated.
+// It fails when src does not parse or belongs to another package than the files added
+// before it
+func (g *Generator) AddFile(name string, src []byte) error {
+	fil, err := parser.ParseFile(token.NewFileSet(), name, src, parser.PackageClauseOnly)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	if g.pkg != "" && fil.Name.Name != g.pkg {
+		return fmt.Errorf("%s is in package %s, not %s", name, fil.Name.Name, g.pkg)
+	}
+	g.pkg = fil.Name.Name
+	g.sources = append(g.sources, generatorSource{name, src})
+	return nil
+}
+
+// Generate writes the code of every struct in the added files to w, in the order they
+// were added and declared, as a single file. Nothing is written if they have no enkodo
+// structs
+func (g *Generator) Generate(w io.Writer) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	saved := opts
+	defer func() { opts = saved }()
+	opts = g.Options
+	if err := checkOptions(); err != nil {
+		return err
+	}
+
+	resetState()
+	var all []*Struct
+	for _, source := range g.sources {
+		_, structs, err := parseSource(source.name, source.src, g.sources)
+		if err != nil {
+			return err
+		}
+		all = append(all, structs...)
+	}
+	if len(all) == 0 || !selected(all) {
+		return nil
+	}
+	return writeFile(w, g.pkg, all, true)
+}
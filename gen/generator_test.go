@@ -0,0 +1,111 @@
+package gen
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGenerator(t *testing.T) {
+	sources := map[string]string{
+		"level.go": "package fixture\n\ntype Level uint8\n\ntype Team struct {\n\tName string `enkodo:\"\"`\n}\n",
+		"user.go":  "package fixture\n\ntype User struct {\n\tName  string `enkodo:\"\"`\n\tLevel Level  `enkodo:\"\"`\n\tTeam  *Team  `enkodo:\"\"`\n}\n",
+	}
+	g := NewGenerator(Options{NilSlice: true})
+	for _, name := range []string{"level.go", "user.go"} {
+		if err := g.AddFile(name, []byte(sources[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.AddFile("other.go", []byte("package other\n")); err == nil || !strings.Contains(err.Error(), "package other") {
+		t.Fatalf("expected an error for a file of another package, received %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := g.Generate(&out); err != nil {
+		t.Fatal(err)
+	}
+	// The files are checked together, so User knows the Level of level.go
+	if !strings.Contains(out.String(), "enc.Uint8(uint8(u.Level))") || !strings.Contains(out.String(), "(t *Team) MarshalEnkodo") {
+		t.Fatalf("expected the structs of both files, received:\n%s", out.String())
+	}
+	if opts.NilSlice {
+		t.Fatal("expected the options set before generating to be restored")
+	}
+
+	// Generators with other options can generate at once, each with its own, along with
+	// the functions of the package setting its options and converters
+	defer SetOptions(Options{})
+	defer delete(enc_types_advanced, "fixture.Unused")
+	var wg sync.WaitGroup
+	outs := make([]bytes.Buffer, 8)
+	errs := make([]error, len(outs))
+	for i := range outs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			SetOptions(Options{MaxDepth: i})
+			RegisterConverter("fixture.Unused", NewBasicTypeConverter("fixture.Unused", "Int"))
+			if err := Generate(io.Discard, []byte(sources["user.go"])); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	for i := range outs {
+		g := NewGenerator(Options{NilSlice: true, Gated: i%2 == 1})
+		for _, name := range []string{"level.go", "user.go"} {
+			if err := g.AddFile(name, []byte(sources[name])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = g.Generate(&outs[i])
+		}()
+	}
+	wg.Wait()
+	for i := range outs {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if expected := out.String(); i%2 == 1 {
+			if outs[i].String() == expected {
+				t.Fatalf("expected generator %d to generate gated methods, received:\n%s", i, outs[i].String())
+			}
+		} else if outs[i].String() != expected {
+			t.Fatalf("expected generator %d to generate as the first, received:\n%s", i, outs[i].String())
+		}
+	}
+
+	files := map[string]string{"enkodo.go": out.String(), "fixture_test.go": `package fixture
+
+import (
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := User{Name: "alice", Level: 3, Team: &Team{Name: "blue"}}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out User
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != in.Name || out.Level != in.Level || out.Team.Name != "blue" {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`}
+	for name, src := range sources {
+		files[name] = src
+	}
+	dir := writeModule(t, files)
+	testModule(t, dir)
+}
@@ -0,0 +1,417 @@
+package gen
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Written after the header of generated files, followed by the hash of what their code
+// was generated from
+const hashDirective = "//enkodo:hash "
+
+// The hash of the sources of each package directory GeneratePath generates, and that of
+// the package being generated, which writeFile records in its header
+var (
+	sourceHashes = map[string]string{}
+	sourceHash   string
+)
+
+// generatorVersion is hashed with the sources of generated files, so those written by an
+// older enkodo are regenerated once. Increase it whenever the code generated for the
+// same sources and options changes
+const generatorVersion = 1
+
+// packageHash returns the hash of everything the code generated for the package in dir
+// depends on: the version of the generator, the options, template and converters, and the
+// declarations of the package and of the packages of the same module it imports, whose
+// named types it may encode. The declarations of the package are returned by file name
+func packageHash(dir string) (string, map[string]*ast.File) {
+	h := sha256.New()
+	fmt.Fprintln(h, "enkodo", generatorVersion)
+
+	// Options that do not change the generated code are left out, and so are paths, which
+	// change with the directory generating is run from. The template is hashed by content
+	o := opts
+	o.Force, o.Jobs, o.Check, o.Diff, o.Stdout, o.DumpAST = false, 0, false, false, false, false
+	o.Template, o.Output, o.Log = "", "", nil
+	data, _ := json.Marshal(o)
+	h.Write(data)
+	if opts.Template != "" {
+		hashFile(h, opts.Template)
+	}
+	names := make([]string, 0, len(enc_types_advanced))
+	for name := range enc_types_advanced {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := enc_types_advanced[name]
+		fmt.Fprintln(h, name, c.EnkodoFunction(), c.Enc("$v"), c.Dec("$v"), c.Imports())
+	}
+
+	decls := hashDir(h, dir)
+	module, path := modulePath(dir)
+	for _, imp := range importPaths(decls) {
+		if rest, ok := strings.CutPrefix(imp, path+"/"); ok && path != "" {
+			hashDir(h, filepath.Join(module, filepath.FromSlash(rest)))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), decls
+}
+
+// hashDir writes the declarations of the go files in dir that were not written by enkodo
+// to h, see hashDecls, returning them by file name. Files that do not parse are hashed
+// whole. Test files are only hashed with Options.IncludeTests
+func hashDir(h hash.Hash, dir string) map[string]*ast.File {
+	// Not the path itself, which changes with the path generating is run with
+	fmt.Fprintln(h, "package")
+	files, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	decls := make(map[string]*ast.File, len(files))
+	for _, file := range files {
+		if isGenerated(file) || strings.HasSuffix(file, "_test.go") && !opts.IncludeTests {
+			continue
+		}
+		fmt.Fprintln(h, filepath.Base(file))
+		fset := token.NewFileSet()
+		fil, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+		if err != nil {
+			hashFile(h, file)
+			continue
+		}
+		hashDecls(h, fset, fil)
+		decls[filepath.Base(file)] = fil
+	}
+	return decls
+}
+
+// hashDecls writes what the code generated for fil may depend on to h: its build
+// constraints, package name, imports, constants, types with their comments, which hold
+// directives, and the signatures of methods. Bodies of functions and variables are left
+// out, so editing them regenerates nothing
+func hashDecls(h hash.Hash, fset *token.FileSet, fil *ast.File) {
+	for _, group := range fil.Comments {
+		if group.Pos() > fil.Package {
+			break
+		}
+		for _, c := range group.List {
+			fmt.Fprintln(h, c.Text)
+		}
+	}
+	fmt.Fprintln(h, "package", fil.Name.Name)
+	for _, decl := range fil.Decls {
+		switch d := decl.(type) {
+		case *ast.GenDecl:
+			if d.Tok == token.VAR {
+				continue
+			}
+			if d.Doc != nil {
+				for _, c := range d.Doc.List {
+					fmt.Fprintln(h, c.Text)
+				}
+			}
+			printer.Fprint(h, fset, &printer.CommentedNode{Node: d, Comments: fil.Comments})
+		case *ast.FuncDecl:
+			if d.Recv == nil {
+				continue
+			}
+			printer.Fprint(h, fset, &ast.FuncDecl{Recv: d.Recv, Name: d.Name, Type: d.Type})
+		}
+		fmt.Fprintln(h)
+	}
+}
+
+// importPaths returns the paths the files of decls import, sorted
+func importPaths(decls map[string]*ast.File) []string {
+	imports := make(map[string]bool)
+	for _, fil := range decls {
+		for _, spec := range fil.Imports {
+			path, _ := strconv.Unquote(spec.Path.Value)
+			imports[path] = true
+		}
+	}
+	paths := make([]string, 0, len(imports))
+	for path := range imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func hashFile(h hash.Hash, file string) {
+	if f, err := os.Open(file); err == nil {
+		io.Copy(h, f)
+		f.Close()
+	}
+}
+
+// modulePath returns the directory and path of the module holding dir, or "" when it is
+// not in one
+func modulePath(dir string) (string, string) {
+	module := moduleDir(dir)
+	f, err := os.Open(filepath.Join(module, "go.mod"))
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if path, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "module "); ok {
+			return module, strings.Trim(strings.TrimSpace(path), `"`)
+		}
+	}
+	return "", ""
+}
+
+// recordedHash returns the hash recorded in the header of the generated file, or "" when
+// it has none
+func recordedHash(file string) string {
+	f, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if h, ok := strings.CutPrefix(line, hashDirective); ok {
+			return h
+		}
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+	}
+	return ""
+}
+
+// outdated hashes the packages of files into sourceHashes, returning the files of those
+// whose generated files do not all record their hash, or that are missing one of the
+// files they generate. Those that do were generated from the same sources with the same
+// options, so are left as they are unless Options.Force is set, and so are packages
+// without generated files that never mention enkodo, unless generating for all their
+// structs. Under Options.Check and Options.Diff every file is compared, as generated
+// files may have been edited since
+func outdated(files []string) []string {
+	sourceHashes = make(map[string]string)
+	expected := make(map[string][]string)
+	for _, file := range files {
+		if dir := filepath.Dir(file); sourceHashes[dir] == "" {
+			h, decls := packageHash(dir)
+			sourceHashes[dir], expected[dir] = h, expectedOutputs(dir, files, decls)
+		}
+	}
+	if opts.Force || opts.Stdout || dryRun() || len(opts.Types) > 0 {
+		return files
+	}
+
+	current := make(map[string]bool, len(sourceHashes))
+	for dir, h := range sourceHashes {
+		generated := packageGenerated(dir, files)
+		current[dir] = len(generated) > 0 || !opts.All && !mentionsEnkodo(dir, files)
+		for _, file := range generated {
+			if recordedHash(file) != h {
+				current[dir] = false
+			}
+		}
+		// Those removed since are written again
+		for _, file := range expected[dir] {
+			if !isGenerated(file) {
+				current[dir] = false
+			}
+		}
+	}
+	out := make([]string, 0, len(files))
+	for _, file := range files {
+		if !current[filepath.Dir(file)] {
+			out = append(out, file)
+		}
+	}
+	return out
+}
+
+// mentionsEnkodo reports whether any of the files in dir mention enkodo, in a tag or a
+// directive, which they need for code to be generated for them
+func mentionsEnkodo(dir string, files []string) bool {
+	for _, file := range files {
+		if filepath.Dir(file) != dir {
+			continue
+		}
+		if data, err := os.ReadFile(file); err != nil || bytes.Contains(data, []byte("enkodo")) {
+			return true
+		}
+	}
+	return false
+}
+
+// packageGenerated returns the generated files of the package in dir that exist, in any
+// of the layouts it may have been generated with
+func packageGenerated(dir string, files []string) []string {
+	outDir := dir
+	if opts.Output != "" && outputIsDir() {
+		outDir = opts.Output
+	}
+	// Leaving out the tests written with Options.Tests, which record no hash
+	candidates, _ := filepath.Glob(filepath.Join(outDir, "enkodo_gen_*.go"))
+	candidates = slices.DeleteFunc(candidates, func(file string) bool { return strings.HasSuffix(file, "_test.go") })
+	candidates = append(candidates, filepath.Join(outDir, combinedName))
+	if output, single := packageOutput(dir); single {
+		candidates = append(candidates, output)
+	}
+	for _, file := range files {
+		if filepath.Dir(file) == dir {
+			candidates = append(candidates, outputName(file))
+		}
+	}
+
+	generated := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, file := range candidates {
+		if !seen[file] && isGenerated(file) {
+			generated = append(generated, file)
+		}
+		seen[file] = true
+	}
+	return generated
+}
+
+// expectedOutputs returns the files generating the package in dir from files writes, in
+// the layout of the options, going by the declarations of its files in decls
+func expectedOutputs(dir string, files []string, decls map[string]*ast.File) []string {
+	generating := generatingFiles(decls)
+	output, single := packageOutput(dir)
+	outputs := make([]string, 0)
+	together := false
+	for _, file := range files {
+		if filepath.Dir(file) != dir || !generating[filepath.Base(file)] {
+			continue
+		}
+		// Test files are generated on their own, see generatePath
+		if (single || opts.MaxStructs > 0) && !strings.HasSuffix(file, "_test.go") {
+			together = true
+			continue
+		}
+		outputs = append(outputs, outputName(file))
+		if opts.Compare || opts.Bench {
+			outputs = append(outputs, compareName(file))
+		}
+		if opts.Tests || opts.Fuzz {
+			outputs = append(outputs, testName(file))
+		}
+	}
+	if !together {
+		return outputs
+	}
+
+	if !single {
+		outDir := dir
+		if opts.Output != "" {
+			outDir = opts.Output
+		}
+		output = filepath.Join(outDir, "enkodo_gen_0.go")
+	}
+	outputs = append(outputs, output)
+	if opts.Compare || opts.Bench {
+		outputs = append(outputs, compareName(filepath.Join(dir, "enkodo.go")))
+	}
+	if opts.Tests || opts.Fuzz {
+		outputs = append(outputs, filepath.Join(dir, "enkodo_gen_test.go"))
+	}
+	return outputs
+}
+
+// generatingFiles returns the names of the files of decls declaring types code is
+// generated for: structs with enkodo tags, or selected by Options.All or an
+// //enkodo:generate directive, and the named slices and maps their fields hold, directly
+// or through one another, as addCollections finds them without type checking
+func generatingFiles(decls map[string]*ast.File) map[string]bool {
+	generating := make(map[string]bool)
+	// The named slices and maps of the package, and the files declaring them
+	collections := make(map[string]ast.Expr)
+	declaredIn := make(map[string]string)
+	held := make([]ast.Expr, 0)
+	for name, fil := range decls {
+		for _, decl := range fil.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if ts.Assign.IsValid() || excludedType(ts.Name.Name) {
+					continue
+				}
+				all := opts.All && ts.Name.IsExported() || hasGenerateDirective(gd, ts)
+				switch t := ts.Type.(type) {
+				case *ast.StructType:
+					for _, field := range t.Fields.List {
+						if encodedField(field, all) {
+							generating[name] = true
+							held = append(held, field.Type)
+						}
+					}
+				case *ast.ArrayType, *ast.MapType:
+					if at, ok := t.(*ast.ArrayType); ok && at.Len != nil {
+						continue
+					}
+					collections[ts.Name.Name], declaredIn[ts.Name.Name] = t, name
+					if all {
+						generating[name] = true
+						held = append(held, t)
+					}
+				}
+			}
+		}
+	}
+
+	for len(held) > 0 {
+		typ := held[len(held)-1]
+		held = held[:len(held)-1]
+		ast.Inspect(typ, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.SelectorExpr:
+				// Those of imported packages are generated there
+				return false
+			case *ast.Ident:
+				if t, ok := collections[n.Name]; ok {
+					generating[declaredIn[n.Name]] = true
+					delete(collections, n.Name)
+					held = append(held, t)
+				}
+			}
+			return true
+		})
+	}
+	return generating
+}
+
+// encodedField reports whether field is encoded, as it is tagged or, with all, exported
+func encodedField(field *ast.Field, all bool) bool {
+	value, tagged := "", false
+	if field.Tag != nil {
+		value, tagged = tagValue(field.Tag.Value)
+	}
+	if value == "-" && tagged {
+		return false
+	}
+	for _, name := range field.Names {
+		if !excludedField(name.Name) && (tagged || all && name.IsExported()) {
+			return true
+		}
+	}
+	return tagged && len(field.Names) == 0
+}
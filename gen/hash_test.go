@@ -0,0 +1,93 @@
+package gen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIncremental(t *testing.T) {
+	defer func() { opts = Options{} }()
+
+	dir := writeModule(t, map[string]string{
+		"othr/othr.go": "package othr\n\ntype Level uint8\n",
+		"user.go":      "package fixture\n\nimport \"fixture/othr\"\n\ntype User struct {\n\tName  string     `enkodo:\"\"`\n\tLevel othr.Level `enkodo:\"\"`\n\tTeams Teams      `enkodo:\"\"`\n}\n",
+		"team.go":      "package fixture\n\ntype Teams []string\n\nfunc (t Teams) Len() int {\n\treturn len(t)\n}\n",
+		"doc.go":       "// Package fixture is generated with enkodo\npackage fixture\n",
+	})
+	generated := filepath.Join(dir, "user_enkodo.go")
+	// edit marks the generated file, which is gone once it is generated again
+	edit := func() {
+		f, err := os.OpenFile(generated, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err = f.WriteString("// edited\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run := func(regenerated bool) {
+		t.Helper()
+		if err := GeneratePath(dir); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(generated)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), hashDirective) {
+			t.Fatalf("expected the hash of the sources in the header, received:\n%s", data)
+		}
+		if edited := strings.Contains(string(data), "// edited"); edited == regenerated {
+			t.Fatalf("expected the file to be regenerated %v, received:\n%s", regenerated, data)
+		}
+	}
+
+	run(true)
+	edit()
+	run(false)
+
+	opts.Force = true
+	run(true)
+	opts.Force = false
+
+	// Changes to the package, or to the packages of the module it imports, regenerate it
+	edit()
+	if err := os.WriteFile(filepath.Join(dir, "othr", "othr.go"), []byte("package othr\n\ntype Level uint16\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	run(true)
+	if data, _ := os.ReadFile(generated); !strings.Contains(string(data), "enc.Uint16(uint16(u.Level))") {
+		t.Fatalf("expected Level to be written as a uint16, received:\n%s", data)
+	}
+	edit()
+	opts.NilSlice = true
+	run(true)
+
+	// Function bodies are not hashed, the generated code does not depend on them
+	edit()
+	if err := os.WriteFile(filepath.Join(dir, "team.go"), []byte("package fixture\n\ntype Teams []string\n\nfunc (t Teams) Len() int {\n\treturn len(t) + 0\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	run(false)
+
+	// Nor is the build of the generator, only its version
+	h, _ := packageHash(dir)
+	if recorded := recordedHash(generated); recorded != h {
+		t.Fatalf("expected the recorded hash %s to be that of the package, received %s", h, recorded)
+	}
+
+	// Generated files removed since are written again, the collection of team.go too
+	if err := os.Remove(filepath.Join(dir, "team_enkodo.go")); err != nil {
+		t.Fatal(err)
+	}
+	run(true)
+	if _, err := os.Stat(filepath.Join(dir, "team_enkodo.go")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "doc_enkodo.go")); err == nil {
+		t.Fatal("expected no code generated for doc.go, which declares no types")
+	}
+}
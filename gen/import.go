@@ -0,0 +1,184 @@
+package gen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Name of the file ImportSchema declares the structs of a package in
+const importName = "enkodo_schema.go"
+
+const importHeader = "// Structs imported from an enkodo schema, import the schema again rather than editing them"
+
+// Packages of the qualified types the runtime encodes, by name
+var schemaImports = map[string]string{
+	"time":  "time",
+	"net":   "net",
+	"netip": "net/netip",
+	"uuid":  "github.com/google/uuid",
+	"big":   "math/big",
+}
+
+// ImportSchema declares the structs described by the schema in file, as written by
+// ExportSchema, in the package directories under dir, then generates their enkodo
+// methods. The schema is the source of truth, so the fields are declared as the types
+// they are written as, e.g. a named type of the exporting package as its underlying type
+func ImportSchema(file, dir string) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var schema Schema
+	if err = json.Unmarshal(data, &schema); err != nil {
+		return fmt.Errorf("invalid schema %s: %w", file, err)
+	}
+
+	// The wire format is the schema's
+	saved := opts
+	defer func() { opts = saved }()
+	opts.FormatVersion, opts.NilSlice, opts.Framed = schema.FormatVersion, schema.NilSlice, schema.Framed
+
+	files := make([]string, 0, len(schema.Packages))
+	for _, pkg := range schema.Packages {
+		src, err := pkg.source()
+		if err != nil {
+			return fmt.Errorf("failed to import %s from %s: %w", pkg.Name, file, err)
+		}
+		pkgDir := filepath.Join(dir, filepath.FromSlash(pkg.Dir))
+		if err = os.MkdirAll(pkgDir, 0o755); err != nil {
+			return err
+		}
+		filename := filepath.Join(pkgDir, importName)
+		logf("Declaring %d structs of %s in %s\n", len(pkg.Structs), pkg.Name, filename)
+		if err = os.WriteFile(filename, src, 0o644); err != nil {
+			return err
+		}
+		files = append(files, filename)
+	}
+	return generatePath(files)
+}
+
+// source returns the go source declaring the structs of the package, and the interfaces
+// their fields are registered for
+func (pkg PackageSchema) source() ([]byte, error) {
+	imports := make(map[string]bool)
+	// The types registered for each interface
+	interfaces := make(map[string][]RegisteredSchema)
+	body := bytes.NewBuffer(nil)
+	for _, s := range pkg.Structs {
+		fmt.Fprintln(body)
+		if s.Version > 0 {
+			fmt.Fprintf(body, "%s%d\n", versionDirective, s.Version)
+		}
+		if s.Framed != nil {
+			fmt.Fprintf(body, "%sframed=%v\n", optionsDirective, *s.Framed)
+		}
+		fmt.Fprintf(body, "type %s struct {\n", s.Name)
+		for _, field := range s.Fields {
+			typ, err := field.Wire.goType(imports, interfaces)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", s.Name, field.Name, err)
+			}
+			fmt.Fprintf(body, "\t%s %s `enkodo:%q`\n", field.Name, typ, importTag(field))
+		}
+		fmt.Fprintln(body, "}")
+	}
+
+	// Registered types implement their interfaces through a method of its own
+	names := make([]string, 0, len(interfaces))
+	for name := range interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entries := make([]string, 0, len(interfaces[name]))
+		for _, entry := range interfaces[name] {
+			entries = append(entries, fmt.Sprintf("%s=%d", entry.Type, entry.ID))
+		}
+		fmt.Fprintf(body, "\n%s%s\ntype %s interface {\n\tis%s()\n}\n", registerDirective, strings.Join(entries, " "), name, name)
+		for _, entry := range interfaces[name] {
+			fmt.Fprintf(body, "\nfunc (%s) is%s() {}\n", entry.Type, name)
+		}
+	}
+
+	out := bytes.NewBuffer(nil)
+	fmt.Fprintf(out, "%s\n\npackage %s\n", importHeader, pkg.Name)
+	paths := make([]string, 0, len(imports))
+	for path := range imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(out, "\nimport %q\n", path)
+	}
+	body.WriteTo(out)
+	return format.Source(out.Bytes())
+}
+
+// goType returns the go type declaring a field written as t, adding the packages it
+// uses to imports and the interfaces it holds to interfaces
+func (t TypeSchema) goType(imports map[string]bool, interfaces map[string][]RegisteredSchema) (string, error) {
+	elem := func() (string, error) {
+		if t.Elem == nil {
+			return "", fmt.Errorf("%s %s has no element type", t.Kind, t.Type)
+		}
+		return t.Elem.goType(imports, interfaces)
+	}
+	switch t.Kind {
+	case "basic", "struct":
+		if pkg, _, ok := strings.Cut(strings.TrimPrefix(baseType(t.Type), "*"), "."); ok {
+			path, ok := schemaImports[pkg]
+			if !ok {
+				return "", fmt.Errorf("%s is of package %s, which is not part of the schema", t.Type, pkg)
+			}
+			imports[path] = true
+		}
+		return t.Type, nil
+	case "interface":
+		interfaces[t.Type] = t.Registry
+		return t.Type, nil
+	case "converter":
+		return "", fmt.Errorf("%s is written by %s, which the schema does not describe", t.Type, t.Method)
+	case "json", "gzip":
+		// Both are written as bytes
+		return "[]byte", nil
+	case "pointer":
+		e, err := elem()
+		return "*" + e, err
+	case "slice", "runs":
+		e, err := elem()
+		return "[]" + e, err
+	case "array":
+		e, err := elem()
+		return fmt.Sprintf("[%d]%s", t.Len, e), err
+	case "map":
+		if t.Key == nil {
+			return "", fmt.Errorf("map %s has no key type", t.Type)
+		}
+		k, err := t.Key.goType(imports, interfaces)
+		if err != nil {
+			return "", err
+		}
+		e, err := elem()
+		return "map[" + k + "]" + e, err
+	}
+	return "", fmt.Errorf("unknown kind %q of %s", t.Kind, t.Type)
+}
+
+// importTag returns the tag of an imported field. The field is declared as the type it is
+// written as, so only the options of its tag are kept
+func importTag(field FieldSchema) string {
+	_, options, ok := strings.Cut(field.Tag, ",")
+	if field.Wire.Kind == "json" || !ok {
+		return ""
+	}
+	return "," + options
+}
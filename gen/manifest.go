@@ -0,0 +1,83 @@
+package gen
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Name of the file recording the field order of the generated structs of a package
+const manifestName = "enkodo.manifest"
+
+// manifest maps each generated struct to the names of its fields in wire order
+type manifest map[string][]string
+
+// loadManifest reads the manifest in dir, a missing manifest is empty
+func loadManifest(dir string) (manifest, error) {
+	m := make(manifest)
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid %s in %s: %w", manifestName, dir, err)
+	}
+	return m, nil
+}
+
+func (m manifest) save(dir string) error {
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, manifestName), append(data, '\n'), 0644)
+}
+
+// checkOrder returns an error if fields is not the recorded order with any new fields
+// appended. Fields moving or being removed changes the wire format
+func checkOrder(name string, recorded, fields []string) error {
+	for i, field := range recorded {
+		if !slices.Contains(fields, field) {
+			return fmt.Errorf("%s.%s was removed", name, field)
+		}
+		if fields[i] != field {
+			return fmt.Errorf("%s.%s moved from position %d to %d", name, field, i, slices.Index(fields, field))
+		}
+	}
+	return nil
+}
+
+// checkFieldOrder compares the structs against the manifest in dir and records their
+// current order. Reordered fields are an error unless -allow-reorder is set
+func checkFieldOrder(dir string, structs []*Struct) error {
+	m, err := loadManifest(dir)
+	if err != nil {
+		return err
+	}
+	for _, s := range structs {
+		fields := make([]string, len(s.Fields))
+		for i, field := range s.Fields {
+			fields[i] = field.Name
+		}
+		if recorded, ok := m[s.Name]; ok {
+			if err := checkOrder(s.Name, recorded, fields); err != nil {
+				if !opts.AllowReorder {
+					return fmt.Errorf("field order changed, this breaks the wire format (use -allow-reorder to accept it): %w", err)
+				}
+				log.Printf("warning: accepting field order change: %v", err)
+			}
+		}
+		m[s.Name] = fields
+	}
+	if dryRun() {
+		return nil
+	}
+	return m.save(dir)
+}
@@ -0,0 +1,47 @@
+package gen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStrictOrder(t *testing.T) {
+	opts.StrictOrder = true
+	defer func() { opts = Options{} }()
+
+	dir := t.TempDir()
+	check := func(fields ...string) error {
+		s := &Struct{Name: "User"}
+		for _, name := range fields {
+			s.Fields = append(s.Fields, Field{Name: name, Type: "string"})
+		}
+		return checkFieldOrder(dir, []*Struct{s})
+	}
+
+	if err := check("ID", "Name"); err != nil {
+		t.Fatal(err)
+	}
+	// Appending a field keeps the wire format
+	if err := check("ID", "Name", "Email"); err != nil {
+		t.Fatalf("expected an appended field to be accepted, received %v", err)
+	}
+	if err := check("ID", "Email", "Name"); err == nil || !strings.Contains(err.Error(), "User.Name moved from position 1 to 2") {
+		t.Fatalf("expected a reorder error, received %v", err)
+	}
+	if err := check("ID", "Name"); err == nil || !strings.Contains(err.Error(), "User.Email was removed") {
+		t.Fatalf("expected a removal error, received %v", err)
+	}
+
+	// The manifest keeps the last accepted order until a reorder is allowed
+	opts.AllowReorder = true
+	if err := check("ID", "Email", "Name"); err != nil {
+		t.Fatal(err)
+	}
+	m, err := loadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(m["User"], ",") != "ID,Email,Name" {
+		t.Fatalf("expected the reordered fields to be recorded, received %v", m["User"])
+	}
+}
@@ -0,0 +1,252 @@
+package gen
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Name of the file recording the fields of each version of the versioned structs
+const schemaName = "enkodo.schema"
+
+const versionDirective = "//enkodo:version "
+
+// schemaField is a field of a recorded struct version, with the value of its tag
+type schemaField struct {
+	Name string
+	Type string
+	Tag  string
+}
+
+// schemas maps each versioned struct to the fields of its versions
+type schemas map[string]map[int][]schemaField
+
+// Package qualifiers in go types, e.g. time in []time.Time
+var qualifier = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_]*)\.`)
+
+// structVersion returns the version declared by an //enkodo:version directive in the
+// doc comment of a struct, or 0 when it has none
+func structVersion(structName string, doc *ast.CommentGroup) int {
+	if doc == nil {
+		return 0
+	}
+	for _, c := range doc.List {
+		if !strings.HasPrefix(c.Text, versionDirective) {
+			continue
+		}
+		version, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(c.Text, versionDirective)))
+		if err != nil || version < 1 {
+			log.Printf("warning: ignoring invalid version %q on %s", c.Text, structName)
+			return 0
+		}
+		return version
+	}
+	return 0
+}
+
+// loadSchemas reads the schemas in dir, missing schemas are empty
+func loadSchemas(dir string) (schemas, error) {
+	m := make(schemas)
+	data, err := os.ReadFile(filepath.Join(dir, schemaName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid %s in %s: %w", schemaName, dir, err)
+	}
+	return m, nil
+}
+
+func (m schemas) save(dir string) error {
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, schemaName), append(data, '\n'), 0644)
+}
+
+// schemaFields returns the fields of s to record. Accessor fields are left out as they
+// are not fields of the struct
+func schemaFields(s *Struct) []schemaField {
+	fields := make([]schemaField, 0, len(s.Fields))
+	for _, field := range s.Fields {
+		if field.Getter != "" {
+			continue
+		}
+		fields = append(fields, schemaField{Name: field.Name, Type: field.Type, Tag: tagString(field)})
+	}
+	return fields
+}
+
+// tagString returns the enkodo tag value that declares field
+func tagString(field Field) string {
+	keys := make([]string, 0, len(field.Opts))
+	for k := range field.Opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := []string{field.OverrideType}
+	for _, k := range keys {
+		if v := field.Opts[k]; v != "" {
+			parts = append(parts, k+"="+v)
+		} else {
+			parts = append(parts, k)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// recordVersions records the fields of the versioned structs in the schemas in dir.
+// When a struct has a new version, a migration stub from its previous version is
+// written, see writeMigration
+func recordVersions(dir, pkg string, structs []*Struct) error {
+	var m schemas
+	for _, s := range structs {
+		if s.Version == 0 {
+			continue
+		}
+		if m == nil {
+			var err error
+			if m, err = loadSchemas(dir); err != nil {
+				return err
+			}
+		}
+		versions, ok := m[s.Name]
+		if !ok {
+			versions = make(map[int][]schemaField)
+			m[s.Name] = versions
+		}
+
+		prior := 0
+		for v := range versions {
+			if v < s.Version && v > prior {
+				prior = v
+			}
+		}
+		fields := schemaFields(s)
+		if _, ok := versions[s.Version]; !ok && prior > 0 {
+			if err := writeMigration(dir, pkg, s.Name, prior, s.Version, versions[prior], fields); err != nil {
+				return err
+			}
+		}
+		versions[s.Version] = fields
+	}
+	if m == nil {
+		return nil
+	}
+	return m.save(dir)
+}
+
+// migrationName returns the file holding the migration of a struct between versions
+func migrationName(dir, name string, from, to int) string {
+	return filepath.Join(dir, fmt.Sprintf("%s_migrate_v%d_v%d.go", strings.ToLower(name), from, to))
+}
+
+// writeMigration writes a stub declaring the old version of a struct, e.g. UserV1, and
+// a function migrating it to the current struct. Fields with the same name and type
+// are copied, the rest are left as TODOs. The stub is for editing, so it is not marked
+// as generated and is never overwritten. Being tagged, the old struct gets enkodo
+// methods on the next run so old values can still be decoded
+func writeMigration(dir, pkg, name string, from, to int, old, current []schemaField) error {
+	filename := migrationName(dir, name, from, to)
+	if _, err := os.Stat(filename); err == nil {
+		return nil
+	}
+	oldName := fmt.Sprintf("%sV%d", name, from)
+	if pkgTypes != nil && pkgTypes.Scope().Lookup(oldName) != nil {
+		log.Printf("warning: not writing a migration for %s, %s is already declared", name, oldName)
+		return nil
+	}
+
+	imports := make(map[string]bool)
+	body := bytes.NewBuffer(nil)
+	fmt.Fprintf(body, "// %s is %s as encoded by version %d, decode old values into it to migrate them\n", oldName, name, from)
+	fmt.Fprintf(body, "type %s struct {\n", oldName)
+	for _, field := range old {
+		fmt.Fprintf(body, "%s%s %s `enkodo:%q`\n", ident, field.Name, field.Type, field.Tag)
+		for _, match := range qualifier.FindAllStringSubmatch(field.Type, -1) {
+			if path, ok := importPath(match[1]); ok {
+				imports[path] = true
+			}
+		}
+	}
+	fmt.Fprint(body, "}\n\n")
+
+	oldFields := make(map[string]schemaField, len(old))
+	for _, field := range old {
+		oldFields[field.Name] = field
+	}
+	fmt.Fprintf(body, "// Migrate%sToV%d converts a %s from version %d to version %d. Fields with the same\n", oldName, to, name, from, to)
+	fmt.Fprint(body, "// name and type are copied, the TODOs are left to migrate by hand\n")
+	fmt.Fprintf(body, "func Migrate%sToV%d(old *%s) *%s {\n", oldName, to, oldName, name)
+	fmt.Fprintf(body, "%sreturn &%s{\n", ident, name)
+	for _, field := range current {
+		prev, ok := oldFields[field.Name]
+		switch {
+		case !ok:
+			fmt.Fprintf(body, "%s// TODO: %s is new in version %d\n", ident+ident, field.Name, to)
+		case prev.Type != field.Type:
+			fmt.Fprintf(body, "%s// TODO: %s changed from %s to %s\n", ident+ident, field.Name, prev.Type, field.Type)
+		default:
+			fmt.Fprintf(body, "%s%s: old.%s,\n", ident+ident, field.Name, field.Name)
+		}
+		delete(oldFields, field.Name)
+	}
+	for _, field := range old {
+		if _, ok := oldFields[field.Name]; ok {
+			fmt.Fprintf(body, "%s// TODO: %s was removed in version %d, old.%s is dropped\n", ident+ident, field.Name, to, field.Name)
+		}
+	}
+	fmt.Fprintf(body, "%s}\n}\n", ident)
+
+	out := bytes.NewBuffer(nil)
+	fmt.Fprintf(out, "package %s\n\n", pkg)
+	paths := make([]string, 0, len(imports))
+	for path := range imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(out, "import %q\n", path)
+	}
+	fmt.Fprintln(out)
+	body.WriteTo(out)
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to format the migration for %s: %w", name, err)
+	}
+	logf("Writing a migration of %s from version %d to %d to %s\n", name, from, to, filename)
+	return os.WriteFile(filename, src, 0644)
+}
+
+// importPath returns the import path of the package imported as name by the package
+// being generated
+func importPath(name string) (string, bool) {
+	if path, ok := dotImportPaths[name]; ok {
+		return path, true
+	}
+	if pkgTypes == nil {
+		return "", false
+	}
+	for _, p := range pkgTypes.Imports() {
+		if p.Name() == name {
+			return p.Path(), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,102 @@
+package gen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigration(t *testing.T) {
+	v1 := `package fixture
+
+//enkodo:version 1
+type User struct {
+	ID       int    ` + "`enkodo:\"\"`" + `
+	Nickname string ` + "`enkodo:\"\"`" + `
+	Age      int    ` + "`enkodo:\"uint8\"`" + `
+}
+`
+	v2 := `package fixture
+
+//enkodo:version 2
+type User struct {
+	ID    int    ` + "`enkodo:\"\"`" + `
+	Age   int    ` + "`enkodo:\"uint8\"`" + `
+	Email string ` + "`enkodo:\"\"`" + `
+}
+`
+	dir := writeModule(t, map[string]string{"user.go": v1})
+	file := filepath.Join(dir, "user.go")
+	run := func() {
+		t.Helper()
+		delete(checkedPackages, dir) // the package changes between runs
+		if err := GenerateFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run()
+	if _, err := os.Stat(filepath.Join(dir, schemaName)); err != nil {
+		t.Fatalf("expected the schema to be recorded: %v", err)
+	}
+	// Regenerating the same version does not migrate
+	run()
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*_migrate_*")); len(matches) != 0 {
+		t.Fatalf("expected no migration, found %v", matches)
+	}
+
+	if err := os.WriteFile(file, []byte(v2), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	run()
+	migration := migrationName(dir, "User", 1, 2)
+	data, err := os.ReadFile(migration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"type UserV1 struct",
+		"func MigrateUserV1ToV2(old *UserV1) *User",
+		"ID:  old.ID",
+		"// TODO: Email is new in version 2",
+		"// TODO: Nickname was removed in version 2",
+		"Age      int    `enkodo:\"uint8\"`",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Fatalf("expected %q in the migration, received:\n%s", expected, data)
+		}
+	}
+
+	// The old struct is tagged, so gets enkodo methods to decode old values with
+	if err = GenerateFile(migration); err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "user_test.go"), []byte(`package fixture
+
+import (
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestMigrate(t *testing.T) {
+	bs, err := enkodo.Marshal(&UserV1{ID: 7, Nickname: "al", Age: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var old UserV1
+	if err = enkodo.Unmarshal(bs, &old); err != nil {
+		t.Fatal(err)
+	}
+	if user := MigrateUserV1ToV2(&old); user.ID != 7 || user.Age != 30 {
+		t.Fatalf("invalid migration, received %+v", user)
+	}
+}
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testModule(t, dir)
+}
@@ -0,0 +1,564 @@
+package gen
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Reads the wire format in the python modules, ahead of the classes of the structs
+const pythonRuntime = `import datetime
+import gzip
+import json
+import struct
+from dataclasses import dataclass, field
+from typing import Any
+
+_EPOCH = datetime.datetime(1970, 1, 1, tzinfo=datetime.timezone.utc)
+
+
+class EnkodoError(Exception):
+    """An error decoded with its code from the enkodo error registry"""
+
+    def __init__(self, code, message):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+
+
+def _signed(v, bits):
+    return v - (1 << bits) if v >> (bits - 1) else v
+
+
+def _duration(ns):
+    return datetime.timedelta(microseconds=ns // 1000)
+
+
+class EnkodoReader:
+    """Reads the values of the enkodo wire format from data"""
+
+    def __init__(self, data):
+        self.data = bytes(data)
+        self.offset = 0
+        # Reads stop at the end of the current frame
+        self.end = len(self.data)
+
+    def read(self, n):
+        if self.offset + n > self.end:
+            raise EOFError(f"reading {n} bytes at offset {self.offset} of {self.end}")
+        v = self.data[self.offset:self.offset + n]
+        self.offset += n
+        return v
+
+    def frame(self):
+        # Reads stop at the end of a struct's fields, returning the end of the outer frame
+        n = self.length()
+        if self.offset + n > self.end:
+            raise EOFError(f"frame of {n} bytes at offset {self.offset} of {self.end}")
+        outer, self.end = self.end, self.offset + n
+        return outer
+
+    def unframe(self, outer):
+        # Skips the fields of newer versions left in the frame
+        self.offset, self.end = self.end, outer
+
+    def uint8(self):
+        return self.read(1)[0]
+
+    def int8(self):
+        return _signed(self.uint8(), 8)
+
+    def uint(self, bits=64):
+        # Varints hold 7 bits in each byte, with the high bit set when another follows,
+        # but the ninth byte holds 8
+        v = 0
+        for i in range(9):
+            b = self.uint8()
+            if b < 0x80 or i == 8:
+                return (v + (b << (7 * i))) & ((1 << bits) - 1)
+            v += (b & 0x7F) << (7 * i)
+
+    def int(self, bits=64):
+        return _signed(self.uint(bits), bits)
+
+    def zigzag(self, bits=64):
+        u = self.uint()
+        return _signed(((u >> 1) ^ -(u & 1)) & ((1 << bits) - 1), bits)
+
+    def float32(self):
+        return struct.unpack("<f", struct.pack("<I", self.uint(32)))[0]
+
+    def float64(self):
+        return struct.unpack("<d", struct.pack("<Q", self.uint(64)))[0]
+
+    def bool(self):
+        return self.uint8() == 1
+
+    def length(self):
+        n = self.int()
+        if n < 0:
+            raise ValueError(f"invalid length {n} at offset {self.offset}")
+        return n
+
+    def bytes(self):
+        return self.read(self.length())
+
+    def nil_bytes(self):
+        n = self.length()
+        return None if n == 0 else self.read(n - 1)
+
+    def string(self):
+        # Go strings may hold any bytes, which round trip through surrogates
+        return self.bytes().decode("utf-8", "surrogateescape")
+
+    def error(self):
+        code, message = self.int(), self.string()
+        if code == 0 and message == "":
+            return None
+        return EnkodoError(code, message)
+
+    def time(self):
+        # The zero time.Time is written as the minimum int64, python only keeps microseconds
+        ns = self.int()
+        return None if ns == -1 << 63 else _EPOCH + _duration(ns)
+
+    def gzip(self):
+        data = self.bytes()
+        return gzip.decompress(data) if data else b""
+
+    def json(self):
+        return json.loads(self.bytes())
+
+    def slice(self, fn):
+        return [fn() for _ in range(self.length())]
+
+    def nil_slice(self, fn):
+        n = self.length()
+        return None if n == 0 else [fn() for _ in range(n - 1)]
+
+    def array(self, n, fn):
+        return [fn() for _ in range(n)]
+
+    def map(self, key, value):
+        return {key(): value() for _ in range(self.length())}
+
+    def pointer(self, fn):
+        return fn() if self.bool() else None
+
+    def registered(self, types):
+        id = self.uint()
+        if id == 0:
+            return None
+        if id not in types:
+            raise ValueError(f"unregistered type ID {id} at offset {self.offset}")
+        return types[id](self)
+
+    def runs(self, fn):
+        is_runs, n = self.bool(), self.length()
+        if not is_runs:
+            return [fn() for _ in range(n)]
+        v = []
+        for _ in range(n):
+            count = self.length()
+            v.extend([fn()] * count)
+        return v
+
+    def unsupported(self, typ):
+        raise NotImplementedError(f"{typ} is not declared in this module")
+`
+
+// Readers of the values written by each Encoder method, formatted with the number of
+// bits of the go type
+var pythonMethods = map[string]string{
+	"Uint":    "r.uint()",
+	"Uint8":   "r.uint8()",
+	"Uint16":  "r.uint(16)",
+	"Uint32":  "r.uint(32)",
+	"Uint64":  "r.uint()",
+	"Int":     "r.int()",
+	"Int8":    "r.int8()",
+	"Int16":   "r.int(16)",
+	"Int32":   "r.int(32)",
+	"Int64":   "r.int()",
+	"ZigZag":  "r.zigzag(%d)",
+	"Float32": "r.float32()",
+	"Float64": "r.float64()",
+	"String":  "r.string()",
+	"Bytes":   "r.bytes()",
+	"Bool":    "r.bool()",
+	"Error":   "r.error()",
+}
+
+// writePython writes a python module decoding the structs of pkg, as described by schema
+func writePython(w io.Writer, schema *Schema, pkg PackageSchema) error {
+	fmt.Fprintf(w, "# This file is auto-generated by enkodo\n\"\"\"Decoders of the enkodo structs of package %s\"\"\"\n\n", pkg.Name)
+	fmt.Fprintf(w, "from __future__ import annotations\n\n%s", pythonRuntime)
+	p := pythonWriter{schema: schema, pkg: pkg, keys: mapKeyStructs(pkg)}
+	for _, s := range pkg.Structs {
+		// Written with tabs, which python code indents as spaces
+		buf := &strings.Builder{}
+		if err := p.writeStruct(buf, s); err != nil {
+			return fmt.Errorf("%s.%s: %w", pkg.Name, s.Name, err)
+		}
+		if _, err := io.WriteString(w, strings.ReplaceAll(buf.String(), "\t", "    ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type pythonWriter struct {
+	schema *Schema
+	pkg    PackageSchema
+	// Structs of pkg used as map keys, see mapKeyStructs
+	keys map[string]bool
+}
+
+// mapKeyStructs returns the structs of pkg that are the keys of maps, or fields of those,
+// whose dataclasses must be hashable to be the keys of dicts
+func mapKeyStructs(pkg PackageSchema) map[string]bool {
+	structs := make(map[string]StructSchema, len(pkg.Structs))
+	for _, s := range pkg.Structs {
+		structs[s.Name] = s
+	}
+	keys := make(map[string]bool)
+	var key func(t TypeSchema)
+	key = func(t TypeSchema) {
+		if t.Kind != "struct" || keys[t.Type] {
+			return
+		}
+		s, ok := structs[t.Type]
+		if !ok {
+			return
+		}
+		keys[t.Type] = true
+		for _, f := range s.Fields {
+			key(f.Wire)
+		}
+	}
+	var walk func(t TypeSchema)
+	walk = func(t TypeSchema) {
+		if t.Key != nil {
+			key(*t.Key)
+			walk(*t.Key)
+		}
+		if t.Elem != nil {
+			walk(*t.Elem)
+		}
+	}
+	for _, s := range pkg.Structs {
+		for _, f := range s.Fields {
+			walk(f.Wire)
+		}
+	}
+	return keys
+}
+
+// writeStruct writes the dataclass of s, with the classmethods decoding it. Those of map
+// keys are hashed by their fields, as decoding sets them after making the dataclass
+// rather than freezing it
+func (p pythonWriter) writeStruct(w io.Writer, s StructSchema) error {
+	decorator := "@dataclass"
+	if p.keys[s.Name] {
+		decorator = "@dataclass(eq=True, unsafe_hash=True)"
+	}
+	fmt.Fprintf(w, "\n\n%s\nclass %s:\n\t\"\"\"%s.%s, decoded from the enkodo wire format\"\"\"\n\n", decorator, s.Name, p.pkg.Name, s.Name)
+	for _, f := range s.Fields {
+		// The bool saying if the field is written is decoded too
+		if f.PresentIf != "" && !slices.ContainsFunc(s.Fields, func(g FieldSchema) bool { return g.Name == f.PresentIf }) {
+			fmt.Fprintf(w, "\t%s: bool = False\n", f.PresentIf)
+		}
+		zero := p.zero(f.Wire)
+		if f.Wire.Kind == "gzip" && f.GoType == "string" {
+			zero = `""`
+		}
+		switch {
+		case zero == "[]":
+			zero = "field(default_factory=list)"
+		case zero == "{}":
+			zero = "field(default_factory=dict)"
+		case strings.ContainsAny(zero, "[("):
+			zero = "field(default_factory=lambda: " + zero + ")"
+		}
+		fmt.Fprintf(w, "\t%s: %s = %s\n", f.Name, p.typ(f.Wire), zero)
+	}
+
+	fmt.Fprintf(w, "\n\t@classmethod\n\tdef unmarshal(cls, data) -> %s:\n\t\treturn cls.decode(EnkodoReader(data))\n", s.Name)
+	fmt.Fprintf(w, "\n\t@classmethod\n\tdef decode(cls, r: EnkodoReader) -> %s:\n\t\tv = cls()\n", s.Name)
+	if s.framed(p.schema) {
+		fmt.Fprintf(w, "\t\touter = r.frame()\n")
+	}
+	if s.FieldVersion > 0 {
+		fmt.Fprintf(w, "\t\tversion = r.uint8()\n\t\tif version > %d:\n", s.FieldVersion)
+		fmt.Fprintf(w, "\t\t\traise ValueError(f\"%s was written by version {version}, newer than version %d it is decoded with\")\n", s.Name, s.FieldVersion)
+	}
+	if cond, ok := anyVersionRange("version", " and ", " or ", s.presenceVersions()); ok && cond == "" {
+		fmt.Fprintf(w, "\t\tpresent = r.uint()\n")
+	} else if ok {
+		fmt.Fprintf(w, "\t\tpresent = r.uint() if %s else 0\n", cond)
+	}
+	for _, f := range s.Fields {
+		read, err := p.read(f.Wire)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if read == "" {
+			fmt.Fprintf(w, "\t\t# %s is left out, enkodo does not know how to write %s\n", f.Name, f.Wire.Type)
+			continue
+		}
+		if f.Wire.Kind == "gzip" && f.GoType == "string" {
+			read += `.decode("utf-8", "surrogateescape")`
+		}
+		dent := "\t\t"
+		if cond := versionRange("version", " and ", f.Since, f.Until); cond != "" {
+			fmt.Fprintf(w, "%sif %s:\n", dent, cond)
+			dent += "\t"
+		}
+		if f.Bit != nil {
+			fmt.Fprintf(w, "%sif present & (1 << %d):\n", dent, *f.Bit)
+			dent += "\t"
+		} else if f.PresentIf != "" {
+			if !f.PresentIfWritten {
+				fmt.Fprintf(w, "%sv.%s = r.bool()\n", dent, f.PresentIf)
+			}
+			fmt.Fprintf(w, "%sif v.%s:\n", dent, f.PresentIf)
+			dent += "\t"
+		}
+		fmt.Fprintf(w, "%sv.%s = %s\n", dent, f.Name, read)
+	}
+	if s.framed(p.schema) {
+		fmt.Fprintf(w, "\t\tr.unframe(outer)\n")
+	}
+	fmt.Fprintf(w, "\t\treturn v\n")
+	return nil
+}
+
+// read returns the python expression reading a value written as t from the
+// EnkodoReader r, or "" when nothing is written
+func (p pythonWriter) read(t TypeSchema) (string, error) {
+	elem := func() (string, error) {
+		if t.Elem == nil {
+			return "", fmt.Errorf("%s %s has no element type", t.Kind, t.Type)
+		}
+		e, err := p.read(*t.Elem)
+		return "lambda: " + e, err
+	}
+	switch t.Kind {
+	case "basic":
+		switch {
+		case t.Method == "":
+			return "", nil
+		case t.Type == "time.Time":
+			return "r.time()", nil
+		case t.Type == "[]byte" && p.schema.NilSlice:
+			return "r.nil_bytes()", nil
+		case addrMethods[t.Method]:
+			return "r.bytes()", nil
+		case t.Method == "UUID":
+			return "r.read(16)", nil
+		case t.Type == "float32":
+			// Floats tagged bits are written as the same bits with a Uint method
+			return "r.float32()", nil
+		case t.Type == "float64":
+			return "r.float64()", nil
+		}
+		read, ok := pythonMethods[t.Method]
+		if !ok {
+			return "", fmt.Errorf("unknown encoder method %s of %s", t.Method, t.Type)
+		}
+		if strings.Contains(read, "%d") {
+			read = fmt.Sprintf(read, intBits(t.Type))
+		}
+		if t.Type == "time.Duration" {
+			read = "_duration(" + read + ")"
+		}
+		return read, nil
+	case "struct":
+		if strings.Contains(t.Type, ".") {
+			return fmt.Sprintf("r.unsupported(%q)", t.Type), nil
+		}
+		return t.Type + ".decode(r)", nil
+	case "interface":
+		types := make([]string, 0, len(t.Registry))
+		for _, entry := range t.Registry {
+			types = append(types, fmt.Sprintf("%d: %s.decode", entry.ID, strings.TrimPrefix(entry.Type, "*")))
+		}
+		return "r.registered({" + strings.Join(types, ", ") + "})", nil
+	case "json":
+		return "r.json()", nil
+	case "converter":
+		return "", fmt.Errorf("%s is written by %s, which only go can decode", t.Type, t.Method)
+	case "gzip":
+		return "r.gzip()", nil
+	case "pointer":
+		e, err := elem()
+		return "r.pointer(" + e + ")", err
+	case "slice":
+		e, err := elem()
+		if p.schema.NilSlice {
+			return "r.nil_slice(" + e + ")", err
+		}
+		return "r.slice(" + e + ")", err
+	case "runs":
+		e, err := elem()
+		return "r.runs(" + e + ")", err
+	case "array":
+		if t.Elem != nil && (t.Elem.Type == "byte" || t.Elem.Type == "uint8") {
+			return fmt.Sprintf("r.read(%d)", t.Len), nil
+		}
+		e, err := elem()
+		return fmt.Sprintf("r.array(%d, %s)", t.Len, e), err
+	case "map":
+		if t.Key == nil {
+			return "", fmt.Errorf("map %s has no key type", t.Type)
+		}
+		k, err := p.read(*t.Key)
+		if err != nil {
+			return "", err
+		}
+		e, err := elem()
+		return "r.map(lambda: " + k + ", " + e + ")", err
+	}
+	return "", fmt.Errorf("unknown kind %q of %s", t.Kind, t.Type)
+}
+
+// typ returns the python type of the values read for t
+func (p pythonWriter) typ(t TypeSchema) string {
+	elem := func() string {
+		if t.Elem == nil {
+			return "Any"
+		}
+		return p.typ(*t.Elem)
+	}
+	switch t.Kind {
+	case "basic":
+		switch {
+		case t.Method == "":
+			return "Any"
+		case t.Type == "time.Time":
+			return "datetime.datetime | None"
+		case t.Type == "time.Duration":
+			return "datetime.timedelta"
+		case t.Type == "[]byte":
+			if p.schema.NilSlice {
+				return "bytes | None"
+			}
+			return "bytes"
+		case addrMethods[t.Method], t.Method == "UUID":
+			return "bytes"
+		case t.Method == "Error":
+			return "EnkodoError | None"
+		case t.Type == "float32", t.Type == "float64":
+			return "float"
+		case t.Method == "String":
+			return "str"
+		case t.Method == "Bool":
+			return "bool"
+		}
+		return "int"
+	case "struct":
+		if strings.Contains(t.Type, ".") {
+			return "Any"
+		}
+		return t.Type
+	case "interface":
+		types := make([]string, 0, len(t.Registry)+1)
+		for _, entry := range t.Registry {
+			types = append(types, strings.TrimPrefix(entry.Type, "*"))
+		}
+		return strings.Join(append(types, "None"), " | ")
+	case "json":
+		return "Any"
+	case "gzip":
+		return "bytes"
+	case "pointer":
+		return elem() + " | None"
+	case "slice", "runs":
+		if p.schema.NilSlice && t.Kind == "slice" {
+			return "list[" + elem() + "] | None"
+		}
+		return "list[" + elem() + "]"
+	case "array":
+		if t.Elem != nil && (t.Elem.Type == "byte" || t.Elem.Type == "uint8") {
+			return "bytes"
+		}
+		return "list[" + elem() + "]"
+	case "map":
+		if t.Key == nil {
+			return "dict"
+		}
+		return "dict[" + p.typ(*t.Key) + ", " + elem() + "]"
+	}
+	return "Any"
+}
+
+// zero returns the python value of a field written as t that is not decoded, the zero
+// value of its go type
+func (p pythonWriter) zero(t TypeSchema) string {
+	switch t.Kind {
+	case "basic":
+		switch {
+		case t.Method == "", t.Type == "time.Time", t.Method == "Error":
+			return "None"
+		case t.Type == "time.Duration":
+			return "datetime.timedelta(0)"
+		case t.Type == "[]byte":
+			if p.schema.NilSlice {
+				return "None"
+			}
+			return `b""`
+		case addrMethods[t.Method]:
+			return `b""`
+		case t.Method == "UUID":
+			return "bytes(16)"
+		case t.Type == "float32", t.Type == "float64":
+			return "0.0"
+		case t.Method == "String":
+			return `""`
+		case t.Method == "Bool":
+			return "False"
+		}
+		return "0"
+	case "struct":
+		if strings.Contains(t.Type, ".") {
+			return "None"
+		}
+		return t.Type + "()"
+	case "gzip":
+		return `b""`
+	case "slice":
+		if p.schema.NilSlice {
+			return "None"
+		}
+		return "[]"
+	case "runs":
+		return "[]"
+	case "array":
+		if t.Elem == nil {
+			return "[]"
+		}
+		if t.Elem.Type == "byte" || t.Elem.Type == "uint8" {
+			return fmt.Sprintf("bytes(%d)", t.Len)
+		}
+		return fmt.Sprintf("[%s for _ in range(%d)]", p.zero(*t.Elem), t.Len)
+	case "map":
+		return "{}"
+	}
+	// Pointers, interfaces and JSON
+	return "None"
+}
+
+// intBits returns the number of bits of the go integer type typ, 64 for int and the
+// types with converters
+func intBits(typ string) int {
+	switch typ {
+	case "int8":
+		return 8
+	case "int16":
+		return 16
+	case "int32":
+		return 32
+	}
+	return 64
+}
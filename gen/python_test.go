@@ -0,0 +1,156 @@
+package gen
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Structs of each kind decoded by the modules of the other languages
+const decoderSrc = `package fixture
+
+import (
+	"net/netip"
+	"time"
+)
+
+//enkodo:register *Square=1
+type Shape interface {
+	Area() float64
+}
+
+type Square struct {
+	Side float32 ` + "`enkodo:\"\"`" + `
+}
+
+func (s *Square) Area() float64 { return float64(s.Side * s.Side) }
+
+type Point struct {
+	X int16 ` + "`enkodo:\"\"`" + `
+	Y int16 ` + "`enkodo:\"\"`" + `
+}
+
+type User struct {
+	Name    string            ` + "`enkodo:\"\"`" + `
+	Age     int               ` + "`enkodo:\"uint8\"`" + `
+	Delta   int16             ` + "`enkodo:\"\"`" + `
+	Offset  int32             ` + "`enkodo:\",zigzag\"`" + `
+	Level   uint16            ` + "`enkodo:\",omitempty\"`" + `
+	Score   float64           ` + "`enkodo:\"\"`" + `
+	Admin   bool              ` + "`enkodo:\"\"`" + `
+	Avatar  []byte            ` + "`enkodo:\"\"`" + `
+	Key     [4]byte           ` + "`enkodo:\"\"`" + `
+	Scores  []int64           ` + "`enkodo:\"\"`" + `
+	Flags   []uint8           ` + "`enkodo:\",rle\"`" + `
+	Labels  map[string]uint32 ` + "`enkodo:\"\"`" + `
+	Corners map[Point]uint8   ` + "`enkodo:\"\"`" + `
+	Home    *Square           ` + "`enkodo:\"\"`" + `
+	Office  *Square           ` + "`enkodo:\"\"`" + `
+	Shape   Shape             ` + "`enkodo:\"\"`" + `
+	Seen    time.Time         ` + "`enkodo:\"\"`" + `
+	Timeout time.Duration     ` + "`enkodo:\"\"`" + `
+	Server  netip.Addr        ` + "`enkodo:\"\"`" + `
+	HasNote bool
+	Note    string ` + "`enkodo:\",presentif=HasNote\"`" + `
+	HasBio  bool   ` + "`enkodo:\"\"`" + `
+	Bio     string ` + "`enkodo:\",presentif=HasBio\"`" + `
+	Email   string ` + "`enkodo:\",since=2\"`" + `
+	Legacy  uint8  ` + "`enkodo:\",until=2\"`" + `
+}
+`
+
+func TestPython(t *testing.T) {
+	defer func() { opts = Options{} }()
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not installed")
+	}
+
+	for name, o := range map[string]Options{
+		"default":                 {},
+		"nilslice_formatversion2": {NilSlice: true, FormatVersion: 2},
+		"framed":                  {Framed: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts = o
+			testPython(t, python)
+		})
+	}
+}
+
+// testPython checks the python module generated with the current options decodes what
+// the go code generated with them encodes
+func testPython(t *testing.T, python string) {
+	dir, out := encodeDecoderFixture(t)
+
+	opts.Lang = "python"
+	if err := GeneratePath(dir); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(python, "-c", `import datetime, sys
+from fixture_enkodo import Point, Square, User
+
+u = User.unmarshal(open(sys.argv[1], "rb").read())
+expected = User(
+	Name="alice", Age=30, Delta=-300, Offset=-2, Level=0, Score=1.5, Admin=True,
+	Avatar=b"\x01\x02", Key=b"\x01\x02\x03\x04", Scores=[1 << 40, -1],
+	Flags=[7, 7, 7, 7, 1], Labels={"a": 1 << 20}, Corners={Point(X=1, Y=-2): 3}, Home=Square(Side=2.0), Office=None,
+	Shape=Square(Side=3.0), Seen=datetime.datetime(2023, 11, 14, 22, 13, 20, 5, tzinfo=datetime.timezone.utc),
+	Timeout=datetime.timedelta(seconds=2), Server=b"\x0a\x00\x00\x01", HasNote=True, Note="hi", HasBio=True, Bio="b", Email="a@b.c", Legacy=0,
+)
+if u != expected:
+	sys.exit(f"invalid value, expected {expected} and received {u}")
+`, out)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		src, _ := os.ReadFile(filepath.Join(dir, "fixture_enkodo.py"))
+		t.Fatalf("python decoder failed: %v\n%s\n%s", err, output, src)
+	}
+
+	opts.Output = filepath.Join(dir, "fixture_enkodo.go")
+	if err := GeneratePath(dir); err == nil || !strings.Contains(err.Error(), "must be a directory") {
+		t.Fatalf("expected an error writing python to a go file, received %v", err)
+	}
+}
+
+// encodeDecoderFixture writes a module of decoderSrc, generates its go code with the
+// current options and returns its directory, with the file of a User it encoded
+func encodeDecoderFixture(t *testing.T) (dir, out string) {
+	dir = writeModule(t, map[string]string{"fixture.go": decoderSrc, "fixture_test.go": `package fixture
+
+import (
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestWrite(t *testing.T) {
+	in := User{
+		Name: "alice", Age: 30, Delta: -300, Offset: -2, Score: 1.5, Admin: true,
+		Avatar: []byte{1, 2}, Key: [4]byte{1, 2, 3, 4}, Scores: []int64{1 << 40, -1},
+		Flags: []uint8{7, 7, 7, 7, 1}, Labels: map[string]uint32{"a": 1 << 20}, Corners: map[Point]uint8{{X: 1, Y: -2}: 3},
+		Home: &Square{Side: 2}, Shape: &Square{Side: 3},
+		Seen: time.Unix(1700000000, 5000), Timeout: 2 * time.Second, Server: netip.MustParseAddr("10.0.0.1"),
+		HasNote: true, Note: "hi", HasBio: true, Bio: "b", Email: "a@b.c", Legacy: 9,
+	}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(os.Getenv("ENKODO_OUT"), bs, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+`})
+	if err := GeneratePath(dir); err != nil {
+		t.Fatal(err)
+	}
+	out = filepath.Join(t.TempDir(), "user.bin")
+	testModule(t, dir, "ENKODO_OUT="+out)
+	return dir, out
+}
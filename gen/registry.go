@@ -0,0 +1,169 @@
+package gen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const registerDirective = "//enkodo:register "
+
+// registered is a concrete type given an ID by an //enkodo:register directive
+type registered struct {
+	Type string
+	ID   uint64
+}
+
+// The concrete types registered for each interface of the package being generated, by
+// interface name, in ID order
+var registries = map[string][]registered{}
+
+// loadRegistries collects the //enkodo:register directives in the doc comments of the
+// interfaces declared in files, e.g. //enkodo:register *Circle=1 Square=2. Fields of a
+// registered interface are written as the ID of the type they hold followed by its
+// value, 0 being nil. Registrations that are invalid, reuse an ID, or are of types that
+// are not enkodo structs implementing the interface are ignored with a warning
+func loadRegistries(files []*ast.File) {
+	registries = make(map[string][]registered)
+	for _, fil := range files {
+		for _, decl := range fil.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if _, ok := ts.Type.(*ast.InterfaceType); !ok {
+					continue
+				}
+				doc := ts.Doc
+				if doc == nil && len(gd.Specs) == 1 {
+					doc = gd.Doc
+				}
+				if doc == nil {
+					continue
+				}
+				for _, c := range doc.List {
+					if entries, ok := strings.CutPrefix(c.Text, registerDirective); ok {
+						register(ts.Name.Name, strings.Fields(entries))
+					}
+				}
+			}
+		}
+	}
+	for _, entries := range registries {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+	}
+}
+
+// register adds the Type=ID entries of an //enkodo:register directive to the registry of
+// the interface
+func register(iface string, entries []string) {
+	taken := make(map[uint64]bool)
+	for _, r := range registries[iface] {
+		taken[r.ID] = true
+	}
+	for _, entry := range entries {
+		typ, idText, ok := strings.Cut(entry, "=")
+		id, err := strconv.ParseUint(idText, 10, 64)
+		if !ok || err != nil || id == 0 {
+			log.Printf("warning: ignoring %q registered on %s, expected Type=ID with an ID above 0", entry, iface)
+			continue
+		}
+		if taken[id] {
+			log.Printf("warning: ignoring %q registered on %s, %d is already registered", entry, iface, id)
+			continue
+		}
+		if err = checkRegistered(iface, typ); err != nil {
+			log.Printf("warning: ignoring %q registered on %s: %v", entry, iface, err)
+			continue
+		}
+		taken[id] = true
+		registries[iface] = append(registries[iface], registered{Type: typ, ID: id})
+	}
+}
+
+// checkRegistered returns an error unless typ is an enkodo struct, or a pointer to one,
+// that implements the interface
+func checkRegistered(iface, typ string) error {
+	base := strings.TrimPrefix(typ, "*")
+	if !isEnkodoStruct(base) {
+		return fmt.Errorf("%s is not a struct with enkodo methods", base)
+	}
+	obj, ok := lookupType(iface)
+	if !ok {
+		return fmt.Errorf("no type information for %s", iface)
+	}
+	it, ok := obj.Type().Underlying().(*types.Interface)
+	if !ok {
+		return fmt.Errorf("%s is not an interface", iface)
+	}
+	concrete, _ := lookupType(base)
+	t := concrete.Type()
+	if base != typ {
+		t = types.NewPointer(t)
+	}
+	if !types.Implements(t, it) {
+		return fmt.Errorf("%s does not implement %s", typ, iface)
+	}
+	return nil
+}
+
+// unregisteredInterface reports whether typ is an interface of the package being
+// generated without an //enkodo:register directive, so can not be encoded
+func unregisteredInterface(typ string) bool {
+	if _, ok := registries[typ]; ok || strings.Contains(typ, ".") {
+		return false
+	}
+	obj, ok := lookupType(typ)
+	if !ok {
+		return false
+	}
+	_, ok = obj.Type().Underlying().(*types.Interface)
+	return ok
+}
+
+// encodeRegistered writes the ID of the registered type held by the interface name
+// followed by its value. Nil interfaces and nil pointers are written as 0
+func encodeRegistered(dent, name, label string, entries []registered, f io.Writer) {
+	fmt.Fprintf(f, "%sswitch _v := %s.(type) {\n", dent, name)
+	fmt.Fprintf(f, "%scase nil:\n%senc.Uint64(0)\n", dent, dent+ident)
+	for _, r := range entries {
+		fmt.Fprintf(f, "%scase %s:\n", dent, r.Type)
+		inner, addr := dent+ident, "&_v"
+		if strings.HasPrefix(r.Type, "*") {
+			fmt.Fprintf(f, "%sif _v == nil {\n%senc.Uint64(0)\n%sbreak\n%s}\n", inner, inner+ident, inner+ident, inner)
+			addr = "_v"
+		}
+		fmt.Fprintf(f, "%senc.Uint64(%d)\n", inner, r.ID)
+		fmt.Fprintf(f, "%sif err = enc.Encode(%s); err != nil {\n%sreturn\n%s}\n", inner, addr, inner+ident, inner)
+	}
+	fmt.Fprintf(f, "%sdefault:\n%sreturn &enkodo.UnregisteredTypeError{Field: %q, Value: _v}\n%s}\n", dent, dent+ident, label, dent)
+}
+
+// decodeRegistered reads the ID of a registered type and decodes a new value of it into
+// the interface name
+func decodeRegistered(dent, name, label string, entries []registered, f io.Writer) {
+	fmt.Fprintf(f, "%sswitch _id, _err := dec.Uint64(); {\n", dent)
+	fmt.Fprintf(f, "%scase _err != nil:\n%sreturn _err\n", dent, dent+ident)
+	fmt.Fprintf(f, "%scase _id == 0:\n%s%s = nil\n", dent, dent+ident, name)
+	for _, r := range entries {
+		inner := dent + ident
+		fmt.Fprintf(f, "%scase _id == %d:\n", dent, r.ID)
+		if base, ok := strings.CutPrefix(r.Type, "*"); ok {
+			fmt.Fprintf(f, "%s_v := new(%s)\n", inner, base)
+			fmt.Fprintf(f, "%sif err = dec.Decode(_v); err != nil {\n%sreturn\n%s}\n", inner, inner+ident, inner)
+		} else {
+			fmt.Fprintf(f, "%svar _v %s\n", inner, r.Type)
+			fmt.Fprintf(f, "%sif err = dec.Decode(&_v); err != nil {\n%sreturn\n%s}\n", inner, inner+ident, inner)
+		}
+		fmt.Fprintf(f, "%s%s = _v\n", inner, name)
+	}
+	fmt.Fprintf(f, "%sdefault:\n%sreturn &enkodo.UnregisteredTypeError{Field: %q, ID: _id}\n%s}\n", dent, dent+ident, label, dent)
+}
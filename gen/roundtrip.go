@@ -0,0 +1,284 @@
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Values built by the round trip tests stop nesting at this depth, so recursive structs
+// end
+const roundTripDepth = 3
+
+// testName returns the file -tests and -fuzz write the tests for file to
+func testName(file string) string {
+	return file[:len(file)-len(filepath.Ext(file))] + "_enkodo_test.go"
+}
+
+// writeTestFile writes the round trip tests and fuzz targets for structs to filename
+func writeTestFile(filename, pkg string, structs []*Struct) error {
+	if !dryRun() {
+		logf("Saving enkodo tests to %s\n", filename)
+	}
+	buf := bytes.NewBuffer(nil)
+	writeTests(buf, pkg, structs)
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to format the tests for %s: %w", pkg, err)
+	}
+
+	out, err := createOutput(filename)
+	if err != nil {
+		return err
+	}
+	if _, err = out.Write(src); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// writeTests writes the tests of Options.Tests and the fuzz targets of Options.Fuzz for
+// each struct. Fields the round trip tests can not write random values for, such as
+// interfaces and coded errors, are left as their zero value, and so are structs of other
+// files unless they are generated together
+func writeTests(out io.Writer, pkg string, structs []*Struct) {
+	r := roundTripWriter{structs: make(map[string]bool)}
+	for _, s := range structs {
+		r.structs[s.Name] = true
+	}
+	body := bytes.NewBuffer(nil)
+	for _, s := range structs {
+		if opts.Tests {
+			r.writeStruct(body, s)
+		}
+		if opts.Fuzz {
+			writeFuzz(body, s)
+		}
+	}
+
+	fmt.Fprint(out, generatedHeader+"\n")
+	fmt.Fprintf(out, "package %s\n\n", pkg)
+	// Only the packages the tests refer to are imported, which depends on the fields and
+	// on the halves of the enkodo methods the structs have
+	imports := map[string]string{
+		"bytes": "bytes", "errors": "errors", "math/big": "big", "math/rand/v2": "rand", "net": "net", "net/netip": "netip", "reflect": "reflect", "testing": "testing", "time": "time",
+	}
+	// Packages qualifying the field types, e.g. uuid in new(uuid.UUID)
+	for _, s := range structs {
+		for _, field := range s.codecFields() {
+			for _, match := range qualifier.FindAllStringSubmatch(field.Type, -1) {
+				if path, ok := importPath(match[1]); ok && path != runtimePath() {
+					imports[path] = match[1]
+				}
+			}
+		}
+	}
+	paths := make([]string, 0, len(imports))
+	for path, name := range imports {
+		if usesPackage(body.String(), name) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	fmt.Fprint(out, "import (\n")
+	for _, path := range paths {
+		fmt.Fprintf(out, "\t%q\n", path)
+	}
+	if usesPackage(body.String(), "enkodo") {
+		fmt.Fprintf(out, "\n\t%s\n", importSpec(runtimePath()))
+	}
+	fmt.Fprint(out, ")\n\n")
+	out.Write(body.Bytes())
+}
+
+// usesPackage reports whether the code in src refers to the package imported as name
+func usesPackage(src, name string) bool {
+	return regexp.MustCompile(`\b` + name + `\.`).MatchString(src)
+}
+
+type roundTripWriter struct {
+	// Structs with a random value function in the file
+	structs map[string]bool
+}
+
+// writeStruct writes the function building a random s, and the test of its round trip
+func (r roundTripWriter) writeStruct(out io.Writer, s *Struct) {
+	body := bytes.NewBuffer(nil)
+	if s.Collection != "" {
+		r.randomize(body, 1, 1, "*v", s.Collection, "", nil)
+	}
+	for _, field := range s.Fields {
+		if field.Getter != "" || field.JSON || !writtenIn(field, s.FieldVersion) {
+			continue
+		}
+		r.randomize(body, 1, 1, "v."+field.Name, field.Type, field.OverrideType, field.Opts)
+	}
+	for _, field := range s.Fields {
+		if flag, ok := field.Opts["presentif"]; ok && field.Getter == "" {
+			// Values without their flag set are not written, so decode as zero
+			fmt.Fprintf(body, "%sif !v.%s {\n%sv.%s = *new(%s)\n%s}\n", ident, flag, ident+ident, field.Name, field.Type, ident)
+		}
+	}
+
+	fmt.Fprintf(out, "func enkodoRandom%s(r *rand.Rand, depth int) *%s {\n", s.Name, s.Name)
+	if strings.Contains(body.String(), "_n") {
+		fmt.Fprintf(out, "%s// Nested values are empty past the max depth\n", ident)
+		fmt.Fprintf(out, "%s_n := 4\n%sif depth >= %d {\n%s_n = 1\n%s}\n", ident, ident, roundTripDepth, ident+ident, ident)
+	}
+	fmt.Fprintf(out, "%sv := &%s{}\n%s%sreturn v\n}\n\n", ident, s.Name, body, ident)
+	if s.Only != "" {
+		return // Only one half of the round trip is generated
+	}
+
+	fmt.Fprintf(out, "func Test%s_EnkodoRoundTrip(t *testing.T) {\n", s.Name)
+	fmt.Fprintf(out, "%sr := rand.New(rand.NewPCG(1, 2))\n", ident)
+	fmt.Fprintf(out, "%sfor i := 0; i < 100; i++ {\n", ident)
+	fmt.Fprintf(out, "%sin := enkodoRandom%s(r, 0)\n", ident+ident, s.Name)
+	fmt.Fprintf(out, "%sbs, err := enkodo.Marshal(in)\n", ident+ident)
+	fmt.Fprintf(out, "%sif err != nil {\n%st.Fatal(err)\n%s}\n", ident+ident, ident+ident+ident, ident+ident)
+	fmt.Fprintf(out, "%svar out %s\n", ident+ident, s.Name)
+	fmt.Fprintf(out, "%sif err = enkodo.Unmarshal(bs, &out); err != nil {\n%st.Fatal(err)\n%s}\n", ident+ident, ident+ident+ident, ident+ident)
+	fmt.Fprintf(out, "%sif !reflect.DeepEqual(in, &out) {\n", ident+ident)
+	fmt.Fprintf(out, "%st.Fatalf(\"%s changed in a round trip, encoded %%+v and decoded %%+v\", in, &out)\n", ident+ident+ident, s.Name)
+	fmt.Fprintf(out, "%s}\n%s}\n}\n\n", ident+ident, ident)
+}
+
+// randomBytes writes the code making _bs random bytes of length n, then the statement
+// assign using them
+func randomBytes(out io.Writer, dent, n, assign string) {
+	fmt.Fprintf(out, "%s{\n%s_bs := make([]byte, %s)\n", dent, dent+ident, n)
+	fmt.Fprintf(out, "%sfor _b := range _bs {\n%s_bs[_b] = byte(r.Uint32())\n%s}\n", dent+ident, dent+ident+ident, dent+ident)
+	fmt.Fprintf(out, "%s%s\n%s}\n", dent+ident, assign, dent)
+}
+
+// randomize writes the code setting target, of type typ written as override, to a
+// random value that round trips. Loops nested at level declare their own variables.
+// Options are those of the field's tag, which only apply to the field itself
+func (r roundTripWriter) randomize(out io.Writer, identCount, level int, target, typ, override string, tagOpts map[string]string) {
+	dent := strings.Repeat(ident, identCount)
+	if underlying, ok := namedTypes[typ]; ok && override == "" {
+		override = underlying
+	}
+	maxLen := 8
+	if max, err := strconv.Atoi(tagOpts["maxlen"]); err == nil {
+		maxLen = min(maxLen, max)
+	}
+	_, omit := tagOpts["omitempty"]
+	// Empty values of omitempty fields are not written, so decode as nil
+	omitEmpty := func() {
+		if omit {
+			fmt.Fprintf(out, "%sif len(%s) == 0 {\n%s%s = nil\n%s}\n", dent, target, dent+ident, target, dent)
+		}
+	}
+
+	// A basic override on a slice, array or map applies to its elements
+	var elemOverride string
+	if isElementOverride(Field{Type: typ, OverrideType: override}) {
+		elemOverride, override = override, ""
+	}
+	basic, convert := typ, func(expr string) string { return expr }
+	if override != "" && override != typ {
+		// Values are made in the range of the type they are written as
+		basic = override
+		convert = func(expr string) string { return typ + "(" + expr + ")" }
+	}
+	switch basic {
+	case "string":
+		randomBytes(out, dent, fmt.Sprintf("r.IntN(%d)", maxLen+1), target+" = "+typ+"(_bs)")
+		return
+	case "error":
+		if !opts.ErrorCodes {
+			// Nil errors can not be written, and empty messages decode as nil
+			randomBytes(out, dent, "1+r.IntN(8)", target+" = errors.New(string(_bs))")
+		}
+		return
+	case "bool":
+		fmt.Fprintf(out, "%s%s = %s\n", dent, target, convert("r.IntN(2) == 1"))
+		return
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "byte", "rune":
+		fmt.Fprintf(out, "%s%s = %s\n", dent, target, convert(basic+"(r.Uint64())"))
+		return
+	case "float32", "float64":
+		fmt.Fprintf(out, "%s%s = %s\n", dent, target, convert(basic+"(r.NormFloat64())"))
+		return
+	case "time.Duration":
+		fmt.Fprintf(out, "%s%s = %s(r.Int64())\n", dent, target, typ)
+		return
+	case "time.Time":
+		fmt.Fprintf(out, "%s%s = time.Unix(0, r.Int64())\n", dent, target)
+		return
+	case "net.IP":
+		randomBytes(out, dent, "4+12*r.IntN(2)", target+" = net.IP(_bs)")
+		return
+	case "netip.Addr":
+		randomBytes(out, dent, "4+12*r.IntN(2)", target+", _ = netip.AddrFromSlice(_bs)")
+		return
+	case "netip.AddrPort":
+		randomBytes(out, dent, "16", target+" = netip.AddrPortFrom(netip.AddrFrom16([16]byte(_bs)), uint16(r.Uint32()))")
+		return
+	case "*big.Int":
+		fmt.Fprintf(out, "%s%s = big.NewInt(r.Int64())\n", dent, target)
+		return
+	case "*big.Float":
+		fmt.Fprintf(out, "%s%s = big.NewFloat(r.NormFloat64())\n", dent, target)
+		return
+	case "uuid.UUID":
+		fmt.Fprintf(out, "%sfor _b := range %s {\n%s%s[_b] = byte(r.Uint32())\n%s}\n", dent, target, dent+ident, indexable(target), dent)
+		return
+	}
+	if override != "" && override != typ {
+		return // Written as another type, which random values may not convert back from
+	}
+
+	i := fmt.Sprintf("_i%d", level)
+	switch {
+	case r.structs[typ]:
+		fmt.Fprintf(out, "%s%s = *enkodoRandom%s(r, depth+1)\n", dent, target, typ)
+		if _, ok := collections[typ]; ok {
+			omitEmpty()
+		}
+	case strings.HasPrefix(typ, "*") && r.structs[typ[1:]]:
+		fmt.Fprintf(out, "%sif r.IntN(_n) != 0 {\n%s%s = enkodoRandom%s(r, depth+1)\n%s}\n", dent, dent+ident, target, typ[1:], dent)
+	case strings.HasPrefix(typ, "*"):
+		fmt.Fprintf(out, "%sif r.IntN(_n) != 0 {\n%s%s = new(%s)\n", dent, dent+ident, target, typ[1:])
+		r.randomize(out, identCount+1, level+1, "*"+target, typ[1:], "", nil)
+		fmt.Fprintf(out, "%s}\n", dent)
+	case strings.HasPrefix(typ, "[]"):
+		length := "r.IntN(_n)"
+		if _, ok := tagOpts["maxlen"]; ok {
+			length = "min(r.IntN(_n), " + tagOpts["maxlen"] + ")"
+		}
+		fmt.Fprintf(out, "%s%s = make(%s, %s)\n", dent, target, typ, length)
+		fmt.Fprintf(out, "%sfor %s := range %s {\n", dent, i, target)
+		r.randomize(out, identCount+1, level+1, indexable(target)+"["+i+"]", typ[2:], elemOverride, nil)
+		fmt.Fprintf(out, "%s}\n", dent)
+		omitEmpty()
+	case isFixedArray(typ):
+		_, elem := splitArrayType(typ)
+		fmt.Fprintf(out, "%sfor %s := range %s {\n", dent, i, target)
+		r.randomize(out, identCount+1, level+1, indexable(target)+"["+i+"]", elem, elemOverride, nil)
+		fmt.Fprintf(out, "%s}\n", dent)
+	case strings.HasPrefix(typ, "map["):
+		key, val := splitMapType(typ)
+		length := "r.IntN(_n)"
+		if _, ok := tagOpts["maxlen"]; ok {
+			length = "min(r.IntN(_n), " + tagOpts["maxlen"] + ")"
+		}
+		fmt.Fprintf(out, "%s%s = make(%s)\n", dent, target, typ)
+		fmt.Fprintf(out, "%sfor range %s {\n", dent, length)
+		k, v := fmt.Sprintf("_k%d", level), fmt.Sprintf("_v%d", level)
+		fmt.Fprintf(out, "%svar %s %s\n", dent+ident, k, key)
+		r.randomize(out, identCount+1, level+1, k, key, "", nil)
+		fmt.Fprintf(out, "%svar %s %s\n", dent+ident, v, val)
+		r.randomize(out, identCount+1, level+1, v, val, elemOverride, nil)
+		fmt.Fprintf(out, "%s%s[%s] = %s\n%s}\n", dent+ident, indexable(target), k, v, dent)
+		omitEmpty()
+	}
+}
@@ -0,0 +1,79 @@
+package gen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRoundTripTests(t *testing.T) {
+	defer func() { opts = Options{} }()
+	src := `package fixture
+
+import "time"
+
+type Level int8
+
+type Node struct {
+	Name     string            ` + "`enkodo:\",maxlen=4\"`" + `
+	Age      int               ` + "`enkodo:\"uint8\"`" + `
+	Level    Level             ` + "`enkodo:\"\"`" + `
+	Delta    int32             ` + "`enkodo:\",zigzag\"`" + `
+	Score    float64           ` + "`enkodo:\"\"`" + `
+	Data     []byte            ` + "`enkodo:\"\"`" + `
+	Key      [4]byte           ` + "`enkodo:\"\"`" + `
+	Counts   []int             ` + "`enkodo:\"uint16\"`" + `
+	Tags     []string          ` + "`enkodo:\",omitempty\"`" + `
+	Attrs    map[string][]int8 ` + "`enkodo:\"\"`" + `
+	Count    *int              ` + "`enkodo:\"\"`" + `
+	Children []*Node           ` + "`enkodo:\"\"`" + `
+	Leaf     Leaf              ` + "`enkodo:\"\"`" + `
+	Seen     time.Time         ` + "`enkodo:\"\"`" + `
+	Timeout  time.Duration     ` + "`enkodo:\"\"`" + `
+	Err      error             ` + "`enkodo:\"\"`" + `
+	HasNote  bool
+	Note     string ` + "`enkodo:\",presentif=HasNote\"`" + `
+}
+
+type Leaf struct {
+	Old   uint32 ` + "`enkodo:\",until=2\"`" + `
+	Email string ` + "`enkodo:\",since=2\"`" + `
+}
+`
+	for name, o := range map[string]Options{
+		"default":                 {Tests: true},
+		"nilslice_formatversion2": {Tests: true, NilSlice: true, FormatVersion: 2},
+		"combined":                {Tests: true, Combined: true, Generics: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts = o
+			dir := writeModule(t, map[string]string{"fixture.go": src})
+			if err := GeneratePath(dir); err != nil {
+				t.Fatal(err)
+			}
+			test := filepath.Join(dir, "fixture_enkodo_test.go")
+			if o.Combined {
+				test = filepath.Join(dir, "enkodo_gen_test.go")
+			}
+			data, err := os.ReadFile(test)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, expected := range []string{"func TestNode_EnkodoRoundTrip(t *testing.T)", "v.Leaf = *enkodoRandomLeaf(r, depth+1)", "v.Age = int(uint8(r.Uint64()))"} {
+				if !strings.Contains(string(data), expected) {
+					t.Fatalf("expected %q in the round trip tests, received:\n%s", expected, data)
+				}
+			}
+			if strings.Contains(string(data), "v.Old") {
+				t.Fatalf("expected fields no longer written to be left out, received:\n%s", data)
+			}
+			testModule(t, dir)
+		})
+	}
+
+	opts = Options{Tests: true, IncludeTests: true}
+	if err := GeneratePath(t.TempDir()); err == nil || !strings.Contains(err.Error(), "-include-tests") {
+		t.Fatalf("expected -tests to conflict with -include-tests, received %v", err)
+	}
+}
@@ -0,0 +1,348 @@
+package gen
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/constant"
+	"go/types"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Schema describes the wire layout of the enkodo structs of some packages, for decoders
+// in other languages and for reviewing format changes. It is written as JSON by
+// ExportSchema
+type Schema struct {
+	// Wire format version the structs are generated for, see FormatVersions
+	FormatVersion int `json:"formatVersion"`
+	// Set when slice lengths are written plus one, with 0 for nil, see Options.NilSlice
+	NilSlice bool `json:"nilSlice,omitempty"`
+	// Set when structs are prefixed with the length of their fields, see Options.Framed
+	Framed   bool            `json:"framed,omitempty"`
+	Packages []PackageSchema `json:"packages"`
+}
+
+// PackageSchema is a package of enkodo structs
+type PackageSchema struct {
+	Name string `json:"name"`
+	// Directory of the package, relative to the exported path
+	Dir     string         `json:"dir"`
+	Structs []StructSchema `json:"structs"`
+}
+
+// StructSchema is an enkodo struct, its fields are written in order. Structs with fields
+// tagged since or until start with a byte of the version they were written by, followed
+// by a varint bitmask of the omitempty fields that are set when they have some. In
+// framed schemas all of it is prefixed with its varint length
+type StructSchema struct {
+	Name string `json:"name"`
+	// Version declared by an //enkodo:version directive, 0 when unversioned
+	Version int `json:"version,omitempty"`
+	// Version written by the struct when its fields are versioned, 0 when they are not
+	FieldVersion int `json:"fieldVersion,omitempty"`
+	// Whether the struct is framed when an //enkodo:options directive says otherwise than
+	// the schema, nil when it does not
+	Framed *bool         `json:"framed,omitempty"`
+	Fields []FieldSchema `json:"fields"`
+}
+
+// framed reports whether the struct, of schema, is prefixed with the length of its fields
+func (s StructSchema) framed(schema *Schema) bool {
+	if s.Framed != nil {
+		return *s.Framed
+	}
+	return schema.Framed
+}
+
+// presenceVersions returns the version ranges of the omitempty fields of s, the bitmask
+// of those that are set is written by the versions in any of them
+func (s StructSchema) presenceVersions() [][2]int {
+	var ranges [][2]int
+	for _, f := range s.Fields {
+		if f.Bit != nil {
+			ranges = append(ranges, [2]int{f.Since, f.Until})
+		}
+	}
+	return ranges
+}
+
+// FieldSchema is a field of an enkodo struct
+type FieldSchema struct {
+	Name string `json:"name"`
+	// Type of the field in Go
+	GoType string `json:"goType"`
+	// The enkodo tag of the field, without the quotes
+	Tag string `json:"tag,omitempty"`
+	// Bit of the field in the bitmask of omitempty fields that are set, the field is only
+	// written when it is set
+	Bit *int `json:"bit,omitempty"`
+	// Bool field of the struct written before the field, which is only written when it is
+	// true, see the presentif option
+	PresentIf string `json:"presentIf,omitempty"`
+	// Whether PresentIf is one of the fields written before the field, whose value says if
+	// it is written, rather than a bool written before it
+	PresentIfWritten bool `json:"presentIfWritten,omitempty"`
+	// Versions of the struct the field is written in, from Since up to but not including
+	// Until, 0 when the field is not tagged with them
+	Since int `json:"since,omitempty"`
+	Until int `json:"until,omitempty"`
+	// How the field is written
+	Wire TypeSchema `json:"wire"`
+}
+
+// TypeSchema is how a value is written
+type TypeSchema struct {
+	// One of:
+	//   - basic: a value written by a single Encoder method, e.g. uint8, string or time.Time
+	//   - struct: an enkodo struct, written as its fields
+	//   - pointer: a bool saying if it is set, followed by Elem when it is
+	//   - slice: the length followed by each Elem
+	//   - array: Len of Elem, the length is not written
+	//   - map: the length followed by each Key and Elem pair
+	//   - interface: the ID in Registry of the type held, 0 being nil, followed by its value
+	//   - json: the JSON of the value, written as bytes by -json-fallback
+	//   - gzip: the gzip of the value, written as bytes
+	//   - converter: whatever the function in Method, tagged converter=, writes
+	//   - runs: a bool saying if it is written as runs and the number of values or runs,
+	//     followed by each Elem or by the length and Elem of each run
+	Kind string `json:"kind"`
+	// The type written, with named types resolved and tag overrides applied
+	Type string `json:"type"`
+	// Encoder method writing a basic value, e.g. Uint8 or ZigZag, or the function writing
+	// a converter, empty when enkodo does not know how to write the type and leaves it out
+	Method   string             `json:"method,omitempty"`
+	Len      int                `json:"len,omitempty"`
+	Key      *TypeSchema        `json:"key,omitempty"`
+	Elem     *TypeSchema        `json:"elem,omitempty"`
+	Registry []RegisteredSchema `json:"registry,omitempty"`
+}
+
+// RegisteredSchema is a type registered for an interface, see loadRegistries
+type RegisteredSchema struct {
+	Type string `json:"type"`
+	ID   uint64 `json:"id"`
+}
+
+// ExportSchema writes the Schema of the enkodo structs under root to w as JSON
+func ExportSchema(w io.Writer, root string) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	schema, err := loadSchema(root)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(schema, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// LoadSchema returns the Schema of the enkodo structs under root
+func LoadSchema(root string) (*Schema, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return loadSchema(root)
+}
+
+func loadSchema(root string) (*Schema, error) {
+	if err := checkOptions(); err != nil {
+		return nil, err
+	}
+	files := collectFiles(root)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no input files in %s", root)
+	}
+
+	base := rootDir(root)
+	schema := &Schema{FormatVersion: max(opts.FormatVersion, 1), NilSlice: opts.NilSlice, Framed: opts.Framed, Packages: []PackageSchema{}}
+	packages := make(map[string]int)
+	for _, file := range files {
+		resetState()
+		pkg, structs, err := parseFile(file, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(structs) == 0 || !selected(structs) {
+			continue
+		}
+
+		dir := filepath.Dir(file)
+		i, ok := packages[dir+" "+pkg]
+		if !ok {
+			rel, err := filepath.Rel(base, dir)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				rel = dir
+			}
+			i = len(schema.Packages)
+			packages[dir+" "+pkg] = i
+			schema.Packages = append(schema.Packages, PackageSchema{Name: pkg, Dir: filepath.ToSlash(rel)})
+		}
+		for _, s := range structs {
+			if s.Collection == "" {
+				schema.Packages[i].Structs = append(schema.Packages[i].Structs, structSchema(s))
+			}
+		}
+	}
+	return schema, nil
+}
+
+// structSchema returns the schema of s, which must be called while the file declaring it
+// is the one parsed, as the types of its fields are looked up in it
+func structSchema(s *Struct) StructSchema {
+	present := s.presenceBits()
+	schema := StructSchema{Name: s.Name, Version: s.Version, FieldVersion: s.FieldVersion, Fields: make([]FieldSchema, 0, len(s.Fields))}
+	if s.Framed != opts.Framed {
+		schema.Framed = &s.Framed
+	}
+	for _, field := range s.Fields {
+		fs := FieldSchema{Name: field.Name, GoType: field.Type, Tag: tagString(field), Wire: typeSchema(field)}
+		fs.Since, fs.Until = fieldVersions(field)
+		if bit, ok := present[field.Name]; ok {
+			fs.Bit = &bit
+		} else {
+			fs.PresentIf = field.Opts["presentif"]
+			fs.PresentIfWritten = fs.PresentIf != "" && s.flagWritten(field)
+		}
+		schema.Fields = append(schema.Fields, fs)
+	}
+	return schema
+}
+
+// typeSchema returns how field is written, following EncodeField
+func typeSchema(field Field) TypeSchema {
+	if encode, _, ok := converterFuncs(field); ok {
+		return TypeSchema{Kind: "converter", Type: field.Type, Method: encode}
+	}
+	if field.JSON {
+		return TypeSchema{Kind: "json", Type: "[]byte"}
+	}
+	if isGzip(field) {
+		return TypeSchema{Kind: "gzip", Type: "[]byte"}
+	}
+	if isRuns(field) {
+		elem := typeSchema(runsElem(field))
+		return TypeSchema{Kind: "runs", Type: "[]" + elem.Type, Elem: &elem}
+	}
+
+	field = resolveNamed(field)
+	if underlying, ok := collections[field.Type]; ok && field.OverrideType == "" {
+		// Named slices and maps are written as their underlying type
+		field.OverrideType = underlying
+	}
+	var elemOverride string
+	if isElementOverride(field) {
+		elemOverride, field.OverrideType = field.OverrideType, ""
+	}
+	if field.OverrideType != "" {
+		field.Type = field.OverrideType
+	}
+	typ := field.Type
+
+	if conv, ok := fieldConverter(field, typ); ok {
+		return TypeSchema{Kind: "basic", Type: typ, Method: conv.EnkodoFunction()}
+	}
+	if isEnkodoStruct(typ) {
+		return TypeSchema{Kind: "struct", Type: typ}
+	}
+	if entries, ok := registries[typ]; ok {
+		schema := TypeSchema{Kind: "interface", Type: typ}
+		for _, entry := range entries {
+			schema.Registry = append(schema.Registry, RegisteredSchema{Type: entry.Type, ID: entry.ID})
+		}
+		return schema
+	}
+
+	switch {
+	case strings.HasPrefix(typ, "*"):
+		elem := typeSchema(Field{Type: typ[1:]})
+		return TypeSchema{Kind: "pointer", Type: "*" + elem.Type, Elem: &elem}
+	case strings.HasPrefix(typ, "map["):
+		k, v := splitMapType(typ)
+		key, elem := typeSchema(Field{Type: k}), typeSchema(Field{Type: v, OverrideType: elemOverride})
+		return TypeSchema{Kind: "map", Type: "map[" + key.Type + "]" + elem.Type, Key: &key, Elem: &elem}
+	case isFixedArray(typ):
+		n, e := splitArrayType(typ)
+		elem := typeSchema(Field{Type: e, OverrideType: elemOverride})
+		return TypeSchema{Kind: "array", Type: "[" + n + "]" + elem.Type, Len: arrayLen(n), Elem: &elem}
+	case strings.HasPrefix(typ, "[]"):
+		elem := typeSchema(Field{Type: typ[2:], OverrideType: elemOverride})
+		return TypeSchema{Kind: "slice", Type: "[]" + elem.Type, Elem: &elem}
+	}
+	return TypeSchema{Kind: "basic", Type: typ}
+}
+
+// arrayLen returns the value of the length n of an array type, which may be a constant
+// of the package, or 0 if it is not known
+func arrayLen(n string) int {
+	if l, err := strconv.Atoi(n); err == nil {
+		return l
+	}
+	if pkgTypes != nil {
+		if c, ok := pkgTypes.Scope().Lookup(n).(*types.Const); ok {
+			if l, ok := constant.Int64Val(c.Val()); ok {
+				return int(l)
+			}
+		}
+	}
+	return 0
+}
+
+// A language other than go that -lang generates a module per package in, decoding its
+// structs from the Schema
+type decoderLanguage struct {
+	// Extension of the module files
+	ext   string
+	write func(w io.Writer, schema *Schema, pkg PackageSchema) error
+}
+
+var decoderLanguages = map[string]*decoderLanguage{
+	"python":     {".py", writePython},
+	"typescript": {".ts", writeTypeScript},
+	"javascript": {".js", writeJavaScript},
+}
+
+// generateDecoders writes the modules of lang decoding the packages under root, as
+// <package>_enkodo<ext> in the package directory or the output directory
+func generateDecoders(root string, lang *decoderLanguage) error {
+	schema, err := loadSchema(root)
+	if err != nil {
+		return err
+	}
+	for _, pkg := range schema.Packages {
+		if opts.Stdout {
+			if err = lang.write(os.Stdout, schema, pkg); err != nil {
+				return err
+			}
+			continue
+		}
+
+		dir := filepath.Join(rootDir(root), filepath.FromSlash(pkg.Dir))
+		if outputIsDir() {
+			if err = os.MkdirAll(opts.Output, 0o755); err != nil {
+				return err
+			}
+			dir = opts.Output
+		}
+		filename := filepath.Join(dir, pkg.Name+"_enkodo"+lang.ext)
+		if !dryRun() {
+			logf("Generating %s decoders of %d structs into %s\n", opts.Lang, len(pkg.Structs), filename)
+		}
+		f, err := createOutput(filename)
+		if err != nil {
+			return err
+		}
+		err = lang.write(f, schema, pkg)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
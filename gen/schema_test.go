@@ -0,0 +1,244 @@
+package gen
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const schemaSrc = `package fixture
+
+type Level uint8
+
+const Size = 4
+
+type Shape interface {
+	Area() float64
+}
+
+//enkodo:register *Square=1
+type Drawing interface {
+	Shape
+}
+
+type Square struct {
+	Side float64 ` + "`enkodo:\"\"`" + `
+}
+
+func (s *Square) Area() float64 { return s.Side * s.Side }
+
+//enkodo:version 2
+type User struct {
+	Name    string            ` + "`enkodo:\"\"`" + `
+	Age     int               ` + "`enkodo:\"uint8\"`" + `
+	Level   Level             ` + "`enkodo:\",omitempty\"`" + `
+	Scores  []int             ` + "`enkodo:\"int32\"`" + `
+	Labels  map[string]Level  ` + "`enkodo:\"\"`" + `
+	Key     [Size]byte        ` + "`enkodo:\"\"`" + `
+	Home    *Square           ` + "`enkodo:\"\"`" + `
+	Drawing Drawing           ` + "`enkodo:\"\"`" + `
+}
+`
+
+func TestSchema(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "fixture.go"), []byte(schemaSrc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := ExportSchema(buf, dir); err != nil {
+		t.Fatal(err)
+	}
+	var schema Schema
+	if err := json.Unmarshal(buf.Bytes(), &schema); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf)
+	}
+	if schema.FormatVersion != 1 || len(schema.Packages) != 1 || schema.Packages[0].Name != "fixture" || schema.Packages[0].Dir != "." {
+		t.Fatalf("invalid schema, received:\n%s", buf)
+	}
+	structs := schema.Packages[0].Structs
+	if len(structs) != 2 || structs[0].Name != "Square" || structs[1].Name != "User" || structs[1].Version != 2 {
+		t.Fatalf("invalid structs, received:\n%s", buf)
+	}
+
+	expected := []struct {
+		name, kind, typ string
+	}{
+		{"Name", "basic", "string"},
+		{"Age", "basic", "uint8"},
+		{"Level", "basic", "uint8"},
+		{"Scores", "slice", "[]int32"},
+		{"Labels", "map", "map[string]uint8"},
+		{"Key", "array", "[Size]byte"},
+		{"Home", "pointer", "*Square"},
+		{"Drawing", "interface", "Drawing"},
+	}
+	fields := structs[1].Fields
+	if len(fields) != len(expected) {
+		t.Fatalf("invalid number of fields, expected %d and received %d", len(expected), len(fields))
+	}
+	for i, e := range expected {
+		if fields[i].Name != e.name || fields[i].Wire.Kind != e.kind || fields[i].Wire.Type != e.typ {
+			t.Fatalf("invalid field %d, expected %s %s %s and received %s %s %s", i, e.name, e.kind, e.typ, fields[i].Name, fields[i].Wire.Kind, fields[i].Wire.Type)
+		}
+	}
+	if fields[2].Bit == nil || *fields[2].Bit != 0 || fields[0].Bit != nil {
+		t.Fatal("expected a presence bit for the omitempty field only")
+	}
+	if fields[1].Wire.Method != "Uint8" || fields[3].Wire.Elem.Method != "Int32" {
+		t.Fatalf("invalid encoder methods, received %s and %s", fields[1].Wire.Method, fields[3].Wire.Elem.Method)
+	}
+	if fields[5].Wire.Len != 4 {
+		t.Fatalf("invalid array length, expected 4 and received %d", fields[5].Wire.Len)
+	}
+	if fields[6].Wire.Elem.Kind != "struct" {
+		t.Fatalf("expected a pointer to a struct, received %+v", fields[6].Wire.Elem)
+	}
+	if r := fields[7].Wire.Registry; len(r) != 1 || r[0].Type != "*Square" || r[0].ID != 1 {
+		t.Fatalf("invalid registry, received %+v", r)
+	}
+}
+
+func TestImportSchema(t *testing.T) {
+	defer func() { opts = Options{} }()
+
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "fixture.go"), []byte(schemaSrc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	exported, err := LoadSchema(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "fixture.schema.json")
+	if err = os.WriteFile(file, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := writeModule(t, map[string]string{"fixture_test.go": `package fixture
+
+import (
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := User{Name: "alice", Age: 30, Level: 2, Scores: []int32{1, -1}, Labels: map[string]uint8{"a": 1}, Key: [4]byte{1, 2, 3, 4}, Home: &Square{Side: 2}, Drawing: &Square{Side: 3}}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out User
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != in.Name || out.Key != in.Key || out.Home.Side != 2 || out.Drawing.(*Square).Side != 3 {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`})
+	if err = ImportSchema(file, dir); err != nil {
+		t.Fatal(err)
+	}
+	testModule(t, dir)
+
+	// The imported structs are written the same as those exported
+	imported, err := LoadSchema(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range exported.Packages[0].Structs {
+		for j, field := range s.Fields {
+			got := imported.Packages[0].Structs[i].Fields[j]
+			if got.Name != field.Name || got.Wire.Kind != field.Wire.Kind || got.Wire.Len != field.Wire.Len || got.Bit == nil != (field.Bit == nil) {
+				t.Fatalf("invalid imported field %s.%s, expected %+v and received %+v", s.Name, field.Name, field, got)
+			}
+		}
+	}
+}
+
+func TestCheckCompat(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "fixture.go"), []byte(schemaSrc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	before, err := LoadSchema(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// change returns a copy of before changed by fn, with the User struct passed to it
+	change := func(fn func(s *StructSchema)) *Schema {
+		data, _ := json.Marshal(before)
+		var after Schema
+		json.Unmarshal(data, &after)
+		fn(&after.Packages[0].Structs[1])
+		return &after
+	}
+	int64s := TypeSchema{Kind: "slice", Type: "[]int64", Elem: &TypeSchema{Kind: "basic", Type: "int64"}}
+
+	for name, test := range map[string]struct {
+		change   func(s *StructSchema)
+		breaking string
+	}{
+		"unchanged": {func(s *StructSchema) {}, ""},
+		"renamed":   {func(s *StructSchema) { s.Fields[0].Name = "FullName" }, ""},
+		"registered": {func(s *StructSchema) {
+			s.Fields[7].Wire.Registry = append(s.Fields[7].Wire.Registry, RegisteredSchema{Type: "Square", ID: 2})
+		}, ""},
+		"reordered": {func(s *StructSchema) { s.Fields[0], s.Fields[1] = s.Fields[1], s.Fields[0] }, "fixture.User.Name moved from position 0 to 1"},
+		"retyped":   {func(s *StructSchema) { s.Fields[3].Wire = int64s }, "fixture.User.Scores is written as slice []int64 instead of slice []int32"},
+		"removed":   {func(s *StructSchema) { s.Fields = s.Fields[:7] }, "fixture.User.Drawing removed"},
+		"removed middle": {func(s *StructSchema) {
+			s.Fields = slices.Delete(s.Fields, 3, 4)
+		}, "fixture.User.Scores removed"},
+		"added": {func(s *StructSchema) {
+			s.Fields = append(s.Fields, FieldSchema{Name: "Email", GoType: "string", Wire: TypeSchema{Kind: "basic", Type: "string"}})
+		}, "fixture.User.Email added, values written without it can not be decoded"},
+		"rewritten":    {func(s *StructSchema) { s.Fields[1].Wire.Method = "Int8" }, "fixture.User.Age is written with Int8 instead of Uint8"},
+		"reregistered": {func(s *StructSchema) { s.Fields[7].Wire.Registry[0].Type = "Square" }, "fixture.User.Drawing registers ID 1 for Square instead of *Square"},
+		"since": {func(s *StructSchema) {
+			s.FieldVersion = 2
+			s.Fields = append(s.Fields, FieldSchema{Name: "Email", GoType: "string", Since: 2, Wire: TypeSchema{Kind: "basic", Type: "string"}})
+		}, "fixture.User is prefixed with its version only when fields are tagged since or until, which changed"},
+		"framed": {func(s *StructSchema) {
+			framed := true
+			s.Framed = &framed
+		}, "fixture.User framed changed from false to true"},
+		"versioned": {func(s *StructSchema) {
+			s.Version = 3
+			s.Fields = s.Fields[:1]
+		}, ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := CheckCompat(before, change(test.change))
+			if test.breaking == "" {
+				if err != nil {
+					t.Fatalf("expected a compatible change, received %v", err)
+				}
+				return
+			}
+			var incompatible *IncompatibleError
+			if !errors.As(err, &incompatible) || !slices.Contains(incompatible.Changes, test.breaking) {
+				t.Fatalf("expected %q, received %v", test.breaking, err)
+			}
+			// The fields after a removed one are not reported as moved
+			for _, change := range incompatible.Changes {
+				if strings.Contains(change, "moved") && !strings.Contains(test.breaking, "moved") {
+					t.Fatalf("expected only %q, received %v", test.breaking, err)
+				}
+			}
+		})
+	}
+}
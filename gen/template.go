@@ -0,0 +1,222 @@
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// methodsTemplate lays out the methods generated for a struct. It declares an empty
+// block for each feature, which the feature's template redefines when it is enabled,
+// so features compose without knowing about each other. Fields are written by
+// EncodeField and DecodeField into .Encode and .Decode
+const methodsTemplate = `{{define "methods"}}{{if not (.Skipped "encode")}}{{template "encode" .}}{{end}}` +
+	`{{if not (.Skipped "decode")}}{{template "decode" .}}{{end}}` +
+	`{{block "msgpack" .}}{{end}}{{block "countbytes" .}}{{end}}{{block "encodedsize" .}}{{end}}` +
+	`{{block "binary" .}}{{end}}{{block "accessors" .}}{{end}}{{end}}
+
+{{define "encode"}}// MarshalEnkodo encodes the fields of {{.Name}} with enc, implementing enkodo.Encodee
+func ({{.Ref}} *{{.Name}}) MarshalEnkodo(enc *enkodo.Encoder) (err error) {
+{{block "gate" .}}{{end}}{{block "encodeFrame" .}}{{.Encode}}{{end}}	return
+}
+
+{{end}}
+
+{{define "decode"}}// UnmarshalEnkodo decodes the fields of {{.Name}} with dec, implementing enkodo.Decodee
+func ({{.Ref}} *{{.Name}}) UnmarshalEnkodo(dec *enkodo.Decoder) (err error) {
+{{template "gate" .}}{{block "depth" .}}{{end}}{{block "budget" .}}{{end}}{{block "decodeFrame" .}}{{.Decode}}{{end}}	return
+}
+
+{{end}}
+
+{{define "decodeN"}}// UnmarshalEnkodoN decodes {{.Name}} with dec and returns the number of bytes it took
+func ({{.Ref}} *{{.Name}}) UnmarshalEnkodoN(dec *enkodo.Decoder) (n int, err error) {
+	start := dec.Offset()
+	err = dec.Decode({{.Ref}})
+	return dec.Offset() - start, err
+}
+
+{{end}}
+
+{{define "size"}}// EncodedSize returns the number of bytes {{.Name}} encodes to, 0 when it fails to encode
+func ({{.Ref}} *{{.Name}}) EncodedSize() int {
+	n, err := enkodo.Size({{.Ref}})
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+{{end}}
+
+{{define "binaryMethods"}}{{if ne .Only "decode"}}// MarshalBinary encodes {{.Name}} with enkodo, implementing encoding.BinaryMarshaler
+func ({{.Ref}} *{{.Name}}) MarshalBinary() ([]byte, error) {
+	return enkodo.Marshal({{.Ref}})
+}
+
+{{end}}{{if ne .Only "encode"}}// UnmarshalBinary decodes {{.Name}} with enkodo, implementing encoding.BinaryUnmarshaler
+func ({{.Ref}} *{{.Name}}) UnmarshalBinary(data []byte) error {
+	return enkodo.Unmarshal(data, {{.Ref}})
+}
+
+{{end}}{{end}}
+
+{{define "encodeField"}}{{.Code}}{{end}}{{define "decodeField"}}{{.Code}}{{end}}`
+
+// Checks EnkodoEnabled at the start of the enkodo methods, see Options.Gated
+const gateTemplate = `{{define "gate"}}	if !EnkodoEnabled {
+		return enkodo.ErrDisabled
+	}
+{{end}}`
+
+// Guards against maliciously deep payloads blowing the stack, see Options.MaxDepth
+const depthTemplate = `{{define "depth"}}{{if and .Recursive .MaxDepth}}	if dec.Depth() > {{.MaxDepth}} {
+		return enkodo.ErrMaxDepth
+	}
+{{end}}{{end}}`
+
+// Limits what decoding each value may allocate, see Options.Budget
+const budgetTemplate = `{{define "budget"}}{{if .Budget}}	dec.LimitBudget({{.Budget}})
+{{end}}{{end}}`
+
+// Prefixes the fields with their length so older decoders skip the ones appended since,
+// see Options.Framed. Named slices and maps have no fields to append so are not framed
+const frameTemplate = `{{define "encodeFrame"}}{{if or .Collection (not .Framed)}}{{.Encode}}{{else}}	err = enkodo.EncodeFramed(enc, func(enc *enkodo.Encoder) (err error) {
+{{.Encode}}		return
+	})
+{{end}}{{end}}{{define "decodeFrame"}}{{if or .Collection (not .Framed)}}{{.Decode}}{{else}}	err = enkodo.DecodeFramed(dec, func(dec *enkodo.Decoder) (err error) {
+{{.Decode}}		return
+	})
+{{end}}{{end}}`
+
+const msgpackTemplate = `{{define "msgpack"}}{{if not (.Skipped "msgpack")}}{{.Msgpack}}{{end}}{{end}}`
+
+const countBytesTemplate = `{{define "countbytes"}}{{if not (or (.Skipped "countbytes") (eq .Only "encode"))}}{{template "decodeN" .}}{{end}}{{end}}`
+
+const encodedSizeTemplate = `{{define "encodedsize"}}{{if not (or (.Skipped "encodedsize") (eq .Only "decode"))}}{{template "size" .}}{{end}}{{end}}`
+
+const binaryTemplate = `{{define "binary"}}{{if not (.Skipped "binary")}}{{template "binaryMethods" .}}{{end}}{{end}}`
+
+const accessorsTemplate = `{{define "accessors"}}{{.Accessors}}{{end}}`
+
+// The methods template built for the current options, see templates
+var methods *template.Template
+
+// templates returns the methods template with the templates of the enabled features,
+// then Options.Template, parsed over it
+func templates() (*template.Template, error) {
+	if methods != nil {
+		return methods, nil
+	}
+	t := template.Must(template.New("methods").Parse(methodsTemplate))
+	for _, layer := range []struct {
+		enabled bool
+		text    string
+	}{
+		{opts.Gated, gateTemplate},
+		// Structs can enable these with an //enkodo:options directive, so they check the
+		// struct's options themselves
+		{true, depthTemplate},
+		{true, budgetTemplate},
+		{true, frameTemplate},
+		{opts.Msgpack, msgpackTemplate},
+		{opts.CountBytes, countBytesTemplate},
+		{opts.EncodedSize, encodedSizeTemplate},
+		{opts.Binary, binaryTemplate},
+		{opts.EmitAccessors, accessorsTemplate},
+	} {
+		if layer.enabled {
+			template.Must(t.Parse(layer.text))
+		}
+	}
+
+	if opts.Template != "" {
+		data, err := os.ReadFile(opts.Template)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = t.Parse(string(data)); err != nil {
+			return nil, fmt.Errorf("invalid template %s: %w", opts.Template, err)
+		}
+	}
+	methods = t
+	return t, nil
+}
+
+// structTemplate is the data the methods template is executed with
+type structTemplate struct {
+	*Struct
+}
+
+// Ref returns the receiver name of the struct's methods
+func (s structTemplate) Ref() string {
+	return strings.ToLower(s.Name[0:1])
+}
+
+// Skipped reports if the helper methods of flag are not generated for the struct, or
+// its MarshalEnkodo for encode and UnmarshalEnkodo for decode
+func (s structTemplate) Skipped(flag string) bool {
+	return s.skip[flag]
+}
+
+// Encode returns the code encoding the fields of the struct
+func (s structTemplate) Encode() (string, error) {
+	buf := bytes.NewBuffer(nil)
+	err := s.encodeFields(buf)
+	return buf.String(), err
+}
+
+// Decode returns the code decoding the fields of the struct
+func (s structTemplate) Decode() (string, error) {
+	buf := bytes.NewBuffer(nil)
+	err := s.decodeFields(buf)
+	return buf.String(), err
+}
+
+func (s structTemplate) Msgpack() string {
+	buf := bytes.NewBuffer(nil)
+	s.MsgpackEncodeFunc(buf)
+	s.MsgpackDecodeFunc(buf)
+	return buf.String()
+}
+
+func (s structTemplate) Accessors() string {
+	buf := bytes.NewBuffer(nil)
+	s.AccessorFuncs(buf)
+	return buf.String()
+}
+
+// fieldTemplate is the data the encodeField and decodeField templates are executed with,
+// the field and the code encoding or decoding it
+type fieldTemplate struct {
+	Field
+	Struct structTemplate
+	Code   string
+}
+
+// executeField writes the template name for field of the struct, encoded or decoded by code
+func (s *Struct) executeField(f io.Writer, name string, field Field, code string) error {
+	t, err := templates()
+	if err != nil {
+		return err
+	}
+	if err = t.ExecuteTemplate(f, name, fieldTemplate{field, structTemplate{s}, code}); err != nil {
+		return fmt.Errorf("failed to generate %s.%s: %w", s.Name, field.Name, err)
+	}
+	return nil
+}
+
+// execute writes the template name for the struct
+func (s *Struct) execute(f io.Writer, name string) error {
+	t, err := templates()
+	if err != nil {
+		return err
+	}
+	if err = t.ExecuteTemplate(f, name, structTemplate{s}); err != nil {
+		return fmt.Errorf("failed to generate %s: %w", s.Name, err)
+	}
+	return nil
+}
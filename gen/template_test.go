@@ -0,0 +1,152 @@
+package gen
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/nullmonk/enkodo"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+const goldenSrc = `package fixture
+
+import "time"
+
+type User struct {
+	Name    string            ` + "`enkodo:\"\"`" + `
+	Age     int               ` + "`enkodo:\"uint8\"`" + `
+	Scores  []int             ` + "`enkodo:\"\"`" + `
+	Labels  map[string]string ` + "`enkodo:\"\"`" + `
+	Created time.Time         ` + "`enkodo:\"\"`" + `
+}
+
+type Tree struct {
+	Value    int     ` + "`enkodo:\"\"`" + `
+	Children []*Tree ` + "`enkodo:\"\"`" + `
+}
+`
+
+// Each golden file holds the output for a combination of options, which is gofmt'd, so features
+// layered onto the method templates are checked both alone and together
+func TestGolden(t *testing.T) {
+	defer func() { opts = Options{} }()
+
+	for name, o := range map[string]Options{
+		"default":                 {},
+		"gated":                   {Gated: true},
+		"gated_countbytes":        {Gated: true, CountBytes: true},
+		"gated_maxdepth_msgpack":  {Gated: true, MaxDepth: 8, Msgpack: true},
+		"countbytes_accessors":    {CountBytes: true, EmitAccessors: true},
+		"encodedsize":             {EncodedSize: true},
+		"nilslice_formatversion2": {NilSlice: true, FormatVersion: 2},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts = o
+			resetState()
+			pkg, structs, err := parseFile("fixture.go", goldenSrc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := bytes.NewBuffer(nil)
+			if err = writeFile(buf, pkg, structs, true); err != nil {
+				t.Fatal(err)
+			}
+			src := buf.Bytes()
+
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err = os.WriteFile(golden, src, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(src) != string(expected) {
+				t.Fatalf("output differs from %s, received:\n%s", golden, src)
+			}
+		})
+	}
+}
+
+func TestTemplateOverride(t *testing.T) {
+	dir := t.TempDir()
+	opts.Template = filepath.Join(dir, "methods.tmpl")
+	defer func() { opts = Options{} }()
+
+	err := os.WriteFile(opts.Template, []byte(`{{define "gate"}}	if {{.Ref}} == nil {
+		return nil
+	}
+{{end}}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := generate(t, goldenSrc)
+	if strings.Count(out, "if u == nil {") != 2 || strings.Count(out, "if t == nil {") != 2 {
+		t.Fatalf("expected the overridden gate in each method, received:\n%s", out)
+	}
+
+	// Each field can be wrapped too
+	err = os.WriteFile(opts.Template, []byte(`{{define "encodeField"}}	// encoding {{.Struct.Name}}.{{.Name}} as {{.Type}}
+{{.Code}}{{end}}{{define "decodeField"}}{{.Code}}	if err != nil {
+		return
+	}
+{{end}}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = generate(t, goldenSrc)
+	if !strings.Contains(out, "// encoding User.Scores as []int") || strings.Count(out, "if err != nil {\n\t\treturn\n\t}") < 7 {
+		t.Fatalf("expected the overridden field templates, received:\n%s", out)
+	}
+
+	if err = os.WriteFile(opts.Template, []byte(`{{define "gate"}}{{.Missing}}{{end}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	resetState()
+	pkg, structs, err := parseFile("fixture.go", goldenSrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writeFile(io.Discard, pkg, structs, true); err == nil {
+		t.Fatal("expected an error executing an invalid template")
+	}
+}
+
+// The runtime is the root package of this module, so the methods the generated code calls
+// must exist on its Encoder and Decoder
+func TestRuntimeMethods(t *testing.T) {
+	goldens, err := filepath.Glob(filepath.Join("testdata", "*.golden"))
+	if err != nil || len(goldens) == 0 {
+		t.Fatalf("no golden files found (%v)", err)
+	}
+	call := regexp.MustCompile(`\b(enc|dec)\.([A-Z]\w*)\(`)
+	types := map[string]reflect.Type{"enc": reflect.TypeOf(&enkodo.Encoder{}), "dec": reflect.TypeOf(&enkodo.Decoder{})}
+	for _, golden := range goldens {
+		data, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Only the enkodo methods, the msgpack ones name their encoder enc too
+		for _, fn := range strings.Split(string(data), "\nfunc ") {
+			if !strings.Contains(fn, "(enc *enkodo.Encoder)") && !strings.Contains(fn, "(dec *enkodo.Decoder)") {
+				continue
+			}
+			for _, m := range call.FindAllStringSubmatch(fn, -1) {
+				if _, ok := types[m[1]].MethodByName(m[2]); !ok {
+					t.Fatalf("%s calls %s.%s, which the runtime %s does not have", golden, m[1], m[2], types[m[1]])
+				}
+			}
+		}
+	}
+}
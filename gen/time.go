@@ -0,0 +1,28 @@
+package gen
+
+import "fmt"
+
+// TimeTypeConverter encodes time.Time as nanoseconds since the unix epoch through
+// enkodo.TimeNano, which writes the zero Time as math.MinInt64 so unset times decode as
+// the zero Time rather than a date in 1754
+type TimeTypeConverter struct{}
+
+func (t *TimeTypeConverter) Name() string {
+	return "time.Time"
+}
+
+func (t *TimeTypeConverter) EnkodoFunction() string {
+	return "Int64"
+}
+
+func (t *TimeTypeConverter) Enc(val string) string {
+	return fmt.Sprintf("enkodo.TimeNano(%s)", val)
+}
+
+func (t *TimeTypeConverter) Dec(val string) string {
+	return fmt.Sprintf("enkodo.NanoTime(%s)", val)
+}
+
+func (t *TimeTypeConverter) Imports() []string {
+	return []string{"time"}
+}
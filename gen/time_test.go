@@ -0,0 +1,49 @@
+package gen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTime(t *testing.T) {
+	src := `package fixture
+
+import "time"
+
+type Event struct {
+	At   time.Time   ` + "`enkodo:\"\"`" + `
+	Seen []time.Time ` + "`enkodo:\"\"`" + `
+}
+`
+	out := generate(t, src)
+	if !strings.Contains(out, "enc.Int64(enkodo.TimeNano(e.At))") || !strings.Contains(out, "enkodo.NanoTime(") {
+		t.Fatalf("expected times to be written through enkodo.TimeNano, received:\n%s", out)
+	}
+
+	// The zero Time is out of the range of UnixNano and decodes as itself
+	runGenerated(t, src, `package fixture
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nullmonk/enkodo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Event{Seen: []time.Time{time.Unix(1700000000, 42), {}}}
+	bs, err := enkodo.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Event
+	if err = enkodo.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.At.IsZero() || len(out.Seen) != 2 || !out.Seen[0].Equal(in.Seen[0]) || !out.Seen[1].IsZero() {
+		t.Fatalf("invalid value, expected %+v and received %+v", in, out)
+	}
+}
+`)
+}
@@ -0,0 +1,577 @@
+package gen
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The typescript and javascript modules are written together, with the typescript types
+// between « and », which are dropped from javascript
+var typeAnnotation = regexp.MustCompile(`«[^»]*»`)
+
+// Reads the wire format in the typescript modules, ahead of the functions of the structs
+const typescriptRuntime = `const textDecoder = new TextDecoder();
+
+/** An error decoded with its code from the enkodo error registry */
+export class EnkodoError extends Error {
+  code«: number»;
+
+  constructor(code«: number», message«: string») {
+    super(message);
+    this.code = code;
+  }
+}
+
+/** Reads the values of the enkodo wire format from data */
+export class EnkodoReader {
+  data«: Uint8Array»;
+  offset = 0;
+  // Reads stop at the end of the current frame
+  end«: number»;
+
+  constructor(data«: Uint8Array») {
+    this.data = data;
+    this.end = data.length;
+  }
+
+  read(n«: number»)«: Uint8Array» {
+    if (this.offset + n > this.end) {
+      throw new RangeError(` + "`reading ${n} bytes at offset ${this.offset} of ${this.end}`" + `);
+    }
+    // Copied, node Buffers slice into views of themselves
+    const v = new Uint8Array(this.data.subarray(this.offset, this.offset + n));
+    this.offset += n;
+    return v;
+  }
+
+  /** Stops reads at the end of a struct's fields, returning the end of the outer frame */
+  frame()«: number» {
+    const n = this.length();
+    if (this.offset + n > this.end) {
+      throw new RangeError(` + "`frame of ${n} bytes at offset ${this.offset} of ${this.end}`" + `);
+    }
+    const outer = this.end;
+    this.end = this.offset + n;
+    return outer;
+  }
+
+  /** Skips the fields of newer versions left in the frame */
+  unframe(outer«: number») {
+    this.offset = this.end;
+    this.end = outer;
+  }
+
+  uint8()«: number» {
+    return this.read(1)[0];
+  }
+
+  int8()«: number» {
+    return (this.uint8() << 24) >> 24;
+  }
+
+  uint(bits = 64)«: bigint» {
+    // Varints hold 7 bits in each byte, with the high bit set when another follows, but
+    // the ninth byte holds 8
+    let v = 0n;
+    for (let i = 0; ; i++) {
+      const b = BigInt(this.uint8());
+      if (b < 0x80n || i === 8) {
+        return BigInt.asUintN(bits, v + (b << BigInt(7 * i)));
+      }
+      v += (b & 0x7fn) << BigInt(7 * i);
+    }
+  }
+
+  int(bits = 64)«: bigint» {
+    return BigInt.asIntN(bits, this.uint(bits));
+  }
+
+  zigzag(bits = 64)«: bigint» {
+    const u = this.uint();
+    return BigInt.asIntN(bits, (u >> 1n) ^ -(u & 1n));
+  }
+
+  uintN(bits«: number»)«: number» {
+    return Number(this.uint(bits));
+  }
+
+  intN(bits«: number»)«: number» {
+    return Number(this.int(bits));
+  }
+
+  zigzagN(bits«: number»)«: number» {
+    return Number(this.zigzag(bits));
+  }
+
+  float32()«: number» {
+    const view = new DataView(new ArrayBuffer(4));
+    view.setUint32(0, this.uintN(32));
+    return view.getFloat32(0);
+  }
+
+  float64()«: number» {
+    const view = new DataView(new ArrayBuffer(8));
+    view.setBigUint64(0, this.uint());
+    return view.getFloat64(0);
+  }
+
+  bool()«: boolean» {
+    return this.uint8() === 1;
+  }
+
+  length()«: number» {
+    const n = this.int();
+    if (n < 0n || n > BigInt(this.data.length)) {
+      throw new RangeError(` + "`invalid length ${n} at offset ${this.offset}`" + `);
+    }
+    return Number(n);
+  }
+
+  bytes()«: Uint8Array» {
+    return this.read(this.length());
+  }
+
+  nilBytes()«: Uint8Array | null» {
+    const n = this.length();
+    return n === 0 ? null : this.read(n - 1);
+  }
+
+  string()«: string» {
+    return textDecoder.decode(this.bytes());
+  }
+
+  error()«: EnkodoError | null» {
+    const code = this.intN(64);
+    const message = this.string();
+    return code === 0 && message === "" ? null : new EnkodoError(code, message);
+  }
+
+  time()«: Date | null» {
+    // The zero time.Time is written as the minimum int64, dates only keep milliseconds
+    const ns = this.int();
+    return ns === -(1n << 63n) ? null : new Date(Number(ns / 1000000n));
+  }
+
+  json()«: any» {
+    return JSON.parse(this.string());
+  }
+
+  slice«<T>»(fn«: () => T»)«: T[]» {
+    return this.array(this.length(), fn);
+  }
+
+  nilSlice«<T>»(fn«: () => T»)«: T[] | null» {
+    const n = this.length();
+    return n === 0 ? null : this.array(n - 1, fn);
+  }
+
+  array«<T>»(n«: number», fn«: () => T»)«: T[]» {
+    const v«: T[]» = [];
+    for (let i = 0; i < n; i++) {
+      v.push(fn());
+    }
+    return v;
+  }
+
+  map«<K, V>»(key«: () => K», value«: () => V»)«: Map<K, V>» {
+    const v = new Map«<K, V>»();
+    for (let n = this.length(); n > 0; n--) {
+      const k = key();
+      v.set(k, value());
+    }
+    return v;
+  }
+
+  pointer«<T>»(fn«: () => T»)«: T | null» {
+    return this.bool() ? fn() : null;
+  }
+
+  registered«<T>»(types«: Record<number, (r: EnkodoReader) => T>»)«: T | null» {
+    const id = this.uintN(64);
+    if (id === 0) {
+      return null;
+    }
+    if (!(id in types)) {
+      throw new RangeError(` + "`unregistered type ID ${id} at offset ${this.offset}`" + `);
+    }
+    return types[id](this);
+  }
+
+  runs«<T>»(fn«: () => T»)«: T[]» {
+    const isRuns = this.bool();
+    const n = this.length();
+    if (!isRuns) {
+      return this.array(n, fn);
+    }
+    const v«: T[]» = [];
+    for (let i = 0; i < n; i++) {
+      const count = this.length();
+      const value = fn();
+      for (let j = 0; j < count; j++) {
+        v.push(value);
+      }
+    }
+    return v;
+  }
+
+  unsupported(typ«: string»)«: never» {
+    throw new Error(` + "`${typ} is not declared in this module`" + `);
+  }
+}
+`
+
+// Readers of the values written by each Encoder method, the integers of more than 32
+// bits are read as bigints
+var typescriptMethods = map[string]string{
+	"Uint":    "r.uint()",
+	"Uint8":   "r.uint8()",
+	"Uint16":  "r.uintN(16)",
+	"Uint32":  "r.uintN(32)",
+	"Uint64":  "r.uint()",
+	"Int":     "r.int()",
+	"Int8":    "r.int8()",
+	"Int16":   "r.intN(16)",
+	"Int32":   "r.intN(32)",
+	"Int64":   "r.int()",
+	"Float32": "r.float32()",
+	"Float64": "r.float64()",
+	"String":  "r.string()",
+	"Bytes":   "r.bytes()",
+	"Bool":    "r.bool()",
+	"Error":   "r.error()",
+}
+
+// Encoder methods of the integers read as bigints, which hold their 64 bits
+var bigintMethods = map[string]bool{"Uint": true, "Uint64": true, "Int": true, "Int64": true}
+
+// writeTypeScript writes a typescript module decoding the structs of pkg, as described
+// by schema
+func writeTypeScript(w io.Writer, schema *Schema, pkg PackageSchema) error {
+	return writeScript(w, schema, pkg, true)
+}
+
+// writeJavaScript writes the javascript module of writeTypeScript, without the types
+func writeJavaScript(w io.Writer, schema *Schema, pkg PackageSchema) error {
+	return writeScript(w, schema, pkg, false)
+}
+
+func writeScript(w io.Writer, schema *Schema, pkg PackageSchema, types bool) error {
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "// This file is auto-generated by enkodo\n// Decoders of the enkodo structs of package %s\n\n%s", pkg.Name, typescriptRuntime)
+	p := scriptWriter{schema: schema, pkg: pkg}
+	for _, s := range pkg.Structs {
+		if err := p.writeStruct(buf, s); err != nil {
+			return fmt.Errorf("%s.%s: %w", pkg.Name, s.Name, err)
+		}
+	}
+
+	src := buf.String()
+	if types {
+		src = strings.NewReplacer("«", "", "»", "").Replace(src)
+	} else {
+		src = typeAnnotation.ReplaceAllString(src, "")
+	}
+	_, err := io.WriteString(w, src)
+	return err
+}
+
+type scriptWriter struct {
+	schema *Schema
+	pkg    PackageSchema
+}
+
+// writeStruct writes the interface of s, with the functions returning its zero value and
+// decoding it. Code is written with tabs, indented as two spaces
+func (p scriptWriter) writeStruct(w io.Writer, s StructSchema) error {
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "\n«/** %s.%s, decoded from the enkodo wire format */\nexport interface %s {\n", p.pkg.Name, s.Name, s.Name)
+	for _, f := range s.Fields {
+		// The bool saying if the field is written is decoded too
+		if f.PresentIf != "" && !slices.ContainsFunc(s.Fields, func(g FieldSchema) bool { return g.Name == f.PresentIf }) {
+			fmt.Fprintf(buf, "\t%s: boolean;\n", f.PresentIf)
+		}
+		typ := p.typ(f.Wire)
+		if f.Wire.Kind == "gzip" {
+			typ = "Uint8Array"
+		}
+		fmt.Fprintf(buf, "\t%s: %s;\n", f.Name, typ)
+	}
+	fmt.Fprintf(buf, "}\n\n»")
+
+	fmt.Fprintf(buf, "/** Returns the zero value of %s.%s */\nexport function new%s()«: %s» {\n\treturn {\n", p.pkg.Name, s.Name, s.Name, s.Name)
+	for _, f := range s.Fields {
+		if f.PresentIf != "" && !slices.ContainsFunc(s.Fields, func(g FieldSchema) bool { return g.Name == f.PresentIf }) {
+			fmt.Fprintf(buf, "\t\t%s: false,\n", f.PresentIf)
+		}
+		fmt.Fprintf(buf, "\t\t%s: %s,\n", f.Name, p.zero(f.Wire))
+	}
+	fmt.Fprintf(buf, "\t};\n}\n")
+
+	fmt.Fprintf(buf, "\n/** Decodes %s.%s from the enkodo wire format in data */\n", p.pkg.Name, s.Name)
+	fmt.Fprintf(buf, "export function unmarshal%s(data«: Uint8Array»)«: %s» {\n\treturn decode%s(new EnkodoReader(data));\n}\n", s.Name, s.Name, s.Name)
+	fmt.Fprintf(buf, "\nexport function decode%s(r«: EnkodoReader»)«: %s» {\n\tconst v = new%s();\n", s.Name, s.Name, s.Name)
+	if s.framed(p.schema) {
+		fmt.Fprintf(buf, "\tconst outer = r.frame();\n")
+	}
+	if s.FieldVersion > 0 {
+		fmt.Fprintf(buf, "\tconst version = r.uint8();\n\tif (version > %d) {\n", s.FieldVersion)
+		fmt.Fprintf(buf, "\t\tthrow new RangeError(`%s was written by version ${version}, newer than version %d it is decoded with`);\n\t}\n", s.Name, s.FieldVersion)
+	}
+	if cond, ok := anyVersionRange("version", " && ", " || ", s.presenceVersions()); ok && cond == "" {
+		fmt.Fprintf(buf, "\tconst present = r.uint();\n")
+	} else if ok {
+		fmt.Fprintf(buf, "\tconst present = %s ? r.uint() : 0n;\n", cond)
+	}
+	for _, f := range s.Fields {
+		read, err := p.read(f.Wire)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if read == "" {
+			fmt.Fprintf(buf, "\t// %s is left out, enkodo does not know how to write %s\n", f.Name, f.Wire.Type)
+			continue
+		}
+		code := fmt.Sprintf("v.%s = %s;\n", f.Name, read)
+		switch {
+		case f.Bit != nil:
+			code = fmt.Sprintf("if (present & (1n << %dn)) {\n%s}\n", *f.Bit, indent(code))
+		case f.PresentIfWritten:
+			code = fmt.Sprintf("if (v.%s) {\n%s}\n", f.PresentIf, indent(code))
+		case f.PresentIf != "":
+			code = fmt.Sprintf("v.%s = r.bool();\nif (v.%s) {\n%s}\n", f.PresentIf, f.PresentIf, indent(code))
+		}
+		if cond := versionRange("version", " && ", f.Since, f.Until); cond != "" {
+			code = fmt.Sprintf("if (%s) {\n%s}\n", cond, indent(code))
+		}
+		fmt.Fprint(buf, indent(code))
+	}
+	if s.framed(p.schema) {
+		fmt.Fprintf(buf, "\tr.unframe(outer);\n")
+	}
+	fmt.Fprintf(buf, "\treturn v;\n}\n")
+	_, err := io.WriteString(w, strings.ReplaceAll(buf.String(), "\t", "  "))
+	return err
+}
+
+// read returns the expression reading a value written as t from the EnkodoReader r, or
+// "" when nothing is written
+func (p scriptWriter) read(t TypeSchema) (string, error) {
+	elem := func() (string, error) {
+		if t.Elem == nil {
+			return "", fmt.Errorf("%s %s has no element type", t.Kind, t.Type)
+		}
+		e, err := p.read(*t.Elem)
+		return "() => " + e, err
+	}
+	switch t.Kind {
+	case "basic":
+		switch {
+		case t.Method == "":
+			return "", nil
+		case t.Type == "time.Time":
+			return "r.time()", nil
+		case t.Type == "[]byte" && p.schema.NilSlice:
+			return "r.nilBytes()", nil
+		case addrMethods[t.Method]:
+			return "r.bytes()", nil
+		case t.Method == "UUID":
+			return "r.read(16)", nil
+		case t.Type == "float32":
+			// Floats tagged bits are written as the same bits with a Uint method
+			return "r.float32()", nil
+		case t.Type == "float64":
+			return "r.float64()", nil
+		case t.Method == "ZigZag":
+			if bits := intBits(t.Type); bits < 64 {
+				return fmt.Sprintf("r.zigzagN(%d)", bits), nil
+			}
+			return "r.zigzag()", nil
+		}
+		read, ok := typescriptMethods[t.Method]
+		if !ok {
+			return "", fmt.Errorf("unknown encoder method %s of %s", t.Method, t.Type)
+		}
+		return read, nil
+	case "struct":
+		if strings.Contains(t.Type, ".") {
+			return fmt.Sprintf("r.unsupported(%q)", t.Type), nil
+		}
+		return "decode" + t.Type + "(r)", nil
+	case "interface":
+		types := make([]string, 0, len(t.Registry))
+		for _, entry := range t.Registry {
+			types = append(types, fmt.Sprintf("%d: decode%s", entry.ID, strings.TrimPrefix(entry.Type, "*")))
+		}
+		return "r.registered({ " + strings.Join(types, ", ") + " })", nil
+	case "json":
+		return "r.json()", nil
+	case "converter":
+		return "", fmt.Errorf("%s is written by %s, which only go can decode", t.Type, t.Method)
+	case "gzip":
+		// Decompressing is asynchronous in browsers, so it is left to the caller
+		return "r.bytes()", nil
+	case "pointer":
+		e, err := elem()
+		return "r.pointer(" + e + ")", err
+	case "slice":
+		e, err := elem()
+		if p.schema.NilSlice {
+			return "r.nilSlice(" + e + ")", err
+		}
+		return "r.slice(" + e + ")", err
+	case "runs":
+		e, err := elem()
+		return "r.runs(" + e + ")", err
+	case "array":
+		if t.Elem != nil && (t.Elem.Type == "byte" || t.Elem.Type == "uint8") {
+			return fmt.Sprintf("r.read(%d)", t.Len), nil
+		}
+		e, err := elem()
+		return fmt.Sprintf("r.array(%d, %s)", t.Len, e), err
+	case "map":
+		if t.Key == nil {
+			return "", fmt.Errorf("map %s has no key type", t.Type)
+		}
+		k, err := p.read(*t.Key)
+		if err != nil {
+			return "", err
+		}
+		e, err := elem()
+		return "r.map(() => " + k + ", " + e + ")", err
+	}
+	return "", fmt.Errorf("unknown kind %q of %s", t.Kind, t.Type)
+}
+
+// typ returns the typescript type of the values read for t
+func (p scriptWriter) typ(t TypeSchema) string {
+	elem := func() string {
+		if t.Elem == nil {
+			return "any"
+		}
+		e := p.typ(*t.Elem)
+		if strings.Contains(e, " ") {
+			return "(" + e + ")"
+		}
+		return e
+	}
+	switch t.Kind {
+	case "basic":
+		switch {
+		case t.Method == "":
+			return "any"
+		case t.Type == "time.Time":
+			return "Date | null"
+		case t.Type == "[]byte":
+			if p.schema.NilSlice {
+				return "Uint8Array | null"
+			}
+			return "Uint8Array"
+		case addrMethods[t.Method], t.Method == "UUID":
+			return "Uint8Array"
+		case t.Method == "Error":
+			return "EnkodoError | null"
+		case t.Method == "String":
+			return "string"
+		case t.Method == "Bool":
+			return "boolean"
+		case t.Type == "float32", t.Type == "float64":
+			return "number"
+		case t.Method == "ZigZag":
+			if intBits(t.Type) < 64 {
+				return "number"
+			}
+			return "bigint"
+		}
+		if bigintMethods[t.Method] {
+			return "bigint"
+		}
+		return "number"
+	case "struct":
+		if strings.Contains(t.Type, ".") {
+			return "any"
+		}
+		return t.Type
+	case "interface":
+		types := make([]string, 0, len(t.Registry)+1)
+		for _, entry := range t.Registry {
+			types = append(types, strings.TrimPrefix(entry.Type, "*"))
+		}
+		return strings.Join(append(types, "null"), " | ")
+	case "json":
+		return "any"
+	case "gzip":
+		return "Uint8Array"
+	case "pointer":
+		return p.typ(*t.Elem) + " | null"
+	case "slice":
+		if p.schema.NilSlice {
+			return elem() + "[] | null"
+		}
+		return elem() + "[]"
+	case "runs":
+		return elem() + "[]"
+	case "array":
+		if t.Elem != nil && (t.Elem.Type == "byte" || t.Elem.Type == "uint8") {
+			return "Uint8Array"
+		}
+		return elem() + "[]"
+	case "map":
+		if t.Key == nil || t.Elem == nil {
+			return "Map<any, any>"
+		}
+		return "Map<" + p.typ(*t.Key) + ", " + p.typ(*t.Elem) + ">"
+	}
+	return "any"
+}
+
+// zero returns the value of a field written as t that is not decoded, the zero value of
+// its go type
+func (p scriptWriter) zero(t TypeSchema) string {
+	switch t.Kind {
+	case "basic":
+		switch typ := p.typ(t); {
+		case t.Method == "UUID":
+			return "new Uint8Array(16)"
+		case typ == "Uint8Array":
+			return "new Uint8Array(0)"
+		case typ == "string":
+			return `""`
+		case typ == "boolean":
+			return "false"
+		case typ == "bigint":
+			return "0n"
+		case typ == "number":
+			return "0"
+		}
+		return "null"
+	case "struct":
+		if strings.Contains(t.Type, ".") {
+			return "null"
+		}
+		return "new" + t.Type + "()"
+	case "gzip":
+		return "new Uint8Array(0)"
+	case "slice":
+		if p.schema.NilSlice {
+			return "null"
+		}
+		return "[]"
+	case "runs":
+		return "[]"
+	case "array":
+		if t.Elem == nil {
+			return "[]"
+		}
+		if t.Elem.Type == "byte" || t.Elem.Type == "uint8" {
+			return fmt.Sprintf("new Uint8Array(%d)", t.Len)
+		}
+		return fmt.Sprintf("Array.from({ length: %d }, () => %s)", t.Len, p.zero(*t.Elem))
+	case "map":
+		return "new Map()"
+	}
+	// Pointers, interfaces and JSON
+	return "null"
+}
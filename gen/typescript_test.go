@@ -0,0 +1,77 @@
+package gen
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestJavaScript(t *testing.T) {
+	defer func() { opts = Options{} }()
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+
+	for name, o := range map[string]Options{
+		"default":                 {},
+		"nilslice_formatversion2": {NilSlice: true, FormatVersion: 2},
+		"framed":                  {Framed: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts = o
+			testJavaScript(t, node)
+		})
+	}
+}
+
+// testJavaScript checks the javascript module, and the typescript one when node can run
+// it, generated with the current options decode what the go code generated with them
+// encodes
+func testJavaScript(t *testing.T, node string) {
+	dir, out := encodeDecoderFixture(t)
+	if err := os.WriteFile(filepath.Join(dir, "package.json"), []byte(`{"type": "module"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	modules := []string{"fixture_enkodo.js"}
+	// Typescript runs without compiling it from node 22.6
+	if exec.Command(node, "--experimental-strip-types", "-e", "").Run() == nil {
+		modules = append(modules, "fixture_enkodo.ts")
+	}
+	for _, module := range modules {
+		opts.Lang = "javascript"
+		if strings.HasSuffix(module, ".ts") {
+			opts.Lang = "typescript"
+		}
+		if err := GeneratePath(dir); err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := exec.Command(node, "--experimental-strip-types", "--input-type=module", "-e", `import assert from "node:assert";
+import { readFileSync } from "node:fs";
+import { unmarshalUser } from "./`+module+`";
+
+const u = unmarshalUser(readFileSync(process.argv[1]));
+assert.deepStrictEqual(u, {
+	Name: "alice", Age: 30, Delta: -300, Offset: -2, Level: 0, Score: 1.5, Admin: true,
+	Avatar: new Uint8Array([1, 2]), Key: new Uint8Array([1, 2, 3, 4]), Scores: [1n << 40n, -1n],
+	Flags: [7, 7, 7, 7, 1], Labels: new Map([["a", 1 << 20]]), Corners: new Map([[{ X: 1, Y: -2 }, 3]]), Home: { Side: 2 }, Office: null,
+	Shape: { Side: 3 }, Seen: new Date(1700000000000), Timeout: 2000000000n, Server: new Uint8Array([10, 0, 0, 1]), HasNote: true, Note: "hi",
+	HasBio: true, Bio: "b", Email: "a@b.c", Legacy: 0,
+});
+`, out)
+		if len(modules) == 1 {
+			// Older versions of node do not know the flag
+			cmd.Args = slices.Delete(cmd.Args, 1, 2)
+		}
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			src, _ := os.ReadFile(filepath.Join(dir, module))
+			t.Fatalf("%s decoder failed: %v\n%s\n%s", opts.Lang, err, output, src)
+		}
+	}
+}
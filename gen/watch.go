@@ -0,0 +1,101 @@
+package gen
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// How long Watch waits after a change for more, as editors often save a file in several
+// writes and tools such as git checkout change many files at once
+const watchDelay = 100 * time.Millisecond
+
+// Watch generates the code for root as GeneratePath does, then again whenever a go file
+// under it is written, created, removed or renamed, until ctx is done. Errors of a run
+// are logged rather than returned, so saving a file that does not parse yet does not end
+// the watch. Files written by enkodo are not watched, so runs do not trigger themselves
+func Watch(ctx context.Context, root string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// A file is generated alone, but the other files of its package can change its types
+	dir := rootDir(root)
+	info, err := os.Stat(root)
+	file := err == nil && !info.IsDir()
+	if file {
+		err = watcher.Add(dir)
+	} else {
+		stateMu.Lock()
+		err = watchDirs(watcher, dir, dir)
+		stateMu.Unlock()
+	}
+	if err != nil {
+		return err
+	}
+
+	run := func() {
+		if err := GeneratePath(root); err != nil {
+			log.Print(err)
+		}
+	}
+	run()
+
+	var changed <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+			changed = nil
+			run()
+		case err := <-watcher.Errors:
+			log.Printf("warning: watching %s: %v", root, err)
+		case event := <-watcher.Events:
+			if watchEvent(watcher, dir, file, event) {
+				changed = time.After(watchDelay)
+			}
+		}
+	}
+}
+
+// watchEvent reports whether event changes the go files under dir, watched by watcher, so
+// they are generated again. dir only holds the file watched when file is set. The
+// options excluding files are read with the lock on the package state held
+func watchEvent(watcher *fsnotify.Watcher, dir string, file bool, event fsnotify.Event) bool {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	// New directories are watched too, as fsnotify does not watch recursively. They may
+	// have been moved here with go files in them
+	if info, err := os.Stat(event.Name); err == nil && info.IsDir() && event.Has(fsnotify.Create) {
+		if file || skipDir(dir, event.Name) {
+			return false
+		}
+		if err := watchDirs(watcher, dir, event.Name); err != nil {
+			log.Printf("warning: watching %s: %v", event.Name, err)
+		}
+		return true
+	}
+	return filepath.Ext(event.Name) == ".go" && !excluded(dir, event.Name) && !isGenerated(event.Name)
+}
+
+// watchDirs adds path and the directories under it to watcher, leaving out those
+// walkFiles leaves out of root
+func watchDirs(watcher *fsnotify.Watcher, root, path string) error {
+	return filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		if skipDir(root, path) {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
@@ -0,0 +1,63 @@
+package gen
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	defer func() { opts = Options{} }()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "pkg", "user.go")
+	if err := os.MkdirAll(filepath.Dir(src), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(src, []byte("package fixture\n\ntype User struct {\n\tName string `enkodo:\"\"`\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- Watch(ctx, dir+"/...") }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// waitFor waits for the generated code of User to contain s
+	generated := filepath.Join(dir, "pkg", "user_enkodo.go")
+	waitFor := func(s string) {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+			if out, err := os.ReadFile(generated); err == nil && strings.Contains(string(out), s) {
+				return
+			}
+		}
+		out, _ := os.ReadFile(generated)
+		t.Fatalf("expected the generated code to contain %q, received:\n%s", s, out)
+	}
+	waitFor("u.Name")
+
+	if err := os.WriteFile(src, []byte("package fixture\n\ntype User struct {\n\tName string `enkodo:\"\"`\n\tAge  uint8  `enkodo:\"\"`\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("u.Age")
+
+	// Packages added while watching are generated too
+	post := filepath.Join(dir, "post", "post.go")
+	if err := os.MkdirAll(filepath.Dir(post), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(post, []byte("package post\n\ntype Post struct {\n\tTitle string `enkodo:\"\"`\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	generated = filepath.Join(dir, "post", "post_enkodo.go")
+	waitFor("p.Title")
+}
@@ -0,0 +1,93 @@
+package enkodo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// EncodeGzip encodes bs compressed with gzip. Empty bytes are written as they are, as
+// compressing them would only add the gzip header
+func EncodeGzip(e *Encoder, bs []byte) (err error) {
+	if len(bs) == 0 {
+		return e.Bytes(nil)
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err = w.Write(bs); err != nil {
+		return
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+
+	return e.Bytes(buf.Bytes())
+}
+
+// DecodeGzip decodes bytes written by EncodeGzip into bs, decompressing them
+func DecodeGzip(d *Decoder, bs *[]byte) (err error) {
+	return decodeGzip(d, bs, "", -1)
+}
+
+// DecodeGzipMax decodes bytes written by EncodeGzip into bs like DecodeGzip, returning a
+// MaxLengthError for field once decompressing them passes max bytes, rather than at the
+// end. Its Length is then the bytes decompressed so far, or the length of the compressed
+// bytes when there are too many of those to hold max bytes
+func DecodeGzipMax(d *Decoder, bs *[]byte, field string, max int) (err error) {
+	return decodeGzip(d, bs, field, max)
+}
+
+// decodeGzip decodes bytes written by EncodeGzip, failing before it decompresses more
+// than max bytes, unless it is negative, or more than the budget has left
+func decodeGzip(d *Decoder, bs *[]byte, field string, max int) (err error) {
+	var compressed []byte
+	// Compressed bytes are at most a little longer than those they hold, when they do not
+	// compress, so they are limited too
+	if err = d.bytes(&compressed, field, gzipBound(max)); err != nil {
+		if lengthErr, ok := err.(*MaxLengthError); ok {
+			lengthErr.Max = max
+		}
+		return
+	}
+	if len(compressed) == 0 {
+		*bs = []byte{}
+		return
+	}
+
+	var r *gzip.Reader
+	if r, err = gzip.NewReader(bytes.NewReader(compressed)); err != nil {
+		return
+	}
+
+	limit := d.remaining()
+	if max >= 0 && (limit < 0 || max < limit) {
+		limit = max
+	}
+
+	var src io.Reader = r
+	if limit >= 0 {
+		// Decompressing stops once the limit is exceeded, rather than at the end
+		src = io.LimitReader(r, int64(limit)+1)
+	}
+
+	if *bs, err = io.ReadAll(src); err != nil {
+		return
+	}
+
+	if max >= 0 && len(*bs) > max {
+		return &MaxLengthError{Field: field, Length: len(*bs), Max: max}
+	}
+
+	return d.Allocate(len(*bs))
+}
+
+// gzipBound returns the most bytes EncodeGzip writes for max bytes, those stored without
+// compression and the gzip header, trailer and block headers, or -1 when max is
+func gzipBound(max int) int {
+	if max < 0 {
+		return -1
+	}
+
+	return max + max/1024 + 64
+}
@@ -0,0 +1,62 @@
+package enkodo
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestGzip(t *testing.T) {
+	large := bytes.Repeat([]byte("enkodo "), 1000)
+	for _, in := range [][]byte{large, []byte("short"), {}} {
+		e := newEncoder(nil)
+		if err := EncodeGzip(e, in); err != nil {
+			t.Fatal(err)
+		}
+		if len(in) == len(large) && len(e.bs) > len(large)/10 {
+			t.Fatalf("expected gzip to shrink the bytes, received %d bytes", len(e.bs))
+		}
+		if len(in) == 0 && len(e.bs) != 1 {
+			t.Fatalf("expected empty bytes to only write their length, received %d bytes", len(e.bs))
+		}
+
+		d := newDecoder(bytes.NewBuffer(e.bs))
+		var out []byte
+		if err := DecodeGzip(d, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(in, out) {
+			t.Fatalf(testErrorFmt, in, out)
+		}
+	}
+}
+
+func TestGzipMax(t *testing.T) {
+	e := newEncoder(nil)
+	if err := EncodeGzip(e, make([]byte, 1<<20)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Decompressing stops a byte past the limit, rather than at the end
+	var (
+		out       []byte
+		lengthErr *MaxLengthError
+	)
+	err := DecodeGzipMax(newDecoder(bytes.NewBuffer(e.bs)), &out, "Blob", 4096)
+	if !errors.As(err, &lengthErr) || lengthErr.Field != "Blob" || lengthErr.Length != 4097 {
+		t.Fatalf("invalid error, expected a MaxLengthError after 4097 bytes and received <%v>", err)
+	}
+
+	if err = DecodeGzipMax(newDecoder(bytes.NewBuffer(e.bs)), &out, "Blob", 1<<20); err != nil || len(out) != 1<<20 {
+		t.Fatalf("invalid value, expected %d bytes and received %d (%v)", 1<<20, len(out), err)
+	}
+
+	// So are the compressed bytes, before they are allocated
+	e = newEncoder(nil)
+	if err = e.Int(1 << 40); err != nil {
+		t.Fatal(err)
+	}
+	if err = DecodeGzipMax(newDecoder(bytes.NewBuffer(e.bs)), &out, "Blob", 16); !errors.As(err, &lengthErr) || lengthErr.Max != 16 {
+		t.Fatalf("invalid error, expected a MaxLengthError and received <%v>", err)
+	}
+}
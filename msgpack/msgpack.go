@@ -0,0 +1,445 @@
+// Package msgpack is a small MessagePack encoder and decoder used by code generated
+// with enkodo -msgpack. It covers the formats needed for enkodo structs: nil, bool,
+// integers, floats, strings, binary, arrays and maps.
+package msgpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrShortBuffer is returned when the input ends in the middle of a value
+var ErrShortBuffer = errors.New("msgpack: not enough bytes to decode value")
+
+// Encoder appends MessagePack values to a byteslice
+type Encoder struct {
+	bs []byte
+}
+
+// NewEncoder will initialize a new instance of Encoder
+func NewEncoder() *Encoder {
+	return &Encoder{}
+}
+
+// Bytes will return the encoded bytes
+func (e *Encoder) Bytes() []byte {
+	return e.bs
+}
+
+// Raw appends an already encoded value
+func (e *Encoder) Raw(v []byte) {
+	e.bs = append(e.bs, v...)
+}
+
+// Nil encodes a nil value
+func (e *Encoder) Nil() {
+	e.bs = append(e.bs, 0xc0)
+}
+
+// Bool encodes a boolean value
+func (e *Encoder) Bool(v bool) {
+	if v {
+		e.bs = append(e.bs, 0xc3)
+		return
+	}
+	e.bs = append(e.bs, 0xc2)
+}
+
+// Uint encodes an unsigned integer in its smallest form
+func (e *Encoder) Uint(v uint64) {
+	switch {
+	case v <= 0x7f:
+		e.bs = append(e.bs, byte(v))
+	case v <= math.MaxUint8:
+		e.bs = append(e.bs, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		e.bs = binary.BigEndian.AppendUint16(append(e.bs, 0xcd), uint16(v))
+	case v <= math.MaxUint32:
+		e.bs = binary.BigEndian.AppendUint32(append(e.bs, 0xce), uint32(v))
+	default:
+		e.bs = binary.BigEndian.AppendUint64(append(e.bs, 0xcf), v)
+	}
+}
+
+// Int encodes a signed integer in its smallest form
+func (e *Encoder) Int(v int64) {
+	switch {
+	case v >= 0:
+		e.Uint(uint64(v))
+	case v >= -32:
+		e.bs = append(e.bs, byte(v))
+	case v >= math.MinInt8:
+		e.bs = append(e.bs, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		e.bs = binary.BigEndian.AppendUint16(append(e.bs, 0xd1), uint16(v))
+	case v >= math.MinInt32:
+		e.bs = binary.BigEndian.AppendUint32(append(e.bs, 0xd2), uint32(v))
+	default:
+		e.bs = binary.BigEndian.AppendUint64(append(e.bs, 0xd3), uint64(v))
+	}
+}
+
+// Float32 encodes a float32 value
+func (e *Encoder) Float32(v float32) {
+	e.bs = binary.BigEndian.AppendUint32(append(e.bs, 0xca), math.Float32bits(v))
+}
+
+// Float64 encodes a float64 value
+func (e *Encoder) Float64(v float64) {
+	e.bs = binary.BigEndian.AppendUint64(append(e.bs, 0xcb), math.Float64bits(v))
+}
+
+// String encodes a string value
+func (e *Encoder) String(v string) {
+	n := len(v)
+	switch {
+	case n < 32:
+		e.bs = append(e.bs, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		e.bs = append(e.bs, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		e.bs = binary.BigEndian.AppendUint16(append(e.bs, 0xda), uint16(n))
+	default:
+		e.bs = binary.BigEndian.AppendUint32(append(e.bs, 0xdb), uint32(n))
+	}
+	e.bs = append(e.bs, v...)
+}
+
+// Bin encodes a binary value
+func (e *Encoder) Bin(v []byte) {
+	n := len(v)
+	switch {
+	case n <= math.MaxUint8:
+		e.bs = append(e.bs, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		e.bs = binary.BigEndian.AppendUint16(append(e.bs, 0xc5), uint16(n))
+	default:
+		e.bs = binary.BigEndian.AppendUint32(append(e.bs, 0xc6), uint32(n))
+	}
+	e.bs = append(e.bs, v...)
+}
+
+// Array encodes the header of an array with n elements, which must follow it
+func (e *Encoder) Array(n int) {
+	switch {
+	case n < 16:
+		e.bs = append(e.bs, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		e.bs = binary.BigEndian.AppendUint16(append(e.bs, 0xdc), uint16(n))
+	default:
+		e.bs = binary.BigEndian.AppendUint32(append(e.bs, 0xdd), uint32(n))
+	}
+}
+
+// Map encodes the header of a map with n key/value pairs, which must follow it
+func (e *Encoder) Map(n int) {
+	switch {
+	case n < 16:
+		e.bs = append(e.bs, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		e.bs = binary.BigEndian.AppendUint16(append(e.bs, 0xde), uint16(n))
+	default:
+		e.bs = binary.BigEndian.AppendUint32(append(e.bs, 0xdf), uint32(n))
+	}
+}
+
+// Decoder reads MessagePack values from a byteslice
+type Decoder struct {
+	bs  []byte
+	off int
+}
+
+// NewDecoder will initialize a new instance of Decoder
+func NewDecoder(bs []byte) *Decoder {
+	return &Decoder{bs: bs}
+}
+
+func (d *Decoder) next(n int) (v []byte, err error) {
+	if len(d.bs)-d.off < n {
+		return nil, ErrShortBuffer
+	}
+	v = d.bs[d.off : d.off+n]
+	d.off += n
+	return
+}
+
+func (d *Decoder) code() (c byte, err error) {
+	var v []byte
+	if v, err = d.next(1); err != nil {
+		return
+	}
+	return v[0], nil
+}
+
+func (d *Decoder) uintN(n int) (v uint64, err error) {
+	var bs []byte
+	if bs, err = d.next(n); err != nil {
+		return
+	}
+	for _, b := range bs {
+		v = v<<8 | uint64(b)
+	}
+	return
+}
+
+func unexpected(c byte, target string) error {
+	return fmt.Errorf("msgpack: unexpected code 0x%x when decoding %s", c, target)
+}
+
+// IsNil reports whether the next value is nil, without consuming it
+func (d *Decoder) IsNil() bool {
+	return d.off < len(d.bs) && d.bs[d.off] == 0xc0
+}
+
+// Bool decodes a boolean value
+func (d *Decoder) Bool() (v bool, err error) {
+	var c byte
+	if c, err = d.code(); err != nil {
+		return
+	}
+	switch c {
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	}
+	return false, unexpected(c, "bool")
+}
+
+// Int decodes any integer value as an int64
+func (d *Decoder) Int() (v int64, err error) {
+	var c byte
+	if c, err = d.code(); err != nil {
+		return
+	}
+	var u uint64
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c >= 0xcc && c <= 0xcf:
+		u, err = d.uintN(1 << (c - 0xcc))
+		return int64(u), err
+	case c >= 0xd0 && c <= 0xd3:
+		n := 1 << (c - 0xd0)
+		u, err = d.uintN(n)
+		// Sign extend from the width of the value
+		shift := 64 - 8*n
+		return int64(u<<shift) >> shift, err
+	}
+	return 0, unexpected(c, "int")
+}
+
+// Uint decodes any integer value as a uint64
+func (d *Decoder) Uint() (v uint64, err error) {
+	var i int64
+	i, err = d.Int()
+	return uint64(i), err
+}
+
+// Float64 decodes a float32 or float64 value
+func (d *Decoder) Float64() (v float64, err error) {
+	var (
+		c byte
+		u uint64
+	)
+	if c, err = d.code(); err != nil {
+		return
+	}
+	switch c {
+	case 0xca:
+		u, err = d.uintN(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case 0xcb:
+		u, err = d.uintN(8)
+		return math.Float64frombits(u), err
+	}
+	return 0, unexpected(c, "float")
+}
+
+// Float32 decodes a float32 or float64 value as a float32
+func (d *Decoder) Float32() (v float32, err error) {
+	var f float64
+	f, err = d.Float64()
+	return float32(f), err
+}
+
+// length reads the length of a string or binary value
+func (d *Decoder) length(target string) (n int, err error) {
+	var (
+		c byte
+		u uint64
+	)
+	if c, err = d.code(); err != nil {
+		return
+	}
+	switch {
+	case c >= 0xa0 && c <= 0xbf:
+		return int(c & 0x1f), nil
+	case c == 0xd9 || c == 0xc4:
+		u, err = d.uintN(1)
+	case c == 0xda || c == 0xc5:
+		u, err = d.uintN(2)
+	case c == 0xdb || c == 0xc6:
+		u, err = d.uintN(4)
+	case c == 0xc0:
+		return 0, nil
+	default:
+		return 0, unexpected(c, target)
+	}
+	return int(u), err
+}
+
+// String decodes a string or binary value as a string
+func (d *Decoder) String() (v string, err error) {
+	var (
+		n  int
+		bs []byte
+	)
+	if n, err = d.length("string"); err != nil {
+		return
+	}
+	if bs, err = d.next(n); err != nil {
+		return
+	}
+	return string(bs), nil
+}
+
+// Bin decodes a binary or string value, the result is a copy of the input
+func (d *Decoder) Bin() (v []byte, err error) {
+	var (
+		n  int
+		bs []byte
+	)
+	if n, err = d.length("bin"); err != nil {
+		return
+	}
+	if bs, err = d.next(n); err != nil {
+		return
+	}
+	return append([]byte{}, bs...), nil
+}
+
+// header reads the length of an array or map, nil decodes as 0
+func (d *Decoder) header(fix, fixMask, b16, b32 byte, target string) (n int, err error) {
+	var (
+		c byte
+		u uint64
+	)
+	if c, err = d.code(); err != nil {
+		return
+	}
+	switch {
+	case c&^fixMask == fix:
+		return int(c & fixMask), nil
+	case c == b16:
+		u, err = d.uintN(2)
+	case c == b32:
+		u, err = d.uintN(4)
+	case c == 0xc0:
+		return 0, nil
+	default:
+		return 0, unexpected(c, target)
+	}
+	return int(u), err
+}
+
+// Array decodes the header of an array, returning its number of elements
+func (d *Decoder) Array() (n int, err error) {
+	return d.header(0x90, 0x0f, 0xdc, 0xdd, "array")
+}
+
+// Map decodes the header of a map, returning its number of key/value pairs
+func (d *Decoder) Map() (n int, err error) {
+	return d.header(0x80, 0x0f, 0xde, 0xdf, "map")
+}
+
+// Raw returns the encoded bytes of the next value and skips over it
+func (d *Decoder) Raw() (v []byte, err error) {
+	start := d.off
+	if err = d.Skip(); err != nil {
+		return
+	}
+	return d.bs[start:d.off], nil
+}
+
+// Skip moves past the next value, including any values nested in it
+func (d *Decoder) Skip() (err error) {
+	var (
+		c byte
+		u uint64
+	)
+	if c, err = d.code(); err != nil {
+		return
+	}
+
+	var size, children int
+	switch {
+	case c <= 0x7f, c >= 0xe0, c == 0xc0, c == 0xc2, c == 0xc3:
+	case c >= 0x80 && c <= 0x8f:
+		children = 2 * int(c&0x0f)
+	case c >= 0x90 && c <= 0x9f:
+		children = int(c & 0x0f)
+	case c >= 0xa0 && c <= 0xbf:
+		size = int(c & 0x1f)
+	case c == 0xcc || c == 0xd0:
+		size = 1
+	case c == 0xcd || c == 0xd1:
+		size = 2
+	case c == 0xce || c == 0xd2 || c == 0xca:
+		size = 4
+	case c == 0xcf || c == 0xd3 || c == 0xcb:
+		size = 8
+	case c == 0xc4 || c == 0xd9:
+		u, err = d.uintN(1)
+		size = int(u)
+	case c == 0xc5 || c == 0xda:
+		u, err = d.uintN(2)
+		size = int(u)
+	case c == 0xc6 || c == 0xdb:
+		u, err = d.uintN(4)
+		size = int(u)
+	case c >= 0xd4 && c <= 0xd8:
+		// fixext, a type byte then 1, 2, 4, 8 or 16 bytes
+		size = 1 + 1<<(c-0xd4)
+	case c == 0xc7:
+		u, err = d.uintN(1)
+		size = int(u) + 1
+	case c == 0xc8:
+		u, err = d.uintN(2)
+		size = int(u) + 1
+	case c == 0xc9:
+		u, err = d.uintN(4)
+		size = int(u) + 1
+	case c == 0xdc:
+		u, err = d.uintN(2)
+		children = int(u)
+	case c == 0xdd:
+		u, err = d.uintN(4)
+		children = int(u)
+	case c == 0xde:
+		u, err = d.uintN(2)
+		children = 2 * int(u)
+	case c == 0xdf:
+		u, err = d.uintN(4)
+		children = 2 * int(u)
+	default:
+		return unexpected(c, "value")
+	}
+	if err != nil {
+		return
+	}
+
+	if _, err = d.next(size); err != nil {
+		return
+	}
+	for i := 0; i < children; i++ {
+		if err = d.Skip(); err != nil {
+			return
+		}
+	}
+	return
+}
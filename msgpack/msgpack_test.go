@@ -0,0 +1,76 @@
+package msgpack
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	ints := []int64{0, 1, 127, 128, 255, 65536, math.MaxInt64, -1, -32, -33, -129, -40000, math.MinInt64}
+	strs := []string{"", "short", string(bytes.Repeat([]byte("x"), 40)), string(bytes.Repeat([]byte("y"), 300))}
+
+	e := NewEncoder()
+	e.Map(2)
+	e.String("ints")
+	e.Array(len(ints))
+	for _, v := range ints {
+		e.Int(v)
+	}
+	e.String("strs")
+	e.Array(len(strs))
+	for _, v := range strs {
+		e.String(v)
+	}
+	e.Float64(3.5)
+	e.Bin([]byte{1, 2, 3})
+	e.Nil()
+	e.Bool(true)
+
+	d := NewDecoder(e.Bytes())
+	if n, err := d.Map(); err != nil || n != 2 {
+		t.Fatalf("invalid map length, expected %d and received %d (%v)", 2, n, err)
+	}
+	if key, _ := d.String(); key != "ints" {
+		t.Fatalf("invalid key, expected %s and received %s", "ints", key)
+	}
+	n, _ := d.Array()
+	for i := 0; i < n; i++ {
+		if v, err := d.Int(); err != nil || v != ints[i] {
+			t.Fatalf("invalid value, expected %d and received %d (%v)", ints[i], v, err)
+		}
+	}
+	// Skip the key, then take the strings as raw bytes
+	if err := d.Skip(); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := d.Raw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd := NewDecoder(raw)
+	if n, _ = sd.Array(); n != len(strs) {
+		t.Fatalf("invalid array length, expected %d and received %d", len(strs), n)
+	}
+	for i := 0; i < n; i++ {
+		if v, err := sd.String(); err != nil || v != strs[i] {
+			t.Fatalf("invalid value, expected %q and received %q (%v)", strs[i], v, err)
+		}
+	}
+	if v, err := d.Float64(); err != nil || v != 3.5 {
+		t.Fatalf("invalid value, expected %v and received %v (%v)", 3.5, v, err)
+	}
+	if v, err := d.Bin(); err != nil || !bytes.Equal(v, []byte{1, 2, 3}) {
+		t.Fatalf("invalid value, expected %v and received %v (%v)", []byte{1, 2, 3}, v, err)
+	}
+	if !d.IsNil() {
+		t.Fatal("expected nil")
+	}
+	d.Skip()
+	if v, err := d.Bool(); err != nil || !v {
+		t.Fatalf("invalid value, expected %v and received %v (%v)", true, v, err)
+	}
+	if _, err := d.Bool(); err != ErrShortBuffer {
+		t.Fatalf("invalid error, expected <%v> and received <%v>", ErrShortBuffer, err)
+	}
+}
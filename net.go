@@ -0,0 +1,68 @@
+package enkodo
+
+import (
+	"net"
+	"net/netip"
+)
+
+// IP will encode an IP address as bytes, 4 for an IPv4 address and 16 for an IPv6 one.
+// A nil IP is encoded as no bytes
+func (e *Encoder) IP(v net.IP) (err error) {
+	if v4 := v.To4(); v4 != nil {
+		v = v4
+	}
+	return e.Bytes(v)
+}
+
+// Addr will encode an address as the bytes of its MarshalBinary, 4 for an IPv4
+// address, 16 and its zone for an IPv6 one and none for the zero Addr
+func (e *Encoder) Addr(v netip.Addr) (err error) {
+	// Marshalling an Addr never fails
+	bs, _ := v.MarshalBinary()
+	return e.Bytes(bs)
+}
+
+// AddrPort will encode an address and port as the bytes of its MarshalBinary, those of
+// the address followed by the port as 2 little endian bytes
+func (e *Encoder) AddrPort(v netip.AddrPort) (err error) {
+	bs, _ := v.MarshalBinary()
+	return e.Bytes(bs)
+}
+
+// IP will return a decoded IP address, nil when none was encoded
+func (d *Decoder) IP() (v net.IP, err error) {
+	var bs []byte
+	if err = d.Bytes(&bs); err != nil {
+		return
+	}
+
+	switch len(bs) {
+	case 0:
+		return nil, nil
+	case net.IPv4len, net.IPv6len:
+		return net.IP(bs), nil
+	}
+	return nil, ErrInvalidLength
+}
+
+// Addr will return a decoded address
+func (d *Decoder) Addr() (v netip.Addr, err error) {
+	var bs []byte
+	if err = d.Bytes(&bs); err != nil {
+		return
+	}
+
+	err = v.UnmarshalBinary(bs)
+	return
+}
+
+// AddrPort will return a decoded address and port
+func (d *Decoder) AddrPort() (v netip.AddrPort, err error) {
+	var bs []byte
+	if err = d.Bytes(&bs); err != nil {
+		return
+	}
+
+	err = v.UnmarshalBinary(bs)
+	return
+}
@@ -0,0 +1,62 @@
+package enkodo
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestNet(t *testing.T) {
+	ips := []net.IP{nil, net.ParseIP("10.0.0.1"), net.ParseIP("2001:db8::1")}
+	addrs := []netip.Addr{{}, netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("fe80::1%eth0")}
+	ports := []netip.AddrPort{{}, netip.MustParseAddrPort("10.0.0.1:443"), netip.MustParseAddrPort("[2001:db8::1]:8080")}
+
+	e := newEncoder(nil)
+	for i := range ips {
+		if err := e.IP(ips[i]); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Addr(addrs[i]); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.AddrPort(ports[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// IPv4 addresses are written as their 4 bytes rather than the 16 ParseIP returns
+	if len(e.bs) > 2*(1+4+16)+(1+4+16+4)+(1+6+18) {
+		t.Fatalf("expected the addresses in their binary form, encoded %d bytes", len(e.bs))
+	}
+
+	d := newDecoder(bytes.NewBuffer(e.bs))
+	for i := range ips {
+		ip, err := d.IP()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ip.Equal(ips[i]) || (ip == nil) != (ips[i] == nil) {
+			t.Fatalf(testErrorFmt, ips[i], ip)
+		}
+		addr, err := d.Addr()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr != addrs[i] {
+			t.Fatalf(testErrorFmt, addrs[i], addr)
+		}
+		port, err := d.AddrPort()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port != ports[i] {
+			t.Fatalf(testErrorFmt, ports[i], port)
+		}
+	}
+
+	e = newEncoder(nil)
+	e.Bytes([]byte{1, 2, 3})
+	if _, err := newDecoder(bytes.NewBuffer(e.bs)).IP(); err != ErrInvalidLength {
+		t.Fatalf(testErrorFmt, ErrInvalidLength, err)
+	}
+}
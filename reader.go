@@ -20,7 +20,7 @@ func (r *Reader) Decode(v Decodee) (err error) {
 		return ErrIsClosed
 	}
 
-	return v.UnmarshalEnkodo(r.d)
+	return r.d.Decode(v)
 }
 
 // Close will close the reader
@@ -0,0 +1,118 @@
+package enkodo
+
+// EncodeRuns encodes s as runs of equal consecutive values, each written as a count and
+// the value encoded by fn. When s has too few repeats for runs to be smaller it is
+// written as a plain slice instead, so the worst case costs a single byte
+func EncodeRuns[T comparable](e *Encoder, s []T, fn func(T) error) (err error) {
+	runs := 0
+	for i := range s {
+		if i == 0 || s[i] != s[i-1] {
+			runs++
+		}
+	}
+
+	if runs > len(s)/2 {
+		if err = e.Bool(false); err != nil {
+			return
+		}
+		if err = e.Int(len(s)); err != nil {
+			return
+		}
+		for _, v := range s {
+			if err = fn(v); err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	if err = e.Bool(true); err != nil {
+		return
+	}
+	if err = e.Int(runs); err != nil {
+		return
+	}
+	for i := 0; i < len(s); {
+		n := 1
+		for i+n < len(s) && s[i+n] == s[i] {
+			n++
+		}
+		if err = e.Int(n); err != nil {
+			return
+		}
+		if err = fn(s[i]); err != nil {
+			return
+		}
+		i += n
+	}
+	return
+}
+
+// DecodeRuns decodes a slice written by EncodeRuns into s, decoding each value with fn
+func DecodeRuns[T any](d *Decoder, s *[]T, fn func(*T) error) (err error) {
+	return decodeRuns(d, s, "", -1, fn)
+}
+
+// DecodeRunsMax decodes a slice written by EncodeRuns into s like DecodeRuns, returning
+// a MaxLengthError for field before expanding the runs past max elements
+func DecodeRunsMax[T any](d *Decoder, s *[]T, field string, max int, fn func(*T) error) (err error) {
+	return decodeRuns(d, s, field, max, fn)
+}
+
+// decodeRuns decodes a slice written by EncodeRuns, failing before it allocates more
+// than max elements, unless it is negative, or more than the budget has left
+func decodeRuns[T any](d *Decoder, s *[]T, field string, max int, fn func(*T) error) (err error) {
+	var isRuns bool
+	if isRuns, err = d.Bool(); err != nil {
+		return
+	}
+
+	var n int
+	if n, err = d.Int(); err != nil {
+		return
+	}
+	// Each run holds at least one element, so there can not be more runs than max either
+	if max >= 0 && n > max {
+		return &MaxLengthError{Field: field, Length: n, Max: max}
+	}
+	if err = d.Allocate(n); err != nil {
+		return
+	}
+
+	if !isRuns {
+		*s = make([]T, n)
+		for i := range *s {
+			if err = fn(&(*s)[i]); err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	*s = make([]T, 0, n)
+	for i := 0; i < n; i++ {
+		var count int
+		if count, err = d.Int(); err != nil {
+			return
+		}
+		if count < 1 {
+			return ErrInvalidLength
+		}
+		if max >= 0 && count > max-len(*s) {
+			return &MaxLengthError{Field: field, Length: len(*s) + count, Max: max}
+		}
+		// The run was counted as one of the n elements
+		if err = d.Allocate(count - 1); err != nil {
+			return
+		}
+
+		var v T
+		if err = fn(&v); err != nil {
+			return
+		}
+		for j := 0; j < count; j++ {
+			*s = append(*s, v)
+		}
+	}
+	return
+}
@@ -0,0 +1,76 @@
+package enkodo
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestRuns(t *testing.T) {
+	sparse := make([]uint8, 1000)
+	sparse[10], sparse[500], sparse[501] = 1, 2, 2
+	distinct := []uint8{1, 2, 3, 4, 5, 6, 7, 8}
+
+	for _, in := range [][]uint8{sparse, distinct, {}, {9}} {
+		e := newEncoder(nil)
+		if err := EncodeRuns(e, in, e.Uint8); err != nil {
+			t.Fatal(err)
+		}
+		if len(in) == len(distinct) && len(e.bs) != len(distinct)+2 {
+			t.Fatalf("expected a plain slice with a single byte of overhead, received %d bytes", len(e.bs))
+		}
+		if len(in) == len(sparse) && len(e.bs) > 20 {
+			t.Fatalf("expected runs to shrink the slice, received %d bytes", len(e.bs))
+		}
+
+		d := newDecoder(bytes.NewBuffer(e.bs))
+		var out []uint8
+		err := DecodeRuns(d, &out, func(v *uint8) (err error) {
+			*v, err = d.Uint8()
+			return
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(in, out) {
+			t.Fatalf(testErrorFmt, in, out)
+		}
+	}
+}
+
+func TestRunsMax(t *testing.T) {
+	decode := func(bs []byte) (out []uint8, err error) {
+		d := newDecoder(bytes.NewBuffer(bs))
+		err = DecodeRunsMax(d, &out, "Runs", 4, func(v *uint8) (err error) {
+			*v, err = d.Uint8()
+			return
+		})
+		return
+	}
+
+	// A run of a trillion values fails before it is expanded
+	e := newEncoder(nil)
+	for _, err := range []error{e.Bool(true), e.Int(1), e.Int(1 << 40), e.Uint8(7)} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var lengthErr *MaxLengthError
+	if _, err := decode(e.bs); !errors.As(err, &lengthErr) || lengthErr.Field != "Runs" || lengthErr.Max != 4 {
+		t.Fatalf("invalid error, expected a MaxLengthError and received <%v>", err)
+	}
+
+	for in, ok := range map[string]bool{"\x01\x01\x01\x02": true, "\x01\x01\x01\x01\x01": false, "\x01\x02\x03\x04\x05": false} {
+		e = newEncoder(nil)
+		if err := EncodeRuns(e, []uint8(in), e.Uint8); err != nil {
+			t.Fatal(err)
+		}
+		out, err := decode(e.bs)
+		if ok && (err != nil || string(out) != in) {
+			t.Fatalf("invalid value, expected %v and received %v (%v)", []uint8(in), out, err)
+		}
+		if !ok && !errors.As(err, &lengthErr) {
+			t.Fatalf("invalid error for %v, expected a MaxLengthError and received <%v>", []uint8(in), err)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package enkodo
+
+import (
+	"math"
+	"time"
+)
+
+// TimeNano returns t as nanoseconds since the unix epoch, as generated code encodes
+// time.Time. The zero Time is out of the range of UnixNano, so is written as
+// math.MinInt64 for NanoTime to restore
+func TimeNano(t time.Time) int64 {
+	if t.IsZero() {
+		return math.MinInt64
+	}
+	return t.UnixNano()
+}
+
+// NanoTime returns the time.Time of nanoseconds returned by TimeNano
+func NanoTime(n int64) time.Time {
+	if n == math.MinInt64 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
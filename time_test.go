@@ -0,0 +1,14 @@
+package enkodo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeNano(t *testing.T) {
+	for _, in := range []time.Time{{}, time.Unix(0, 0), time.Unix(1700000000, 42), time.Unix(-1, 0)} {
+		if out := NanoTime(TimeNano(in)); !out.Equal(in) || out.IsZero() != in.IsZero() {
+			t.Fatalf(testErrorFmt, in, out)
+		}
+	}
+}
@@ -34,6 +34,14 @@ func MarshalAppend(v Encodee, buffer []byte) (bs []byte, err error) {
 	return
 }
 
+// Size returns the number of bytes v encodes to. The encoded bytes are counted as they
+// are written rather than kept, so this does not allocate a buffer for the whole value
+func Size(v Encodee) (n int, err error) {
+	enc := newEncoder(io.Discard)
+	err = enc.Encode(v)
+	return int(enc.written), err
+}
+
 // Unmarshal will decode a value
 func Unmarshal(bs []byte, v Decodee) (err error) {
 	dec := newDecoder(bytes.NewReader(bs))
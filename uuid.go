@@ -0,0 +1,14 @@
+package enkodo
+
+// UUID will encode a UUID, e.g. a github.com/google/uuid UUID, as its 16 bytes without a
+// length prefix
+func (e *Encoder) UUID(v [16]byte) (err error) {
+	e.bs = append(e.bs, v[:]...)
+	return e.flush()
+}
+
+// UUID will return a decoded UUID
+func (d *Decoder) UUID() (v [16]byte, err error) {
+	err = d.FixedBytes(v[:])
+	return
+}
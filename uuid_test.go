@@ -0,0 +1,24 @@
+package enkodo
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUUID(t *testing.T) {
+	in := [16]byte{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+	e := newEncoder(nil)
+	if err := e.UUID(in); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.bs) != 16 {
+		t.Fatalf("expected the 16 bytes of the UUID, encoded %d bytes", len(e.bs))
+	}
+	out, err := newDecoder(bytes.NewBuffer(e.bs)).UUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != in {
+		t.Fatalf(testErrorFmt, in, out)
+	}
+}